@@ -4,21 +4,90 @@ import (
 	"encoding/json"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/pivotal-cf/cf-redis-broker/redis/client"
 	"github.com/pivotal-cf/cf-redis-broker/redisconf"
+	"github.com/pivotal-cf/cf-redis-broker/resetter"
 )
 
 type KeycountResponse struct {
 	Keycount int `json:"key_count"`
 }
 
+type InfoResponse map[string]map[string]string
+
+type SlowlogResponse struct {
+	Entries []client.SlowlogEntry `json:"entries"`
+}
+
+type ClientsResponse struct {
+	Clients []map[string]string `json:"clients"`
+}
+
+type SnapshotsResponse struct {
+	Snapshots []resetter.Snapshot `json:"snapshots"`
+}
+
+// ReplicationRequest makes a node part of a Sentinel-monitored group. The
+// node replicates from the master unless Replica is false, in which case it
+// is the master. Every node of a group shares the same password.
+type ReplicationRequest struct {
+	MasterName string `json:"master_name"`
+	MasterHost string `json:"master_host"`
+	MasterPort int    `json:"master_port"`
+	Password   string `json:"password"`
+	Quorum     int    `json:"quorum"`
+	Replica    bool   `json:"replica"`
+}
+
+type FailoverRequest struct {
+	MasterName string `json:"master_name"`
+}
+
+// ClusterRequest restarts a node in cluster mode. Every node of a cluster
+// shares the same password.
+type ClusterRequest struct {
+	Password string `json:"password"`
+}
+
+// ConfigSetting is a redis.conf setting whose running value is not the one
+// the default redis.conf asks for.
+type ConfigSetting struct {
+	Key     string `json:"key"`
+	Desired string `json:"desired"`
+	Live    string `json:"live"`
+}
+
+// ConfigDriftResponse lists the settings the agent changed on the running
+// redis at its last check, and the ones that only a restart can change.
+type ConfigDriftResponse struct {
+	CheckedAt       time.Time       `json:"checked_at"`
+	Applied         []ConfigSetting `json:"applied"`
+	RequiresRestart []ConfigSetting `json:"requires_restart"`
+	Error           string          `json:"error,omitempty"`
+}
+
 type redisResetter interface {
 	ResetRedis() error
+	EnableCluster(password string) error
+	ListSnapshots() ([]resetter.Snapshot, error)
+	RestoreSnapshot(id string, force bool) error
+	MarkAllocated() error
+}
+
+type replicationManager interface {
+	Configure(request ReplicationRequest) error
+	Remove(masterName string) error
+	Failover(masterName string) error
+}
+
+type configWatcher interface {
+	Drift() ConfigDriftResponse
 }
 
-func New(resetter redisResetter, configPath string) http.Handler {
+func New(resetter redisResetter, replication replicationManager, watcher configWatcher, configPath string) http.Handler {
 	router := mux.NewRouter()
 
 	router.Path("/").
@@ -27,12 +96,56 @@ func New(resetter redisResetter, configPath string) http.Handler {
 
 	router.Path("/").
 		Methods("GET").
-		HandlerFunc(credentialsHandler(configPath))
+		HandlerFunc(credentialsHandler(resetter, configPath))
 
 	router.Path("/keycount").
 		Methods("GET").
 		HandlerFunc(keyCountHandler(configPath))
 
+	router.Path("/ping").
+		Methods("GET").
+		HandlerFunc(pingHandler(configPath))
+
+	router.Path("/info").
+		Methods("GET").
+		HandlerFunc(infoHandler(configPath))
+
+	router.Path("/slowlog").
+		Methods("GET").
+		HandlerFunc(slowlogHandler(configPath))
+
+	router.Path("/clients").
+		Methods("GET").
+		HandlerFunc(clientsHandler(configPath))
+
+	router.Path("/cluster").
+		Methods("PUT").
+		HandlerFunc(enableClusterHandler(resetter))
+
+	router.Path("/snapshots").
+		Methods("GET").
+		HandlerFunc(snapshotsHandler(resetter))
+
+	router.Path("/snapshots/{id}/restore").
+		Methods("POST").
+		HandlerFunc(restoreSnapshotHandler(resetter))
+
+	router.Path("/replication").
+		Methods("PUT").
+		HandlerFunc(configureReplicationHandler(replication))
+
+	router.Path("/replication").
+		Methods("DELETE").
+		HandlerFunc(removeReplicationHandler(replication))
+
+	router.Path("/failover").
+		Methods("POST").
+		HandlerFunc(failoverHandler(replication))
+
+	router.Path("/config/drift").
+		Methods("GET").
+		HandlerFunc(configDriftHandler(watcher))
+
 	return router
 }
 
@@ -48,8 +161,129 @@ func resetHandler(resetter redisResetter) http.HandlerFunc {
 	}
 }
 
-func credentialsHandler(configPath string) http.HandlerFunc {
+func enableClusterHandler(resetter redisResetter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		request := ClusterRequest{}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := resetter.EnableCluster(request.Password); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func snapshotsHandler(resetter redisResetter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		snapshots, err := resetter.ListSnapshots()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		encoder := json.NewEncoder(w)
+		encoder.Encode(SnapshotsResponse{Snapshots: snapshots})
+	}
+}
+
+// restoreSnapshotHandler puts the data of a reset instance back on the
+// node. Once the node's credentials have been handed out since its last
+// reset, the restore would overwrite another instance's data and needs
+// ?force=true.
+func restoreSnapshotHandler(redisResetter redisResetter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		force := r.URL.Query().Get("force") == "true"
+		err := redisResetter.RestoreSnapshot(mux.Vars(r)["id"], force)
+		switch err {
+		case nil:
+			w.WriteHeader(http.StatusOK)
+		case resetter.ErrNodeAllocated:
+			http.Error(w, err.Error(), http.StatusConflict)
+		case resetter.ErrSnapshotNotFound:
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case resetter.ErrSnapshotsDisabled:
+			http.Error(w, err.Error(), http.StatusNotImplemented)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
+
+func configureReplicationHandler(replication replicationManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		request := ReplicationRequest{}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if request.MasterName == "" || request.MasterHost == "" || request.MasterPort == 0 || request.Quorum == 0 {
+			http.Error(w, "master_name, master_host, master_port and quorum are required", http.StatusBadRequest)
+			return
+		}
+
+		if err := replication.Configure(request); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func removeReplicationHandler(replication replicationManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		masterName := r.URL.Query().Get("master_name")
+		if masterName == "" {
+			http.Error(w, "master_name is required", http.StatusBadRequest)
+			return
+		}
+
+		if err := replication.Remove(masterName); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func failoverHandler(replication replicationManager) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		request := FailoverRequest{}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if request.MasterName == "" {
+			http.Error(w, "master_name is required", http.StatusBadRequest)
+			return
+		}
+
+		if err := replication.Failover(request.MasterName); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// credentialsHandler marks the node allocated before handing out its
+// credentials, so that no snapshot is restored over the instance's data.
+func credentialsHandler(resetter redisResetter, configPath string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := resetter.MarkAllocated(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
 		conf, err := redisconf.Load(configPath)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -63,12 +297,19 @@ func credentialsHandler(configPath string) http.HandlerFunc {
 		}
 		password := conf.Get("requirepass")
 
+		//tls-port is only in redis.conf when the server supports TLS
+		tlsPort, _ := strconv.Atoi(conf.Get("tls-port"))
+
 		credentials := struct {
 			Port     int    `json:"port"`
+			TLSPort  int    `json:"tls_port,omitempty"`
 			Password string `json:"password"`
+			Version  string `json:"redis_version,omitempty"`
 		}{
 			Port:     port,
+			TLSPort:  tlsPort,
 			Password: password,
+			Version:  serverVersion(configPath),
 		}
 
 		w.Header().Set("Content-Type", "application/json")
@@ -80,40 +321,155 @@ func credentialsHandler(configPath string) http.HandlerFunc {
 
 func keyCountHandler(configPath string) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		conf, err := redisconf.Load(configPath)
+		redis, err := connectToRedis(configPath)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		defer redis.Disconnect()
 
-		port, err := strconv.Atoi(conf.Get("port"))
+		count, err := redis.GlobalKeyCount()
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
-		redis, err := client.Connect(
-			client.Port(port),
-			client.Password(conf.Password()),
-		)
+		result := &KeycountResponse{
+			Keycount: count,
+		}
+
+		if err := json.NewEncoder(w).Encode(result); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
 
+// pingHandler lets the broker check that redis answers before handing the
+// node out.
+func pingHandler(configPath string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		redis, err := connectToRedis(configPath)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		defer redis.Disconnect()
+
+		if err := redis.Ping(); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func infoHandler(configPath string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		redis, err := connectToRedis(configPath)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		defer redis.Disconnect()
 
-		count, err := redis.GlobalKeyCount()
+		sections, err := redis.InfoSections()
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
-		result := &KeycountResponse{
-			Keycount: count,
+		writeJSON(w, InfoResponse(sections))
+	}
+}
+
+func slowlogHandler(configPath string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		redis, err := connectToRedis(configPath)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
+		defer redis.Disconnect()
 
-		if err := json.NewEncoder(w).Encode(result); err != nil {
+		entries, err := redis.Slowlog()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, &SlowlogResponse{Entries: entries})
+	}
+}
+
+func clientsHandler(configPath string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		redis, err := connectToRedis(configPath)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer redis.Disconnect()
+
+		clients, err := redis.ClientList()
+		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
+
+		writeJSON(w, &ClientsResponse{Clients: clients})
+	}
+}
+
+// serverVersion reads redis_version from INFO server. Credentials are still
+// served when redis is down, so errors leave the version out.
+func serverVersion(configPath string) string {
+	redis, err := connectToRedis(configPath)
+	if err != nil {
+		return ""
+	}
+	defer redis.Disconnect()
+
+	version, err := redis.InfoField("redis_version")
+	if err != nil {
+		return ""
+	}
+
+	return version
+}
+
+func connectToRedis(configPath string) (client.Client, error) {
+	conf, err := redisconf.Load(configPath)
+	if err != nil {
+		return nil, err
 	}
+
+	port, err := strconv.Atoi(conf.Get("port"))
+	if err != nil {
+		return nil, err
+	}
+
+	return client.Connect(
+		client.Port(port),
+		client.Password(conf.Password()),
+		client.CmdAliases(conf.CommandAliases()),
+	)
+}
+
+func configDriftHandler(watcher configWatcher) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, watcher.Drift())
+	}
+}
+
+func writeJSON(w http.ResponseWriter, payload interface{}) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
 }
@@ -1,29 +1,91 @@
 package agentapi_test
 
 import (
+	"encoding/json"
 	"errors"
 	"net/http"
 	"net/http/httptest"
 	"path/filepath"
+	"strings"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 
 	"github.com/pivotal-cf/cf-redis-broker/agentapi"
+	"github.com/pivotal-cf/cf-redis-broker/resetter"
 )
 
 type fakeRedisResetter struct {
-	deleteAllData func() error
+	deleteAllData    func() error
+	clusterPasswords []string
+	enableClusterErr error
+	snapshots        []resetter.Snapshot
+	restored         []string
+	forced           []bool
+	restoreErr       error
+	allocated        int
 }
 
 func (client *fakeRedisResetter) ResetRedis() error {
 	return client.deleteAllData()
 }
 
+func (client *fakeRedisResetter) EnableCluster(password string) error {
+	client.clusterPasswords = append(client.clusterPasswords, password)
+	return client.enableClusterErr
+}
+
+func (client *fakeRedisResetter) ListSnapshots() ([]resetter.Snapshot, error) {
+	return client.snapshots, nil
+}
+
+func (client *fakeRedisResetter) RestoreSnapshot(id string, force bool) error {
+	client.restored = append(client.restored, id)
+	client.forced = append(client.forced, force)
+	return client.restoreErr
+}
+
+func (client *fakeRedisResetter) MarkAllocated() error {
+	client.allocated++
+	return nil
+}
+
+type fakeReplicationManager struct {
+	configured  []agentapi.ReplicationRequest
+	removed     []string
+	failedOver  []string
+	returnedErr error
+}
+
+func (manager *fakeReplicationManager) Configure(request agentapi.ReplicationRequest) error {
+	manager.configured = append(manager.configured, request)
+	return manager.returnedErr
+}
+
+func (manager *fakeReplicationManager) Remove(masterName string) error {
+	manager.removed = append(manager.removed, masterName)
+	return manager.returnedErr
+}
+
+func (manager *fakeReplicationManager) Failover(masterName string) error {
+	manager.failedOver = append(manager.failedOver, masterName)
+	return manager.returnedErr
+}
+
+type fakeConfigWatcher struct {
+	drift agentapi.ConfigDriftResponse
+}
+
+func (watcher *fakeConfigWatcher) Drift() agentapi.ConfigDriftResponse {
+	return watcher.drift
+}
+
 var _ = Describe("redis agent HTTP API", func() {
 	var (
 		server      *httptest.Server
 		redisClient *fakeRedisResetter
+		replication *fakeReplicationManager
+		watcher     *fakeConfigWatcher
 		deleteCount int
 		configPath  string
 		response    *http.Response
@@ -32,11 +94,13 @@ var _ = Describe("redis agent HTTP API", func() {
 	BeforeEach(func() {
 		configPath = getAbsPath(filepath.FromSlash("assets/redis.conf"))
 		redisClient = new(fakeRedisResetter)
+		replication = new(fakeReplicationManager)
+		watcher = new(fakeConfigWatcher)
 		deleteCount = 0
 	})
 
 	JustBeforeEach(func() {
-		handler := agentapi.New(redisClient, configPath)
+		handler := agentapi.New(redisClient, replication, watcher, configPath)
 		server = httptest.NewServer(handler)
 	})
 
@@ -57,6 +121,20 @@ var _ = Describe("redis agent HTTP API", func() {
 				Expect(response["port"]).To(Equal(float64(1234)))
 				Expect(response["password"]).To(Equal("an-password"))
 			})
+
+			It("marks the node allocated", func() {
+				Expect(redisClient.allocated).To(Equal(1))
+			})
+
+			It("leaves out the redis version when redis is not reachable", func() {
+				body := readAll(response.Body)
+				Expect(unmarshalJSON(body)).NotTo(HaveKey("redis_version"))
+			})
+
+			It("leaves out the TLS port when redis does not serve TLS", func() {
+				body := readAll(response.Body)
+				Expect(unmarshalJSON(body)).NotTo(HaveKey("tls_port"))
+			})
 		})
 
 		Context("When it is unable to read the conf file", func() {
@@ -114,6 +192,182 @@ var _ = Describe("redis agent HTTP API", func() {
 		})
 	})
 
+	Describe("GET /ping", func() {
+		Context("when redis is not reachable", func() {
+			It("returns 503", func() {
+				response = makeRequest("GET", server.URL+"/ping")
+				Expect(response.StatusCode).To(Equal(http.StatusServiceUnavailable))
+			})
+		})
+	})
+
+	Describe("PUT /cluster", func() {
+		It("restarts redis in cluster mode", func() {
+			response = makeRequestWithBody("PUT", server.URL+"/cluster", `{"password":"cluster-password"}`)
+			Expect(response.StatusCode).To(Equal(http.StatusOK))
+			Expect(redisClient.clusterPasswords).To(Equal([]string{"cluster-password"}))
+		})
+
+		It("returns 400 for an invalid body", func() {
+			response = makeRequestWithBody("PUT", server.URL+"/cluster", `not json`)
+			Expect(response.StatusCode).To(Equal(http.StatusBadRequest))
+		})
+
+		Context("when redis cannot be restarted", func() {
+			BeforeEach(func() {
+				redisClient.enableClusterErr = errors.New("Monit has failed to start")
+			})
+
+			It("returns 500 with the error", func() {
+				response = makeRequestWithBody("PUT", server.URL+"/cluster", `{"password":"cluster-password"}`)
+				Expect(response.StatusCode).To(Equal(http.StatusInternalServerError))
+				Expect(string(readAll(response.Body))).To(Equal("Monit has failed to start\n"))
+			})
+		})
+	})
+
+	Describe("GET /snapshots", func() {
+		It("lists the snapshots", func() {
+			redisClient.snapshots = []resetter.Snapshot{{ID: "20261018T120000.000000000Z", Files: []string{"dump.rdb"}}}
+
+			response = makeRequest("GET", server.URL+"/snapshots")
+			Expect(response.StatusCode).To(Equal(http.StatusOK))
+
+			var snapshots agentapi.SnapshotsResponse
+			Expect(json.Unmarshal(readAll(response.Body), &snapshots)).To(Succeed())
+			Expect(snapshots.Snapshots[0].ID).To(Equal("20261018T120000.000000000Z"))
+			Expect(snapshots.Snapshots[0].Files).To(Equal([]string{"dump.rdb"}))
+		})
+	})
+
+	Describe("POST /snapshots/:id/restore", func() {
+		It("restores the snapshot", func() {
+			response = makeRequest("POST", server.URL+"/snapshots/20261018T120000.000000000Z/restore")
+			Expect(response.StatusCode).To(Equal(http.StatusOK))
+			Expect(redisClient.restored).To(Equal([]string{"20261018T120000.000000000Z"}))
+			Expect(redisClient.forced).To(Equal([]bool{false}))
+		})
+
+		It("returns 409 when the node has been allocated since its reset", func() {
+			redisClient.restoreErr = resetter.ErrNodeAllocated
+
+			response = makeRequest("POST", server.URL+"/snapshots/20261018T120000.000000000Z/restore")
+			Expect(response.StatusCode).To(Equal(http.StatusConflict))
+		})
+
+		It("passes on the force flag", func() {
+			response = makeRequest("POST", server.URL+"/snapshots/20261018T120000.000000000Z/restore?force=true")
+			Expect(response.StatusCode).To(Equal(http.StatusOK))
+			Expect(redisClient.forced).To(Equal([]bool{true}))
+		})
+
+		It("returns 404 for an unknown snapshot", func() {
+			redisClient.restoreErr = resetter.ErrSnapshotNotFound
+
+			response = makeRequest("POST", server.URL+"/snapshots/unknown/restore")
+			Expect(response.StatusCode).To(Equal(http.StatusNotFound))
+		})
+
+		It("returns 501 when snapshots are not enabled", func() {
+			redisClient.restoreErr = resetter.ErrSnapshotsDisabled
+
+			response = makeRequest("POST", server.URL+"/snapshots/unknown/restore")
+			Expect(response.StatusCode).To(Equal(http.StatusNotImplemented))
+		})
+	})
+
+	Describe("PUT /replication", func() {
+		var body string
+
+		BeforeEach(func() {
+			body = `{"master_name":"instance-id","master_host":"10.0.0.1","master_port":6379,"password":"secret","quorum":2,"replica":true}`
+		})
+
+		JustBeforeEach(func() {
+			response = makeRequestWithBody("PUT", server.URL+"/replication", body)
+		})
+
+		It("configures replication", func() {
+			Expect(response.StatusCode).To(Equal(http.StatusOK))
+			Expect(replication.configured).To(Equal([]agentapi.ReplicationRequest{{
+				MasterName: "instance-id",
+				MasterHost: "10.0.0.1",
+				MasterPort: 6379,
+				Password:   "secret",
+				Quorum:     2,
+				Replica:    true,
+			}}))
+		})
+
+		Context("when the master is missing", func() {
+			BeforeEach(func() {
+				body = `{"master_name":"instance-id","quorum":2}`
+			})
+
+			It("returns 400", func() {
+				Expect(response.StatusCode).To(Equal(http.StatusBadRequest))
+				Expect(replication.configured).To(BeEmpty())
+			})
+		})
+
+		Context("when configuring fails", func() {
+			BeforeEach(func() {
+				replication.returnedErr = errors.New("sentinel is down")
+			})
+
+			It("returns 500 with the error", func() {
+				Expect(response.StatusCode).To(Equal(http.StatusInternalServerError))
+				Expect(string(readAll(response.Body))).To(Equal("sentinel is down\n"))
+			})
+		})
+	})
+
+	Describe("DELETE /replication", func() {
+		It("removes the master from replication", func() {
+			response = makeRequest("DELETE", server.URL+"/replication?master_name=instance-id")
+			Expect(response.StatusCode).To(Equal(http.StatusOK))
+			Expect(replication.removed).To(Equal([]string{"instance-id"}))
+		})
+
+		It("requires a master name", func() {
+			response = makeRequest("DELETE", server.URL+"/replication")
+			Expect(response.StatusCode).To(Equal(http.StatusBadRequest))
+			Expect(replication.removed).To(BeEmpty())
+		})
+	})
+
+	Describe("POST /failover", func() {
+		It("asks sentinel to fail over", func() {
+			response = makeRequestWithBody("POST", server.URL+"/failover", `{"master_name":"instance-id"}`)
+			Expect(response.StatusCode).To(Equal(http.StatusOK))
+			Expect(replication.failedOver).To(Equal([]string{"instance-id"}))
+		})
+
+		It("requires a master name", func() {
+			response = makeRequestWithBody("POST", server.URL+"/failover", `{}`)
+			Expect(response.StatusCode).To(Equal(http.StatusBadRequest))
+		})
+	})
+
+	Describe("GET /config/drift", func() {
+		BeforeEach(func() {
+			watcher.drift = agentapi.ConfigDriftResponse{
+				Applied:         []agentapi.ConfigSetting{{Key: "maxmemory-policy", Desired: "allkeys-lru", Live: "noeviction"}},
+				RequiresRestart: []agentapi.ConfigSetting{{Key: "databases", Desired: "32", Live: "16"}},
+			}
+		})
+
+		It("returns the drift found by the last check", func() {
+			response = makeRequest("GET", server.URL+"/config/drift")
+			Expect(response.StatusCode).To(Equal(http.StatusOK))
+
+			drift := agentapi.ConfigDriftResponse{}
+			Expect(json.Unmarshal(readAll(response.Body), &drift)).To(Succeed())
+			Expect(drift.Applied).To(Equal(watcher.drift.Applied))
+			Expect(drift.RequiresRestart).To(Equal(watcher.drift.RequiresRestart))
+		})
+	})
+
 	Describe("All other HTTP methods", func() {
 		for _, method := range []string{"POST", "PUT"} {
 			requestMethod := method
@@ -139,3 +393,13 @@ func makeRequest(method string, url string) *http.Response {
 
 	return response
 }
+
+func makeRequestWithBody(method string, url string, body string) *http.Response {
+	request, err := http.NewRequest(method, url, strings.NewReader(body))
+	Expect(err).NotTo(HaveOccurred())
+
+	response, err := http.DefaultClient.Do(request)
+	Expect(err).NotTo(HaveOccurred())
+
+	return response
+}
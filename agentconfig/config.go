@@ -11,12 +11,60 @@ type AuthConfiguration struct {
 	Username string `yaml:"username"`
 }
 
+const (
+	ProcessControlMonit   = "monit"
+	ProcessControlSystemd = "systemd"
+	ProcessControlExec    = "exec"
+
+	ResetModeRestart = "restart"
+	ResetModeFast    = "fast"
+)
+
+// SnapshotConfiguration keeps the data of reset instances in Directory for
+// RetentionHours. Snapshots are off without a directory.
+type SnapshotConfiguration struct {
+	Directory      string `yaml:"directory"`
+	RetentionHours int    `yaml:"retention_hours"`
+}
+
+// TLSConfiguration has redis serve TLS on Port, alongside its plain port,
+// when the redis-server version supports it. TLS is off without a port.
+type TLSConfiguration struct {
+	Port       int    `yaml:"port"`
+	CertFile   string `yaml:"cert_file"`
+	KeyFile    string `yaml:"key_file"`
+	CACertFile string `yaml:"ca_cert_file"`
+}
+
 type Config struct {
-	DefaultConfPath     string            `yaml:"default_conf_path"`
-	ConfPath            string            `yaml:"conf_path"`
-	MonitExecutablePath string            `yaml:"monit_executable_path"`
-	Port                string            `yaml:"backend_port"`
-	AuthConfiguration   AuthConfiguration `yaml:"auth"`
+	DefaultConfPath           string                `yaml:"default_conf_path"`
+	ConfPath                  string                `yaml:"conf_path"`
+	MonitExecutablePath       string                `yaml:"monit_executable_path"`
+	RedisServerExecutablePath string                `yaml:"redis_server_executable_path"`
+	Port                      string                `yaml:"backend_port"`
+	AuthConfiguration         AuthConfiguration     `yaml:"auth"`
+	Snapshots                 SnapshotConfiguration `yaml:"snapshots"`
+	TLS                       TLSConfiguration      `yaml:"tls"`
+
+	// The agent runs a Sentinel for the HA plan on SentinelPort, 26379 by
+	// default, which records its state in SentinelConfPath, sentinel.conf
+	// next to conf_path by default.
+	SentinelPort     int    `yaml:"sentinel_port"`
+	SentinelConfPath string `yaml:"sentinel_conf_path"`
+
+	// ProcessControl is how the agent stops and starts redis: monit, the
+	// default, systemd with SystemdUnit, or exec, where the agent runs
+	// redis-server itself.
+	ProcessControl string `yaml:"process_control"`
+	SystemdUnit    string `yaml:"systemd_unit"`
+
+	// ResetMode restart, the default, stops redis and deletes its data files.
+	// fast flushes redis in place and falls back to a restart when it cannot.
+	ResetMode string `yaml:"reset_mode"`
+
+	// ConfigWatchInterval is how often the agent checks the default
+	// redis.conf and the running redis for drift, 30 seconds by default.
+	ConfigWatchInterval int `yaml:"config_watch_interval_seconds"`
 }
 
 func Load(path string) (*Config, error) {
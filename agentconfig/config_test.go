@@ -42,14 +42,53 @@ var _ = Describe("Config", func() {
 				Expect(config.MonitExecutablePath).To(Equal("/foo/monit"))
 			})
 
+			It("Has the correct redis_server_executable_path", func() {
+				Expect(config.RedisServerExecutablePath).To(Equal("/foo/redis-server"))
+			})
+
 			It("Has the correct backend_port", func() {
 				Expect(config.Port).To(Equal("9876"))
 			})
 
+			It("Has the correct sentinel_port", func() {
+				Expect(config.SentinelPort).To(Equal(26380))
+			})
+
+			It("Has the correct sentinel_conf_path", func() {
+				Expect(config.SentinelConfPath).To(Equal("/conf/sentinel.conf"))
+			})
+
 			It("Has the correct username and password", func() {
 				Expect(config.AuthConfiguration.Username).To(Equal("admin"))
 				Expect(config.AuthConfiguration.Password).To(Equal("secret"))
 			})
+
+			It("Has the process control", func() {
+				Expect(config.ProcessControl).To(Equal(agentconfig.ProcessControlSystemd))
+				Expect(config.SystemdUnit).To(Equal("redis-server.service"))
+			})
+
+			It("Has the reset mode", func() {
+				Expect(config.ResetMode).To(Equal(agentconfig.ResetModeFast))
+			})
+
+			It("Has the config watch interval", func() {
+				Expect(config.ConfigWatchInterval).To(Equal(10))
+			})
+
+			It("Has the snapshot directory and retention", func() {
+				Expect(config.Snapshots.Directory).To(Equal("/var/vcap/store/redis-snapshots"))
+				Expect(config.Snapshots.RetentionHours).To(Equal(72))
+			})
+
+			It("Has the TLS port and files", func() {
+				Expect(config.TLS).To(Equal(agentconfig.TLSConfiguration{
+					Port:       16379,
+					CertFile:   "/var/vcap/jobs/redis/config/tls/redis.crt",
+					KeyFile:    "/var/vcap/jobs/redis/config/tls/redis.key",
+					CACertFile: "/var/vcap/jobs/redis/config/tls/ca.crt",
+				}))
+			})
 		})
 	})
 })
@@ -0,0 +1,89 @@
+package agentintegration_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gexec"
+
+	"github.com/pivotal-cf/cf-redis-broker/agentapi"
+)
+
+var _ = Describe("stats requests", func() {
+	var (
+		agentSession, redisSession *gexec.Session
+		aofPath                    string
+	)
+
+	BeforeEach(func() {
+		agentSession = startAgent()
+		redisSession, aofPath = startRedisAndBlockUntilUp()
+	})
+
+	AfterEach(func() {
+		agentSession.Kill()
+		redisSession.Kill()
+		os.Remove(aofPath)
+	})
+
+	Describe("GET /info", func() {
+		It("reports the INFO output grouped by section", func() {
+			info := agentapi.InfoResponse{}
+			Expect(getStats("/info", &info)).To(Succeed())
+
+			Expect(info).To(HaveKey("server"))
+			Expect(info["server"]).To(HaveKey("redis_version"))
+			Expect(info).To(HaveKey("memory"))
+			Expect(info["memory"]).To(HaveKey("used_memory"))
+		})
+	})
+
+	Describe("GET /slowlog", func() {
+		It("reports the slowlog entries", func() {
+			slowlog := agentapi.SlowlogResponse{}
+			Expect(getStats("/slowlog", &slowlog)).To(Succeed())
+			Expect(slowlog.Entries).NotTo(BeNil())
+		})
+	})
+
+	Describe("GET /clients", func() {
+		It("includes the connection made by the agent", func() {
+			clients := agentapi.ClientsResponse{}
+			Expect(getStats("/clients", &clients)).To(Succeed())
+
+			Expect(clients.Clients).NotTo(BeEmpty())
+			Expect(clients.Clients[0]).To(HaveKey("addr"))
+		})
+	})
+})
+
+func getStats(path string, result interface{}) error {
+	httpClient := &http.Client{
+		Timeout:   5 * time.Second,
+		Transport: http.DefaultTransport,
+	}
+
+	request, err := http.NewRequest("GET", "http://127.0.0.1:9876"+path, nil)
+	if err != nil {
+		return err
+	}
+
+	request.SetBasicAuth("admin", "supersecretpassword")
+
+	response, err := httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if want, got := response.StatusCode, http.StatusOK; want != got {
+		return fmt.Errorf("unexpected HTTP response code: want %d, got %d", want, got)
+	}
+
+	return json.NewDecoder(response.Body).Decode(result)
+}
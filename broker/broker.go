@@ -1,6 +1,7 @@
 package broker
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 
@@ -11,16 +12,39 @@ import (
 const (
 	PlanNameShared    = "shared-vm"
 	PlanNameDedicated = "dedicated-vm"
+	PlanNameHA        = "ha-vm"
+	PlanNameCluster   = "cluster-vm"
 )
 
 type InstanceCredentials struct {
-	Host     string
-	Port     int
-	Password string
+	Host       string
+	Port       int
+	Password   string
+	UnixSocket string
+	Version    string
+
+	// TLSPort is where the instance also serves TLS, when its redis-server
+	// supports it.
+	TLSPort int
+
+	// Sentinels and MasterName are set for high availability instances,
+	// whose clients should discover the current master through Sentinel.
+	Sentinels  []string
+	MasterName string
+
+	// Nodes are the host:port addresses of every node of a Redis Cluster
+	// instance.
+	Nodes []string
+}
+
+// ProvisionParameters are the parameters users may pass on provision, e.g.
+// cf create-service p-redis shared-vm my-redis -c '{"redis_version":"3.2"}'.
+type ProvisionParameters struct {
+	RedisVersion string `json:"redis_version"`
 }
 
 type InstanceCreator interface {
-	Create(instanceID string) error
+	Create(instanceID string, parameters ProvisionParameters) error
 	Destroy(instanceID string) error
 	InstanceExists(instanceID string) (bool, error)
 }
@@ -95,7 +119,14 @@ func (redisServiceBroker *RedisServiceBroker) Provision(instanceID string, servi
 		return spec, errors.New("instance creator not found for plan")
 	}
 
-	err = instanceCreator.Create(instanceID)
+	parameters := ProvisionParameters{}
+	if len(serviceDetails.RawParameters) > 0 {
+		if err := json.Unmarshal(serviceDetails.RawParameters, &parameters); err != nil {
+			return spec, brokerapi.ErrRawParamsInvalid
+		}
+	}
+
+	err = instanceCreator.Create(instanceID, parameters)
 	if err != nil {
 		return spec, err
 	}
@@ -131,6 +162,27 @@ func (redisServiceBroker *RedisServiceBroker) Bind(instanceID, bindingID string,
 				"password": instanceCredentials.Password,
 			}
 
+			if instanceCredentials.UnixSocket != "" {
+				credentialsMap["unix_socket"] = instanceCredentials.UnixSocket
+			}
+
+			if instanceCredentials.TLSPort != 0 {
+				credentialsMap["tls_port"] = instanceCredentials.TLSPort
+			}
+
+			if instanceCredentials.Version != "" {
+				credentialsMap["redis_version"] = instanceCredentials.Version
+			}
+
+			if instanceCredentials.MasterName != "" {
+				credentialsMap["sentinels"] = instanceCredentials.Sentinels
+				credentialsMap["master_name"] = instanceCredentials.MasterName
+			}
+
+			if len(instanceCredentials.Nodes) > 0 {
+				credentialsMap["nodes"] = instanceCredentials.Nodes
+			}
+
 			binding.Credentials = credentialsMap
 			return binding, nil
 		}
@@ -188,6 +240,38 @@ func (redisServiceBroker *RedisServiceBroker) plans() map[string]*brokerapi.Serv
 		}
 	}
 
+	if redisServiceBroker.Config.HAEnabled() {
+		plans["ha"] = &brokerapi.ServicePlan{
+			ID:          redisServiceBroker.Config.RedisConfiguration.HAVMPlanID,
+			Name:        PlanNameHA,
+			Description: "This plan provides a replicated Redis server with automatic failover through Redis Sentinel.",
+			Metadata: &brokerapi.ServicePlanMetadata{
+				Bullets: []string{
+					"Master and replicas on dedicated VMs",
+					"Automatic failover through Redis Sentinel",
+					"Suitable for production workloads that need high availability",
+				},
+				DisplayName: "HA-VM",
+			},
+		}
+	}
+
+	if redisServiceBroker.Config.ClusterEnabled() {
+		plans["cluster"] = &brokerapi.ServicePlan{
+			ID:          redisServiceBroker.Config.RedisConfiguration.ClusterVMPlanID,
+			Name:        PlanNameCluster,
+			Description: "This plan provides a Redis Cluster that spreads its data over several dedicated VMs.",
+			Metadata: &brokerapi.ServicePlanMetadata{
+				Bullets: []string{
+					"Hash slots spread over dedicated VMs",
+					"Requires a cluster-aware Redis client",
+					"Suitable for data sets larger than one VM's memory",
+				},
+				DisplayName: "Cluster-VM",
+			},
+		}
+	}
+
 	return plans
 }
 
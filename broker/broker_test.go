@@ -14,17 +14,19 @@ import (
 type fakeInstanceCreatorAndBinder struct {
 	createErr            error
 	createdInstanceIds   []string
+	createdParameters    []broker.ProvisionParameters
 	destroyErr           error
 	destroyedInstanceIds []string
 	instanceCredentials  broker.InstanceCredentials
 	bindingExists        bool
 }
 
-func (fakeInstanceCreatorAndBinder *fakeInstanceCreatorAndBinder) Create(instanceID string) error {
+func (fakeInstanceCreatorAndBinder *fakeInstanceCreatorAndBinder) Create(instanceID string, parameters broker.ProvisionParameters) error {
 	if fakeInstanceCreatorAndBinder.createErr != nil {
 		return fakeInstanceCreatorAndBinder.createErr
 	}
 	fakeInstanceCreatorAndBinder.createdInstanceIds = append(fakeInstanceCreatorAndBinder.createdInstanceIds, instanceID)
+	fakeInstanceCreatorAndBinder.createdParameters = append(fakeInstanceCreatorAndBinder.createdParameters, parameters)
 	return nil
 }
 
@@ -110,6 +112,32 @@ var _ = Describe("Redis service broker", func() {
 
 				Expect(len(someCreatorAndBinder.createdInstanceIds)).To(Equal(1))
 				Expect(someCreatorAndBinder.createdInstanceIds[0]).To(Equal(instanceID))
+				Expect(someCreatorAndBinder.createdParameters[0]).To(Equal(broker.ProvisionParameters{}))
+			})
+
+			It("passes the provision parameters to the instance creator", func() {
+				details := brokerapi.ProvisionDetails{
+					PlanID:        sharedPlanID,
+					RawParameters: []byte(`{"redis_version":"3.2"}`),
+				}
+
+				_, err := redisBroker.Provision(instanceID, details, false)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(someCreatorAndBinder.createdParameters[0].RedisVersion).To(Equal("3.2"))
+			})
+
+			Context("when the parameters are not valid JSON", func() {
+				It("returns brokerapi.ErrRawParamsInvalid", func() {
+					details := brokerapi.ProvisionDetails{
+						PlanID:        sharedPlanID,
+						RawParameters: []byte(`{"redis_version":`),
+					}
+
+					_, err := redisBroker.Provision(instanceID, details, false)
+					Expect(err).To(Equal(brokerapi.ErrRawParamsInvalid))
+					Expect(someCreatorAndBinder.createdInstanceIds).To(BeEmpty())
+				})
 			})
 
 			Context("when the instance already exists", func() {
@@ -136,6 +164,32 @@ var _ = Describe("Redis service broker", func() {
 			})
 		})
 
+		Context("when the plan is the ha plan", func() {
+			BeforeEach(func() {
+				redisBroker.Config.RedisConfiguration.HAVMPlanID = "ha-plan-id"
+				redisBroker.InstanceCreators["ha"] = someCreatorAndBinder
+			})
+
+			It("creates the instance with the ha instance creator", func() {
+				_, err := redisBroker.Provision(instanceID, brokerapi.ProvisionDetails{PlanID: "ha-plan-id"}, false)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(someCreatorAndBinder.createdInstanceIds).To(Equal([]string{instanceID}))
+			})
+		})
+
+		Context("when the plan is the cluster plan", func() {
+			BeforeEach(func() {
+				redisBroker.Config.RedisConfiguration.ClusterVMPlanID = "cluster-plan-id"
+				redisBroker.InstanceCreators["cluster"] = someCreatorAndBinder
+			})
+
+			It("creates the instance with the cluster instance creator", func() {
+				_, err := redisBroker.Provision(instanceID, brokerapi.ProvisionDetails{PlanID: "cluster-plan-id"}, false)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(someCreatorAndBinder.createdInstanceIds).To(Equal([]string{instanceID}))
+			})
+		})
+
 		Context("when the plan is not recognized", func() {
 			It("returns a suitable error", func() {
 				_, err := redisBroker.Provision(instanceID, brokerapi.ProvisionDetails{PlanID: "not_a_plan_id"}, false)
@@ -191,7 +245,7 @@ var _ = Describe("Redis service broker", func() {
 	Describe(".Bind", func() {
 		Context("when the instance exists", func() {
 			BeforeEach(func() {
-				someCreatorAndBinder.Create(instanceID)
+				someCreatorAndBinder.Create(instanceID, broker.ProvisionParameters{})
 			})
 
 			It("returns credentials", func() {
@@ -212,6 +266,81 @@ var _ = Describe("Redis service broker", func() {
 
 				Expect(credentials).To(Equal(expectedCredentials))
 			})
+
+			Context("when the instance has a unix socket", func() {
+				BeforeEach(func() {
+					someCreatorAndBinder.instanceCredentials.UnixSocket = "/var/vcap/sys/run/redis/instanceID.sock"
+				})
+
+				It("includes the socket path in the credentials", func() {
+					credentials, err := redisBroker.Bind(instanceID, "bindingID", brokerapi.BindDetails{})
+					Expect(err).NotTo(HaveOccurred())
+
+					Expect(credentials.Credentials).To(HaveKeyWithValue("unix_socket", "/var/vcap/sys/run/redis/instanceID.sock"))
+					Expect(credentials.Credentials).To(HaveKeyWithValue("host", host))
+				})
+			})
+
+			Context("when the redis version is known", func() {
+				BeforeEach(func() {
+					someCreatorAndBinder.instanceCredentials.Version = "3.2.8"
+				})
+
+				It("includes the version in the credentials", func() {
+					credentials, err := redisBroker.Bind(instanceID, "bindingID", brokerapi.BindDetails{})
+					Expect(err).NotTo(HaveOccurred())
+
+					Expect(credentials.Credentials).To(HaveKeyWithValue("redis_version", "3.2.8"))
+				})
+			})
+
+			Context("when the instance serves TLS", func() {
+				BeforeEach(func() {
+					someCreatorAndBinder.instanceCredentials.TLSPort = 16379
+				})
+
+				It("includes the TLS port in the credentials", func() {
+					credentials, err := redisBroker.Bind(instanceID, "bindingID", brokerapi.BindDetails{})
+					Expect(err).NotTo(HaveOccurred())
+
+					Expect(credentials.Credentials).To(HaveKeyWithValue("tls_port", 16379))
+				})
+			})
+
+			Context("when the instance is monitored by sentinel", func() {
+				BeforeEach(func() {
+					someCreatorAndBinder.instanceCredentials.Sentinels = []string{"10.0.0.1:26379", "10.0.0.2:26379"}
+					someCreatorAndBinder.instanceCredentials.MasterName = instanceID
+				})
+
+				It("includes the sentinels and master name in the credentials", func() {
+					credentials, err := redisBroker.Bind(instanceID, "bindingID", brokerapi.BindDetails{})
+					Expect(err).NotTo(HaveOccurred())
+
+					Expect(credentials.Credentials).To(HaveKeyWithValue("sentinels", []string{"10.0.0.1:26379", "10.0.0.2:26379"}))
+					Expect(credentials.Credentials).To(HaveKeyWithValue("master_name", instanceID))
+				})
+			})
+
+			Context("when the instance is a cluster", func() {
+				BeforeEach(func() {
+					someCreatorAndBinder.instanceCredentials.Nodes = []string{"10.0.0.1:6379", "10.0.0.2:6379", "10.0.0.3:6379"}
+				})
+
+				It("includes every node in the credentials", func() {
+					credentials, err := redisBroker.Bind(instanceID, "bindingID", brokerapi.BindDetails{})
+					Expect(err).NotTo(HaveOccurred())
+
+					Expect(credentials.Credentials).To(HaveKeyWithValue("nodes", []string{"10.0.0.1:6379", "10.0.0.2:6379", "10.0.0.3:6379"}))
+				})
+			})
+
+			It("leaves out sentinels for other instances", func() {
+				credentials, err := redisBroker.Bind(instanceID, "bindingID", brokerapi.BindDetails{})
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(credentials.Credentials).NotTo(HaveKey("sentinels"))
+			})
 		})
 
 		Context("when the instance does not exist", func() {
@@ -226,7 +355,7 @@ var _ = Describe("Redis service broker", func() {
 
 	Describe(".Unbind", func() {
 		BeforeEach(func() {
-			someCreatorAndBinder.Create(instanceID)
+			someCreatorAndBinder.Create(instanceID, broker.ProvisionParameters{})
 			_, err := redisBroker.Bind(instanceID, "EXISTANT-BINDING", brokerapi.BindDetails{})
 			Expect(err).NotTo(HaveOccurred())
 		})
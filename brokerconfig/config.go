@@ -3,8 +3,10 @@ package brokerconfig
 import (
 	"errors"
 	"fmt"
+	"net"
 	"os"
 	"regexp"
+	"strconv"
 	"strings"
 
 	"github.com/cloudfoundry-incubator/candiedyaml"
@@ -19,6 +21,7 @@ type Config struct {
 	RedisServerExecutablePath       string               `yaml:"redis_server_executable_path"`
 	AgentPort                       string               `yaml:"agent_port"`
 	ConsistencyVerificationInterval int                  `yaml:"consistency_check_interval_seconds"`
+	PendingResetInterval            int                  `yaml:"pending_reset_interval_seconds"`
 }
 
 type AuthConfiguration struct {
@@ -27,42 +30,151 @@ type AuthConfiguration struct {
 }
 
 type ServiceConfiguration struct {
-	ServiceName                 string    `yaml:"service_name"`
-	ServiceID                   string    `yaml:"service_id"`
-	DedicatedVMPlanID           string    `yaml:"dedicated_vm_plan_id"`
-	SharedVMPlanID              string    `yaml:"shared_vm_plan_id"`
-	Host                        string    `yaml:"host"`
-	DefaultConfigPath           string    `yaml:"redis_conf_path"`
-	ProcessCheckIntervalSeconds int       `yaml:"process_check_interval"`
-	StartRedisTimeoutSeconds    int       `yaml:"start_redis_timeout"`
-	InstanceDataDirectory       string    `yaml:"data_directory"`
-	PidfileDirectory            string    `yaml:"pidfile_directory"`
-	InstanceLogDirectory        string    `yaml:"log_directory"`
-	ServiceInstanceLimit        int       `yaml:"service_instance_limit"`
-	Dedicated                   Dedicated `yaml:"dedicated"`
-	Description                 string    `yaml:"description"`
-	LongDescription             string    `yaml:"long_description"`
-	ProviderDisplayName         string    `yaml:"provider_display_name"`
-	DocumentationURL            string    `yaml:"documentation_url"`
-	SupportURL                  string    `yaml:"support_url"`
-	DisplayName                 string    `yaml:"display_name"`
-	IconImage                   string    `yaml:"icon_image"`
+	ServiceName                     string        `yaml:"service_name"`
+	ServiceID                       string        `yaml:"service_id"`
+	DedicatedVMPlanID               string        `yaml:"dedicated_vm_plan_id"`
+	SharedVMPlanID                  string        `yaml:"shared_vm_plan_id"`
+	HAVMPlanID                      string        `yaml:"ha_vm_plan_id"`
+	ClusterVMPlanID                 string        `yaml:"cluster_vm_plan_id"`
+	Host                            string        `yaml:"host"`
+	DefaultConfigPath               string        `yaml:"redis_conf_path"`
+	ProcessCheckIntervalSeconds     int           `yaml:"process_check_interval"`
+	ProcessRestartBackoffSeconds    int           `yaml:"process_restart_backoff"`
+	ProcessMaxRestartBackoffSeconds int           `yaml:"process_max_restart_backoff"`
+	ProcessMaxRestartFailures       int           `yaml:"process_max_restart_failures"`
+	StartRedisTimeoutSeconds        int           `yaml:"start_redis_timeout"`
+	InstanceDataDirectory           string        `yaml:"data_directory"`
+	PidfileDirectory                string        `yaml:"pidfile_directory"`
+	InstanceLogDirectory            string        `yaml:"log_directory"`
+	ServiceInstanceLimit            int           `yaml:"service_instance_limit"`
+	Dedicated                       Dedicated     `yaml:"dedicated"`
+	HA                              HA            `yaml:"ha"`
+	Cluster                         Cluster       `yaml:"cluster"`
+	Cgroup                          Cgroup        `yaml:"cgroup"`
+	Shared                          Shared        `yaml:"shared"`
+	PortRange                       PortRange     `yaml:"port_range"`
+	RedisBinaries                   []RedisBinary `yaml:"redis_binaries"`
+	DefaultRedisBinary              string        `yaml:"default_redis_binary"`
+	Description                     string        `yaml:"description"`
+	LongDescription                 string        `yaml:"long_description"`
+	ProviderDisplayName             string        `yaml:"provider_display_name"`
+	DocumentationURL                string        `yaml:"documentation_url"`
+	SupportURL                      string        `yaml:"support_url"`
+	DisplayName                     string        `yaml:"display_name"`
+	IconImage                       string        `yaml:"icon_image"`
 }
 
 type Dedicated struct {
-	Nodes         []string `yaml:"nodes"`
-	Port          int      `yaml:"port"`
-	StatefilePath string   `yaml:"statefile_path"`
+	Nodes         []string                `yaml:"nodes"`
+	Port          int                     `yaml:"port"`
+	StatefilePath string                  `yaml:"statefile_path"`
+	Placement     string                  `yaml:"placement"`
+	NodeMetadata  map[string]NodeMetadata `yaml:"node_metadata"`
+}
+
+const (
+	PlacementFirstAvailable     = "first-available"
+	PlacementLeastRecentlyFreed = "least-recently-freed"
+	PlacementSpreadZones        = "spread-zones"
+)
+
+// NodeMetadata describes a dedicated node, keyed by its address, for the
+// placement strategies.
+type NodeMetadata struct {
+	Zone string `yaml:"zone"`
+}
+
+// HA configures the high availability plan, which allocates GroupSize
+// dedicated nodes per instance: a master and its replicas, each node running
+// a Sentinel on SentinelPort. The agents run the Sentinels and point them at
+// the group's master; SentinelPort must match their sentinel_port.
+type HA struct {
+	GroupSize    int `yaml:"group_size"`
+	SentinelPort int `yaml:"sentinel_port"`
+}
+
+// Cluster configures the Redis Cluster plan, which spreads the hash slots of
+// each instance over NodeCount dedicated nodes.
+type Cluster struct {
+	NodeCount int `yaml:"node_count"`
+}
+
+// Cgroup puts each shared instance in its own cgroup beneath Root. The
+// memory limit is derived from the plan's maxmemory in redis_conf_path unless
+// MemoryMaxMB sets it for every instance.
+type Cgroup struct {
+	Root        string `yaml:"root"`
+	MemoryMaxMB int    `yaml:"memory_max_mb"`
+	CPUWeight   int    `yaml:"cpu_weight"`
+	PidsMax     int    `yaml:"pids_max"`
+}
+
+// Shared configures the shared-vm plan. Host, when set, is what the plan's
+// instances are reached on instead of the service's host, and Network is
+// what they listen on.
+type Shared struct {
+	Host    string        `yaml:"host"`
+	Network SharedNetwork `yaml:"network"`
+}
+
+type SharedNetwork struct {
+	BindAddresses       []string `yaml:"bind_addresses"`
+	ProtectedMode       string   `yaml:"protected_mode"`
+	UnixSocketDirectory string   `yaml:"unix_socket_directory"`
+	UnixSocketPerm      string   `yaml:"unix_socket_perm"`
+}
+
+// PortRange is where shared instances get their ports, 16384-32767 when
+// unset, which keeps them out of the ephemeral range.
+type PortRange struct {
+	Min int `yaml:"min"`
+	Max int `yaml:"max"`
+}
+
+// RedisBinary is a named redis-server executable that shared instances can
+// be provisioned with, selected by the redis_version provision parameter.
+type RedisBinary struct {
+	Name           string `yaml:"name"`
+	ExecutablePath string `yaml:"executable_path"`
+}
+
+// SharedHost is the host of shared-vm instances.
+func (config ServiceConfiguration) SharedHost() string {
+	if config.Shared.Host != "" {
+		return config.Shared.Host
+	}
+	return config.Host
+}
+
+// RedisBinaryPaths maps binary names to executable paths.
+func (config ServiceConfiguration) RedisBinaryPaths() map[string]string {
+	paths := map[string]string{}
+	for _, binary := range config.RedisBinaries {
+		paths[binary.Name] = binary.ExecutablePath
+	}
+	return paths
 }
 
 func (config *Config) DedicatedEnabled() bool {
 	return len(config.RedisConfiguration.Dedicated.Nodes) > 0
 }
 
+func (config *Config) HAEnabled() bool {
+	return config.RedisConfiguration.HAVMPlanID != "" && config.DedicatedEnabled()
+}
+
+func (config *Config) ClusterEnabled() bool {
+	return config.RedisConfiguration.ClusterVMPlanID != "" && config.DedicatedEnabled()
+}
+
 func (config *Config) SharedEnabled() bool {
 	return config.RedisConfiguration.ServiceInstanceLimit > 0
 }
 
+func (config *Config) CgroupsEnabled() bool {
+	return config.RedisConfiguration.Cgroup.Root != ""
+}
+
 func ParseConfig(path string) (Config, error) {
 	file, err := os.Open(path)
 	if err != nil {
@@ -93,7 +205,37 @@ func ValidateConfig(config ServiceConfiguration) error {
 		return err
 	}
 
-	err = checkDedicatedNodesAreIPs(config.Dedicated.Nodes)
+	err = checkDedicatedNodes(config.Dedicated.Nodes)
+	if err != nil {
+		return err
+	}
+
+	err = checkPlacement(config.Dedicated.Placement)
+	if err != nil {
+		return err
+	}
+
+	err = checkProtectedMode(config.Shared.Network.ProtectedMode)
+	if err != nil {
+		return err
+	}
+
+	err = checkPortRange(config.PortRange)
+	if err != nil {
+		return err
+	}
+
+	err = checkRedisBinaries(config.RedisBinaries, config.DefaultRedisBinary)
+	if err != nil {
+		return err
+	}
+
+	err = checkHA(config.HAVMPlanID, config.HA)
+	if err != nil {
+		return err
+	}
+
+	err = checkCluster(config.ClusterVMPlanID, config.Cluster)
 	if err != nil {
 		return err
 	}
@@ -113,15 +255,152 @@ func checkPathExists(path string, description string) error {
 	return nil
 }
 
-func checkDedicatedNodesAreIPs(dedicatedNodes []string) error {
-	valid_ip_field := "(25[0-5]|2[0-4][0-9]|[01]?[0-9][0-9]?)"
-	ip_regex := fmt.Sprintf("^(%[1]s\\.){3}%[1]s$", valid_ip_field)
-
+func checkDedicatedNodes(dedicatedNodes []string) error {
 	for _, nodeAddress := range dedicatedNodes {
-		match, _ := regexp.MatchString(ip_regex, strings.TrimSpace(nodeAddress))
-		if !match {
-			return errors.New("The broker only supports IP addresses for dedicated nodes")
+		err := CheckDedicatedNode(nodeAddress)
+		if err != nil {
+			return err
 		}
 	}
 	return nil
 }
+
+var (
+	hostnameLabel = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?$`)
+	numericLabel  = regexp.MustCompile(`^[0-9]+$`)
+)
+
+// CheckDedicatedNode validates the address of a dedicated node, whether it
+// comes from the config or is added at runtime. Nodes may be IPv4 or IPv6
+// addresses, or DNS names which are resolved when connecting.
+func CheckDedicatedNode(nodeAddress string) error {
+	address := NormalizeDedicatedNode(nodeAddress)
+
+	if net.ParseIP(address) != nil {
+		return nil
+	}
+
+	if address != "" && len(address) <= 253 {
+		labels := strings.Split(strings.TrimSuffix(address, "."), ".")
+
+		// A numeric last label is a mistyped IP address rather than a name.
+		valid := !numericLabel.MatchString(labels[len(labels)-1])
+		for _, label := range labels {
+			if !hostnameLabel.MatchString(label) {
+				valid = false
+				break
+			}
+		}
+		if valid {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("Invalid dedicated node '%s', must be an IP address or hostname", nodeAddress)
+}
+
+var dottedQuad = regexp.MustCompile(`^[0-9]{1,3}(\.[0-9]{1,3}){3}$`)
+
+// NormalizeDedicatedNode gives each dedicated node a single spelling, so
+// that config entries, statefile entries and agent requests for the same
+// node compare equal. IPv4 addresses with leading zeros, which older
+// configs could contain, keep referring to the same node.
+func NormalizeDedicatedNode(nodeAddress string) string {
+	address := strings.TrimSpace(nodeAddress)
+	address = strings.TrimSuffix(strings.TrimPrefix(address, "["), "]")
+
+	if dottedQuad.MatchString(address) {
+		octets := strings.Split(address, ".")
+		for i, octet := range octets {
+			value, _ := strconv.Atoi(octet)
+			octets[i] = strconv.Itoa(value)
+		}
+		address = strings.Join(octets, ".")
+	}
+
+	if ip := net.ParseIP(address); ip != nil {
+		return ip.String()
+	}
+
+	return strings.ToLower(address)
+}
+
+func checkPlacement(placement string) error {
+	switch placement {
+	case "", PlacementFirstAvailable, PlacementLeastRecentlyFreed, PlacementSpreadZones:
+		return nil
+	}
+
+	return fmt.Errorf(
+		"Invalid dedicated placement '%s', must be one of %s, %s or %s",
+		placement,
+		PlacementFirstAvailable,
+		PlacementLeastRecentlyFreed,
+		PlacementSpreadZones,
+	)
+}
+
+func checkProtectedMode(protectedMode string) error {
+	switch protectedMode {
+	case "", "yes", "no":
+		return nil
+	}
+
+	return fmt.Errorf("Invalid protected_mode '%s', must be 'yes' or 'no'", protectedMode)
+}
+
+func checkPortRange(portRange PortRange) error {
+	if portRange.Min == 0 && portRange.Max == 0 {
+		return nil
+	}
+
+	if portRange.Min < 1024 || portRange.Max > 65535 || portRange.Min > portRange.Max {
+		return fmt.Errorf("Invalid port_range %d-%d", portRange.Min, portRange.Max)
+	}
+
+	return nil
+}
+
+func checkRedisBinaries(binaries []RedisBinary, defaultBinary string) error {
+	names := map[string]bool{}
+	for _, binary := range binaries {
+		if binary.Name == "" || binary.ExecutablePath == "" {
+			return errors.New("Every redis_binaries entry needs a name and an executable_path")
+		}
+
+		if names[binary.Name] {
+			return fmt.Errorf("Duplicate redis_binaries name '%s'", binary.Name)
+		}
+		names[binary.Name] = true
+	}
+
+	if defaultBinary != "" && !names[defaultBinary] {
+		return fmt.Errorf("Unknown default_redis_binary '%s'", defaultBinary)
+	}
+
+	return nil
+}
+
+func checkHA(planID string, ha HA) error {
+	if planID == "" {
+		return nil
+	}
+
+	if ha.GroupSize < 2 || ha.GroupSize > 3 {
+		return fmt.Errorf("Invalid ha group_size %d, must be 2 or 3", ha.GroupSize)
+	}
+
+	return nil
+}
+
+func checkCluster(planID string, cluster Cluster) error {
+	if planID == "" {
+		return nil
+	}
+
+	if cluster.NodeCount < 3 {
+		return fmt.Errorf("Invalid cluster node_count %d, must be at least 3", cluster.NodeCount)
+	}
+
+	return nil
+}
@@ -87,6 +87,8 @@ var _ = Describe("parsing the broker config file", func() {
 			It("loads plan ids", func() {
 				Ω(config.RedisConfiguration.DedicatedVMPlanID).To(Equal("id-for-dedicated-vm-plan"))
 				Ω(config.RedisConfiguration.SharedVMPlanID).To(Equal("id-for-shared-vm-plan"))
+				Ω(config.RedisConfiguration.HAVMPlanID).To(Equal("id-for-ha-vm-plan"))
+				Ω(config.RedisConfiguration.ClusterVMPlanID).To(Equal("id-for-cluster-vm-plan"))
 			})
 
 			It("loads the start Redis timeout", func() {
@@ -133,6 +135,10 @@ var _ = Describe("parsing the broker config file", func() {
 			It("loads the consistency verification interval", func() {
 				Ω(config.ConsistencyVerificationInterval).Should(Equal(123))
 			})
+
+			It("loads the pending reset interval", func() {
+				Ω(config.PendingResetInterval).Should(Equal(45))
+			})
 		})
 
 		Context("when the configuration is invalid", func() {
@@ -161,6 +167,78 @@ var _ = Describe("parsing the broker config file", func() {
 			It("sets the path to the statefile", func() {
 				Ω(config.RedisConfiguration.Dedicated.StatefilePath).Should(Equal("/tmp/redis-config-dir/statefile.json"))
 			})
+
+			It("loads the placement strategy and node metadata", func() {
+				Ω(config.RedisConfiguration.Dedicated.Placement).Should(Equal(brokerconfig.PlacementSpreadZones))
+				Ω(config.RedisConfiguration.Dedicated.NodeMetadata).Should(Equal(map[string]brokerconfig.NodeMetadata{
+					"10.0.0.1": {Zone: "z1"},
+					"10.0.0.2": {Zone: "z2"},
+					"10.0.0.3": {Zone: "z1"},
+				}))
+			})
+		})
+
+		Describe("cgroup", func() {
+			It("loads the cgroup root and limits", func() {
+				Ω(config.RedisConfiguration.Cgroup).Should(Equal(brokerconfig.Cgroup{
+					Root:        "/sys/fs/cgroup/cf-redis-broker",
+					MemoryMaxMB: 256,
+					CPUWeight:   50,
+					PidsMax:     64,
+				}))
+			})
+
+			It("enables cgroups", func() {
+				Ω(config.CgroupsEnabled()).Should(BeTrue())
+			})
+		})
+
+		Describe("shared", func() {
+			It("loads the shared plan's host and network", func() {
+				Ω(config.RedisConfiguration.Shared).Should(Equal(brokerconfig.Shared{
+					Host: "10.0.0.9",
+					Network: brokerconfig.SharedNetwork{
+						BindAddresses:       []string{"10.0.0.9", "127.0.0.1"},
+						ProtectedMode:       "yes",
+						UnixSocketDirectory: "/var/vcap/sys/run/redis-sockets",
+						UnixSocketPerm:      "770",
+					},
+				}))
+			})
+
+			It("reaches shared instances on the shared plan's host", func() {
+				Ω(config.RedisConfiguration.SharedHost()).Should(Equal("10.0.0.9"))
+			})
+		})
+
+		Describe("ha", func() {
+			It("loads the group size and sentinel port", func() {
+				Ω(config.RedisConfiguration.HA).Should(Equal(brokerconfig.HA{GroupSize: 3, SentinelPort: 26379}))
+			})
+
+			It("enables the ha plan", func() {
+				Ω(config.HAEnabled()).Should(BeTrue())
+			})
+		})
+
+		Describe("cluster", func() {
+			It("loads the node count", func() {
+				Ω(config.RedisConfiguration.Cluster.NodeCount).Should(Equal(3))
+			})
+
+			It("enables the cluster plan", func() {
+				Ω(config.ClusterEnabled()).Should(BeTrue())
+			})
+		})
+
+		Describe("redis binaries", func() {
+			It("loads the named binaries and the default", func() {
+				Ω(config.RedisConfiguration.RedisBinaryPaths()).Should(Equal(map[string]string{
+					"3.2": "/var/vcap/packages/redis-3.2/bin/redis-server",
+					"4.0": "/var/vcap/packages/redis-4.0/bin/redis-server",
+				}))
+				Ω(config.RedisConfiguration.DefaultRedisBinary).Should(Equal("3.2"))
+			})
 		})
 	})
 
@@ -238,22 +316,181 @@ var _ = Describe("parsing the broker config file", func() {
 			})
 		})
 
-		Describe("DedicatedNodesKnownByIP", func() {
-			Context("when redis.dedicated.nodes contains entries that aren't IPv4  addresses", func() {
+		Describe("SharedNetwork", func() {
+			Context("when protected_mode is not yes or no", func() {
 				BeforeEach(func() {
-					config.Dedicated = brokerconfig.Dedicated{
-						Nodes:         []string{"10.1.3.4", "10.1.3.40", "instance-id-50.dedicated-node.redis-z1.cf-cfapps-io2-redis.bosh"},
-						Port:          12345,
-						StatefilePath: "statefilepath",
-					}
+					config.Shared.Network.ProtectedMode = "true"
 				})
 
 				It("returns an error", func() {
 					err := brokerconfig.ValidateConfig(config)
-					Ω(err).To(HaveOccurred())
-					Ω(err.Error()).To(Equal("The broker only supports IP addresses for dedicated nodes"))
+					Ω(err).To(MatchError("Invalid protected_mode 'true', must be 'yes' or 'no'"))
+				})
+			})
+		})
+
+		Describe("PortRange", func() {
+			It("accepts a valid range", func() {
+				config.PortRange = brokerconfig.PortRange{Min: 32768, Max: 33768}
+				Ω(brokerconfig.ValidateConfig(config)).Should(Succeed())
+			})
+
+			Context("when the range is inverted", func() {
+				It("returns an error", func() {
+					config.PortRange = brokerconfig.PortRange{Min: 33768, Max: 32768}
+					Ω(brokerconfig.ValidateConfig(config)).Should(MatchError("Invalid port_range 33768-32768"))
+				})
+			})
+
+			Context("when the range includes privileged ports", func() {
+				It("returns an error", func() {
+					config.PortRange = brokerconfig.PortRange{Min: 80, Max: 32768}
+					Ω(brokerconfig.ValidateConfig(config)).Should(HaveOccurred())
+				})
+			})
+		})
+
+		Describe("RedisBinaries", func() {
+			BeforeEach(func() {
+				config.RedisBinaries = []brokerconfig.RedisBinary{
+					{Name: "3.2", ExecutablePath: "/redis-3.2/redis-server"},
+					{Name: "4.0", ExecutablePath: "/redis-4.0/redis-server"},
+				}
+				config.DefaultRedisBinary = "4.0"
+			})
+
+			It("accepts uniquely named binaries", func() {
+				Ω(brokerconfig.ValidateConfig(config)).Should(Succeed())
+			})
+
+			Context("when a name is used twice", func() {
+				It("returns an error", func() {
+					config.RedisBinaries[1].Name = "3.2"
+					Ω(brokerconfig.ValidateConfig(config)).Should(MatchError("Duplicate redis_binaries name '3.2'"))
+				})
+			})
+
+			Context("when an executable path is missing", func() {
+				It("returns an error", func() {
+					config.RedisBinaries[0].ExecutablePath = ""
+					Ω(brokerconfig.ValidateConfig(config)).Should(HaveOccurred())
 				})
 			})
+
+			Context("when the default is not one of the binaries", func() {
+				It("returns an error", func() {
+					config.DefaultRedisBinary = "5.0"
+					Ω(brokerconfig.ValidateConfig(config)).Should(MatchError("Unknown default_redis_binary '5.0'"))
+				})
+			})
+		})
+
+		Describe("HA", func() {
+			BeforeEach(func() {
+				config.HAVMPlanID = "ha-plan"
+				config.HA.GroupSize = 3
+			})
+
+			It("accepts groups of two or three nodes", func() {
+				Ω(brokerconfig.ValidateConfig(config)).Should(Succeed())
+			})
+
+			Context("when the group size is out of range", func() {
+				It("returns an error", func() {
+					config.HA.GroupSize = 5
+					Ω(brokerconfig.ValidateConfig(config)).Should(MatchError("Invalid ha group_size 5, must be 2 or 3"))
+				})
+			})
+
+			Context("when the ha plan is disabled", func() {
+				It("ignores the group size", func() {
+					config.HAVMPlanID = ""
+					config.HA.GroupSize = 0
+					Ω(brokerconfig.ValidateConfig(config)).Should(Succeed())
+				})
+			})
+		})
+
+		Describe("Cluster", func() {
+			BeforeEach(func() {
+				config.ClusterVMPlanID = "cluster-plan"
+				config.Cluster.NodeCount = 3
+			})
+
+			It("accepts three or more nodes", func() {
+				Ω(brokerconfig.ValidateConfig(config)).Should(Succeed())
+			})
+
+			Context("when there are fewer than three nodes", func() {
+				It("returns an error", func() {
+					config.Cluster.NodeCount = 2
+					Ω(brokerconfig.ValidateConfig(config)).Should(MatchError("Invalid cluster node_count 2, must be at least 3"))
+				})
+			})
+		})
+
+		Describe("dedicated node addresses", func() {
+			BeforeEach(func() {
+				config.Dedicated = brokerconfig.Dedicated{
+					Nodes: []string{
+						"10.1.3.4",
+						"fd00::10",
+						"[fd00::11]",
+						"instance-id-50.dedicated-node.redis-z1.cf-cfapps-io2-redis.bosh",
+					},
+					Port:          12345,
+					StatefilePath: "statefilepath",
+				}
+			})
+
+			It("accepts IPv4 and IPv6 addresses and hostnames", func() {
+				Ω(brokerconfig.ValidateConfig(config)).Should(Succeed())
+			})
+
+			for _, invalid := range []string{"", "300.1.2.3", "under_score.bosh", "-leading-hyphen", "10.1.3"} {
+				invalid := invalid
+
+				It("rejects '"+invalid+"'", func() {
+					config.Dedicated.Nodes = append(config.Dedicated.Nodes, invalid)
+					Ω(brokerconfig.ValidateConfig(config)).Should(MatchError(
+						"Invalid dedicated node '" + invalid + "', must be an IP address or hostname",
+					))
+				})
+			}
+		})
+
+		Describe("dedicated placement", func() {
+			It("accepts the known strategies", func() {
+				for _, placement := range []string{"", "first-available", "least-recently-freed", "spread-zones"} {
+					config.Dedicated.Placement = placement
+					Ω(brokerconfig.ValidateConfig(config)).Should(Succeed())
+				}
+			})
+
+			It("rejects unknown strategies", func() {
+				config.Dedicated.Placement = "random"
+				Ω(brokerconfig.ValidateConfig(config)).Should(MatchError(
+					"Invalid dedicated placement 'random', must be one of first-available, least-recently-freed or spread-zones",
+				))
+			})
+		})
+
+		Describe("NormalizeDedicatedNode", func() {
+			It("leaves IPv4 addresses alone", func() {
+				Ω(brokerconfig.NormalizeDedicatedNode("10.0.0.1")).Should(Equal("10.0.0.1"))
+			})
+
+			It("drops leading zeros from IPv4 addresses", func() {
+				Ω(brokerconfig.NormalizeDedicatedNode("010.000.000.001")).Should(Equal("10.0.0.1"))
+			})
+
+			It("gives IPv6 addresses their canonical form", func() {
+				Ω(brokerconfig.NormalizeDedicatedNode("[FD00:0:0::0010]")).Should(Equal("fd00::10"))
+			})
+
+			It("lowercases hostnames and trims whitespace", func() {
+				Ω(brokerconfig.NormalizeDedicatedNode(" Redis-0.Example.COM ")).Should(Equal("redis-0.example.com"))
+			})
 		})
 	})
 })
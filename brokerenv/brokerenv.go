@@ -0,0 +1,62 @@
+// Package brokerenv sets up what the broker, the process monitor, the
+// upgrader and the config migrator share: the broker config path and the
+// repository of shared-vm instances, both taken from the environment the
+// commands run in.
+package brokerenv
+
+import (
+	"os"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/pivotal-cf/cf-redis-broker/brokerconfig"
+	"github.com/pivotal-cf/cf-redis-broker/redis"
+	"github.com/pivotal-cf/cf-redis-broker/redisconf"
+)
+
+// ConfigPath returns BROKER_CONFIG_PATH and panics when it is not set.
+func ConfigPath() string {
+	brokerConfigYamlPath := os.Getenv("BROKER_CONFIG_PATH")
+	if brokerConfigYamlPath == "" {
+		panic("BROKER_CONFIG_PATH not set")
+	}
+	return brokerConfigYamlPath
+}
+
+// NewLocalRepository returns the repository of shared-vm instances, with
+// the pidfile directory overridden by SHARED_PID_DIR and the versions of the
+// configured redis-server binaries detected.
+func NewLocalRepository(config brokerconfig.Config, logger lager.Logger) *redis.LocalRepository {
+	repo := redis.NewLocalRepository(config.RedisConfiguration, logger)
+
+	pidDir := os.Getenv("SHARED_PID_DIR")
+	if pidDir != "" {
+		repo.RedisConf.PidfileDirectory = pidDir
+	}
+
+	repo.RedisVersion = detectRedisVersion(config.RedisServerExecutablePath, logger)
+	repo.BinaryVersions = detectBinaryVersions(config.RedisConfiguration.RedisBinaries, logger)
+
+	return repo
+}
+
+func detectRedisVersion(executablePath string, logger lager.Logger) redisconf.Version {
+	version, err := redis.DetectServerVersion(executablePath)
+	if err != nil {
+		logger.Error("detect-redis-version", err)
+		return redisconf.Version{}
+	}
+
+	logger.Info("detected-redis-version", lager.Data{
+		"version": version.String(),
+	})
+
+	return version
+}
+
+func detectBinaryVersions(binaries []brokerconfig.RedisBinary, logger lager.Logger) map[string]redisconf.Version {
+	versions := map[string]redisconf.Version{}
+	for _, binary := range binaries {
+		versions[binary.Name] = detectRedisVersion(binary.ExecutablePath, logger)
+	}
+	return versions
+}
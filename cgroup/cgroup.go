@@ -0,0 +1,98 @@
+package cgroup
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+var controllers = "+memory +cpu +pids"
+
+type Limits struct {
+	MemoryMaxBytes int64
+	CPUWeight      int
+	PidsMax        int
+}
+
+// Manager creates one cgroup v2 group per instance beneath Root. Root is
+// normally a directory in /sys/fs/cgroup, but any directory will do, which
+// allows tests to use a fake cgroupfs.
+type Manager struct {
+	Root string
+}
+
+func NewManager(root string) *Manager {
+	return &Manager{
+		Root: root,
+	}
+}
+
+func (manager *Manager) Path(name string) string {
+	return filepath.Join(manager.Root, name)
+}
+
+// Create makes the group for name and applies the limits. It is safe to call
+// for a group that already exists, in which case the limits are reapplied.
+func (manager *Manager) Create(name string, limits Limits) error {
+	if err := os.MkdirAll(manager.Root, 0755); err != nil {
+		return err
+	}
+
+	if err := manager.write(manager.Root, "cgroup.subtree_control", controllers); err != nil {
+		return err
+	}
+
+	groupPath := manager.Path(name)
+	if err := os.MkdirAll(groupPath, 0755); err != nil {
+		return err
+	}
+
+	if err := manager.write(groupPath, "memory.max", limitValue(limits.MemoryMaxBytes)); err != nil {
+		return err
+	}
+
+	if limits.CPUWeight > 0 {
+		if err := manager.write(groupPath, "cpu.weight", strconv.Itoa(limits.CPUWeight)); err != nil {
+			return err
+		}
+	}
+
+	return manager.write(groupPath, "pids.max", limitValue(int64(limits.PidsMax)))
+}
+
+func (manager *Manager) AddProcess(name string, pid int) error {
+	return manager.write(manager.Path(name), "cgroup.procs", strconv.Itoa(pid))
+}
+
+// Remove deletes the group for name. The kernel only allows a group to be
+// removed once all of its processes have exited. A group that does not exist
+// is not an error.
+func (manager *Manager) Remove(name string) error {
+	groupPath := manager.Path(name)
+
+	err := os.Remove(groupPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	return err
+}
+
+func (manager *Manager) write(dir, file, value string) error {
+	err := ioutil.WriteFile(filepath.Join(dir, file), []byte(value), 0644)
+	if err != nil {
+		return fmt.Errorf("writing %s to %s: %s", value, filepath.Join(dir, file), err)
+	}
+
+	return nil
+}
+
+func limitValue(limit int64) string {
+	if limit <= 0 {
+		return "max"
+	}
+
+	return strconv.FormatInt(limit, 10)
+}
@@ -0,0 +1,15 @@
+package cgroup_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/reporters"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestCgroup(t *testing.T) {
+	RegisterFailHandler(Fail)
+	junitReporter := reporters.NewJUnitReporter("junit_cgroup.xml")
+	RunSpecsWithDefaultAndCustomReporters(t, "Cgroup Suite", []Reporter{junitReporter})
+}
@@ -0,0 +1,143 @@
+package cgroup_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pivotal-cf/cf-redis-broker/cgroup"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Manager", func() {
+	var (
+		root    string
+		manager *cgroup.Manager
+		limits  cgroup.Limits
+	)
+
+	BeforeEach(func() {
+		var err error
+		root, err = ioutil.TempDir("", "cgroupfs")
+		Expect(err).NotTo(HaveOccurred())
+
+		manager = cgroup.NewManager(filepath.Join(root, "cf-redis-broker"))
+		limits = cgroup.Limits{
+			MemoryMaxBytes: 104857600,
+			CPUWeight:      50,
+			PidsMax:        64,
+		}
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(root)).To(Succeed())
+	})
+
+	readFile := func(path ...string) string {
+		contents, err := ioutil.ReadFile(filepath.Join(path...))
+		Expect(err).NotTo(HaveOccurred())
+		return string(contents)
+	}
+
+	Describe("Create", func() {
+		It("enables the controllers for the groups beneath the root", func() {
+			Expect(manager.Create("some-instance", limits)).To(Succeed())
+			Expect(readFile(manager.Root, "cgroup.subtree_control")).To(Equal("+memory +cpu +pids"))
+		})
+
+		It("writes the limits into the group", func() {
+			Expect(manager.Create("some-instance", limits)).To(Succeed())
+
+			groupPath := manager.Path("some-instance")
+			Expect(groupPath).To(BeADirectory())
+			Expect(readFile(groupPath, "memory.max")).To(Equal("104857600"))
+			Expect(readFile(groupPath, "cpu.weight")).To(Equal("50"))
+			Expect(readFile(groupPath, "pids.max")).To(Equal("64"))
+		})
+
+		It("can be called for an existing group", func() {
+			Expect(manager.Create("some-instance", limits)).To(Succeed())
+			limits.PidsMax = 128
+			Expect(manager.Create("some-instance", limits)).To(Succeed())
+
+			Expect(readFile(manager.Path("some-instance"), "pids.max")).To(Equal("128"))
+		})
+
+		Context("when limits are not set", func() {
+			BeforeEach(func() {
+				limits = cgroup.Limits{}
+			})
+
+			It("leaves memory and pids unlimited and the cpu weight at its default", func() {
+				Expect(manager.Create("some-instance", limits)).To(Succeed())
+
+				groupPath := manager.Path("some-instance")
+				Expect(readFile(groupPath, "memory.max")).To(Equal("max"))
+				Expect(readFile(groupPath, "pids.max")).To(Equal("max"))
+				Expect(filepath.Join(groupPath, "cpu.weight")).NotTo(BeAnExistingFile())
+			})
+		})
+
+		Context("when the root cannot be created", func() {
+			BeforeEach(func() {
+				Expect(ioutil.WriteFile(manager.Root, []byte{}, 0644)).To(Succeed())
+			})
+
+			It("returns an error", func() {
+				Expect(manager.Create("some-instance", limits)).NotTo(Succeed())
+			})
+		})
+	})
+
+	Describe("AddProcess", func() {
+		It("writes the pid to cgroup.procs", func() {
+			Expect(manager.Create("some-instance", limits)).To(Succeed())
+			Expect(manager.AddProcess("some-instance", 1234)).To(Succeed())
+
+			Expect(readFile(manager.Path("some-instance"), "cgroup.procs")).To(Equal("1234"))
+		})
+
+		Context("when the group does not exist", func() {
+			It("returns an error", func() {
+				Expect(manager.AddProcess("some-instance", 1234)).NotTo(Succeed())
+			})
+		})
+	})
+
+	Describe("Remove", func() {
+		// The kernel removes a group's interface files along with it; in a
+		// plain directory they have to go first.
+		removeInterfaceFiles := func(name string) {
+			files, err := ioutil.ReadDir(manager.Path(name))
+			Expect(err).NotTo(HaveOccurred())
+			for _, file := range files {
+				Expect(os.Remove(filepath.Join(manager.Path(name), file.Name()))).To(Succeed())
+			}
+		}
+
+		It("removes the group", func() {
+			Expect(manager.Create("some-instance", limits)).To(Succeed())
+			removeInterfaceFiles("some-instance")
+			Expect(manager.Remove("some-instance")).To(Succeed())
+
+			Expect(manager.Path("some-instance")).NotTo(BeADirectory())
+			Expect(manager.Root).To(BeADirectory())
+		})
+
+		Context("when the group does not exist", func() {
+			It("does not return an error", func() {
+				Expect(manager.Remove("some-instance")).To(Succeed())
+			})
+		})
+
+		Context("when the group cannot be removed", func() {
+			It("returns the error", func() {
+				Expect(manager.Create("some-instance", limits)).To(Succeed())
+				Expect(manager.Remove("some-instance")).NotTo(Succeed())
+				Expect(manager.Path("some-instance")).To(BeADirectory())
+			})
+		})
+	})
+})
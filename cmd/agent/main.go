@@ -5,6 +5,8 @@ import (
 	"net"
 	"net/http"
 	"os"
+	"path/filepath"
+	"sync"
 	"time"
 
 	"code.cloudfoundry.org/lager"
@@ -12,8 +14,12 @@ import (
 	"github.com/pivotal-cf/cf-redis-broker/agentapi"
 	"github.com/pivotal-cf/cf-redis-broker/agentconfig"
 	"github.com/pivotal-cf/cf-redis-broker/availability"
+	"github.com/pivotal-cf/cf-redis-broker/confwatch"
+	"github.com/pivotal-cf/cf-redis-broker/redis"
 	"github.com/pivotal-cf/cf-redis-broker/redisconf"
+	"github.com/pivotal-cf/cf-redis-broker/replication"
 	"github.com/pivotal-cf/cf-redis-broker/resetter"
+	"github.com/pivotal-cf/redisutils/monit"
 )
 
 type portChecker struct{}
@@ -37,48 +43,122 @@ func main() {
 		})
 	}
 
-	templateRedisConf(config, logger)
+	version := detectRedisVersion(config, logger)
+	templateRedisConf(config, version, logger)
+
+	// Resets, replication changes and the config watcher all rewrite
+	// redis.conf, one at a time.
+	confLock := new(sync.Mutex)
 
 	redisResetter := resetter.New(
 		config.DefaultConfPath,
 		config.ConfPath,
 		portChecker{},
 	)
-	redisResetter.Monit.SetExecutable(config.MonitExecutablePath)
+	redisResetter.Process = processControl(config, logger)
+	redisResetter.ConfLock = confLock
+	redisResetter.Logger = logger
+	redisResetter.FastReset = config.ResetMode == agentconfig.ResetModeFast
+	redisResetter.Version = version
+	redisResetter.Features = redisFeatures(config)
+
+	if config.Snapshots.Directory != "" {
+		retention := 24 * time.Hour
+		if config.Snapshots.RetentionHours > 0 {
+			retention = time.Duration(config.Snapshots.RetentionHours) * time.Hour
+		}
+		redisResetter.Snapshots = &resetter.Snapshots{
+			Dir:       config.Snapshots.Directory,
+			Retention: retention,
+		}
+	}
+
+	watchInterval := time.Duration(config.ConfigWatchInterval) * time.Second
+	if watchInterval <= 0 {
+		watchInterval = 30 * time.Second
+	}
+	watcher := confwatch.New(
+		config.DefaultConfPath,
+		config.ConfPath,
+		func() (redisconf.Conf, error) { return renderRedisConf(config, version) },
+		logger,
+	)
+	watcher.ConfLock = confLock
+	go watcher.Watch(watchInterval, nil)
+
+	sentinelConfPath := config.SentinelConfPath
+	if sentinelConfPath == "" {
+		sentinelConfPath = filepath.Join(filepath.Dir(config.ConfPath), "sentinel.conf")
+	}
+	sentinel := replication.NewSentinel(
+		config.RedisServerExecutablePath,
+		sentinelConfPath,
+		config.SentinelPort,
+		logger,
+	)
+	go func() {
+		if err := sentinel.Run(nil); err != nil {
+			logger.Fatal("Error running sentinel", err, lager.Data{
+				"conf_path": sentinelConfPath,
+			})
+		}
+	}()
+
+	replicationManager := replication.New(config.ConfPath, config.SentinelPort)
+	replicationManager.ConfLock = confLock
 
 	handler := auth.NewWrapper(
 		config.AuthConfiguration.Username,
 		config.AuthConfiguration.Password,
 	).Wrap(
-		agentapi.New(redisResetter, config.ConfPath),
+		agentapi.New(
+			redisResetter,
+			replicationManager,
+			watcher,
+			config.ConfPath,
+		),
 	)
 
 	http.Handle("/", handler)
 	logger.Fatal("http-listen", http.ListenAndServe("localhost:"+config.Port, nil))
 }
 
-func templateRedisConf(config *agentconfig.Config, logger lager.Logger) {
-	newConfig, err := redisconf.Load(config.DefaultConfPath)
-	if err != nil {
-		logger.Fatal("Error loading default redis.conf", err, lager.Data{
-			"path": config.DefaultConfPath,
-		})
-	}
-
-	if fileExists(config.ConfPath) {
-		existingConf, err := redisconf.Load(config.ConfPath)
-		if err != nil {
-			logger.Fatal("Error loading existing redis.conf", err, lager.Data{
-				"path": config.ConfPath,
-			})
+func processControl(config *agentconfig.Config, logger lager.Logger) resetter.ProcessControl {
+	switch config.ProcessControl {
+	case "", agentconfig.ProcessControlMonit:
+		redisMonit := monit.New()
+		redisMonit.SetExecutable(config.MonitExecutablePath)
+		return &resetter.MonitProcess{Monit: redisMonit, Name: "redis"}
+	case agentconfig.ProcessControlSystemd:
+		unit := config.SystemdUnit
+		if unit == "" {
+			unit = "redis-server"
+		}
+		return &resetter.SystemdProcess{Unit: unit}
+	case agentconfig.ProcessControlExec:
+		process := &resetter.ExecProcess{
+			Path: config.RedisServerExecutablePath,
+			Args: []string{config.ConfPath},
 		}
-		err = newConfig.InitForDedicatedNode(existingConf.Password())
-	} else {
-		err = newConfig.InitForDedicatedNode()
+		if err := process.Start(); err != nil {
+			logger.Fatal("Error starting redis-server", err)
+		}
+		return process
+	default:
+		logger.Fatal("Invalid process_control", nil, lager.Data{
+			"process_control": config.ProcessControl,
+		})
+		return nil
 	}
+}
 
+func templateRedisConf(config *agentconfig.Config, version redisconf.Version, logger lager.Logger) {
+	newConfig, err := renderRedisConf(config, version)
 	if err != nil {
-		logger.Fatal("Error initializing redis conf for dedicated node", err)
+		logger.Fatal("Error rendering redis.conf", err, lager.Data{
+			"default_conf_path": config.DefaultConfPath,
+			"conf_path":         config.ConfPath,
+		})
 	}
 
 	err = newConfig.Save(config.ConfPath)
@@ -94,6 +174,65 @@ func templateRedisConf(config *agentconfig.Config, logger lager.Logger) {
 	})
 }
 
+// renderRedisConf builds redis.conf from the default redis.conf, keeping
+// the password and group settings of the node's existing redis.conf.
+func renderRedisConf(config *agentconfig.Config, version redisconf.Version) (redisconf.Conf, error) {
+	if !fileExists(config.ConfPath) {
+		return redisconf.RenderForDedicatedNode(config.DefaultConfPath, version, redisFeatures(config))
+	}
+
+	existingConf, err := redisconf.Load(config.ConfPath)
+	if err != nil {
+		return nil, err
+	}
+
+	newConfig, err := redisconf.RenderForDedicatedNode(config.DefaultConfPath, version, redisFeatures(config), existingConf.Password())
+	if err != nil {
+		return nil, err
+	}
+
+	keepGroupSettings(&newConfig, existingConf)
+	return newConfig, nil
+}
+
+// detectRedisVersion leaves redis.conf unfiltered when the version of
+// redis-server cannot be told.
+func detectRedisVersion(config *agentconfig.Config, logger lager.Logger) redisconf.Version {
+	version, err := redis.DetectServerVersion(config.RedisServerExecutablePath)
+	if err != nil {
+		logger.Error("Error detecting redis version, leaving redis.conf unfiltered", err)
+		return redisconf.Version{}
+	}
+
+	logger.Info("Rendering redis.conf for redis version", lager.Data{
+		"version": version.String(),
+	})
+	return version
+}
+
+func redisFeatures(config *agentconfig.Config) redisconf.Features {
+	return redisconf.Features{
+		LazyFree: true,
+		TLS: redisconf.TLS{
+			Port:       config.TLS.Port,
+			CertFile:   config.TLS.CertFile,
+			KeyFile:    config.TLS.KeyFile,
+			CACertFile: config.TLS.CACertFile,
+		},
+	}
+}
+
+// keepGroupSettings carries over the replication and cluster settings of a
+// node in a high availability group or cluster, which are not part of the
+// default redis.conf.
+func keepGroupSettings(newConfig *redisconf.Conf, existingConf redisconf.Conf) {
+	for _, key := range []string{"slaveof", "replicaof", "masterauth", "cluster-enabled", "cluster-config-file"} {
+		if existingConf.HasKey(key) {
+			newConfig.Set(key, existingConf.Get(key))
+		}
+	}
+}
+
 func fileExists(path string) bool {
 	_, err := os.Stat(path)
 	return err == nil || os.IsExist(err)
@@ -14,16 +14,17 @@ import (
 	"github.com/pivotal-cf/cf-redis-broker/availability"
 	"github.com/pivotal-cf/cf-redis-broker/broker"
 	"github.com/pivotal-cf/cf-redis-broker/brokerconfig"
+	"github.com/pivotal-cf/cf-redis-broker/brokerenv"
 	"github.com/pivotal-cf/cf-redis-broker/consistency"
 	"github.com/pivotal-cf/cf-redis-broker/debug"
+	"github.com/pivotal-cf/cf-redis-broker/nodes"
 	"github.com/pivotal-cf/cf-redis-broker/process"
 	"github.com/pivotal-cf/cf-redis-broker/redis"
 	"github.com/pivotal-cf/cf-redis-broker/redisinstance"
-	"github.com/pivotal-cf/cf-redis-broker/system"
 )
 
 func main() {
-	brokerConfigPath := configPath()
+	brokerConfigPath := brokerenv.ConfigPath()
 
 	brokerLogger := lager.NewLogger("redis-broker")
 	brokerLogger.RegisterSink(lager.NewWriterSink(os.Stdout, lager.DEBUG))
@@ -40,8 +41,7 @@ func main() {
 		})
 	}
 
-	localRepo := redis.NewLocalRepository(config.RedisConfiguration, brokerLogger)
-	setPidDir(localRepo)
+	localRepo := brokerenv.NewLocalRepository(config, brokerLogger)
 	localRepo.AllInstancesVerbose()
 
 	processController := redis.NewOSProcessController(
@@ -53,9 +53,17 @@ func main() {
 		availability.Check,
 		"",
 	)
+	processController.Cgroups = localRepo.Cgroups
+	processController.RedisBinaries = config.RedisConfiguration.RedisBinaryPaths()
+
+	portAllocator := redis.NewLocalPortAllocator(
+		localRepo,
+		config.RedisConfiguration.PortRange.Min,
+		config.RedisConfiguration.PortRange.Max,
+	)
 
 	localCreator := &redis.LocalInstanceCreator{
-		FindFreePort:            system.FindFreePort,
+		PortAllocator:           portAllocator,
 		RedisConfiguration:      config.RedisConfiguration,
 		ProcessController:       processController,
 		LocalInstanceRepository: localRepo,
@@ -73,6 +81,15 @@ func main() {
 		brokerLogger.Fatal("Error initializing remote repository", err)
 	}
 
+	haRepo := redis.NewHARepository(remoteRepo, agentClient, config, brokerLogger)
+	clusterRepo := redis.NewClusterRepository(remoteRepo, agentClient, config, brokerLogger)
+
+	pendingResetInterval := time.Minute
+	if config.PendingResetInterval > 0 {
+		pendingResetInterval = time.Duration(config.PendingResetInterval) * time.Second
+	}
+	go remoteRepo.KeepResetting(pendingResetInterval, nil)
+
 	if config.ConsistencyVerificationInterval > 0 {
 		interval := time.Duration(config.ConsistencyVerificationInterval) * time.Second
 
@@ -99,10 +116,14 @@ func main() {
 		InstanceCreators: map[string]broker.InstanceCreator{
 			"shared":    localCreator,
 			"dedicated": remoteRepo,
+			"ha":        haRepo,
+			"cluster":   clusterRepo,
 		},
 		InstanceBinders: map[string]broker.InstanceBinder{
 			"shared":    localRepo,
 			"dedicated": remoteRepo,
+			"ha":        haRepo,
+			"cluster":   clusterRepo,
 		},
 		Config: config,
 	}
@@ -115,27 +136,25 @@ func main() {
 	brokerAPI := brokerapi.New(serviceBroker, brokerLogger, brokerCredentials)
 
 	authWrapper := auth.NewWrapper(brokerCredentials.Username, brokerCredentials.Password)
-	debugHandler := authWrapper.WrapFunc(debug.NewHandler(remoteRepo))
+	debugHandler := authWrapper.WrapFunc(debug.NewHandler(
+		remoteRepo,
+		localRepo,
+		new(process.ProcessChecker),
+		redis.ReadInstanceStats,
+	))
 	instanceHandler := authWrapper.WrapFunc(redisinstance.NewHandler(remoteRepo))
+	instanceStatsHandler := authWrapper.Wrap(redisinstance.NewStatsHandler(remoteRepo, agentClient))
+	failoverHandler := authWrapper.WrapFunc(redisinstance.NewFailoverHandler(haRepo))
+	nodesHandler := authWrapper.WrapFunc(nodes.NewHandler(remoteRepo))
 
 	http.HandleFunc("/instance", instanceHandler)
+	http.Handle("/instance/", instanceStatsHandler)
+	http.HandleFunc("/failover", failoverHandler)
+	http.HandleFunc("/nodes", nodesHandler)
+	http.HandleFunc("/nodes/drain", nodesHandler)
+	http.HandleFunc("/nodes/quarantine", nodesHandler)
 	http.HandleFunc("/debug", debugHandler)
 	http.Handle("/", brokerAPI)
 
 	brokerLogger.Fatal("http-listen", http.ListenAndServe(config.Host+":"+config.Port, nil))
 }
-
-func configPath() string {
-	brokerConfigYamlPath := os.Getenv("BROKER_CONFIG_PATH")
-	if brokerConfigYamlPath == "" {
-		panic("BROKER_CONFIG_PATH not set")
-	}
-	return brokerConfigYamlPath
-}
-
-func setPidDir(localRepo *redis.LocalRepository) {
-	pidDir := os.Getenv("SHARED_PID_DIR")
-	if pidDir != "" {
-		localRepo.RedisConf.PidfileDirectory = pidDir
-	}
-}
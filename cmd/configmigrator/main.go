@@ -4,12 +4,13 @@ import (
 	"os"
 
 	"github.com/pivotal-cf/cf-redis-broker/brokerconfig"
+	"github.com/pivotal-cf/cf-redis-broker/brokerenv"
 	"github.com/pivotal-cf/cf-redis-broker/configmigrator"
 	"code.cloudfoundry.org/lager"
 )
 
 func main() {
-	brokerConfigPath := configPath()
+	brokerConfigPath := brokerenv.ConfigPath()
 
 	log := lager.NewLogger("redis-configmigrator")
 	log.RegisterSink(lager.NewWriterSink(os.Stdout, lager.DEBUG))
@@ -33,11 +34,3 @@ func main() {
 		log.Fatal("Could not migrate data", err)
 	}
 }
-
-func configPath() string {
-	brokerConfigYamlPath := os.Getenv("BROKER_CONFIG_PATH")
-	if brokerConfigYamlPath == "" {
-		panic("BROKER_CONFIG_PATH not set")
-	}
-	return brokerConfigYamlPath
-}
@@ -3,14 +3,16 @@ package main
 import (
 	"os"
 	"os/signal"
-	"path/filepath"
 	"syscall"
 	"time"
 
 	"code.cloudfoundry.org/lager"
 	"github.com/pivotal-cf/cf-redis-broker/availability"
 	"github.com/pivotal-cf/cf-redis-broker/brokerconfig"
+	"github.com/pivotal-cf/cf-redis-broker/brokerenv"
+	"github.com/pivotal-cf/cf-redis-broker/filelock"
 	"github.com/pivotal-cf/cf-redis-broker/process"
+	"github.com/pivotal-cf/cf-redis-broker/processmonitor"
 	"github.com/pivotal-cf/cf-redis-broker/redis"
 )
 
@@ -28,26 +30,33 @@ func main() {
 		skipProcessCheck = true
 	}()
 
-	config, err := brokerconfig.ParseConfig(configPath())
+	maintenanceChannel := make(chan os.Signal, 1)
+	signal.Notify(maintenanceChannel, syscall.SIGUSR2)
+
+	config, err := brokerconfig.ParseConfig(brokerenv.ConfigPath())
 	if err != nil {
 		logger.Fatal("could not parse config file", err, lager.Data{
-			"config-path": configPath(),
+			"config-path": brokerenv.ConfigPath(),
 		})
 	}
 
 	logger.Info("Starting process monitor")
 
-	repo := redis.NewLocalRepository(config.RedisConfiguration, logger)
-	setPidDir(repo)
-	processController := redis.NewOSProcessController(
+	repo := brokerenv.NewLocalRepository(config, logger)
+	supervisor := processmonitor.NewSupervisor(
 		logger,
 		repo,
 		new(process.ProcessChecker),
-		new(process.ProcessKiller),
 		redis.PingServer,
 		availability.Check,
-		"",
+		process.CommandLine,
+		config.RedisServerExecutablePath,
+		time.Duration(config.RedisConfiguration.StartRedisTimeoutSeconds)*time.Second,
 	)
+	supervisor.Cgroups = repo.Cgroups
+	supervisor.RedisBinaries = config.RedisConfiguration.RedisBinaryPaths()
+
+	monitor := processmonitor.New(logger, repo, supervisor, config.RedisConfiguration)
 
 	checkInterval := config.RedisConfiguration.ProcessCheckIntervalSeconds
 
@@ -64,62 +73,87 @@ func main() {
 			instances, _ := repo.AllInstances()
 
 			for _, instance := range instances {
-				ensureRunningIfNotLocked(instance, repo, processController, logger)
+				ensureRunningIfNotLocked(instance, repo, monitor, logger)
 			}
 		}
 
-		time.Sleep(time.Second * time.Duration(checkInterval))
+		select {
+		case <-maintenanceChannel:
+			logger.Info("Trapped USR2, stopping instances for host maintenance")
+			skipProcessCheck = true
+			stopAllInstances(repo, supervisor, logger)
+		case <-time.After(time.Second * time.Duration(checkInterval)):
+		}
 	}
 }
 
-func ensureRunningIfNotLocked(instance *redis.Instance, repo *redis.LocalRepository, processController *redis.OSProcessController, logger lager.Logger) {
-	_, err := os.Stat(filepath.Join(repo.InstanceBaseDir(instance.ID), "lock"))
+func stopAllInstances(repo *redis.LocalRepository, supervisor *processmonitor.Supervisor, logger lager.Logger) {
+	instances, _ := repo.AllInstances()
+
+	for _, instance := range instances {
+		err := supervisor.Stop(instance, true)
+		if err != nil {
+			logger.Error("Error stopping instance", err, lager.Data{
+				"instance": instance.ID,
+			})
+			continue
+		}
+
+		logger.Info("Stopped instance", lager.Data{
+			"instance": instance.ID,
+		})
+	}
+}
+
+// ensureRunningIfNotLocked holds the instance lock while checking the
+// instance, so that the broker cannot provision or deprovision it mid-check.
+func ensureRunningIfNotLocked(instance *redis.Instance, repo *redis.LocalRepository, monitor *processmonitor.Monitor, logger lager.Logger) {
+	err := repo.TryLock(instance)
+	if err == filelock.ErrLocked || os.IsNotExist(err) {
+		return
+	}
+
 	if err != nil {
-		ensureRunning(instance, repo, processController, logger)
+		logger.Error("Error locking instance", err, lager.Data{
+			"instance": instance.ID,
+		})
+		return
 	}
+	defer repo.Unlock(instance)
+
+	ensureRunning(instance, monitor, logger)
 }
 
 func copyConfigFile(instance *redis.Instance, repo *redis.LocalRepository, logger lager.Logger) {
 	err := repo.EnsureDirectoriesExist(instance)
 	if err != nil {
-		logger.Fatal("Error creating instance directories", err, lager.Data{
+		logger.Error("Error creating instance directories", err, lager.Data{
 			"instance": instance.ID,
 		})
+		return
 	}
 
 	err = repo.WriteConfigFile(instance)
 	if err != nil {
-		logger.Fatal("Error writing redis config", err, lager.Data{
+		logger.Error("Error writing redis config", err, lager.Data{
 			"instance": instance.ID,
 		})
+		return
 	}
-}
-
-func ensureRunning(instance *redis.Instance, repo *redis.LocalRepository, processController *redis.OSProcessController, logger lager.Logger) {
-	configPath := repo.InstanceConfigPath(instance.ID)
-	instanceDataDir := repo.InstanceDataDir(instance.ID)
-	pidfilePath := repo.InstancePidFilePath(instance.ID)
-	logfilePath := repo.InstanceLogFilePath(instance.ID)
 
-	err := processController.EnsureRunning(instance, configPath, instanceDataDir, pidfilePath, logfilePath)
+	err = repo.EnsureCgroup(instance)
 	if err != nil {
-		logger.Fatal("Error starting instance", err, lager.Data{
+		logger.Error("Error creating instance cgroup", err, lager.Data{
 			"instance": instance.ID,
 		})
 	}
 }
 
-func configPath() string {
-	brokerConfigYamlPath := os.Getenv("BROKER_CONFIG_PATH")
-	if brokerConfigYamlPath == "" {
-		panic("BROKER_CONFIG_PATH not set")
-	}
-	return brokerConfigYamlPath
-}
-
-func setPidDir(localRepo *redis.LocalRepository) {
-	pidDir := os.Getenv("SHARED_PID_DIR")
-	if pidDir != "" {
-		localRepo.RedisConf.PidfileDirectory = pidDir
+func ensureRunning(instance *redis.Instance, monitor *processmonitor.Monitor, logger lager.Logger) {
+	err := monitor.Check(instance)
+	if err != nil {
+		logger.Error("Error supervising instance", err, lager.Data{
+			"instance": instance.ID,
+		})
 	}
 }
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/pivotal-cf/cf-redis-broker/availability"
+	"github.com/pivotal-cf/cf-redis-broker/brokerconfig"
+	"github.com/pivotal-cf/cf-redis-broker/brokerenv"
+	"github.com/pivotal-cf/cf-redis-broker/process"
+	"github.com/pivotal-cf/cf-redis-broker/redis"
+	"github.com/pivotal-cf/cf-redis-broker/upgrader"
+)
+
+func main() {
+	redisBinary := flag.String("redisBinary", "", "Name of the redis_binaries entry to upgrade to, empty for redis_server_executable_path")
+	statePath := flag.String("statePath", "/var/vcap/store/redis/upgrade.json", "Where to record progress for resuming")
+	flag.Parse()
+
+	logger := lager.NewLogger("redis-upgrader")
+	logger.RegisterSink(lager.NewWriterSink(os.Stdout, lager.DEBUG))
+	logger.RegisterSink(lager.NewWriterSink(os.Stderr, lager.ERROR))
+
+	config, err := brokerconfig.ParseConfig(brokerenv.ConfigPath())
+	if err != nil {
+		logger.Fatal("could not parse config file", err, lager.Data{
+			"config-path": brokerenv.ConfigPath(),
+		})
+	}
+
+	if _, found := config.RedisConfiguration.RedisBinaryPaths()[*redisBinary]; *redisBinary != "" && !found {
+		logger.Fatal("unknown redis binary", fmt.Errorf("no redis_binaries entry named '%s'", *redisBinary))
+	}
+
+	repo := brokerenv.NewLocalRepository(config, logger)
+
+	processController := redis.NewOSProcessController(
+		logger,
+		repo,
+		new(process.ProcessChecker),
+		new(process.ProcessKiller),
+		redis.PingServer,
+		availability.Check,
+		config.RedisServerExecutablePath,
+	)
+	processController.Cgroups = repo.Cgroups
+	processController.RedisBinaries = config.RedisConfiguration.RedisBinaryPaths()
+
+	err = upgrader.New(logger, repo, processController, *statePath, config.RedisConfiguration).Upgrade(*redisBinary)
+	if err != nil {
+		logger.Fatal("upgrade failed", err)
+	}
+}
@@ -0,0 +1,15 @@
+package confwatch_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/reporters"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestConfwatch(t *testing.T) {
+	RegisterFailHandler(Fail)
+	junitReporter := reporters.NewJUnitReporter("junit_confwatch.xml")
+	RunSpecsWithDefaultAndCustomReporters(t, "Config Watcher Suite", []Reporter{junitReporter})
+}
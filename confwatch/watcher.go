@@ -0,0 +1,247 @@
+package confwatch
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/pivotal-cf/cf-redis-broker/agentapi"
+	"github.com/pivotal-cf/cf-redis-broker/redis/client"
+	"github.com/pivotal-cf/cf-redis-broker/redisconf"
+)
+
+// RenderFunc builds the redis.conf the node should run with from the
+// default redis.conf, keeping whatever the node's own redis.conf has to keep.
+type RenderFunc func() (redisconf.Conf, error)
+
+type ConnectFunc func(port int, password string, aliases map[string]string) (client.Client, error)
+
+// unmanaged settings are set per node by resets, replication and clustering,
+// or cannot be read back with CONFIG GET.
+var unmanaged = map[string]bool{
+	"requirepass":    true,
+	"masterauth":     true,
+	"slaveof":        true,
+	"replicaof":      true,
+	"rename-command": true,
+	"include":        true,
+	"loadmodule":     true,
+}
+
+// restartRequired settings cannot be changed with CONFIG SET, or move the
+// data of the running redis, which is left to a restart.
+var restartRequired = map[string]bool{
+	"always-show-logo":    true,
+	"appenddirname":       true,
+	"appendfilename":      true,
+	"bind":                true,
+	"cluster-config-file": true,
+	"cluster-enabled":     true,
+	"daemonize":           true,
+	"databases":           true,
+	"dbfilename":          true,
+	"dir":                 true,
+	"io-threads":          true,
+	"io-threads-do-reads": true,
+	"logfile":             true,
+	"pidfile":             true,
+	"port":                true,
+	"supervised":          true,
+	"syslog-enabled":      true,
+	"syslog-facility":     true,
+	"syslog-ident":        true,
+	"tcp-backlog":         true,
+	"unixsocket":          true,
+	"unixsocketperm":      true,
+}
+
+// Watcher keeps the local redis in line with the default redis.conf. When
+// the default changes, the node's redis.conf is templated again, so that a
+// restart picks the change up. Every check compares the desired settings
+// with the running ones, applies the ones CONFIG SET can change and reports
+// the ones that need a restart.
+type Watcher struct {
+	DefaultConfPath string
+	ConfPath        string
+	Render          RenderFunc
+	Connect         ConnectFunc
+	Logger          lager.Logger
+	ConfLock        sync.Locker
+
+	lock         sync.RWMutex
+	desired      redisconf.Conf
+	lastModified time.Time
+	drift        agentapi.ConfigDriftResponse
+}
+
+func New(defaultConfPath, confPath string, render RenderFunc, logger lager.Logger) *Watcher {
+	return &Watcher{
+		DefaultConfPath: defaultConfPath,
+		ConfPath:        confPath,
+		Render:          render,
+		Connect:         connect,
+		Logger:          logger,
+		ConfLock:        new(sync.Mutex),
+	}
+}
+
+func connect(port int, password string, aliases map[string]string) (client.Client, error) {
+	return client.Connect(
+		client.Port(port),
+		client.Password(password),
+		client.CmdAliases(aliases),
+	)
+}
+
+// Drift returns the result of the last check.
+func (watcher *Watcher) Drift() agentapi.ConfigDriftResponse {
+	watcher.lock.RLock()
+	defer watcher.lock.RUnlock()
+
+	return watcher.drift
+}
+
+// Watch checks every interval until stop is closed.
+func (watcher *Watcher) Watch(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			watcher.Check()
+		}
+	}
+}
+
+// Check templates redis.conf again if the default has changed, then
+// corrects the drift of the running redis. ConfLock is held throughout, so
+// that a reset does not change the password between the render and the save.
+func (watcher *Watcher) Check() error {
+	drift := agentapi.ConfigDriftResponse{
+		CheckedAt:       time.Now(),
+		Applied:         []agentapi.ConfigSetting{},
+		RequiresRestart: []agentapi.ConfigSetting{},
+	}
+
+	watcher.ConfLock.Lock()
+	err := watcher.reload()
+	if err == nil {
+		err = watcher.correct(&drift)
+	}
+	watcher.ConfLock.Unlock()
+
+	if err != nil {
+		watcher.Logger.Error("config-check-failed", err)
+		drift.Error = err.Error()
+	}
+
+	watcher.lock.Lock()
+	watcher.drift = drift
+	watcher.lock.Unlock()
+
+	return err
+}
+
+func (watcher *Watcher) reload() error {
+	info, err := os.Stat(watcher.DefaultConfPath)
+	if err != nil {
+		return err
+	}
+
+	if watcher.desired != nil && info.ModTime().Equal(watcher.lastModified) {
+		return nil
+	}
+
+	desired, err := watcher.Render()
+	if err != nil {
+		return err
+	}
+
+	current, err := ioutil.ReadFile(watcher.ConfPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if !bytes.Equal(current, desired.Encode()) {
+		if err := desired.Save(watcher.ConfPath); err != nil {
+			return err
+		}
+		watcher.Logger.Info("redis-conf-templated", lager.Data{
+			"default_conf_path": watcher.DefaultConfPath,
+			"conf_path":         watcher.ConfPath,
+		})
+	}
+
+	watcher.desired = desired
+	watcher.lastModified = info.ModTime()
+	return nil
+}
+
+func (watcher *Watcher) correct(drift *agentapi.ConfigDriftResponse) error {
+	conf, err := redisconf.Load(watcher.ConfPath)
+	if err != nil {
+		return err
+	}
+
+	redis, err := watcher.Connect(conf.Port(), conf.Password(), conf.CommandAliases())
+	if err != nil {
+		return err
+	}
+	defer redis.Disconnect()
+
+	for _, key := range managedKeys(watcher.desired) {
+		desired, err := watcher.desired.Setting(key)
+		if err != nil {
+			continue
+		}
+
+		// Settings this redis does not know are not drift.
+		live, err := redis.GetConfig(key)
+		if err != nil {
+			continue
+		}
+
+		if redisconf.NormalizeSetting(strings.Fields(live)) == redisconf.NormalizeSetting(desired) {
+			continue
+		}
+
+		setting := agentapi.ConfigSetting{Key: key, Desired: strings.Join(desired, " "), Live: live}
+		if restartRequired[key] {
+			drift.RequiresRestart = append(drift.RequiresRestart, setting)
+			continue
+		}
+
+		if err := redis.SetConfig(key, setting.Desired); err != nil {
+			watcher.Logger.Error("config-set-failed", err, lager.Data{"key": key})
+			drift.RequiresRestart = append(drift.RequiresRestart, setting)
+			continue
+		}
+
+		watcher.Logger.Info("config-drift-corrected", lager.Data{
+			"key":     key,
+			"desired": setting.Desired,
+			"live":    setting.Live,
+		})
+		drift.Applied = append(drift.Applied, setting)
+	}
+
+	return nil
+}
+
+// managedKeys returns each setting of conf once, in the order of conf.
+func managedKeys(conf redisconf.Conf) []string {
+	keys := []string{}
+	for _, key := range conf.Keys() {
+		if !unmanaged[key] {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
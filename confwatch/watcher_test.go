@@ -0,0 +1,201 @@
+package confwatch_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"code.cloudfoundry.org/lager/lagertest"
+	"github.com/pivotal-cf/cf-redis-broker/agentapi"
+	"github.com/pivotal-cf/cf-redis-broker/confwatch"
+	"github.com/pivotal-cf/cf-redis-broker/redis/client"
+	"github.com/pivotal-cf/cf-redis-broker/redis/client/fakes"
+	"github.com/pivotal-cf/cf-redis-broker/redisconf"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+)
+
+var _ = Describe("Watcher", func() {
+	var (
+		tmpDir          string
+		defaultConfPath string
+		confPath        string
+		renders         int
+		fakeRedis       *fakes.Client
+		connectedWith   string
+		logger          *lagertest.TestLogger
+		watcher         *confwatch.Watcher
+		checkErr        error
+	)
+
+	writeDefaultConf := func(contents string) {
+		Expect(ioutil.WriteFile(defaultConfPath, []byte(contents), 0644)).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		var err error
+		tmpDir, err = ioutil.TempDir("", "confwatch")
+		Expect(err).NotTo(HaveOccurred())
+
+		defaultConfPath = filepath.Join(tmpDir, "redis.conf-default")
+		confPath = filepath.Join(tmpDir, "redis.conf")
+		writeDefaultConf("port 6379\nmaxmemory-policy allkeys-lru\nsave 900 1\nsave 300 10\nmaxmemory 100mb\ndatabases 16\n")
+
+		renders = 0
+		fakeRedis = &fakes.Client{GetConfigReturns: map[string]string{
+			"port":             "6379",
+			"maxmemory-policy": "allkeys-lru",
+			"save":             "900 1 300 10",
+			"maxmemory":        "104857600",
+			"databases":        "16",
+		}}
+		logger = lagertest.NewTestLogger("confwatch")
+
+		watcher = confwatch.New(defaultConfPath, confPath, func() (redisconf.Conf, error) {
+			renders++
+			conf, err := redisconf.Load(defaultConfPath)
+			if err != nil {
+				return nil, err
+			}
+			conf.Set("requirepass", "secret")
+			return conf, nil
+		}, logger)
+		watcher.Connect = func(port int, password string, aliases map[string]string) (client.Client, error) {
+			connectedWith = password
+			return fakeRedis, nil
+		}
+	})
+
+	JustBeforeEach(func() {
+		checkErr = watcher.Check()
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(tmpDir)
+	})
+
+	It("templates redis.conf from the default", func() {
+		Expect(checkErr).NotTo(HaveOccurred())
+
+		conf, err := redisconf.Load(confPath)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(conf.Get("maxmemory-policy")).To(Equal("allkeys-lru"))
+		Expect(connectedWith).To(Equal("secret"))
+	})
+
+	It("reports no drift when redis runs with the desired settings", func() {
+		drift := watcher.Drift()
+		Expect(drift.Applied).To(BeEmpty())
+		Expect(drift.RequiresRestart).To(BeEmpty())
+		Expect(drift.Error).To(BeEmpty())
+		Expect(fakeRedis.SetConfigCalls).To(BeEmpty())
+	})
+
+	It("does not compare the password", func() {
+		Expect(fakeRedis.SetConfigCalls).NotTo(ContainElement(HavePrefix("requirepass")))
+	})
+
+	It("renders again only when the default changes", func() {
+		Expect(watcher.Check()).To(Succeed())
+		Expect(renders).To(Equal(1))
+
+		writeDefaultConf("port 6379\nmaxmemory-policy noeviction\n")
+		later := time.Now().Add(time.Minute)
+		Expect(os.Chtimes(defaultConfPath, later, later)).To(Succeed())
+
+		Expect(watcher.Check()).To(Succeed())
+		Expect(renders).To(Equal(2))
+
+		conf, err := redisconf.Load(confPath)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(conf.Get("maxmemory-policy")).To(Equal("noeviction"))
+		Expect(fakeRedis.SetConfigCalls).To(Equal([]string{"maxmemory-policy noeviction"}))
+	})
+
+	It("waits for whoever holds the conf lock", func() {
+		watcher.ConfLock.Lock()
+
+		done := make(chan error)
+		go func() {
+			done <- watcher.Check()
+		}()
+
+		Consistently(done).ShouldNot(Receive())
+		watcher.ConfLock.Unlock()
+		Eventually(done).Should(Receive(BeNil()))
+	})
+
+	Context("when a setting has been changed live", func() {
+		BeforeEach(func() {
+			fakeRedis.GetConfigReturns["maxmemory-policy"] = "noeviction"
+			fakeRedis.GetConfigReturns["save"] = ""
+		})
+
+		It("changes it back", func() {
+			Expect(fakeRedis.SetConfigCalls).To(Equal([]string{
+				"maxmemory-policy allkeys-lru",
+				"save 900 1 300 10",
+			}))
+			Expect(watcher.Drift().Applied).To(Equal([]agentapi.ConfigSetting{
+				{Key: "maxmemory-policy", Desired: "allkeys-lru", Live: "noeviction"},
+				{Key: "save", Desired: "900 1 300 10", Live: ""},
+			}))
+			Expect(logger).To(gbytes.Say("config-drift-corrected"))
+		})
+	})
+
+	Context("when a setting needs a restart", func() {
+		BeforeEach(func() {
+			fakeRedis.GetConfigReturns["databases"] = "32"
+		})
+
+		It("flags it without changing it", func() {
+			Expect(fakeRedis.SetConfigCalls).To(BeEmpty())
+			Expect(watcher.Drift().RequiresRestart).To(Equal([]agentapi.ConfigSetting{
+				{Key: "databases", Desired: "16", Live: "32"},
+			}))
+		})
+	})
+
+	Context("when redis refuses a setting", func() {
+		BeforeEach(func() {
+			fakeRedis.GetConfigReturns["maxmemory-policy"] = "noeviction"
+			fakeRedis.SetConfigErrs = map[string]error{"maxmemory-policy": errors.New("ERR Unsupported CONFIG parameter")}
+		})
+
+		It("flags it as needing a restart", func() {
+			Expect(watcher.Drift().Applied).To(BeEmpty())
+			Expect(watcher.Drift().RequiresRestart).To(HaveLen(1))
+			Expect(logger).To(gbytes.Say("config-set-failed"))
+		})
+	})
+
+	Context("when redis does not know a setting", func() {
+		BeforeEach(func() {
+			delete(fakeRedis.GetConfigReturns, "maxmemory-policy")
+		})
+
+		It("is not drift", func() {
+			Expect(watcher.Drift().Applied).To(BeEmpty())
+			Expect(watcher.Drift().RequiresRestart).To(BeEmpty())
+		})
+	})
+
+	Context("when redis cannot be reached", func() {
+		BeforeEach(func() {
+			watcher.Connect = func(int, string, map[string]string) (client.Client, error) {
+				return nil, errors.New("connection refused")
+			}
+		})
+
+		It("reports the error", func() {
+			Expect(checkErr).To(MatchError("connection refused"))
+			Expect(watcher.Drift().Error).To(Equal("connection refused"))
+			Expect(watcher.Drift().CheckedAt).NotTo(BeZero())
+		})
+	})
+})
@@ -4,14 +4,28 @@ import (
 	"encoding/json"
 	"net/http"
 
+	"github.com/pivotal-cf/cf-redis-broker/processmonitor"
 	"github.com/pivotal-cf/cf-redis-broker/redis"
 )
 
-func NewHandler(repo *redis.RemoteRepository) http.HandlerFunc {
+type SharedRepository interface {
+	AllInstances() ([]*redis.Instance, []error)
+	InstancePid(instanceID string) (int, error)
+	InstanceRestartHistoryPath(instanceID string) string
+}
+
+type InstanceStatsFunc func(instance *redis.Instance) (redis.InstanceStats, error)
+
+func NewHandler(
+	repo *redis.RemoteRepository,
+	sharedRepo SharedRepository,
+	processChecker redis.ProcessChecker,
+	instanceStats InstanceStatsFunc,
+) http.HandlerFunc {
 	return func(res http.ResponseWriter, req *http.Request) {
 		res.Header().Add("Content-Type", "application/json")
 
-		debugInfoBytes, err := buildDebugInfoBytes(repo)
+		debugInfoBytes, err := buildDebugInfoBytes(repo, sharedRepo, processChecker, instanceStats)
 
 		if err != nil {
 			res.Write([]byte(http.StatusText(http.StatusInternalServerError)))
@@ -28,8 +42,10 @@ type Binding struct {
 
 type Cluster struct {
 	ID       string
-	Hosts    []string  `json:"hosts"`
-	Bindings []Binding `json:"bindings"`
+	Plan     string            `json:"plan,omitempty"`
+	Hosts    []string          `json:"hosts"`
+	Slots    map[string]string `json:"slots,omitempty"`
+	Bindings []Binding         `json:"bindings"`
 }
 
 type Pool struct {
@@ -42,20 +58,49 @@ type Allocated struct {
 	Clusters []Cluster `json:"clusters"`
 }
 
+type SharedInstance struct {
+	ID           string `json:"id"`
+	Port         int    `json:"port"`
+	PID          int    `json:"pid"`
+	Alive        bool   `json:"alive"`
+	UsedMemory   int64  `json:"used_memory"`
+	Keycount     int    `json:"key_count"`
+	RedisVersion string `json:"redis_version,omitempty"`
+	RedisBinary  string `json:"redis_binary,omitempty"`
+	Error        string `json:"error,omitempty"`
+
+	RestartHistory *processmonitor.History `json:"restart_history,omitempty"`
+}
+
+type Shared struct {
+	Count     int              `json:"count"`
+	Instances []SharedInstance `json:"instances"`
+	Errors    []string         `json:"errors,omitempty"`
+}
+
 type Info struct {
-	Pool      Pool      `json:"pool"`
-	Allocated Allocated `json:"allocated"`
+	Pool          Pool                 `json:"pool"`
+	Allocated     Allocated            `json:"allocated"`
+	PendingResets []redis.PendingReset `json:"pending_resets"`
+	Shared        Shared               `json:"shared"`
 }
 
-func buildDebugInfoBytes(repo *redis.RemoteRepository) ([]byte, error) {
+func buildDebugInfoBytes(
+	repo *redis.RemoteRepository,
+	sharedRepo SharedRepository,
+	processChecker redis.ProcessChecker,
+	instanceStats InstanceStatsFunc,
+) ([]byte, error) {
 	allocatedInfo, err := getAllocatedInfo(repo)
 	if err != nil {
 		return nil, err
 	}
 
 	return json.Marshal(&Info{
-		Pool:      getPoolInfo(repo),
-		Allocated: allocatedInfo,
+		Pool:          getPoolInfo(repo),
+		Allocated:     allocatedInfo,
+		PendingResets: repo.PendingResets(),
+		Shared:        getSharedInfo(sharedRepo, processChecker, instanceStats),
 	})
 }
 
@@ -103,7 +148,76 @@ func getAllocatedInfo(repo *redis.RemoteRepository) (Allocated, error) {
 		allocated.Clusters = append(allocated.Clusters, c)
 	}
 
-	allocated.Count = len(allocatedInstances)
+	for _, group := range repo.AllGroups() {
+		bindingIDs, err := repo.BindingsForInstance(group.ID)
+		if err != nil {
+			return allocated, err
+		}
+
+		c := Cluster{
+			ID:    group.ID,
+			Plan:  group.Plan,
+			Hosts: group.Hosts,
+			Slots: group.Slots,
+		}
+
+		for _, id := range bindingIDs {
+			c.Bindings = append(c.Bindings, Binding{ID: id})
+		}
+
+		allocated.Clusters = append(allocated.Clusters, c)
+	}
+
+	allocated.Count = len(allocated.Clusters)
 
 	return allocated, nil
 }
+
+func getSharedInfo(sharedRepo SharedRepository, processChecker redis.ProcessChecker, instanceStats InstanceStatsFunc) Shared {
+	shared := Shared{Instances: []SharedInstance{}}
+
+	instances, errs := sharedRepo.AllInstances()
+	for _, err := range errs {
+		shared.Errors = append(shared.Errors, err.Error())
+	}
+
+	for _, instance := range instances {
+		sharedInstance := SharedInstance{
+			ID:          instance.ID,
+			Port:        instance.Port,
+			RedisBinary: instance.RedisBinary,
+		}
+
+		history, err := processmonitor.LoadHistory(sharedRepo.InstanceRestartHistoryPath(instance.ID))
+		if err == nil {
+			sharedInstance.RestartHistory = history
+		}
+
+		pid, err := sharedRepo.InstancePid(instance.ID)
+		if err != nil {
+			sharedInstance.Error = err.Error()
+			shared.Instances = append(shared.Instances, sharedInstance)
+			continue
+		}
+
+		sharedInstance.PID = pid
+		sharedInstance.Alive = processChecker.Alive(pid)
+
+		if sharedInstance.Alive {
+			stats, err := instanceStats(instance)
+			if err != nil {
+				sharedInstance.Error = err.Error()
+			}
+
+			sharedInstance.UsedMemory = stats.UsedMemory
+			sharedInstance.Keycount = stats.Keycount
+			sharedInstance.RedisVersion = stats.Version
+		}
+
+		shared.Instances = append(shared.Instances, sharedInstance)
+	}
+
+	shared.Count = len(instances)
+
+	return shared
+}
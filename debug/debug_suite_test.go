@@ -1,10 +1,12 @@
 package debug_test
 
 import (
+	"io/ioutil"
 	"net/http"
 	"os"
 	"path"
 	"path/filepath"
+	"strconv"
 	"time"
 
 	. "github.com/onsi/ginkgo"
@@ -13,6 +15,8 @@ import (
 	"github.com/pivotal-cf/cf-redis-broker/brokerconfig"
 	"github.com/pivotal-cf/cf-redis-broker/debug"
 	"github.com/pivotal-cf/cf-redis-broker/integration/helpers"
+	"github.com/pivotal-cf/cf-redis-broker/process"
+	"github.com/pivotal-cf/cf-redis-broker/processmonitor"
 	"github.com/pivotal-cf/cf-redis-broker/redis"
 	"code.cloudfoundry.org/lager"
 
@@ -30,7 +34,7 @@ var dirs []string
 var _ = BeforeSuite(func() {
 	helpers.ResetTestDirs()
 
-	dirs = []string{"/tmp/to/redis", "/tmp/redis/data/directory", "/tmp/redis/log/directory"}
+	dirs = []string{"/tmp/to/redis", "/tmp/redis/data/directory", "/tmp/redis/log/directory", "/tmp/redis/pidfiles"}
 	for _, dir := range dirs {
 		err := os.MkdirAll(dir, 0755)
 		Ω(err).ShouldNot(HaveOccurred())
@@ -46,7 +50,10 @@ var _ = BeforeSuite(func() {
 	repo, err := redis.NewRemoteRepository(&redis.RemoteAgentClient{}, config, logger)
 	Ω(err).NotTo(HaveOccurred())
 
-	handler := debug.NewHandler(repo)
+	localRepo := redis.NewLocalRepository(config.RedisConfiguration, logger)
+	writeSharedInstance(localRepo, "shared-instance-id", 6390, os.Getpid())
+
+	handler := debug.NewHandler(repo, localRepo, new(process.ProcessChecker), fakeInstanceStats)
 
 	http.HandleFunc("/debug", handler)
 	go func() {
@@ -74,3 +81,23 @@ var _ = AfterSuite(func() {
 		Ω(err).ShouldNot(HaveOccurred())
 	}
 })
+
+func writeSharedInstance(localRepo *redis.LocalRepository, instanceID string, port, pid int) {
+	err := os.MkdirAll(localRepo.InstanceBaseDir(instanceID), 0755)
+	Ω(err).ShouldNot(HaveOccurred())
+
+	conf := []byte("port " + strconv.Itoa(port) + "\nrequirepass secret\n")
+	err = ioutil.WriteFile(localRepo.InstanceConfigPath(instanceID), conf, 0644)
+	Ω(err).ShouldNot(HaveOccurred())
+
+	err = ioutil.WriteFile(localRepo.InstancePidFilePath(instanceID), []byte(strconv.Itoa(pid)), 0644)
+	Ω(err).ShouldNot(HaveOccurred())
+
+	history := &processmonitor.History{State: processmonitor.StateCrashLoop, Restarts: 2}
+	err = history.Save(localRepo.InstanceRestartHistoryPath(instanceID))
+	Ω(err).ShouldNot(HaveOccurred())
+}
+
+func fakeInstanceStats(instance *redis.Instance) (redis.InstanceStats, error) {
+	return redis.InstanceStats{UsedMemory: 1024, Keycount: 5, Version: "3.2.8"}, nil
+}
@@ -2,6 +2,7 @@ package debug_test
 
 import (
 	"encoding/json"
+	"os"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -44,6 +45,26 @@ var _ = Describe("Handler", func() {
 					} `json:"bindings"`
 				} `json:"clusters"`
 			} `json:"allocated"`
+			PendingResets []struct {
+				Host string `json:"host"`
+			} `json:"pending_resets"`
+			Shared struct {
+				Count     int `json:"count"`
+				Instances []struct {
+					ID           string `json:"id"`
+					Port         int    `json:"port"`
+					PID          int    `json:"pid"`
+					Alive        bool   `json:"alive"`
+					UsedMemory   int64  `json:"used_memory"`
+					Keycount     int    `json:"key_count"`
+					RedisVersion string `json:"redis_version"`
+
+					RestartHistory struct {
+						State    string `json:"state"`
+						Restarts int    `json:"restarts"`
+					} `json:"restart_history"`
+				} `json:"instances"`
+			} `json:"shared"`
 		}
 
 		BeforeEach(func() {
@@ -62,5 +83,26 @@ var _ = Describe("Handler", func() {
 			Ω(len(debugInfo.Pool.Clusters)).Should(Equal(3))
 			Ω(len(debugInfo.Allocated.Clusters)).Should(Equal(0))
 		})
+
+		It("has no pending resets", func() {
+			Ω(debugInfo.PendingResets).ShouldNot(BeNil())
+			Ω(debugInfo.PendingResets).Should(BeEmpty())
+		})
+
+		It("has the shared instances", func() {
+			Ω(debugInfo.Shared.Count).Should(Equal(1))
+			Ω(debugInfo.Shared.Instances).Should(HaveLen(1))
+
+			instance := debugInfo.Shared.Instances[0]
+			Ω(instance.ID).Should(Equal("shared-instance-id"))
+			Ω(instance.Port).Should(Equal(6390))
+			Ω(instance.PID).Should(Equal(os.Getpid()))
+			Ω(instance.Alive).Should(BeTrue())
+			Ω(instance.UsedMemory).Should(Equal(int64(1024)))
+			Ω(instance.Keycount).Should(Equal(5))
+			Ω(instance.RedisVersion).Should(Equal("3.2.8"))
+			Ω(instance.RestartHistory.State).Should(Equal("crash-loop"))
+			Ω(instance.RestartHistory.Restarts).Should(Equal(2))
+		})
 	})
 })
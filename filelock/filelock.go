@@ -0,0 +1,184 @@
+package filelock
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"syscall"
+	"time"
+)
+
+const pollInterval = 100 * time.Millisecond
+
+var ErrLocked = errors.New("lock is held by another process")
+
+// Owner is written into the lock file by the process holding the lock.
+type Owner struct {
+	PID      int       `json:"pid"`
+	Acquired time.Time `json:"acquired"`
+}
+
+// Lock is an advisory flock on a file. The kernel releases it when the
+// holder exits, so a lock file left behind by a crashed process can be
+// acquired straight away.
+type Lock struct {
+	path string
+	file *os.File
+}
+
+// TryAcquire takes the lock without blocking, returning ErrLocked if another
+// process holds it. A lock file that nobody holds a flock on but that names
+// a live owner acquired less than staleAfter ago is also treated as held;
+// such files are left by lock holders that do not use flock.
+func TryAcquire(path string, staleAfter time.Duration) (*Lock, error) {
+	for {
+		file, created, err := open(path)
+		if err != nil {
+			return nil, err
+		}
+
+		err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == syscall.EWOULDBLOCK {
+			file.Close()
+			return nil, ErrLocked
+		}
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+
+		// The previous holder removes the file on release, so the flock may
+		// have been taken on a file that is no longer at path.
+		if !isCurrent(file, path) {
+			file.Close()
+			continue
+		}
+
+		if !created && !isStale(file, staleAfter) {
+			file.Close()
+			return nil, ErrLocked
+		}
+
+		lock := &Lock{path: path, file: file}
+		if err := lock.writeOwner(); err != nil {
+			file.Close()
+			return nil, err
+		}
+
+		return lock, nil
+	}
+}
+
+// Acquire retries TryAcquire until it succeeds or timeout elapses.
+func Acquire(path string, staleAfter, timeout time.Duration) (*Lock, error) {
+	deadline := time.Now().Add(timeout)
+
+	for {
+		lock, err := TryAcquire(path, staleAfter)
+		if err != ErrLocked || time.Now().After(deadline) {
+			return lock, err
+		}
+
+		time.Sleep(pollInterval)
+	}
+}
+
+// Release removes the lock file and drops the flock. A lock file that has
+// already been removed, e.g. along with its directory, is not an error.
+func (lock *Lock) Release() error {
+	err := os.Remove(lock.path)
+	if err != nil && !os.IsNotExist(err) {
+		lock.file.Close()
+		return err
+	}
+
+	return lock.file.Close()
+}
+
+func ReadOwner(path string) (Owner, error) {
+	owner := Owner{}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return owner, err
+	}
+
+	err = json.Unmarshal(data, &owner)
+	return owner, err
+}
+
+func (lock *Lock) writeOwner() error {
+	data, err := json.Marshal(Owner{PID: os.Getpid(), Acquired: time.Now()})
+	if err != nil {
+		return err
+	}
+
+	if err := lock.file.Truncate(0); err != nil {
+		return err
+	}
+
+	if _, err := lock.file.WriteAt(data, 0); err != nil {
+		return err
+	}
+
+	return lock.file.Sync()
+}
+
+func open(path string) (*os.File, bool, error) {
+	for {
+		file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			return file, true, nil
+		}
+
+		if !os.IsExist(err) {
+			return nil, false, err
+		}
+
+		file, err = os.OpenFile(path, os.O_RDWR, 0644)
+		if os.IsNotExist(err) {
+			// removed by its holder in the meantime
+			continue
+		}
+
+		return file, false, err
+	}
+}
+
+func isCurrent(file *os.File, path string) bool {
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return false
+	}
+
+	pathInfo, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+
+	return os.SameFile(fileInfo, pathInfo)
+}
+
+func isStale(file *os.File, staleAfter time.Duration) bool {
+	owner, err := ReadOwner(file.Name())
+	if err != nil {
+		fileInfo, err := file.Stat()
+		if err != nil {
+			return true
+		}
+
+		return time.Since(fileInfo.ModTime()) > staleAfter
+	}
+
+	return !processAlive(owner.PID) || time.Since(owner.Acquired) > staleAfter
+}
+
+func processAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+
+	err := syscall.Kill(pid, syscall.Signal(0))
+	return err == nil || err == syscall.EPERM
+}
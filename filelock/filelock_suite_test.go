@@ -0,0 +1,15 @@
+package filelock_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/reporters"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestFilelock(t *testing.T) {
+	RegisterFailHandler(Fail)
+	junitReporter := reporters.NewJUnitReporter("junit_filelock.xml")
+	RunSpecsWithDefaultAndCustomReporters(t, "Filelock Suite", []Reporter{junitReporter})
+}
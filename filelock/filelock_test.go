@@ -0,0 +1,171 @@
+package filelock_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pivotal-cf/cf-redis-broker/filelock"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Filelock", func() {
+	var (
+		dir      string
+		lockPath string
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "filelock")
+		Expect(err).NotTo(HaveOccurred())
+
+		lockPath = filepath.Join(dir, "lock")
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	writeOwner := func(owner filelock.Owner) {
+		data, err := json.Marshal(owner)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ioutil.WriteFile(lockPath, data, 0644)).To(Succeed())
+	}
+
+	Describe("TryAcquire", func() {
+		It("creates the lock file with the owner", func() {
+			lock, err := filelock.TryAcquire(lockPath, time.Minute)
+			Expect(err).NotTo(HaveOccurred())
+			defer lock.Release()
+
+			owner, err := filelock.ReadOwner(lockPath)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(owner.PID).To(Equal(os.Getpid()))
+			Expect(owner.Acquired).To(BeTemporally("~", time.Now(), time.Second))
+		})
+
+		It("fails while the lock is held", func() {
+			lock, err := filelock.TryAcquire(lockPath, time.Minute)
+			Expect(err).NotTo(HaveOccurred())
+			defer lock.Release()
+
+			_, err = filelock.TryAcquire(lockPath, time.Minute)
+			Expect(err).To(Equal(filelock.ErrLocked))
+		})
+
+		It("succeeds once the lock is released", func() {
+			lock, err := filelock.TryAcquire(lockPath, time.Minute)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(lock.Release()).To(Succeed())
+			Expect(lockPath).NotTo(BeAnExistingFile())
+
+			lock, err = filelock.TryAcquire(lockPath, time.Minute)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(lock.Release()).To(Succeed())
+		})
+
+		Context("when the lock file was left by a process that has exited", func() {
+			BeforeEach(func() {
+				writeOwner(filelock.Owner{PID: 999999999, Acquired: time.Now()})
+			})
+
+			It("takes over the lock", func() {
+				lock, err := filelock.TryAcquire(lockPath, time.Minute)
+				Expect(err).NotTo(HaveOccurred())
+				defer lock.Release()
+
+				owner, err := filelock.ReadOwner(lockPath)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(owner.PID).To(Equal(os.Getpid()))
+			})
+		})
+
+		Context("when the lock file names a live owner without holding a flock", func() {
+			BeforeEach(func() {
+				writeOwner(filelock.Owner{PID: os.Getppid(), Acquired: time.Now()})
+			})
+
+			It("treats the lock as held", func() {
+				_, err := filelock.TryAcquire(lockPath, time.Minute)
+				Expect(err).To(Equal(filelock.ErrLocked))
+			})
+
+			Context("and the lock is older than the stale age", func() {
+				BeforeEach(func() {
+					writeOwner(filelock.Owner{PID: os.Getppid(), Acquired: time.Now().Add(-time.Hour)})
+				})
+
+				It("takes over the lock", func() {
+					lock, err := filelock.TryAcquire(lockPath, time.Minute)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(lock.Release()).To(Succeed())
+				})
+			})
+		})
+
+		Context("when an empty lock file exists", func() {
+			BeforeEach(func() {
+				Expect(ioutil.WriteFile(lockPath, []byte{}, 0644)).To(Succeed())
+			})
+
+			It("treats the lock as held until it is older than the stale age", func() {
+				_, err := filelock.TryAcquire(lockPath, time.Minute)
+				Expect(err).To(Equal(filelock.ErrLocked))
+
+				old := time.Now().Add(-time.Hour)
+				Expect(os.Chtimes(lockPath, old, old)).To(Succeed())
+
+				lock, err := filelock.TryAcquire(lockPath, time.Minute)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(lock.Release()).To(Succeed())
+			})
+		})
+
+		Context("when the directory does not exist", func() {
+			It("returns an error", func() {
+				_, err := filelock.TryAcquire(filepath.Join(dir, "missing", "lock"), time.Minute)
+				Expect(os.IsNotExist(err)).To(BeTrue())
+			})
+		})
+	})
+
+	Describe("Acquire", func() {
+		It("waits for the lock to be released", func() {
+			lock, err := filelock.TryAcquire(lockPath, time.Minute)
+			Expect(err).NotTo(HaveOccurred())
+
+			go func() {
+				time.Sleep(200 * time.Millisecond)
+				lock.Release()
+			}()
+
+			lock, err = filelock.Acquire(lockPath, time.Minute, 5*time.Second)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(lock.Release()).To(Succeed())
+		})
+
+		It("gives up after the timeout", func() {
+			lock, err := filelock.TryAcquire(lockPath, time.Minute)
+			Expect(err).NotTo(HaveOccurred())
+			defer lock.Release()
+
+			_, err = filelock.Acquire(lockPath, time.Minute, 200*time.Millisecond)
+			Expect(err).To(Equal(filelock.ErrLocked))
+		})
+	})
+
+	Describe("Release", func() {
+		It("does not fail when the lock file is already gone", func() {
+			lock, err := filelock.TryAcquire(lockPath, time.Minute)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(os.Remove(lockPath)).To(Succeed())
+			Expect(lock.Release()).To(Succeed())
+		})
+	})
+})
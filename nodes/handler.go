@@ -0,0 +1,106 @@
+package nodes
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/pivotal-cf/cf-redis-broker/brokerconfig"
+	"github.com/pivotal-cf/cf-redis-broker/redis"
+)
+
+type Pool interface {
+	Nodes() []redis.Node
+	Drift() []string
+	AddNode(host string) error
+	RemoveNode(host string) error
+	DrainNode(host string) error
+	UndrainNode(host string) error
+	ReleaseNode(host string) error
+}
+
+type Response struct {
+	Nodes []redis.Node `json:"nodes"`
+	Drift []string     `json:"drift"`
+}
+
+// NewHandler lets operators manage the dedicated node pool without
+// restarting the broker:
+//
+//	GET    /nodes                   lists the nodes and any config drift
+//	POST   /nodes?host=<host>       adds a node
+//	DELETE /nodes?host=<host>       removes an unallocated node
+//	PUT    /nodes/drain?host=<host> stops new allocations on a node
+//	DELETE /nodes/drain?host=<host> allows them again
+//	DELETE /nodes/quarantine?host=<host> releases a node that failed its health check
+func NewHandler(pool Pool) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		path := strings.TrimSuffix(req.URL.Path, "/")
+		draining := strings.HasSuffix(path, "/drain")
+		quarantine := strings.HasSuffix(path, "/quarantine")
+		nodes := !draining && !quarantine
+
+		if req.Method == "GET" && nodes {
+			list(res, pool)
+			return
+		}
+
+		host := req.URL.Query().Get("host")
+		if host == "" {
+			http.Error(res, "", http.StatusBadRequest)
+			return
+		}
+
+		var err error
+		switch {
+		case nodes && req.Method == "POST":
+			if invalid := brokerconfig.CheckDedicatedNode(host); invalid != nil {
+				http.Error(res, invalid.Error(), http.StatusBadRequest)
+				return
+			}
+			err = pool.AddNode(host)
+		case nodes && req.Method == "DELETE":
+			err = pool.RemoveNode(host)
+		case draining && req.Method == "PUT":
+			err = pool.DrainNode(host)
+		case draining && req.Method == "DELETE":
+			err = pool.UndrainNode(host)
+		case quarantine && req.Method == "DELETE":
+			err = pool.ReleaseNode(host)
+		default:
+			http.Error(res, "", http.StatusMethodNotAllowed)
+			return
+		}
+
+		switch err {
+		case nil:
+			if req.Method == "POST" {
+				res.WriteHeader(http.StatusCreated)
+				return
+			}
+			res.WriteHeader(http.StatusOK)
+		case redis.ErrNodeDoesNotExist:
+			http.Error(res, err.Error(), http.StatusNotFound)
+		case redis.ErrNodeAlreadyExists, redis.ErrNodeAllocated:
+			http.Error(res, err.Error(), http.StatusConflict)
+		default:
+			http.Error(res, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
+
+func list(res http.ResponseWriter, pool Pool) {
+	drift := pool.Drift()
+	if drift == nil {
+		drift = []string{}
+	}
+
+	payload, err := json.Marshal(Response{Nodes: pool.Nodes(), Drift: drift})
+	if err != nil {
+		http.Error(res, "", http.StatusInternalServerError)
+		return
+	}
+
+	res.Header().Add("Content-Type", "application/json")
+	res.Write(payload)
+}
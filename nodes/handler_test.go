@@ -0,0 +1,187 @@
+package nodes_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/pivotal-cf/cf-redis-broker/nodes"
+	"github.com/pivotal-cf/cf-redis-broker/redis"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type fakePool struct {
+	nodes []redis.Node
+	drift []string
+	calls []string
+	err   error
+}
+
+func (pool *fakePool) Nodes() []redis.Node { return pool.nodes }
+func (pool *fakePool) Drift() []string     { return pool.drift }
+
+func (pool *fakePool) AddNode(host string) error {
+	pool.calls = append(pool.calls, "add "+host)
+	return pool.err
+}
+
+func (pool *fakePool) RemoveNode(host string) error {
+	pool.calls = append(pool.calls, "remove "+host)
+	return pool.err
+}
+
+func (pool *fakePool) DrainNode(host string) error {
+	pool.calls = append(pool.calls, "drain "+host)
+	return pool.err
+}
+
+func (pool *fakePool) UndrainNode(host string) error {
+	pool.calls = append(pool.calls, "undrain "+host)
+	return pool.err
+}
+
+func (pool *fakePool) ReleaseNode(host string) error {
+	pool.calls = append(pool.calls, "release "+host)
+	return pool.err
+}
+
+var _ = Describe("Handler", func() {
+	var (
+		recorder *httptest.ResponseRecorder
+		pool     *fakePool
+		handler  http.HandlerFunc
+	)
+
+	BeforeEach(func() {
+		recorder = httptest.NewRecorder()
+		pool = new(fakePool)
+		handler = nodes.NewHandler(pool)
+	})
+
+	serve := func(method, url string) {
+		request, err := http.NewRequest(method, url, nil)
+		Expect(err).NotTo(HaveOccurred())
+		handler.ServeHTTP(recorder, request)
+	}
+
+	Describe("GET /nodes", func() {
+		BeforeEach(func() {
+			pool.nodes = []redis.Node{
+				{Host: "10.0.0.1", State: redis.NodeStateAllocated, InstanceID: "instance-id"},
+				{Host: "10.0.0.2", State: redis.NodeStateAvailable, Draining: true},
+			}
+		})
+
+		It("lists the nodes and the drift", func() {
+			pool.drift = []string{"10.0.0.3: node allocated to instance orphan is not in the config"}
+			serve("GET", "http://localhost/nodes")
+
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+			Expect(recorder.Header().Get("Content-Type")).To(Equal("application/json"))
+
+			var response nodes.Response
+			Expect(json.Unmarshal(recorder.Body.Bytes(), &response)).To(Succeed())
+			Expect(response.Nodes).To(Equal(pool.nodes))
+			Expect(response.Drift).To(Equal(pool.drift))
+		})
+
+		It("returns an empty drift list when there is none", func() {
+			serve("GET", "http://localhost/nodes")
+			Expect(recorder.Body.String()).To(ContainSubstring(`"drift":[]`))
+		})
+	})
+
+	Describe("POST /nodes", func() {
+		It("adds the node", func() {
+			serve("POST", "http://localhost/nodes?host=10.0.0.3")
+
+			Expect(recorder.Code).To(Equal(http.StatusCreated))
+			Expect(pool.calls).To(Equal([]string{"add 10.0.0.3"}))
+		})
+
+		It("returns a 400 for an invalid host", func() {
+			serve("POST", "http://localhost/nodes?host=not_a_hostname")
+
+			Expect(recorder.Code).To(Equal(http.StatusBadRequest))
+			Expect(pool.calls).To(BeEmpty())
+		})
+
+		It("returns a 409 for a known node", func() {
+			pool.err = redis.ErrNodeAlreadyExists
+			serve("POST", "http://localhost/nodes?host=10.0.0.3")
+			Expect(recorder.Code).To(Equal(http.StatusConflict))
+		})
+	})
+
+	Describe("DELETE /nodes", func() {
+		It("removes the node", func() {
+			serve("DELETE", "http://localhost/nodes?host=10.0.0.2")
+
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+			Expect(pool.calls).To(Equal([]string{"remove 10.0.0.2"}))
+		})
+
+		It("returns a 409 for an allocated node", func() {
+			pool.err = redis.ErrNodeAllocated
+			serve("DELETE", "http://localhost/nodes?host=10.0.0.1")
+
+			Expect(recorder.Code).To(Equal(http.StatusConflict))
+			Expect(recorder.Body.String()).To(ContainSubstring("node is allocated to an instance"))
+		})
+
+		It("returns a 404 for an unknown node", func() {
+			pool.err = redis.ErrNodeDoesNotExist
+			serve("DELETE", "http://localhost/nodes?host=10.0.0.9")
+			Expect(recorder.Code).To(Equal(http.StatusNotFound))
+		})
+
+		It("returns a 500 when the pool cannot be saved", func() {
+			pool.err = errors.New("disk full")
+			serve("DELETE", "http://localhost/nodes?host=10.0.0.2")
+			Expect(recorder.Code).To(Equal(http.StatusInternalServerError))
+		})
+	})
+
+	Describe("/nodes/drain", func() {
+		It("drains the node on PUT", func() {
+			serve("PUT", "http://localhost/nodes/drain?host=10.0.0.1")
+
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+			Expect(pool.calls).To(Equal([]string{"drain 10.0.0.1"}))
+		})
+
+		It("undrains the node on DELETE", func() {
+			serve("DELETE", "http://localhost/nodes/drain?host=10.0.0.1")
+
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+			Expect(pool.calls).To(Equal([]string{"undrain 10.0.0.1"}))
+		})
+
+		It("returns a 405 for other methods", func() {
+			serve("POST", "http://localhost/nodes/drain?host=10.0.0.1")
+			Expect(recorder.Code).To(Equal(http.StatusMethodNotAllowed))
+		})
+	})
+
+	Describe("/nodes/quarantine", func() {
+		It("releases the node on DELETE", func() {
+			serve("DELETE", "http://localhost/nodes/quarantine?host=10.0.0.1")
+
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+			Expect(pool.calls).To(Equal([]string{"release 10.0.0.1"}))
+		})
+
+		It("returns a 405 for other methods", func() {
+			serve("PUT", "http://localhost/nodes/quarantine?host=10.0.0.1")
+			Expect(recorder.Code).To(Equal(http.StatusMethodNotAllowed))
+		})
+	})
+
+	It("returns a 400 without a host", func() {
+		serve("DELETE", "http://localhost/nodes")
+		Expect(recorder.Code).To(Equal(http.StatusBadRequest))
+	})
+})
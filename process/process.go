@@ -1,6 +1,8 @@
 package process
 
 import (
+	"bytes"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"strconv"
@@ -20,6 +22,20 @@ func ReadPID(pidFilePath string) (int, error) {
 	return strconv.Atoi(strings.TrimSpace(string(contents)))
 }
 
+func CommandLine(pid int) ([]string, error) {
+	contents, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/cmdline", pid))
+	if err != nil {
+		return nil, err
+	}
+
+	args := []string{}
+	for _, arg := range bytes.Split(bytes.TrimRight(contents, "\x00"), []byte{0}) {
+		args = append(args, string(arg))
+	}
+
+	return args, nil
+}
+
 func (*ProcessChecker) Alive(pid int) bool {
 	osProcess, findProcessErr := os.FindProcess(pid)
 	if findProcessErr != nil {
@@ -44,6 +60,15 @@ func (k *ProcessKiller) KillProvidedPID(pidProvider PIDProvider) error {
 	return k.Kill(pid)
 }
 
+func (*ProcessKiller) Terminate(pid int) error {
+	osProcess, findProcessErr := os.FindProcess(pid)
+	if findProcessErr != nil {
+		return findProcessErr
+	}
+
+	return osProcess.Signal(syscall.SIGTERM)
+}
+
 func (*ProcessKiller) Kill(pid int) error {
 	osProcess, findProcessErr := os.FindProcess(pid)
 	if findProcessErr != nil {
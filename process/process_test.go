@@ -56,6 +56,28 @@ var _ = Describe("process", func() {
 		})
 	})
 
+	Describe(".CommandLine", func() {
+		It("returns the arguments the process was started with", func() {
+			cmd := exec.Command("sleep", "60")
+			Expect(cmd.Start()).To(Succeed())
+			defer cmd.Process.Kill()
+
+			args, err := process.CommandLine(cmd.Process.Pid)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(args).To(Equal([]string{"sleep", "60"}))
+		})
+
+		Context("when the process does not exist", func() {
+			It("returns an error", func() {
+				cmd := exec.Command("true")
+				Expect(cmd.Run()).To(Succeed())
+
+				_, err := process.CommandLine(cmd.Process.Pid)
+				Expect(err).To(HaveOccurred())
+			})
+		})
+	})
+
 	Describe("ProcessKiller", func() {
 		Describe("Alive", func() {
 			It("returns false when the process is not alive", func() {
@@ -75,6 +97,19 @@ var _ = Describe("process", func() {
 			})
 		})
 
+		Describe("Terminate", func() {
+			It("sends SIGTERM to the process", func() {
+				cmd := exec.Command("sleep", "60")
+				Expect(cmd.Start()).To(Succeed())
+
+				err := new(process.ProcessKiller).Terminate(cmd.Process.Pid)
+				Ω(err).ShouldNot(HaveOccurred())
+
+				err = cmd.Wait()
+				Ω(err).Should(MatchError("signal: terminated"))
+			})
+		})
+
 		Describe("Kill", func() {
 			It("does not return an error when the process has been killed", func() {
 				cmd := exec.Command("sleep", "60")
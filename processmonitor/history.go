@@ -0,0 +1,79 @@
+package processmonitor
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+type State string
+
+const (
+	StateRunning   State = "running"
+	StateBackoff   State = "backoff"
+	StateCrashLoop State = "crash-loop"
+)
+
+const (
+	EventRestarted     = "restarted"
+	EventRestartFailed = "restart-failed"
+	EventCrashLoop     = "crash-loop"
+	EventRecovered     = "recovered"
+)
+
+const maxHistoryEvents = 20
+
+type Event struct {
+	Time  time.Time `json:"time"`
+	Type  string    `json:"type"`
+	Error string    `json:"error,omitempty"`
+}
+
+type History struct {
+	State               State     `json:"state"`
+	Restarts            int       `json:"restarts"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+	NextAttempt         time.Time `json:"next_attempt"`
+	Events              []Event   `json:"events"`
+}
+
+func LoadHistory(path string) (*History, error) {
+	history := &History{State: StateRunning, Events: []Event{}}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return history, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, history); err != nil {
+		return nil, err
+	}
+
+	return history, nil
+}
+
+func (history *History) Save(path string) error {
+	data, err := json.Marshal(history)
+	if err != nil {
+		return err
+	}
+
+	tmpPath := path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, path)
+}
+
+func (history *History) record(event Event) {
+	history.Events = append(history.Events, event)
+	if len(history.Events) > maxHistoryEvents {
+		history.Events = history.Events[len(history.Events)-maxHistoryEvents:]
+	}
+}
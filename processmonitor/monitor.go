@@ -0,0 +1,169 @@
+package processmonitor
+
+import (
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/pivotal-cf/cf-redis-broker/brokerconfig"
+	"github.com/pivotal-cf/cf-redis-broker/redis"
+)
+
+const (
+	defaultInitialBackoff = 5 * time.Second
+	defaultMaxBackoff     = 5 * time.Minute
+	defaultMaxFailures    = 5
+)
+
+type ProcessController interface {
+	Alive(instance *redis.Instance) bool
+	EnsureRunning(instance *redis.Instance, configPath, instanceDataDir, pidfilePath, logfilePath string) error
+}
+
+type InstanceRepository interface {
+	InstanceConfigPath(instanceID string) string
+	InstanceDataDir(instanceID string) string
+	InstancePidFilePath(instanceID string) string
+	InstanceLogFilePath(instanceID string) string
+	InstanceRestartHistoryPath(instanceID string) string
+}
+
+type Monitor struct {
+	Logger            lager.Logger
+	Repo              InstanceRepository
+	ProcessController ProcessController
+	InitialBackoff    time.Duration
+	MaxBackoff        time.Duration
+	MaxFailures       int
+	Now               func() time.Time
+}
+
+func New(
+	logger lager.Logger,
+	repo InstanceRepository,
+	processController ProcessController,
+	config brokerconfig.ServiceConfiguration,
+) *Monitor {
+	monitor := &Monitor{
+		Logger:            logger,
+		Repo:              repo,
+		ProcessController: processController,
+		InitialBackoff:    time.Duration(config.ProcessRestartBackoffSeconds) * time.Second,
+		MaxBackoff:        time.Duration(config.ProcessMaxRestartBackoffSeconds) * time.Second,
+		MaxFailures:       config.ProcessMaxRestartFailures,
+		Now:               time.Now,
+	}
+
+	if monitor.InitialBackoff <= 0 {
+		monitor.InitialBackoff = defaultInitialBackoff
+	}
+
+	if monitor.MaxBackoff <= 0 {
+		monitor.MaxBackoff = defaultMaxBackoff
+	}
+
+	if monitor.MaxFailures <= 0 {
+		monitor.MaxFailures = defaultMaxFailures
+	}
+
+	return monitor
+}
+
+// Check makes sure the instance is running, restarting it if necessary.
+// Failed restarts are retried with exponential backoff until MaxFailures
+// consecutive failures, after which the instance is left in crash-loop
+// state until it is found running again.
+func (monitor *Monitor) Check(instance *redis.Instance) error {
+	historyPath := monitor.Repo.InstanceRestartHistoryPath(instance.ID)
+
+	history, err := LoadHistory(historyPath)
+	if err != nil {
+		return err
+	}
+
+	now := monitor.Now()
+
+	if monitor.ProcessController.Alive(instance) {
+		if history.State == StateRunning {
+			return nil
+		}
+
+		monitor.Logger.Info("instance-recovered", lager.Data{
+			"instance": instance.ID,
+			"state":    history.State,
+		})
+
+		history.State = StateRunning
+		history.ConsecutiveFailures = 0
+		history.NextAttempt = time.Time{}
+		history.record(Event{Time: now, Type: EventRecovered})
+
+		return history.Save(historyPath)
+	}
+
+	if history.State == StateCrashLoop {
+		monitor.Logger.Info("skipping-crash-looping-instance", lager.Data{
+			"instance": instance.ID,
+			"failures": history.ConsecutiveFailures,
+		})
+		return nil
+	}
+
+	if now.Before(history.NextAttempt) {
+		return nil
+	}
+
+	err = monitor.ProcessController.EnsureRunning(
+		instance,
+		monitor.Repo.InstanceConfigPath(instance.ID),
+		monitor.Repo.InstanceDataDir(instance.ID),
+		monitor.Repo.InstancePidFilePath(instance.ID),
+		monitor.Repo.InstanceLogFilePath(instance.ID),
+	)
+
+	if err == nil {
+		history.State = StateRunning
+		history.Restarts++
+		history.ConsecutiveFailures = 0
+		history.NextAttempt = time.Time{}
+		history.record(Event{Time: now, Type: EventRestarted})
+
+		return history.Save(historyPath)
+	}
+
+	history.ConsecutiveFailures++
+	history.record(Event{Time: now, Type: EventRestartFailed, Error: err.Error()})
+
+	if history.ConsecutiveFailures >= monitor.MaxFailures {
+		history.State = StateCrashLoop
+		history.NextAttempt = time.Time{}
+		history.record(Event{Time: now, Type: EventCrashLoop})
+
+		monitor.Logger.Error("instance-crash-looping", err, lager.Data{
+			"instance": instance.ID,
+			"failures": history.ConsecutiveFailures,
+		})
+	} else {
+		history.State = StateBackoff
+		history.NextAttempt = now.Add(monitor.backoff(history.ConsecutiveFailures))
+
+		monitor.Logger.Error("instance-restart-failed", err, lager.Data{
+			"instance":     instance.ID,
+			"failures":     history.ConsecutiveFailures,
+			"next_attempt": history.NextAttempt,
+		})
+	}
+
+	return history.Save(historyPath)
+}
+
+func (monitor *Monitor) backoff(failures int) time.Duration {
+	backoff := monitor.InitialBackoff
+	for i := 1; i < failures; i++ {
+		backoff *= 2
+		if backoff >= monitor.MaxBackoff {
+			return monitor.MaxBackoff
+		}
+	}
+
+	return backoff
+}
@@ -0,0 +1,205 @@
+package processmonitor_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"code.cloudfoundry.org/lager/lagertest"
+	"github.com/pivotal-cf/cf-redis-broker/brokerconfig"
+	"github.com/pivotal-cf/cf-redis-broker/processmonitor"
+	"github.com/pivotal-cf/cf-redis-broker/redis"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type fakeProcessController struct {
+	alive              bool
+	ensureRunningErr   error
+	ensureRunningCalls int
+}
+
+func (controller *fakeProcessController) Alive(instance *redis.Instance) bool {
+	return controller.alive
+}
+
+func (controller *fakeProcessController) EnsureRunning(instance *redis.Instance, configPath, instanceDataDir, pidfilePath, logfilePath string) error {
+	controller.ensureRunningCalls++
+	if controller.ensureRunningErr == nil {
+		controller.alive = true
+	}
+	return controller.ensureRunningErr
+}
+
+type fakeRepo struct {
+	dir string
+}
+
+func (repo fakeRepo) InstanceConfigPath(instanceID string) string {
+	return filepath.Join(repo.dir, "redis.conf")
+}
+
+func (repo fakeRepo) InstanceDataDir(instanceID string) string {
+	return filepath.Join(repo.dir, "db")
+}
+
+func (repo fakeRepo) InstancePidFilePath(instanceID string) string {
+	return filepath.Join(repo.dir, "redis.pid")
+}
+
+func (repo fakeRepo) InstanceLogFilePath(instanceID string) string {
+	return filepath.Join(repo.dir, "redis.log")
+}
+
+func (repo fakeRepo) InstanceRestartHistoryPath(instanceID string) string {
+	return filepath.Join(repo.dir, "restart_history.json")
+}
+
+var _ = Describe("Monitor", func() {
+	var (
+		monitor    *processmonitor.Monitor
+		controller *fakeProcessController
+		repo       fakeRepo
+		instance   *redis.Instance
+		now        time.Time
+	)
+
+	BeforeEach(func() {
+		dir, err := ioutil.TempDir("", "processmonitor")
+		Expect(err).NotTo(HaveOccurred())
+
+		repo = fakeRepo{dir: dir}
+		controller = new(fakeProcessController)
+		instance = &redis.Instance{ID: "some-instance"}
+		now = time.Unix(1500000000, 0)
+
+		monitor = processmonitor.New(
+			lagertest.NewTestLogger("process-monitor"),
+			repo,
+			controller,
+			brokerconfig.ServiceConfiguration{
+				ProcessRestartBackoffSeconds:    10,
+				ProcessMaxRestartBackoffSeconds: 30,
+				ProcessMaxRestartFailures:       3,
+			},
+		)
+		monitor.Now = func() time.Time { return now }
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(repo.dir)
+	})
+
+	loadHistory := func() *processmonitor.History {
+		history, err := processmonitor.LoadHistory(repo.InstanceRestartHistoryPath(instance.ID))
+		Expect(err).NotTo(HaveOccurred())
+		return history
+	}
+
+	Context("when the instance is running", func() {
+		BeforeEach(func() {
+			controller.alive = true
+		})
+
+		It("does not restart it", func() {
+			Expect(monitor.Check(instance)).To(Succeed())
+			Expect(controller.ensureRunningCalls).To(Equal(0))
+		})
+
+		It("does not write any history", func() {
+			Expect(monitor.Check(instance)).To(Succeed())
+
+			_, err := os.Stat(repo.InstanceRestartHistoryPath(instance.ID))
+			Expect(os.IsNotExist(err)).To(BeTrue())
+		})
+	})
+
+	Context("when the instance is not running", func() {
+		It("restarts it and records the restart", func() {
+			Expect(monitor.Check(instance)).To(Succeed())
+			Expect(controller.ensureRunningCalls).To(Equal(1))
+
+			history := loadHistory()
+			Expect(history.State).To(Equal(processmonitor.StateRunning))
+			Expect(history.Restarts).To(Equal(1))
+			Expect(history.Events).To(HaveLen(1))
+			Expect(history.Events[0].Type).To(Equal(processmonitor.EventRestarted))
+		})
+
+		Context("and it fails to start", func() {
+			BeforeEach(func() {
+				controller.ensureRunningErr = errors.New("redis failed to start")
+			})
+
+			It("backs off before trying again", func() {
+				Expect(monitor.Check(instance)).To(Succeed())
+
+				history := loadHistory()
+				Expect(history.State).To(Equal(processmonitor.StateBackoff))
+				Expect(history.ConsecutiveFailures).To(Equal(1))
+				Expect(history.NextAttempt.Equal(now.Add(10 * time.Second))).To(BeTrue())
+				Expect(history.Events[0].Error).To(Equal("redis failed to start"))
+
+				now = now.Add(5 * time.Second)
+				Expect(monitor.Check(instance)).To(Succeed())
+				Expect(controller.ensureRunningCalls).To(Equal(1))
+
+				now = now.Add(5 * time.Second)
+				Expect(monitor.Check(instance)).To(Succeed())
+				Expect(controller.ensureRunningCalls).To(Equal(2))
+			})
+
+			It("doubles the backoff after each failure", func() {
+				Expect(monitor.Check(instance)).To(Succeed())
+
+				now = now.Add(10 * time.Second)
+				Expect(monitor.Check(instance)).To(Succeed())
+
+				Expect(loadHistory().NextAttempt.Equal(now.Add(20 * time.Second))).To(BeTrue())
+			})
+
+			It("stops retrying once the instance is crash looping", func() {
+				for i := 0; i < 3; i++ {
+					Expect(monitor.Check(instance)).To(Succeed())
+					now = now.Add(time.Minute)
+				}
+
+				history := loadHistory()
+				Expect(history.State).To(Equal(processmonitor.StateCrashLoop))
+				Expect(history.Events[len(history.Events)-1].Type).To(Equal(processmonitor.EventCrashLoop))
+
+				now = now.Add(time.Hour)
+				Expect(monitor.Check(instance)).To(Succeed())
+				Expect(controller.ensureRunningCalls).To(Equal(3))
+			})
+
+			Context("and the instance is later found running", func() {
+				It("resets the failure count", func() {
+					Expect(monitor.Check(instance)).To(Succeed())
+
+					controller.alive = true
+					Expect(monitor.Check(instance)).To(Succeed())
+
+					history := loadHistory()
+					Expect(history.State).To(Equal(processmonitor.StateRunning))
+					Expect(history.ConsecutiveFailures).To(Equal(0))
+					Expect(history.Events[len(history.Events)-1].Type).To(Equal(processmonitor.EventRecovered))
+				})
+			})
+		})
+	})
+
+	Context("when the history file is corrupt", func() {
+		BeforeEach(func() {
+			err := ioutil.WriteFile(repo.InstanceRestartHistoryPath(instance.ID), []byte("{"), 0644)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("returns an error", func() {
+			Expect(monitor.Check(instance)).NotTo(Succeed())
+		})
+	})
+})
@@ -0,0 +1,417 @@
+package processmonitor
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/pivotal-cf/cf-redis-broker/process"
+	"github.com/pivotal-cf/cf-redis-broker/redis"
+)
+
+const (
+	defaultStartTimeout     = 10 * time.Second
+	defaultShutdownTimeout  = 30 * time.Second
+	defaultTerminateTimeout = 10 * time.Second
+	stderrTailSize          = 4096
+	orphanKillTimeout       = 5 * time.Second
+	orphanKillPollDelay     = 100 * time.Millisecond
+)
+
+type CommandLineFunc func(pid int) ([]string, error)
+
+// Supervisor runs shared redis-server processes in the foreground as
+// children of the process monitor, so that an exit is noticed as soon as it
+// happens rather than on the next pidfile probe. ProcessController stops
+// them the way the broker does.
+type Supervisor struct {
+	Logger                    lager.Logger
+	InstanceInformer          redis.InstanceInformer
+	ProcessChecker            redis.ProcessChecker
+	ProcessController         *redis.OSProcessController
+	PingFunc                  redis.PingServerFunc
+	WaitUntilConnectableFunc  redis.WaitUntilConnectableFunc
+	CommandLineFunc           CommandLineFunc
+	RedisServerExecutablePath string
+	RedisBinaries             map[string]string
+	StartTimeout              time.Duration
+	Cgroups                   redis.CgroupManager
+
+	children map[string]*child
+	mutex    sync.Mutex
+}
+
+// child is a redis-server run by the supervisor. stopRequested is guarded by
+// the supervisor's mutex.
+type child struct {
+	cmd           *exec.Cmd
+	stderr        *tailBuffer
+	exited        chan struct{}
+	exitErr       error
+	stopRequested bool
+}
+
+func (c *child) hasExited() bool {
+	select {
+	case <-c.exited:
+		return true
+	default:
+		return false
+	}
+}
+
+func NewSupervisor(
+	logger lager.Logger,
+	instanceInformer redis.InstanceInformer,
+	processChecker redis.ProcessChecker,
+	pingFunc redis.PingServerFunc,
+	waitUntilConnectableFunc redis.WaitUntilConnectableFunc,
+	commandLineFunc CommandLineFunc,
+	redisServerExecutablePath string,
+	startTimeout time.Duration,
+) *Supervisor {
+	if startTimeout <= 0 {
+		startTimeout = defaultStartTimeout
+	}
+
+	return &Supervisor{
+		Logger:           logger,
+		InstanceInformer: instanceInformer,
+		ProcessChecker:   processChecker,
+		ProcessController: &redis.OSProcessController{
+			Logger:           logger,
+			InstanceInformer: instanceInformer,
+			ProcessChecker:   processChecker,
+			ProcessKiller:    new(process.ProcessKiller),
+			ShutdownFunc:     redis.ShutdownServer,
+			ShutdownTimeout:  defaultShutdownTimeout,
+			TerminateTimeout: defaultTerminateTimeout,
+		},
+		PingFunc:                  pingFunc,
+		WaitUntilConnectableFunc:  waitUntilConnectableFunc,
+		CommandLineFunc:           commandLineFunc,
+		RedisServerExecutablePath: redisServerExecutablePath,
+		StartTimeout:              startTimeout,
+		children:                  map[string]*child{},
+	}
+}
+
+// Alive checks our child for the instance or, if it has exited, whichever
+// redis-server the pidfile points to. The latter covers instances restarted
+// by someone else, e.g. a rolling upgrade, which are adopted as they are.
+func (supervisor *Supervisor) Alive(instance *redis.Instance) bool {
+	supervisor.mutex.Lock()
+	c, found := supervisor.children[instance.ID]
+	supervisor.mutex.Unlock()
+
+	if found && !c.hasExited() {
+		return supervisor.PingFunc(instance) == nil
+	}
+
+	pid, err := supervisor.InstanceInformer.InstancePid(instance.ID)
+	if err != nil || !supervisor.ProcessChecker.Alive(pid) {
+		return false
+	}
+
+	return supervisor.isRedisInstance(pid, instance, "") && supervisor.PingFunc(instance) == nil
+}
+
+func (supervisor *Supervisor) EnsureRunning(instance *redis.Instance, configPath, instanceDataDir, pidfilePath, logfilePath string) error {
+	if supervisor.Alive(instance) {
+		return nil
+	}
+
+	if err := supervisor.stopChild(instance); err != nil {
+		return err
+	}
+
+	if err := supervisor.stopOrphan(instance, configPath, pidfilePath); err != nil {
+		return err
+	}
+
+	return supervisor.start(instance, configPath, instanceDataDir, logfilePath)
+}
+
+// Stop gracefully shuts the instance down with SHUTDOWN SAVE or
+// SHUTDOWN NOSAVE, escalating to SIGTERM and then SIGKILL if it does not exit
+// in time. Instances adopted from a pidfile are only signalled once their
+// command line has been verified.
+func (supervisor *Supervisor) Stop(instance *redis.Instance, save bool) error {
+	c, found := supervisor.takeChild(instance)
+
+	if found && !c.hasExited() {
+		pid := c.cmd.Process.Pid
+		if err := supervisor.ProcessController.StopProcess(instance, pid, save); err != nil {
+			return err
+		}
+
+		select {
+		case <-c.exited:
+			return nil
+		case <-time.After(orphanKillTimeout):
+			return fmt.Errorf("timed out waiting for pid %d to exit", pid)
+		}
+	}
+
+	pid, err := supervisor.InstanceInformer.InstancePid(instance.ID)
+	if err != nil || !supervisor.ProcessChecker.Alive(pid) {
+		return nil
+	}
+
+	if !supervisor.isRedisInstance(pid, instance, "") {
+		return fmt.Errorf("pid %d is not the redis-server for instance %s", pid, instance.ID)
+	}
+
+	if err := supervisor.ProcessController.StopProcess(instance, pid, save); err != nil {
+		return err
+	}
+
+	if !supervisor.waitForExit(pid, orphanKillTimeout) {
+		return fmt.Errorf("timed out waiting for pid %d to exit", pid)
+	}
+
+	return nil
+}
+
+// takeChild forgets the instance's child and marks it as asked to stop, so
+// that its exit is not reported as a crash.
+func (supervisor *Supervisor) takeChild(instance *redis.Instance) (*child, bool) {
+	supervisor.mutex.Lock()
+	defer supervisor.mutex.Unlock()
+
+	c, found := supervisor.children[instance.ID]
+	if found {
+		c.stopRequested = true
+		delete(supervisor.children, instance.ID)
+	}
+
+	return c, found
+}
+
+func (supervisor *Supervisor) waitForExit(pid int, timeout time.Duration) bool {
+	for elapsed := time.Duration(0); elapsed < timeout; elapsed += orphanKillPollDelay {
+		if !supervisor.ProcessChecker.Alive(pid) {
+			return true
+		}
+		time.Sleep(orphanKillPollDelay)
+	}
+
+	return !supervisor.ProcessChecker.Alive(pid)
+}
+
+func (supervisor *Supervisor) start(instance *redis.Instance, configPath, instanceDataDir, logfilePath string) error {
+	executable, err := redis.ExecutablePath(instance, supervisor.RedisBinaries, supervisor.RedisServerExecutablePath)
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command(
+		executable,
+		configPath,
+		"--dir", instanceDataDir,
+		"--logfile", logfilePath,
+		"--daemonize", "no",
+	)
+
+	c := &child{
+		cmd:    cmd,
+		stderr: newTailBuffer(stderrTailSize),
+		exited: make(chan struct{}),
+	}
+	cmd.Stderr = c.stderr
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("redis failed to start: %s", err)
+	}
+
+	supervisor.mutex.Lock()
+	supervisor.children[instance.ID] = c
+	supervisor.mutex.Unlock()
+
+	go func() {
+		c.exitErr = cmd.Wait()
+		close(c.exited)
+
+		supervisor.mutex.Lock()
+		stopRequested := c.stopRequested
+		supervisor.mutex.Unlock()
+
+		data := lager.Data{
+			"instance": instance.ID,
+			"pid":      cmd.Process.Pid,
+			"stderr":   c.stderr.String(),
+		}
+
+		if stopRequested {
+			data["exit"] = exitError(c).Error()
+			supervisor.Logger.Info("redis-server-stopped", data)
+			return
+		}
+
+		supervisor.Logger.Error("redis-server-exited", exitError(c), data)
+	}()
+
+	if supervisor.Cgroups != nil {
+		if err := supervisor.Cgroups.AddProcess(instance.ID, cmd.Process.Pid); err != nil {
+			supervisor.Logger.Error("add-to-cgroup", err, lager.Data{
+				"instance": instance.ID,
+				"pid":      cmd.Process.Pid,
+			})
+			supervisor.stopChild(instance)
+			return err
+		}
+	}
+
+	ready := make(chan error, 1)
+	go func() {
+		address, err := instance.Address()
+		if err != nil {
+			ready <- err
+			return
+		}
+		ready <- supervisor.WaitUntilConnectableFunc(address, supervisor.StartTimeout)
+	}()
+
+	select {
+	case <-c.exited:
+		return fmt.Errorf("redis failed to start: %s: %s", exitError(c), strings.TrimSpace(c.stderr.String()))
+	case err := <-ready:
+		if err != nil {
+			supervisor.stopChild(instance)
+			return err
+		}
+	}
+
+	supervisor.Logger.Info("redis-server-started", lager.Data{
+		"instance": instance.ID,
+		"pid":      cmd.Process.Pid,
+	})
+
+	return nil
+}
+
+func (supervisor *Supervisor) stopChild(instance *redis.Instance) error {
+	c, found := supervisor.takeChild(instance)
+
+	if !found || c.hasExited() {
+		return nil
+	}
+
+	if err := c.cmd.Process.Kill(); err != nil {
+		return err
+	}
+
+	<-c.exited
+	return nil
+}
+
+// stopOrphan kills a redis-server for this instance that is not one of our
+// children, e.g. one left over from the daemonized pidfile mode or a previous
+// process monitor. The PID is only signalled once its command line has been
+// verified, as the pidfile may point to a recycled PID.
+func (supervisor *Supervisor) stopOrphan(instance *redis.Instance, configPath, pidfilePath string) error {
+	pid, err := supervisor.InstanceInformer.InstancePid(instance.ID)
+	if err != nil || !supervisor.ProcessChecker.Alive(pid) {
+		return nil
+	}
+
+	if !supervisor.isRedisInstance(pid, instance, configPath) {
+		supervisor.Logger.Info("pidfile-points-to-other-process", lager.Data{
+			"instance": instance.ID,
+			"pid":      pid,
+		})
+		return os.Remove(pidfilePath)
+	}
+
+	supervisor.Logger.Info("killing-orphaned-redis-server", lager.Data{
+		"instance": instance.ID,
+		"pid":      pid,
+	})
+
+	if err := syscall.Kill(pid, syscall.SIGKILL); err != nil {
+		return err
+	}
+
+	if !supervisor.waitForExit(pid, orphanKillTimeout) {
+		return fmt.Errorf("timed out waiting for pid %d to exit", pid)
+	}
+
+	return nil
+}
+
+// isRedisInstance checks that pid is a redis-server serving this instance.
+// redis-server rewrites its process title to "redis-server <bind>:<port>",
+// so either the port or the config path must appear in the command line.
+func (supervisor *Supervisor) isRedisInstance(pid int, instance *redis.Instance, configPath string) bool {
+	args, err := supervisor.CommandLineFunc(pid)
+	if err != nil {
+		return false
+	}
+
+	fields := strings.Fields(strings.Join(args, " "))
+
+	isRedis := false
+	for _, field := range fields {
+		if strings.Contains(filepath.Base(field), "redis-server") {
+			isRedis = true
+			break
+		}
+	}
+
+	if !isRedis {
+		return false
+	}
+
+	portSuffix := ":" + strconv.Itoa(instance.Port)
+	for _, field := range fields {
+		if strings.HasSuffix(field, portSuffix) || (configPath != "" && field == configPath) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func exitError(c *child) error {
+	if c.exitErr != nil {
+		return c.exitErr
+	}
+	return errors.New("exited with status 0")
+}
+
+type tailBuffer struct {
+	size  int
+	data  []byte
+	mutex sync.Mutex
+}
+
+func newTailBuffer(size int) *tailBuffer {
+	return &tailBuffer{size: size}
+}
+
+func (buffer *tailBuffer) Write(p []byte) (int, error) {
+	buffer.mutex.Lock()
+	defer buffer.mutex.Unlock()
+
+	buffer.data = append(buffer.data, p...)
+	if len(buffer.data) > buffer.size {
+		buffer.data = buffer.data[len(buffer.data)-buffer.size:]
+	}
+
+	return len(p), nil
+}
+
+func (buffer *tailBuffer) String() string {
+	buffer.mutex.Lock()
+	defer buffer.mutex.Unlock()
+
+	return string(buffer.data)
+}
@@ -0,0 +1,304 @@
+package processmonitor_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"code.cloudfoundry.org/lager/lagertest"
+	"github.com/pivotal-cf/cf-redis-broker/cgroup"
+	"github.com/pivotal-cf/cf-redis-broker/process"
+	"github.com/pivotal-cf/cf-redis-broker/processmonitor"
+	"github.com/pivotal-cf/cf-redis-broker/redis"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type fakeInstanceInformer struct {
+	pid int
+	err error
+}
+
+func (informer *fakeInstanceInformer) InstancePid(instanceID string) (int, error) {
+	return informer.pid, informer.err
+}
+
+var _ = Describe("Supervisor", func() {
+	var (
+		supervisor  *processmonitor.Supervisor
+		informer    *fakeInstanceInformer
+		instance    *redis.Instance
+		binDir      string
+		commandLine []string
+		pingErr     error
+		logger      *lagertest.TestLogger
+	)
+
+	writeExecutable := func(script string) string {
+		path := filepath.Join(binDir, "redis-server")
+		err := ioutil.WriteFile(path, []byte("#!/bin/sh\n"+script+"\n"), 0755)
+		Expect(err).NotTo(HaveOccurred())
+		return path
+	}
+
+	BeforeEach(func() {
+		var err error
+		binDir, err = ioutil.TempDir("", "supervisor")
+		Expect(err).NotTo(HaveOccurred())
+
+		informer = &fakeInstanceInformer{err: errors.New("no pidfile")}
+		instance = &redis.Instance{ID: "some-instance", Host: "127.0.0.1", Port: 6399}
+		commandLine = []string{"redis-server", "127.0.0.1:6399"}
+		pingErr = nil
+
+		logger = lagertest.NewTestLogger("supervisor")
+		supervisor = processmonitor.NewSupervisor(
+			logger,
+			informer,
+			new(process.ProcessChecker),
+			func(*redis.Instance) error { return pingErr },
+			func(*net.TCPAddr, time.Duration) error { return nil },
+			func(int) ([]string, error) { return commandLine, nil },
+			writeExecutable("exec sleep 60"),
+			time.Second,
+		)
+		supervisor.ProcessController.ShutdownFunc = func(*redis.Instance, bool) error { return errors.New("not redis") }
+		supervisor.ProcessController.ShutdownTimeout = 100 * time.Millisecond
+		supervisor.ProcessController.TerminateTimeout = 100 * time.Millisecond
+	})
+
+	AfterEach(func() {
+		supervisor.Stop(instance, false)
+		os.RemoveAll(binDir)
+	})
+
+	ensureRunning := func() error {
+		return supervisor.EnsureRunning(instance, "/path/to/redis.conf", "/data", filepath.Join(binDir, "pid"), "/log")
+	}
+
+	It("starts redis-server in the foreground and supervises it", func() {
+		Expect(supervisor.Alive(instance)).To(BeFalse())
+		Expect(ensureRunning()).To(Succeed())
+		Expect(supervisor.Alive(instance)).To(BeTrue())
+	})
+
+	It("notices when redis-server exits", func() {
+		supervisor.RedisServerExecutablePath = writeExecutable("sleep 0.2")
+		Expect(ensureRunning()).To(Succeed())
+
+		Eventually(func() bool { return supervisor.Alive(instance) }).Should(BeFalse())
+	})
+
+	It("logs an exit it did not ask for as an error", func() {
+		supervisor.RedisServerExecutablePath = writeExecutable("sleep 0.2")
+		Expect(ensureRunning()).To(Succeed())
+
+		Eventually(logger.LogMessages).Should(ContainElement("supervisor.redis-server-exited"))
+		for _, log := range logger.Logs() {
+			if log.Message == "supervisor.redis-server-exited" {
+				Expect(log.LogLevel).To(Equal(lager.ERROR))
+			}
+		}
+	})
+
+	It("starts the instance's named redis binary", func() {
+		marker := filepath.Join(binDir, "named-binary-started")
+		namedBinary := filepath.Join(binDir, "redis-server-4.0")
+		script := "#!/bin/sh\ntouch " + marker + "\nexec sleep 60\n"
+		Expect(ioutil.WriteFile(namedBinary, []byte(script), 0755)).To(Succeed())
+
+		supervisor.RedisBinaries = map[string]string{"4.0": namedBinary}
+		instance.RedisBinary = "4.0"
+
+		Expect(ensureRunning()).To(Succeed())
+		Eventually(marker).Should(BeAnExistingFile())
+	})
+
+	It("refuses a named redis binary that is not configured", func() {
+		instance.RedisBinary = "9.9"
+
+		Expect(ensureRunning()).To(MatchError(ContainSubstring("redis binary '9.9', which is not configured")))
+	})
+
+	Context("when its child has exited and redis-server was restarted elsewhere", func() {
+		var adopted *exec.Cmd
+
+		BeforeEach(func() {
+			supervisor.RedisServerExecutablePath = writeExecutable("sleep 0.2")
+		})
+
+		JustBeforeEach(func() {
+			Expect(ensureRunning()).To(Succeed())
+			Eventually(func() bool { return supervisor.Alive(instance) }).Should(BeFalse())
+
+			adopted = exec.Command("sleep", "60")
+			Expect(adopted.Start()).To(Succeed())
+			informer.pid = adopted.Process.Pid
+			informer.err = nil
+		})
+
+		AfterEach(func() {
+			adopted.Process.Kill()
+			adopted.Wait()
+		})
+
+		It("adopts the running redis-server", func() {
+			Expect(supervisor.Alive(instance)).To(BeTrue())
+			Expect(ensureRunning()).To(Succeed())
+			Expect(syscall.Kill(adopted.Process.Pid, 0)).To(Succeed())
+		})
+	})
+
+	It("is not alive when redis does not respond to PING", func() {
+		Expect(ensureRunning()).To(Succeed())
+
+		pingErr = errors.New("connection refused")
+		Expect(supervisor.Alive(instance)).To(BeFalse())
+	})
+
+	Describe("Stop", func() {
+		var shutdownSave bool
+
+		JustBeforeEach(func() {
+			Expect(ensureRunning()).To(Succeed())
+		})
+
+		Context("when SHUTDOWN succeeds", func() {
+			BeforeEach(func() {
+				supervisor.RedisServerExecutablePath = writeExecutable(`echo $$ > ` + filepath.Join(binDir, "child.pid") + `; trap 'exit 0' USR1; while true; do sleep 0.01; done`)
+				supervisor.ProcessController.ShutdownFunc = func(instance *redis.Instance, save bool) error {
+					shutdownSave = save
+					var pid int
+					Eventually(func() error {
+						var err error
+						pid, err = process.ReadPID(filepath.Join(binDir, "child.pid"))
+						return err
+					}).Should(Succeed())
+					Expect(pid).To(BeNumerically(">", 1))
+
+					return syscall.Kill(pid, syscall.SIGUSR1)
+				}
+			})
+
+			It("shuts redis down with the requested save mode", func() {
+				Expect(supervisor.Stop(instance, true)).To(Succeed())
+
+				Expect(shutdownSave).To(BeTrue())
+				Expect(supervisor.Alive(instance)).To(BeFalse())
+			})
+
+			It("logs the exit as requested rather than as an error", func() {
+				Expect(supervisor.Stop(instance, true)).To(Succeed())
+
+				Eventually(logger.LogMessages).Should(ContainElement("supervisor.redis-server-stopped"))
+				Expect(logger.LogMessages()).NotTo(ContainElement("supervisor.redis-server-exited"))
+			})
+		})
+
+		Context("when SHUTDOWN fails", func() {
+			It("falls back to signals", func() {
+				Expect(supervisor.Stop(instance, true)).To(Succeed())
+				Expect(supervisor.Alive(instance)).To(BeFalse())
+			})
+		})
+	})
+
+	Context("when cgroups are enabled", func() {
+		var cgroups *cgroup.Manager
+
+		BeforeEach(func() {
+			cgroups = cgroup.NewManager(filepath.Join(binDir, "cgroup"))
+			supervisor.Cgroups = cgroups
+		})
+
+		It("starts redis-server in the instance cgroup", func() {
+			Expect(cgroups.Create(instance.ID, cgroup.Limits{})).To(Succeed())
+			Expect(ensureRunning()).To(Succeed())
+
+			procs, err := ioutil.ReadFile(filepath.Join(cgroups.Path(instance.ID), "cgroup.procs"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(strconv.Atoi(string(procs))).To(BeNumerically(">", 1))
+		})
+
+		Context("when the cgroup does not exist", func() {
+			It("stops redis-server and returns an error", func() {
+				Expect(ensureRunning()).NotTo(Succeed())
+				Expect(supervisor.Alive(instance)).To(BeFalse())
+			})
+		})
+	})
+
+	Context("when redis-server exits during startup", func() {
+		BeforeEach(func() {
+			supervisor.RedisServerExecutablePath = writeExecutable("echo 'FATAL CONFIG FILE ERROR' >&2; exit 1")
+			supervisor.WaitUntilConnectableFunc = func(*net.TCPAddr, time.Duration) error {
+				time.Sleep(time.Second)
+				return nil
+			}
+		})
+
+		It("returns an error including stderr", func() {
+			err := ensureRunning()
+			Expect(err).To(MatchError(ContainSubstring("FATAL CONFIG FILE ERROR")))
+		})
+	})
+
+	Context("when a redis-server from the pidfile is still around", func() {
+		var orphan *exec.Cmd
+
+		BeforeEach(func() {
+			orphan = exec.Command("sleep", "60")
+			Expect(orphan.Start()).To(Succeed())
+			go orphan.Wait()
+
+			informer.pid = orphan.Process.Pid
+			informer.err = nil
+			pingErr = errors.New("connection refused")
+		})
+
+		AfterEach(func() {
+			orphan.Process.Kill()
+		})
+
+		It("kills it once its command line is verified", func() {
+			Expect(ensureRunning()).To(Succeed())
+			Eventually(func() bool {
+				return new(process.ProcessChecker).Alive(orphan.Process.Pid)
+			}).Should(BeFalse())
+		})
+
+		Context("and it still responds to PING", func() {
+			BeforeEach(func() {
+				pingErr = nil
+			})
+
+			It("adopts it instead of starting another one", func() {
+				Expect(supervisor.Alive(instance)).To(BeTrue())
+				Expect(ensureRunning()).To(Succeed())
+				Expect(new(process.ProcessChecker).Alive(orphan.Process.Pid)).To(BeTrue())
+			})
+		})
+
+		Context("and the PID belongs to another process", func() {
+			BeforeEach(func() {
+				commandLine = []string{"postgres", "-D", "/var/lib/postgres"}
+				Expect(ioutil.WriteFile(filepath.Join(binDir, "pid"), []byte("1"), 0644)).To(Succeed())
+			})
+
+			It("does not kill it", func() {
+				Expect(ensureRunning()).To(Succeed())
+				Consistently(func() bool {
+					return new(process.ProcessChecker).Alive(orphan.Process.Pid)
+				}, "200ms").Should(BeTrue())
+			})
+		})
+	})
+})
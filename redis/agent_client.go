@@ -1,26 +1,80 @@
 package redis
 
 import (
+	"bytes"
+	"context"
 	"crypto/tls"
 	"encoding/json"
-	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
+	"net/url"
+	"time"
 
 	"github.com/pivotal-cf/cf-redis-broker/agentapi"
 )
 
 type Credentials struct {
 	Port     int    `json:"port"`
+	TLSPort  int    `json:"tls_port,omitempty"`
 	Password string `json:"password"`
+	Version  string `json:"redis_version,omitempty"`
 }
 
+// AgentUnreachableError means a request got no answer from the agent: it
+// could not be dialled or did not respond in time.
+type AgentUnreachableError struct {
+	Host string
+	Err  error
+}
+
+func (err *AgentUnreachableError) Error() string {
+	return fmt.Sprintf("agent on %s is unreachable: %s", err.Host, err.Err)
+}
+
+// AgentError means the agent answered, but could not carry out the request.
+type AgentError struct {
+	StatusCode int
+	Body       string
+}
+
+func (err *AgentError) Error() string {
+	if err.Body == "" {
+		return fmt.Sprintf("Agent error: %d", err.StatusCode)
+	}
+	return fmt.Sprintf("Agent error: %d, %s", err.StatusCode, err.Body)
+}
+
+func IsAgentUnreachable(err error) bool {
+	_, ok := err.(*AgentUnreachableError)
+	return ok
+}
+
+const (
+	DefaultAgentTimeout      = 30 * time.Second
+	DefaultAgentResetTimeout = 5 * time.Minute
+	DefaultAgentRetries      = 2
+	DefaultAgentRetryBackoff = 500 * time.Millisecond
+)
+
 type RemoteAgentClient struct {
-	protocol string
-	port     string
-	username string
-	password string
+	// Timeout bounds each request, ResetTimeout bounds resets, which restart
+	// redis and wipe its data on the node.
+	Timeout      time.Duration
+	ResetTimeout time.Duration
+
+	// Retries is how often a GET is retried when the agent is unreachable,
+	// waiting RetryBackoff before the first retry and doubling it after.
+	Retries      int
+	RetryBackoff time.Duration
+
+	protocol   string
+	port       string
+	username   string
+	password   string
+	httpClient *http.Client
 }
 
 func NewRemoteAgentClient(port, username, password string, secure bool) *RemoteAgentClient {
@@ -29,92 +83,179 @@ func NewRemoteAgentClient(port, username, password string, secure bool) *RemoteA
 		proto = "http"
 	}
 
+	transport := &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout:   5 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSClientConfig:     &tls.Config{InsecureSkipVerify: true},
+		TLSHandshakeTimeout: 5 * time.Second,
+		MaxIdleConnsPerHost: 2,
+		IdleConnTimeout:     90 * time.Second,
+	}
+
 	return &RemoteAgentClient{
-		protocol: proto,
-		port:     port,
-		username: username,
-		password: password,
+		Timeout:      DefaultAgentTimeout,
+		ResetTimeout: DefaultAgentResetTimeout,
+		Retries:      DefaultAgentRetries,
+		RetryBackoff: DefaultAgentRetryBackoff,
+		protocol:     proto,
+		port:         port,
+		username:     username,
+		password:     password,
+		httpClient:   &http.Client{Transport: transport},
 	}
 }
 
 func (client *RemoteAgentClient) Reset(host string) error {
-	response, err := client.doAuthenticatedRequest(host, "DELETE", "/")
-	if err != nil {
-		return err
+	return client.do(host, "DELETE", "/", nil, client.ResetTimeout, nil)
+}
+
+func (client *RemoteAgentClient) Credentials(host string) (Credentials, error) {
+	credentials := Credentials{}
+	err := client.getJSON(host, "/", &credentials)
+	return credentials, err
+}
+
+func (client *RemoteAgentClient) Keycount(host string) (int, error) {
+	result := agentapi.KeycountResponse{}
+	if err := client.getJSON(host, "/keycount", &result); err != nil {
+		return 0, err
 	}
 
-	if response.StatusCode != http.StatusOK {
-		return client.agentError(response)
+	return result.Keycount, nil
+}
+
+// Ping checks that the agent is reachable and that its redis answers.
+func (client *RemoteAgentClient) Ping(host string) error {
+	return client.do(host, "GET", "/ping", nil, client.Timeout, nil)
+}
+
+func (client *RemoteAgentClient) Info(host string) (agentapi.InfoResponse, error) {
+	result := agentapi.InfoResponse{}
+	if err := client.getJSON(host, "/info", &result); err != nil {
+		return nil, err
 	}
 
-	return nil
+	return result, nil
 }
 
-func (client *RemoteAgentClient) Credentials(host string) (Credentials, error) {
-	credentials := Credentials{}
+func (client *RemoteAgentClient) Slowlog(host string) (agentapi.SlowlogResponse, error) {
+	result := agentapi.SlowlogResponse{}
+	err := client.getJSON(host, "/slowlog", &result)
+	return result, err
+}
+
+func (client *RemoteAgentClient) Clients(host string) (agentapi.ClientsResponse, error) {
+	result := agentapi.ClientsResponse{}
+	err := client.getJSON(host, "/clients", &result)
+	return result, err
+}
+
+func (client *RemoteAgentClient) EnableCluster(host string, password string) error {
+	return client.sendJSON(host, "PUT", "/cluster", agentapi.ClusterRequest{Password: password})
+}
 
-	response, err := client.doAuthenticatedRequest(host, "GET", "/")
+func (client *RemoteAgentClient) ConfigureReplication(host string, request agentapi.ReplicationRequest) error {
+	return client.sendJSON(host, "PUT", "/replication", request)
+}
+
+func (client *RemoteAgentClient) RemoveReplication(host string, masterName string) error {
+	path := "/replication?master_name=" + url.QueryEscape(masterName)
+	return client.do(host, "DELETE", path, nil, client.Timeout, nil)
+}
+
+func (client *RemoteAgentClient) Failover(host string, masterName string) error {
+	return client.sendJSON(host, "POST", "/failover", agentapi.FailoverRequest{MasterName: masterName})
+}
+
+func (client *RemoteAgentClient) sendJSON(host, method, path string, payload interface{}) error {
+	body, err := json.Marshal(payload)
 	if err != nil {
-		return credentials, err
+		return err
 	}
 
-	if response.StatusCode != http.StatusOK {
-		return credentials, client.agentError(response)
+	return client.do(host, method, path, body, client.Timeout, nil)
+}
+
+func (client *RemoteAgentClient) getJSON(host, path string, result interface{}) error {
+	return client.do(host, "GET", path, nil, client.Timeout, func(response *http.Response) error {
+		return json.NewDecoder(response.Body).Decode(result)
+	})
+}
+
+// do sends a request and hands a successful response to decode, if given.
+// GETs are retried while the agent is unreachable; other requests change
+// state on the node, so they are sent once. The response body is always
+// drained and closed, so the connection can be reused.
+func (client *RemoteAgentClient) do(host, method, path string, body []byte, timeout time.Duration, decode func(*http.Response) error) error {
+	retries := 0
+	if method == "GET" {
+		retries = client.Retries
 	}
 
-	body, err := ioutil.ReadAll(response.Body)
-	if err != nil {
-		return credentials, err
+	backoff := client.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		err := client.attempt(host, method, path, body, timeout, decode)
+		if !IsAgentUnreachable(err) || attempt >= retries {
+			return err
+		}
+
+		time.Sleep(backoff)
+		backoff *= 2
 	}
+}
+
+func (client *RemoteAgentClient) attempt(host, method, path string, body []byte, timeout time.Duration, decode func(*http.Response) error) error {
+	url := fmt.Sprintf("%s://%s%s", client.protocol, net.JoinHostPort(host, client.port), path)
 
-	err = json.Unmarshal(body, &credentials)
+	var bodyReader io.Reader
+	if body != nil {
+		bodyReader = bytes.NewReader(body)
+	}
+
+	request, err := http.NewRequest(method, url, bodyReader)
 	if err != nil {
-		return credentials, err
+		return err
 	}
 
-	return credentials, nil
-}
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
 
-func (client *RemoteAgentClient) Keycount(host string) (int, error) {
-	response, err := client.doAuthenticatedRequest(host, "GET", "/keycount")
+	request.SetBasicAuth(client.username, client.password)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	response, err := client.httpClient.Do(request.WithContext(ctx))
 	if err != nil {
-		return 0, err
+		return &AgentUnreachableError{Host: host, Err: err}
 	}
+	defer func() {
+		io.Copy(ioutil.Discard, response.Body)
+		response.Body.Close()
+	}()
 
 	if response.StatusCode != http.StatusOK {
-		return 0, client.agentError(response)
+		return client.agentError(response)
 	}
 
-	result := new(agentapi.KeycountResponse)
-	if err := json.NewDecoder(response.Body).Decode(result); err != nil {
-		return 0, err
+	if decode == nil {
+		return nil
 	}
 
-	return result.Keycount, nil
+	if err := decode(response); err != nil {
+		if ctx.Err() != nil {
+			return &AgentUnreachableError{Host: host, Err: err}
+		}
+		return err
+	}
+
+	return nil
 }
 
 func (client *RemoteAgentClient) agentError(response *http.Response) error {
 	body, _ := ioutil.ReadAll(response.Body)
-	formattedBody := ""
-	if len(body) > 0 {
-		formattedBody = fmt.Sprintf(", %s", string(body))
-	}
-	return errors.New(fmt.Sprintf("Agent error: %d%s", response.StatusCode, formattedBody))
-}
-
-func (client *RemoteAgentClient) doAuthenticatedRequest(host, method, path string) (*http.Response, error) {
-	url := fmt.Sprintf("%s://%s:%s%s", client.protocol, host, client.port, path)
-	request, err := http.NewRequest(method, url, nil)
-	if err != nil {
-		return nil, err
-	}
-
-	request.SetBasicAuth(client.username, client.password)
-
-	httpClient := &http.Client{
-		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-		},
-	}
-	return httpClient.Do(request)
+	return &AgentError{StatusCode: response.StatusCode, Body: string(body)}
 }
@@ -3,6 +3,7 @@ package redis_test
 import (
 	"net"
 	"net/http"
+	"time"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -10,6 +11,7 @@ import (
 
 	"github.com/pivotal-cf/cf-redis-broker/agentapi"
 	"github.com/pivotal-cf/cf-redis-broker/redis"
+	redisclient "github.com/pivotal-cf/cf-redis-broker/redis/client"
 )
 
 var _ = Describe("RemoteAgentClient", func() {
@@ -76,6 +78,12 @@ var _ = Describe("RemoteAgentClient", func() {
 				err := client.Reset(host)
 				Expect(err).To(MatchError("Agent error: 500"))
 			})
+
+			It("reports that the reset failed rather than the agent being unreachable", func() {
+				err := client.Reset(host)
+				Expect(err).To(BeAssignableToTypeOf(&redis.AgentError{}))
+				Expect(redis.IsAgentUnreachable(err)).To(BeFalse())
+			})
 		})
 	})
 
@@ -164,4 +172,284 @@ var _ = Describe("RemoteAgentClient", func() {
 			})
 		})
 	})
+
+	Describe(".Ping", func() {
+		It("makes a GET request to the host", func() {
+			server.AppendHandlers(
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", "/ping"),
+					ghttp.VerifyBasicAuth(username, password),
+					ghttp.RespondWith(http.StatusOK, ""),
+				),
+			)
+
+			Expect(client.Ping(host)).To(Succeed())
+			Expect(server.ReceivedRequests()).To(HaveLen(1))
+		})
+
+		Context("when redis does not answer", func() {
+			It("returns an error", func() {
+				server.AppendHandlers(ghttp.RespondWith(http.StatusServiceUnavailable, "connection refused"))
+
+				Expect(client.Ping(host)).To(MatchError("Agent error: 503, connection refused"))
+			})
+		})
+	})
+
+	Describe(".Info", func() {
+		var infoResponse = agentapi.InfoResponse{
+			"server": {"redis_version": "3.2.8"},
+			"memory": {"used_memory": "1024"},
+		}
+
+		BeforeEach(func() {
+			server.AppendHandlers(
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", "/info"),
+					ghttp.VerifyBasicAuth(username, password),
+					ghttp.RespondWithJSONEncodedPtr(&status, &infoResponse),
+				),
+			)
+		})
+
+		It("returns the info sections", func() {
+			info, err := client.Info(host)
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(info).To(Equal(infoResponse))
+		})
+
+		Context("when the request fails", func() {
+			BeforeEach(func() {
+				status = http.StatusInternalServerError
+			})
+
+			It("returns an error", func() {
+				_, err := client.Info(host)
+				Expect(err).To(MatchError(ContainSubstring("Agent error: 500")))
+			})
+		})
+	})
+
+	Describe(".Slowlog", func() {
+		var slowlogResponse = agentapi.SlowlogResponse{
+			Entries: []redisclient.SlowlogEntry{
+				{ID: 1, Timestamp: 1500000000, DurationMicroseconds: 20000, Command: []string{"KEYS", "*"}},
+			},
+		}
+
+		BeforeEach(func() {
+			server.AppendHandlers(
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", "/slowlog"),
+					ghttp.VerifyBasicAuth(username, password),
+					ghttp.RespondWithJSONEncodedPtr(&status, &slowlogResponse),
+				),
+			)
+		})
+
+		It("returns the slowlog entries", func() {
+			slowlog, err := client.Slowlog(host)
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(slowlog).To(Equal(slowlogResponse))
+		})
+
+		Context("when the request fails", func() {
+			BeforeEach(func() {
+				status = http.StatusInternalServerError
+			})
+
+			It("returns an error", func() {
+				_, err := client.Slowlog(host)
+				Expect(err).To(MatchError(ContainSubstring("Agent error: 500")))
+			})
+		})
+	})
+
+	Describe(".Clients", func() {
+		var clientsResponse = agentapi.ClientsResponse{
+			Clients: []map[string]string{
+				{"addr": "10.0.0.1:51234", "cmd": "get"},
+			},
+		}
+
+		BeforeEach(func() {
+			server.AppendHandlers(
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", "/clients"),
+					ghttp.VerifyBasicAuth(username, password),
+					ghttp.RespondWithJSONEncodedPtr(&status, &clientsResponse),
+				),
+			)
+		})
+
+		It("returns the connected clients", func() {
+			clients, err := client.Clients(host)
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(clients).To(Equal(clientsResponse))
+		})
+
+		Context("when the request fails", func() {
+			BeforeEach(func() {
+				status = http.StatusInternalServerError
+			})
+
+			It("returns an error", func() {
+				_, err := client.Clients(host)
+				Expect(err).To(MatchError(ContainSubstring("Agent error: 500")))
+			})
+		})
+	})
+
+	Describe(".EnableCluster", func() {
+		BeforeEach(func() {
+			server.AppendHandlers(
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("PUT", "/cluster"),
+					ghttp.VerifyBasicAuth(username, password),
+					ghttp.VerifyJSONRepresenting(agentapi.ClusterRequest{Password: "secret"}),
+					ghttp.RespondWithPtr(&status, nil),
+				),
+			)
+		})
+
+		It("asks the agent to enable cluster mode", func() {
+			Expect(client.EnableCluster(host, "secret")).To(Succeed())
+			Expect(server.ReceivedRequests()).To(HaveLen(1))
+		})
+
+		Context("when the request fails", func() {
+			BeforeEach(func() {
+				status = http.StatusInternalServerError
+			})
+
+			It("returns an error", func() {
+				Expect(client.EnableCluster(host, "secret")).To(MatchError("Agent error: 500"))
+			})
+		})
+	})
+
+	Describe(".ConfigureReplication", func() {
+		var request = agentapi.ReplicationRequest{
+			MasterName: "instance-id",
+			MasterHost: "10.0.0.1",
+			MasterPort: 6379,
+			Password:   "secret",
+			Quorum:     2,
+			Replica:    true,
+		}
+
+		BeforeEach(func() {
+			server.AppendHandlers(
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("PUT", "/replication"),
+					ghttp.VerifyBasicAuth(username, password),
+					ghttp.VerifyJSONRepresenting(request),
+					ghttp.RespondWithPtr(&status, nil),
+				),
+			)
+		})
+
+		It("sends the replication settings", func() {
+			Expect(client.ConfigureReplication(host, request)).To(Succeed())
+			Expect(server.ReceivedRequests()).To(HaveLen(1))
+		})
+
+		Context("when the request fails", func() {
+			BeforeEach(func() {
+				status = http.StatusInternalServerError
+			})
+
+			It("returns an error", func() {
+				err := client.ConfigureReplication(host, request)
+				Expect(err).To(MatchError("Agent error: 500"))
+			})
+		})
+	})
+
+	Describe(".RemoveReplication", func() {
+		BeforeEach(func() {
+			server.AppendHandlers(
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("DELETE", "/replication", "master_name=instance-id"),
+					ghttp.VerifyBasicAuth(username, password),
+					ghttp.RespondWithPtr(&status, nil),
+				),
+			)
+		})
+
+		It("makes a DELETE request for the master", func() {
+			Expect(client.RemoveReplication(host, "instance-id")).To(Succeed())
+			Expect(server.ReceivedRequests()).To(HaveLen(1))
+		})
+	})
+
+	Describe(".Failover", func() {
+		BeforeEach(func() {
+			server.AppendHandlers(
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("POST", "/failover"),
+					ghttp.VerifyBasicAuth(username, password),
+					ghttp.VerifyJSONRepresenting(agentapi.FailoverRequest{MasterName: "instance-id"}),
+					ghttp.RespondWithPtr(&status, nil),
+				),
+			)
+		})
+
+		It("asks the agent to fail over", func() {
+			Expect(client.Failover(host, "instance-id")).To(Succeed())
+			Expect(server.ReceivedRequests()).To(HaveLen(1))
+		})
+
+		Context("when the request fails", func() {
+			BeforeEach(func() {
+				status = http.StatusInternalServerError
+			})
+
+			It("returns an error", func() {
+				err := client.Failover(host, "instance-id")
+				Expect(err).To(MatchError("Agent error: 500"))
+			})
+		})
+	})
+
+	Context("when the agent does not answer in time", func() {
+		hang := func(http.ResponseWriter, *http.Request) {
+			time.Sleep(200 * time.Millisecond)
+		}
+
+		BeforeEach(func() {
+			client.Timeout = 20 * time.Millisecond
+			client.ResetTimeout = 20 * time.Millisecond
+			client.RetryBackoff = time.Millisecond
+		})
+
+		It("retries GET requests", func() {
+			server.AppendHandlers(hang, ghttp.RespondWithJSONEncoded(http.StatusOK, redis.Credentials{Port: 6379}))
+
+			credentials, err := client.Credentials(host)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(credentials.Port).To(Equal(6379))
+			Expect(server.ReceivedRequests()).To(HaveLen(2))
+		})
+
+		It("gives up once the retries are used up", func() {
+			server.AppendHandlers(hang, hang, hang)
+
+			err := client.Ping(host)
+			Expect(redis.IsAgentUnreachable(err)).To(BeTrue())
+			Expect(err).To(MatchError(ContainSubstring("agent on " + host + " is unreachable")))
+			Expect(server.ReceivedRequests()).To(HaveLen(3))
+		})
+
+		It("does not retry resets", func() {
+			server.AppendHandlers(hang)
+
+			err := client.Reset(host)
+			Expect(redis.IsAgentUnreachable(err)).To(BeTrue())
+			Expect(server.ReceivedRequests()).To(HaveLen(1))
+		})
+	})
 })
@@ -3,6 +3,7 @@ package client
 import (
 	"errors"
 	"fmt"
+	"net"
 	"path/filepath"
 	"regexp"
 	"strconv"
@@ -19,6 +20,7 @@ type Client interface {
 	EnableAOF() error
 	LastRDBSaveTime() (int64, error)
 	Info() (map[string]string, error)
+	InfoSections() (map[string]map[string]string, error)
 	InfoField(fieldName string) (string, error)
 	GlobalKeyCount() (int, error)
 	GetConfig(key string) (string, error)
@@ -27,9 +29,24 @@ type Client interface {
 	WaitForNewSaveSince(lastSaveTime int64, timeout time.Duration) error
 	RunBGSave() error
 	Ping() error
+	Shutdown(save bool) error
+	Slowlog() ([]SlowlogEntry, error)
+	ClientList() ([]map[string]string, error)
+	SetConfig(key string, value string) error
+	ReplicaOf(host string, port int) error
+	ClusterAddSlots(slots ...int) error
+	ClusterMeet(host string, port int) error
+	ClusterInfo() (map[string]string, error)
 	Exec(command string, args ...interface{}) (interface{}, error)
 }
 
+type SlowlogEntry struct {
+	ID                   int64    `json:"id"`
+	Timestamp            int64    `json:"timestamp"`
+	DurationMicroseconds int64    `json:"duration_microseconds"`
+	Command              []string `json:"command"`
+}
+
 type client struct {
 	host     string
 	port     int
@@ -81,7 +98,7 @@ func Connect(options ...Option) (Client, error) {
 		opt(c)
 	}
 
-	address := fmt.Sprintf("%v:%v", c.host, c.port)
+	address := c.Address()
 
 	var err error
 	c.connection, err = redisclient.Dial("tcp", address)
@@ -108,7 +125,7 @@ func (c *client) Disconnect() error {
 }
 
 func (c *client) Address() string {
-	return fmt.Sprintf("%s:%d", c.host, c.port)
+	return net.JoinHostPort(c.host, strconv.Itoa(c.port))
 }
 
 func (c *client) WaitUntilRedisNotLoading(timeoutMilliseconds int) error {
@@ -129,7 +146,7 @@ func (c *client) WaitUntilRedisNotLoading(timeoutMilliseconds int) error {
 }
 
 func (c *client) EnableAOF() error {
-	return c.setConfig("appendonly", "yes")
+	return c.SetConfig("appendonly", "yes")
 }
 
 func (c *client) RunBGSave() error {
@@ -161,26 +178,53 @@ func (c *client) InfoField(fieldName string) (string, error) {
 }
 
 func (c *client) Info() (map[string]string, error) {
-	infoCommand := c.lookupAlias("INFO")
+	sections, err := c.InfoSections()
+	if err != nil {
+		return nil, err
+	}
 
 	info := map[string]string{}
+	for _, section := range sections {
+		for key, value := range section {
+			info[key] = value
+		}
+	}
+
+	return info, nil
+}
+
+func (c *client) InfoSections() (map[string]map[string]string, error) {
+	infoCommand := c.lookupAlias("INFO")
 
 	response, err := redisclient.String(c.Exec(infoCommand))
 	if err != nil {
 		return nil, err
 	}
 
+	sections := map[string]map[string]string{}
+	section := map[string]string{}
+
 	for _, entry := range strings.Split(response, "\n") {
 		trimmedEntry := strings.TrimSpace(entry)
-		if trimmedEntry == "" || trimmedEntry[0] == '#' {
+		if trimmedEntry == "" {
 			continue
 		}
 
-		pair := strings.Split(trimmedEntry, ":")
-		info[pair[0]] = pair[1]
+		if trimmedEntry[0] == '#' {
+			name := strings.ToLower(strings.TrimSpace(trimmedEntry[1:]))
+			section = map[string]string{}
+			sections[name] = section
+			continue
+		}
+
+		pair := strings.SplitN(trimmedEntry, ":", 2)
+		if len(pair) != 2 {
+			continue
+		}
+		section[pair[0]] = pair[1]
 	}
 
-	return info, nil
+	return sections, nil
 }
 
 func (c *client) GlobalKeyCount() (int, error) {
@@ -264,13 +308,28 @@ func (c *client) RDBPath() (string, error) {
 	return filepath.Join(dataDir, dbFilename), nil
 }
 
-func (c *client) setConfig(key string, value string) error {
+func (c *client) SetConfig(key string, value string) error {
 	configCommand := c.lookupAlias("CONFIG")
 
 	_, err := c.Exec(configCommand, "SET", key, value)
 	return err
 }
 
+// ReplicaOf makes the server replicate from host:port, or stop replicating
+// and become a master when host is empty. It uses SLAVEOF, which every redis
+// version understands; REPLICAOF is only an alias from 5.0 on.
+func (c *client) ReplicaOf(host string, port int) error {
+	slaveofCommand := c.lookupAlias("SLAVEOF")
+
+	if host == "" {
+		_, err := c.Exec(slaveofCommand, "NO", "ONE")
+		return err
+	}
+
+	_, err := c.Exec(slaveofCommand, host, port)
+	return err
+}
+
 func (c *client) Ping() error {
 	pingCommand := c.lookupAlias("PING")
 
@@ -286,6 +345,132 @@ func (c *client) Ping() error {
 	return nil
 }
 
+func (c *client) Shutdown(save bool) error {
+	shutdownCommand := c.lookupAlias("SHUTDOWN")
+
+	mode := "NOSAVE"
+	if save {
+		mode = "SAVE"
+	}
+
+	// A successful SHUTDOWN closes the connection without a reply, so only
+	// an error reply from the server means it refused to shut down.
+	_, err := c.Exec(shutdownCommand, mode)
+	if _, ok := err.(redisclient.Error); ok {
+		return err
+	}
+
+	return nil
+}
+
+func (c *client) Slowlog() ([]SlowlogEntry, error) {
+	slowlogCommand := c.lookupAlias("SLOWLOG")
+
+	replies, err := redisclient.Values(c.Exec(slowlogCommand, "GET"))
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []SlowlogEntry{}
+	for _, reply := range replies {
+		fields, err := redisclient.Values(reply, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(fields) < 4 {
+			return nil, fmt.Errorf("unexpected slowlog entry: %v", fields)
+		}
+
+		entry := SlowlogEntry{}
+		if entry.ID, err = redisclient.Int64(fields[0], nil); err != nil {
+			return nil, err
+		}
+		if entry.Timestamp, err = redisclient.Int64(fields[1], nil); err != nil {
+			return nil, err
+		}
+		if entry.DurationMicroseconds, err = redisclient.Int64(fields[2], nil); err != nil {
+			return nil, err
+		}
+		if entry.Command, err = redisclient.Strings(fields[3], nil); err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+func (c *client) ClientList() ([]map[string]string, error) {
+	clientCommand := c.lookupAlias("CLIENT")
+
+	response, err := redisclient.String(c.Exec(clientCommand, "LIST"))
+	if err != nil {
+		return nil, err
+	}
+
+	clients := []map[string]string{}
+	for _, line := range strings.Split(response, "\n") {
+		trimmedLine := strings.TrimSpace(line)
+		if trimmedLine == "" {
+			continue
+		}
+
+		fields := map[string]string{}
+		for _, field := range strings.Fields(trimmedLine) {
+			pair := strings.SplitN(field, "=", 2)
+			if len(pair) != 2 {
+				continue
+			}
+			fields[pair[0]] = pair[1]
+		}
+
+		clients = append(clients, fields)
+	}
+
+	return clients, nil
+}
+
+func (c *client) ClusterAddSlots(slots ...int) error {
+	clusterCommand := c.lookupAlias("CLUSTER")
+
+	args := []interface{}{"ADDSLOTS"}
+	for _, slot := range slots {
+		args = append(args, slot)
+	}
+
+	_, err := c.Exec(clusterCommand, args...)
+	return err
+}
+
+func (c *client) ClusterMeet(host string, port int) error {
+	clusterCommand := c.lookupAlias("CLUSTER")
+
+	_, err := c.Exec(clusterCommand, "MEET", host, port)
+	return err
+}
+
+func (c *client) ClusterInfo() (map[string]string, error) {
+	clusterCommand := c.lookupAlias("CLUSTER")
+
+	response, err := redisclient.String(c.Exec(clusterCommand, "INFO"))
+	if err != nil {
+		return nil, err
+	}
+
+	info := map[string]string{}
+	for _, line := range strings.Split(response, "\n") {
+		pair := strings.SplitN(strings.TrimSpace(line), ":", 2)
+		if len(pair) != 2 {
+			continue
+		}
+		info[pair[0]] = pair[1]
+	}
+
+	return info, nil
+}
+
 func (c *client) registerAlias(cmd, alias string) {
 	c.aliases[strings.ToUpper(cmd)] = alias
 }
@@ -195,6 +195,129 @@ var _ = Describe("Client", func() {
 			})
 		})
 
+		Describe(".InfoSections", func() {
+			var redis client.Client
+
+			BeforeEach(func() {
+				var err error
+				redis, err = client.Connect(
+					client.Host(host),
+					client.Port(port),
+				)
+				Expect(err).ToNot(HaveOccurred())
+			})
+
+			It("groups the entries by section", func() {
+				sections, err := redis.InfoSections()
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(sections).To(HaveKey("server"))
+				Expect(sections["persistence"]["aof_enabled"]).To(Equal("0"))
+			})
+		})
+
+		Describe(".Slowlog", func() {
+			var redis client.Client
+
+			BeforeEach(func() {
+				var err error
+				redis, err = client.Connect(
+					client.Host(host),
+					client.Port(port),
+				)
+				Expect(err).ToNot(HaveOccurred())
+
+				_, err = redis.Exec("CONFIG", "SET", "slowlog-log-slower-than", "0")
+				Expect(err).ToNot(HaveOccurred())
+
+				_, err = redis.Exec("SET", "FOO", "BAR")
+				Expect(err).ToNot(HaveOccurred())
+			})
+
+			It("returns the logged commands", func() {
+				entries, err := redis.Slowlog()
+				Expect(err).ToNot(HaveOccurred())
+
+				commands := [][]string{}
+				for _, entry := range entries {
+					commands = append(commands, entry.Command)
+				}
+				Expect(commands).To(ContainElement([]string{"SET", "FOO", "BAR"}))
+			})
+		})
+
+		Describe(".ClientList", func() {
+			var redis client.Client
+
+			BeforeEach(func() {
+				var err error
+				redis, err = client.Connect(
+					client.Host(host),
+					client.Port(port),
+				)
+				Expect(err).ToNot(HaveOccurred())
+			})
+
+			It("includes the current connection", func() {
+				clients, err := redis.ClientList()
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(clients).To(HaveLen(1))
+				Expect(clients[0]["cmd"]).To(Equal("client"))
+			})
+		})
+
+		Describe(".ReplicaOf", func() {
+			It("leaves a master standalone", func() {
+				redis, err := client.Connect(
+					client.Host(host),
+					client.Port(port),
+				)
+				Expect(err).ToNot(HaveOccurred())
+
+				Expect(redis.ReplicaOf("", 0)).To(Succeed())
+
+				role, err := redis.InfoField("role")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(role).To(Equal("master"))
+			})
+		})
+
+		Describe(".ClusterInfo", func() {
+			It("fails when cluster support is disabled", func() {
+				redis, err := client.Connect(
+					client.Host(host),
+					client.Port(port),
+				)
+				Expect(err).ToNot(HaveOccurred())
+
+				_, err = redis.ClusterInfo()
+				Expect(err).To(MatchError(ContainSubstring("cluster support disabled")))
+			})
+		})
+
+		Describe(".Shutdown", func() {
+			var redis client.Client
+
+			BeforeEach(func() {
+				var err error
+				redis, err = client.Connect(
+					client.Host(host),
+					client.Port(port),
+				)
+				Expect(err).ToNot(HaveOccurred())
+			})
+
+			It("stops the server", func() {
+				Expect(redis.Shutdown(false)).To(Succeed())
+
+				Eventually(func() error {
+					_, err := client.Connect(client.Host(host), client.Port(port))
+					return err
+				}).Should(HaveOccurred())
+			})
+		})
+
 		Describe(".GlobalKeyCount", func() {
 			var redis client.Client
 
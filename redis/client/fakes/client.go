@@ -2,6 +2,7 @@ package fakes
 
 import (
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/pivotal-cf/cf-redis-broker/redis/client"
@@ -19,12 +20,33 @@ type Client struct {
 	ExpectedWaitForNewSaveSinceErr error
 	PingReturns                    error
 
+	ShutdownCallCount int
+	ShutdownSaveArg   bool
+	ShutdownReturns   error
+
+	InfoSectionsReturns map[string]map[string]string
+	SlowlogReturns      []client.SlowlogEntry
+	ClientListReturns   []map[string]string
+
+	GetConfigReturns map[string]string
+	SetConfigCalls   []string
+	SetConfigErrs    map[string]error
+	ReplicaOfCalls   []string
+	ExecCalls        []string
+	ExecErrs         map[string]error
+
+	ClusterSlots       []int
+	ClusterMeetCalls   []string
+	ClusterInfoReturns map[string]string
+
 	Host string
 	Port int
 }
 
 func (c *Client) Exec(command string, args ...interface{}) (interface{}, error) {
-	return nil, nil
+	call := strings.TrimSuffix(fmt.Sprintln(append([]interface{}{command}, args...)...), "\n")
+	c.ExecCalls = append(c.ExecCalls, call)
+	return nil, c.ExecErrs[call]
 }
 
 func (c *Client) GlobalKeyCount() (int, error) {
@@ -62,8 +84,20 @@ func (c *Client) Info() (map[string]string, error) {
 	return map[string]string{}, nil
 }
 
+func (c *Client) InfoSections() (map[string]map[string]string, error) {
+	return c.InfoSectionsReturns, nil
+}
+
 func (c *Client) GetConfig(key string) (string, error) {
-	return "", nil
+	if c.GetConfigReturns == nil {
+		return "", nil
+	}
+
+	value, found := c.GetConfigReturns[key]
+	if !found {
+		return "", fmt.Errorf("Key '%s' not found", key)
+	}
+	return value, nil
 }
 
 func (c *Client) RDBPath() (string, error) {
@@ -85,4 +119,46 @@ func (c *Client) Ping() error {
 	return c.PingReturns
 }
 
+func (c *Client) Shutdown(save bool) error {
+	c.ShutdownCallCount++
+	c.ShutdownSaveArg = save
+	return c.ShutdownReturns
+}
+
+func (c *Client) Slowlog() ([]client.SlowlogEntry, error) {
+	return c.SlowlogReturns, nil
+}
+
+func (c *Client) ClientList() ([]map[string]string, error) {
+	return c.ClientListReturns, nil
+}
+
+func (c *Client) SetConfig(key string, value string) error {
+	c.SetConfigCalls = append(c.SetConfigCalls, key+" "+value)
+	return c.SetConfigErrs[key]
+}
+
+func (c *Client) ReplicaOf(host string, port int) error {
+	if host == "" {
+		c.ReplicaOfCalls = append(c.ReplicaOfCalls, "NO ONE")
+	} else {
+		c.ReplicaOfCalls = append(c.ReplicaOfCalls, fmt.Sprintf("%s %d", host, port))
+	}
+	return nil
+}
+
+func (c *Client) ClusterAddSlots(slots ...int) error {
+	c.ClusterSlots = append(c.ClusterSlots, slots...)
+	return nil
+}
+
+func (c *Client) ClusterMeet(host string, port int) error {
+	c.ClusterMeetCalls = append(c.ClusterMeetCalls, fmt.Sprintf("%s %d", host, port))
+	return nil
+}
+
+func (c *Client) ClusterInfo() (map[string]string, error) {
+	return c.ClusterInfoReturns, nil
+}
+
 var _ client.Client = new(Client)
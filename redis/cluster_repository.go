@@ -0,0 +1,332 @@
+package redis
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/pivotal-cf/brokerapi"
+	"github.com/pivotal-cf/cf-redis-broker/broker"
+	"github.com/pivotal-cf/cf-redis-broker/brokerconfig"
+	"github.com/pivotal-cf/cf-redis-broker/redis/client"
+)
+
+const (
+	clusterHashSlots           = 16384
+	defaultClusterReadyTimeout = 30 * time.Second
+	clusterReadyPollInterval   = 500 * time.Millisecond
+)
+
+type ClusterAgentClient interface {
+	AgentClient
+	EnableCluster(host string, password string) error
+}
+
+type ClusterConnectFunc func(host string, port int, password string) (client.Client, error)
+
+// ClusterRepository provisions Redis Cluster instances from the dedicated
+// node pool of a RemoteRepository. Every node of an instance is a master
+// holding an even share of the hash slots.
+type ClusterRepository struct {
+	repo         *RemoteRepository
+	agentClient  ClusterAgentClient
+	nodeCount    int
+	Connect      ClusterConnectFunc
+	ReadyTimeout time.Duration
+	logger       lager.Logger
+}
+
+func NewClusterRepository(repo *RemoteRepository, agentClient ClusterAgentClient, config brokerconfig.Config, logger lager.Logger) *ClusterRepository {
+	return &ClusterRepository{
+		repo:         repo,
+		agentClient:  agentClient,
+		nodeCount:    config.RedisConfiguration.Cluster.NodeCount,
+		Connect:      connectToClusterNode,
+		ReadyTimeout: defaultClusterReadyTimeout,
+		logger:       logger,
+	}
+}
+
+func connectToClusterNode(host string, port int, password string) (client.Client, error) {
+	return client.Connect(
+		client.Host(host),
+		client.Port(port),
+		client.Password(password),
+	)
+}
+
+func (cluster *ClusterRepository) FindByID(instanceID string) (*InstanceGroup, error) {
+	return cluster.repo.findPlanGroup(broker.PlanNameCluster, instanceID)
+}
+
+func (cluster *ClusterRepository) InstanceExists(instanceID string) (bool, error) {
+	_, err := cluster.FindByID(instanceID)
+	return err == nil, nil
+}
+
+// Create reserves the nodes under the repository lock and forms the cluster
+// without it, so that slow agents or a cluster that takes a while to settle
+// hold up no other request. The group is only persisted once the cluster is
+// ready.
+func (cluster *ClusterRepository) Create(instanceID string, parameters broker.ProvisionParameters) error {
+	if parameters.RedisVersion != "" {
+		return errors.New("redis_version can only be chosen for shared-vm instances")
+	}
+
+	cluster.repo.Lock()
+
+	if cluster.repo.instanceIDTaken(instanceID) {
+		cluster.repo.Unlock()
+		return brokerapi.ErrInstanceAlreadyExists
+	}
+
+	if cluster.nodeCount <= 0 {
+		cluster.repo.Unlock()
+		return brokerapi.ErrInstanceLimitMet
+	}
+
+	nodes, err := cluster.repo.chooseHealthyNodes(instanceID, cluster.nodeCount)
+	if err != nil {
+		cluster.repo.Unlock()
+		return err
+	}
+
+	group := cluster.repo.allocateGroup(instanceID, broker.PlanNameCluster, nodes)
+	hosts := append([]string{}, group.Hosts...)
+	cluster.repo.Unlock()
+
+	slots, err := cluster.formCluster(hosts)
+	if err == nil {
+		cluster.repo.Lock()
+		group.Slots = slots
+		err = cluster.repo.PersistStatefile()
+		cluster.repo.Unlock()
+	}
+
+	if err != nil {
+		cluster.resetNodes(hosts)
+		cluster.repo.abandonGroup(group)
+		return err
+	}
+
+	cluster.logger.Info("provision-instance", lager.Data{
+		"instance_id": instanceID,
+		"plan":        broker.PlanNameCluster,
+		"hosts":       hosts,
+		"message":     "Successfully provisioned Redis instance",
+	})
+
+	return nil
+}
+
+// Destroy releases the group's nodes before resetting them, so that the
+// repository is not locked while the agents wipe the nodes.
+func (cluster *ClusterRepository) Destroy(instanceID string) error {
+	cluster.repo.Lock()
+
+	group, err := cluster.FindByID(instanceID)
+	if err == nil {
+		err = cluster.repo.releaseGroup(group)
+	}
+	cluster.repo.Unlock()
+	if err != nil {
+		return err
+	}
+
+	cluster.repo.resetReleased(instanceID, group.Hosts)
+
+	cluster.logger.Info("deprovision-instance", lager.Data{
+		"instance_id": instanceID,
+		"plan":        broker.PlanNameCluster,
+		"message":     "Successfully deprovisioned Redis instance",
+	})
+
+	return nil
+}
+
+// Bind fetches the credentials without holding the repository lock, and
+// records the binding once relocked, provided the instance has not been
+// deprovisioned meanwhile.
+func (cluster *ClusterRepository) Bind(instanceID string, bindingID string) (broker.InstanceCredentials, error) {
+	cluster.repo.RLock()
+	group, err := cluster.FindByID(instanceID)
+	if err == nil && cluster.repo.bindingExists(instanceID, bindingID) {
+		err = brokerapi.ErrBindingAlreadyExists
+	}
+	if err == nil {
+		group = copyGroup(group)
+	}
+	cluster.repo.RUnlock()
+	if err != nil {
+		return broker.InstanceCredentials{}, err
+	}
+
+	credentials, err := cluster.agentClient.Credentials(group.Hosts[0])
+	if err != nil {
+		return broker.InstanceCredentials{}, err
+	}
+
+	cluster.repo.Lock()
+	defer cluster.repo.Unlock()
+
+	if _, err := cluster.FindByID(instanceID); err != nil {
+		return broker.InstanceCredentials{}, err
+	}
+
+	if cluster.repo.bindingExists(instanceID, bindingID) {
+		return broker.InstanceCredentials{}, brokerapi.ErrBindingAlreadyExists
+	}
+
+	err = cluster.repo.recordBinding(instanceID, bindingID)
+	if err != nil {
+		return broker.InstanceCredentials{}, err
+	}
+
+	nodes := []string{}
+	for _, host := range group.Hosts {
+		nodes = append(nodes, net.JoinHostPort(host, strconv.Itoa(credentials.Port)))
+	}
+
+	return broker.InstanceCredentials{
+		Host:     group.Hosts[0],
+		Port:     credentials.Port,
+		Password: credentials.Password,
+		Version:  credentials.Version,
+		Nodes:    nodes,
+	}, nil
+}
+
+func (cluster *ClusterRepository) Unbind(instanceID string, bindingID string) error {
+	cluster.repo.Lock()
+	defer cluster.repo.Unlock()
+
+	if _, err := cluster.FindByID(instanceID); err != nil {
+		return err
+	}
+
+	return cluster.repo.forgetBinding(instanceID, bindingID)
+}
+
+// formCluster puts every node in cluster mode with the first node's
+// password, so that clients can use one password for the whole cluster,
+// then assigns the hash slots and introduces the nodes to each other. It
+// returns the hash slots assigned to each host.
+func (cluster *ClusterRepository) formCluster(hosts []string) (map[string]string, error) {
+	credentials, err := cluster.agentClient.Credentials(hosts[0])
+	if err != nil {
+		return nil, err
+	}
+
+	for _, host := range hosts {
+		err := cluster.agentClient.EnableCluster(host, credentials.Password)
+		if err != nil {
+			return nil, fmt.Errorf("enabling cluster mode on %s: %s", host, err)
+		}
+	}
+
+	clients := []client.Client{}
+	defer func() {
+		for _, nodeClient := range clients {
+			nodeClient.Disconnect()
+		}
+	}()
+
+	for _, host := range hosts {
+		nodeClient, err := cluster.Connect(host, credentials.Port, credentials.Password)
+		if err != nil {
+			return nil, fmt.Errorf("connecting to %s: %s", host, err)
+		}
+		clients = append(clients, nodeClient)
+	}
+
+	assigned := map[string]string{}
+	for index, host := range hosts {
+		first, last := slotRange(index, len(hosts))
+
+		slots := make([]int, 0, last-first+1)
+		for slot := first; slot <= last; slot++ {
+			slots = append(slots, slot)
+		}
+
+		err := clients[index].ClusterAddSlots(slots...)
+		if err != nil {
+			return nil, fmt.Errorf("assigning slots to %s: %s", host, err)
+		}
+
+		assigned[host] = fmt.Sprintf("%d-%d", first, last)
+	}
+
+	for _, host := range hosts[1:] {
+		ip, err := resolveIP(host)
+		if err != nil {
+			return nil, fmt.Errorf("resolving %s: %s", host, err)
+		}
+
+		err = clients[0].ClusterMeet(ip, credentials.Port)
+		if err != nil {
+			return nil, fmt.Errorf("introducing %s: %s", host, err)
+		}
+	}
+
+	err = cluster.waitUntilReady(clients[0])
+	if err != nil {
+		return nil, err
+	}
+
+	return assigned, nil
+}
+
+func (cluster *ClusterRepository) waitUntilReady(nodeClient client.Client) error {
+	deadline := time.Now().Add(cluster.ReadyTimeout)
+
+	for {
+		info, err := nodeClient.ClusterInfo()
+		if err == nil && info["cluster_state"] == "ok" {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			if err != nil {
+				return fmt.Errorf("cluster did not become ready: %s", err)
+			}
+			return fmt.Errorf("cluster did not become ready, state is '%s'", info["cluster_state"])
+		}
+
+		time.Sleep(clusterReadyPollInterval)
+	}
+}
+
+// resetNodes returns the nodes of a cluster that could not be provisioned
+// to a clean state. It carries on past errors, which are only logged.
+func (cluster *ClusterRepository) resetNodes(hosts []string) {
+	for _, host := range hosts {
+		if err := cluster.agentClient.Reset(host); err != nil {
+			cluster.logger.Error("teardown-reset", err, lager.Data{"host": host})
+		}
+	}
+}
+
+// slotRange returns the first and last hash slot of the index-th of count
+// even shares, with any remainder going to the first nodes.
+func slotRange(index, count int) (int, int) {
+	share := clusterHashSlots / count
+	remainder := clusterHashSlots % count
+
+	first := index*share + minInt(index, remainder)
+	size := share
+	if index < remainder {
+		size++
+	}
+
+	return first, first + size - 1
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,307 @@
+package redis_test
+
+import (
+	"errors"
+	"os"
+
+	"code.cloudfoundry.org/lager/lagertest"
+	"github.com/pivotal-cf/brokerapi"
+	"github.com/pivotal-cf/cf-redis-broker/broker"
+	"github.com/pivotal-cf/cf-redis-broker/brokerconfig"
+	"github.com/pivotal-cf/cf-redis-broker/redis"
+	"github.com/pivotal-cf/cf-redis-broker/redis/client"
+	clientfakes "github.com/pivotal-cf/cf-redis-broker/redis/client/fakes"
+	"github.com/pivotal-cf/cf-redis-broker/redis/fakes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ClusterRepository", func() {
+	var (
+		remoteRepo      *redis.RemoteRepository
+		repo            *redis.ClusterRepository
+		statefilePath   string
+		tmpDir          string
+		config          brokerconfig.Config
+		fakeAgentClient *fakes.FakeAgentClient
+		nodeClients     map[string]*clientfakes.Client
+		logger          *lagertest.TestLogger
+	)
+
+	BeforeEach(func() {
+		logger = lagertest.NewTestLogger("cluster-repo")
+		config, tmpDir = dedicatedConfig("10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.4")
+		statefilePath = config.RedisConfiguration.Dedicated.StatefilePath
+		config.RedisConfiguration.ClusterVMPlanID = "cluster-plan"
+		config.RedisConfiguration.Cluster.NodeCount = 3
+
+		fakeAgentClient = &fakes.FakeAgentClient{}
+		fakeAgentClient.CredentialsFunc = func(host string) (redis.Credentials, error) {
+			return redis.Credentials{
+				Port:     6379,
+				Password: "password-of-" + host,
+			}, nil
+		}
+
+		nodeClients = map[string]*clientfakes.Client{}
+		for _, host := range config.RedisConfiguration.Dedicated.Nodes {
+			nodeClients[host] = &clientfakes.Client{
+				ClusterInfoReturns: map[string]string{"cluster_state": "ok"},
+			}
+		}
+	})
+
+	JustBeforeEach(func() {
+		remoteRepo = newRemoteRepository(fakeAgentClient, config, logger)
+
+		repo = redis.NewClusterRepository(remoteRepo, fakeAgentClient, config, logger)
+		repo.ReadyTimeout = 0
+		repo.Connect = func(host string, port int, password string) (client.Client, error) {
+			Expect(port).To(Equal(6379))
+			Expect(password).To(Equal("password-of-10.0.0.1"))
+			return nodeClients[host], nil
+		}
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(tmpDir)
+	})
+
+	Describe("#Create", func() {
+		It("allocates the nodes of the cluster", func() {
+			Expect(repo.Create("cluster-instance", broker.ProvisionParameters{})).To(Succeed())
+
+			group, err := repo.FindByID("cluster-instance")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(group.Plan).To(Equal(broker.PlanNameCluster))
+			Expect(group.Hosts).To(Equal([]string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}))
+
+			Expect(remoteRepo.AvailableInstances()).To(HaveLen(1))
+		})
+
+		It("enables cluster mode on every node with the first node's password", func() {
+			Expect(repo.Create("cluster-instance", broker.ProvisionParameters{})).To(Succeed())
+
+			Expect(fakeAgentClient.ClusterPasswords).To(Equal(map[string]string{
+				"10.0.0.1": "password-of-10.0.0.1",
+				"10.0.0.2": "password-of-10.0.0.1",
+				"10.0.0.3": "password-of-10.0.0.1",
+			}))
+		})
+
+		It("spreads every hash slot over the nodes", func() {
+			Expect(repo.Create("cluster-instance", broker.ProvisionParameters{})).To(Succeed())
+
+			Expect(nodeClients["10.0.0.1"].ClusterSlots).To(HaveLen(5462))
+			Expect(nodeClients["10.0.0.1"].ClusterSlots[0]).To(Equal(0))
+			Expect(nodeClients["10.0.0.2"].ClusterSlots).To(HaveLen(5461))
+			Expect(nodeClients["10.0.0.2"].ClusterSlots[0]).To(Equal(5462))
+			Expect(nodeClients["10.0.0.3"].ClusterSlots).To(HaveLen(5461))
+			Expect(nodeClients["10.0.0.3"].ClusterSlots[5460]).To(Equal(16383))
+
+			group, err := repo.FindByID("cluster-instance")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(group.Slots).To(Equal(map[string]string{
+				"10.0.0.1": "0-5461",
+				"10.0.0.2": "5462-10922",
+				"10.0.0.3": "10923-16383",
+			}))
+		})
+
+		It("introduces the first node to the others", func() {
+			Expect(repo.Create("cluster-instance", broker.ProvisionParameters{})).To(Succeed())
+
+			Expect(nodeClients["10.0.0.1"].ClusterMeetCalls).To(Equal([]string{"10.0.0.2 6379", "10.0.0.3 6379"}))
+		})
+
+		It("persists the cluster in the statefile", func() {
+			Expect(repo.Create("cluster-instance", broker.ProvisionParameters{})).To(Succeed())
+
+			state := getStatefileContents(statefilePath)
+			Expect(state.AllocatedGroups).To(HaveLen(1))
+			Expect(state.AllocatedGroups[0].Plan).To(Equal(broker.PlanNameCluster))
+			Expect(state.AllocatedGroups[0].Slots).To(HaveLen(3))
+		})
+
+		It("forms the cluster without holding the repo lock", func() {
+			fakeAgentClient.EnableClusterFunc = func(string, string) error {
+				unlocked := make(chan struct{})
+				go func() {
+					remoteRepo.Lock()
+					remoteRepo.Unlock()
+					close(unlocked)
+				}()
+				Eventually(unlocked).Should(BeClosed())
+				return nil
+			}
+
+			Expect(repo.Create("cluster-instance", broker.ProvisionParameters{})).To(Succeed())
+			Expect(remoteRepo.AllGroups()).To(HaveLen(1))
+		})
+
+		It("rejects a redis_version", func() {
+			err := repo.Create("cluster-instance", broker.ProvisionParameters{RedisVersion: "4.0"})
+			Expect(err).To(MatchError("redis_version can only be chosen for shared-vm instances"))
+		})
+
+		Context("when there are not enough nodes", func() {
+			It("returns brokerapi.ErrInstanceLimitMet", func() {
+				Expect(repo.Create("cluster-instance", broker.ProvisionParameters{})).To(Succeed())
+				Expect(repo.Create("other-instance", broker.ProvisionParameters{})).To(Equal(brokerapi.ErrInstanceLimitMet))
+			})
+		})
+
+		Context("when cluster mode cannot be enabled on a node", func() {
+			BeforeEach(func() {
+				fakeAgentClient.EnableClusterFunc = func(host, password string) error {
+					if host == "10.0.0.2" {
+						return errors.New("Agent error: 500")
+					}
+					return nil
+				}
+			})
+
+			It("resets the nodes and returns them to the pool", func() {
+				err := repo.Create("cluster-instance", broker.ProvisionParameters{})
+				Expect(err).To(MatchError("enabling cluster mode on 10.0.0.2: Agent error: 500"))
+
+				Expect(fakeAgentClient.ResetHosts).To(Equal([]string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}))
+				Expect(remoteRepo.AvailableInstances()).To(HaveLen(4))
+			})
+
+			It("resets the nodes without holding the repo lock", func() {
+				fakeAgentClient.ResetHandler = func(string) error {
+					unlocked := make(chan struct{})
+					go func() {
+						remoteRepo.Lock()
+						remoteRepo.Unlock()
+						close(unlocked)
+					}()
+					Eventually(unlocked).Should(BeClosed())
+					return nil
+				}
+
+				Expect(repo.Create("cluster-instance", broker.ProvisionParameters{})).NotTo(Succeed())
+				Expect(remoteRepo.AvailableInstances()).To(HaveLen(4))
+			})
+
+			It("keeps the nodes reserved until they are reset", func() {
+				fakeAgentClient.ResetHandler = func(string) error {
+					Expect(remoteRepo.AvailableInstances()).To(HaveLen(1))
+					return nil
+				}
+
+				Expect(repo.Create("cluster-instance", broker.ProvisionParameters{})).NotTo(Succeed())
+			})
+		})
+
+		Context("when the cluster does not become ready", func() {
+			BeforeEach(func() {
+				nodeClients["10.0.0.1"].ClusterInfoReturns = map[string]string{"cluster_state": "fail"}
+			})
+
+			It("returns an error and releases the nodes", func() {
+				err := repo.Create("cluster-instance", broker.ProvisionParameters{})
+				Expect(err).To(MatchError("cluster did not become ready, state is 'fail'"))
+
+				exists, _ := repo.InstanceExists("cluster-instance")
+				Expect(exists).To(BeFalse())
+				Expect(remoteRepo.AvailableInstances()).To(HaveLen(4))
+			})
+		})
+	})
+
+	Context("when a cluster is allocated", func() {
+		JustBeforeEach(func() {
+			Expect(repo.Create("cluster-instance", broker.ProvisionParameters{})).To(Succeed())
+		})
+
+		Describe("#Bind", func() {
+			It("returns the address of every node", func() {
+				credentials, err := repo.Bind("cluster-instance", "binding-id")
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(credentials).To(Equal(broker.InstanceCredentials{
+					Host:     "10.0.0.1",
+					Port:     6379,
+					Password: "password-of-10.0.0.1",
+					Nodes:    []string{"10.0.0.1:6379", "10.0.0.2:6379", "10.0.0.3:6379"},
+				}))
+				Expect(remoteRepo.BindingsForInstance("cluster-instance")).To(Equal([]string{"binding-id"}))
+			})
+
+			It("fetches the credentials without holding the repo lock", func() {
+				fakeAgentClient.CredentialsFunc = func(host string) (redis.Credentials, error) {
+					unlocked := make(chan struct{})
+					go func() {
+						remoteRepo.Lock()
+						remoteRepo.Unlock()
+						close(unlocked)
+					}()
+					Eventually(unlocked).Should(BeClosed())
+					return redis.Credentials{Port: 6379, Password: "password-of-" + host}, nil
+				}
+
+				_, err := repo.Bind("cluster-instance", "binding-id")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(remoteRepo.BindingsForInstance("cluster-instance")).To(Equal([]string{"binding-id"}))
+			})
+
+			Context("when the instance is deprovisioned while the credentials are fetched", func() {
+				JustBeforeEach(func() {
+					fakeAgentClient.CredentialsFunc = func(host string) (redis.Credentials, error) {
+						Expect(repo.Destroy("cluster-instance")).To(Succeed())
+						return redis.Credentials{Port: 6379, Password: "password-of-" + host}, nil
+					}
+				})
+
+				It("does not record the binding", func() {
+					_, err := repo.Bind("cluster-instance", "binding-id")
+					Expect(err).To(Equal(brokerapi.ErrInstanceDoesNotExist))
+
+					_, err = remoteRepo.BindingsForInstance("cluster-instance")
+					Expect(err).To(HaveOccurred())
+				})
+			})
+
+			It("rejects a binding that already exists", func() {
+				_, err := repo.Bind("cluster-instance", "binding-id")
+				Expect(err).NotTo(HaveOccurred())
+
+				_, err = repo.Bind("cluster-instance", "binding-id")
+				Expect(err).To(Equal(brokerapi.ErrBindingAlreadyExists))
+			})
+		})
+
+		Describe("#Unbind", func() {
+			It("removes the binding", func() {
+				_, err := repo.Bind("cluster-instance", "binding-id")
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(repo.Unbind("cluster-instance", "binding-id")).To(Succeed())
+				Expect(remoteRepo.BindingsForInstance("cluster-instance")).To(BeEmpty())
+			})
+		})
+
+		Describe("#Destroy", func() {
+			It("resets every node and returns them to the pool", func() {
+				Expect(repo.Destroy("cluster-instance")).To(Succeed())
+
+				Expect(fakeAgentClient.ResetHosts).To(Equal([]string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}))
+				Expect(remoteRepo.AvailableInstances()).To(HaveLen(4))
+				Expect(getStatefileContents(statefilePath).AllocatedGroups).To(BeEmpty())
+			})
+
+			It("returns brokerapi.ErrInstanceDoesNotExist for unknown instances", func() {
+				Expect(repo.Destroy("unknown")).To(Equal(brokerapi.ErrInstanceDoesNotExist))
+			})
+		})
+
+		It("is not visible to the HA repository", func() {
+			haRepo := redis.NewHARepository(remoteRepo, fakeAgentClient, config, logger)
+			exists, _ := haRepo.InstanceExists("cluster-instance")
+			Expect(exists).To(BeFalse())
+		})
+	})
+})
@@ -1,12 +1,29 @@
 package fakes
 
-import "github.com/pivotal-cf/cf-redis-broker/redis"
+import (
+	"github.com/pivotal-cf/cf-redis-broker/agentapi"
+	"github.com/pivotal-cf/cf-redis-broker/redis"
+)
 
 type FakeAgentClient struct {
 	ResetHosts      []string
 	CredentialsFunc func(string) (redis.Credentials, error)
 
 	ResetHandler func(string) error
+
+	PingFunc     func(string) error
+	KeycountFunc func(string) (int, error)
+
+	InfoFunc                 func(string) (agentapi.InfoResponse, error)
+	ReplicationRequests      map[string]agentapi.ReplicationRequest
+	ConfigureReplicationFunc func(string, agentapi.ReplicationRequest) error
+	RemovedReplicationHosts  []string
+	RemoveReplicationFunc    func(string, string) error
+	FailoverHosts            []string
+	FailoverFunc             func(string, string) error
+
+	ClusterPasswords  map[string]string
+	EnableClusterFunc func(string, string) error
 }
 
 func (fakeAgentClient *FakeAgentClient) Reset(host string) error {
@@ -21,3 +38,68 @@ func (fakeAgentClient *FakeAgentClient) Reset(host string) error {
 func (fakeAgentClient *FakeAgentClient) Credentials(host string) (redis.Credentials, error) {
 	return fakeAgentClient.CredentialsFunc(host)
 }
+
+func (fakeAgentClient *FakeAgentClient) Ping(host string) error {
+	if fakeAgentClient.PingFunc == nil {
+		return nil
+	}
+	return fakeAgentClient.PingFunc(host)
+}
+
+func (fakeAgentClient *FakeAgentClient) Keycount(host string) (int, error) {
+	if fakeAgentClient.KeycountFunc == nil {
+		return 0, nil
+	}
+	return fakeAgentClient.KeycountFunc(host)
+}
+
+func (fakeAgentClient *FakeAgentClient) Info(host string) (agentapi.InfoResponse, error) {
+	if fakeAgentClient.InfoFunc == nil {
+		return agentapi.InfoResponse{}, nil
+	}
+	return fakeAgentClient.InfoFunc(host)
+}
+
+func (fakeAgentClient *FakeAgentClient) ConfigureReplication(host string, request agentapi.ReplicationRequest) error {
+	if fakeAgentClient.ConfigureReplicationFunc != nil {
+		if err := fakeAgentClient.ConfigureReplicationFunc(host, request); err != nil {
+			return err
+		}
+	}
+
+	if fakeAgentClient.ReplicationRequests == nil {
+		fakeAgentClient.ReplicationRequests = map[string]agentapi.ReplicationRequest{}
+	}
+	fakeAgentClient.ReplicationRequests[host] = request
+	return nil
+}
+
+func (fakeAgentClient *FakeAgentClient) RemoveReplication(host string, masterName string) error {
+	fakeAgentClient.RemovedReplicationHosts = append(fakeAgentClient.RemovedReplicationHosts, host)
+	if fakeAgentClient.RemoveReplicationFunc == nil {
+		return nil
+	}
+	return fakeAgentClient.RemoveReplicationFunc(host, masterName)
+}
+
+func (fakeAgentClient *FakeAgentClient) Failover(host string, masterName string) error {
+	fakeAgentClient.FailoverHosts = append(fakeAgentClient.FailoverHosts, host)
+	if fakeAgentClient.FailoverFunc == nil {
+		return nil
+	}
+	return fakeAgentClient.FailoverFunc(host, masterName)
+}
+
+func (fakeAgentClient *FakeAgentClient) EnableCluster(host string, password string) error {
+	if fakeAgentClient.EnableClusterFunc != nil {
+		if err := fakeAgentClient.EnableClusterFunc(host, password); err != nil {
+			return err
+		}
+	}
+
+	if fakeAgentClient.ClusterPasswords == nil {
+		fakeAgentClient.ClusterPasswords = map[string]string{}
+	}
+	fakeAgentClient.ClusterPasswords[host] = password
+	return nil
+}
@@ -97,6 +97,18 @@ type FakeLocalRepository struct {
 	unlockReturns struct {
 		result1 error
 	}
+	LockGlobalStub        func() error
+	lockGlobalMutex       sync.RWMutex
+	lockGlobalArgsForCall []struct{}
+	lockGlobalReturns     struct {
+		result1 error
+	}
+	UnlockGlobalStub        func() error
+	unlockGlobalMutex       sync.RWMutex
+	unlockGlobalArgsForCall []struct{}
+	unlockGlobalReturns     struct {
+		result1 error
+	}
 }
 
 func (fake *FakeLocalRepository) FindByID(instanceID string) (*redis.Instance, error) {
@@ -446,4 +458,52 @@ func (fake *FakeLocalRepository) UnlockReturns(result1 error) {
 	}{result1}
 }
 
+func (fake *FakeLocalRepository) LockGlobal() error {
+	fake.lockGlobalMutex.Lock()
+	fake.lockGlobalArgsForCall = append(fake.lockGlobalArgsForCall, struct{}{})
+	fake.lockGlobalMutex.Unlock()
+	if fake.LockGlobalStub != nil {
+		return fake.LockGlobalStub()
+	} else {
+		return fake.lockGlobalReturns.result1
+	}
+}
+
+func (fake *FakeLocalRepository) LockGlobalCallCount() int {
+	fake.lockGlobalMutex.RLock()
+	defer fake.lockGlobalMutex.RUnlock()
+	return len(fake.lockGlobalArgsForCall)
+}
+
+func (fake *FakeLocalRepository) LockGlobalReturns(result1 error) {
+	fake.LockGlobalStub = nil
+	fake.lockGlobalReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeLocalRepository) UnlockGlobal() error {
+	fake.unlockGlobalMutex.Lock()
+	fake.unlockGlobalArgsForCall = append(fake.unlockGlobalArgsForCall, struct{}{})
+	fake.unlockGlobalMutex.Unlock()
+	if fake.UnlockGlobalStub != nil {
+		return fake.UnlockGlobalStub()
+	} else {
+		return fake.unlockGlobalReturns.result1
+	}
+}
+
+func (fake *FakeLocalRepository) UnlockGlobalCallCount() int {
+	fake.unlockGlobalMutex.RLock()
+	defer fake.unlockGlobalMutex.RUnlock()
+	return len(fake.unlockGlobalArgsForCall)
+}
+
+func (fake *FakeLocalRepository) UnlockGlobalReturns(result1 error) {
+	fake.UnlockGlobalStub = nil
+	fake.unlockGlobalReturns = struct {
+		result1 error
+	}{result1}
+}
+
 var _ redis.LocalInstanceRepository = new(FakeLocalRepository)
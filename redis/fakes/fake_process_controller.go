@@ -9,8 +9,12 @@ import (
 type FakeProcessController struct {
 	StartedInstances  []redis.Instance
 	DoOnInstanceStart func()
+	StartErr          error
 	KilledInstances   []redis.Instance
+	StoppedInstances  []redis.Instance
+	StopSaveArgs      []bool
 	DoOnInstanceStop  func()
+	StopErr           error
 }
 
 func (fakeProcessController *FakeProcessController) StartAndWaitUntilReady(instance *redis.Instance, configPath, instanceDataDir, logfilePath string, timeout time.Duration) error {
@@ -18,7 +22,7 @@ func (fakeProcessController *FakeProcessController) StartAndWaitUntilReady(insta
 	if fakeProcessController.DoOnInstanceStart != nil {
 		fakeProcessController.DoOnInstanceStart()
 	}
-	return nil
+	return fakeProcessController.StartErr
 }
 
 func (fakeProcessController *FakeProcessController) Kill(instance *redis.Instance) error {
@@ -28,3 +32,12 @@ func (fakeProcessController *FakeProcessController) Kill(instance *redis.Instanc
 	}
 	return nil
 }
+
+func (fakeProcessController *FakeProcessController) Stop(instance *redis.Instance, save bool) error {
+	fakeProcessController.StoppedInstances = append(fakeProcessController.StoppedInstances, *instance)
+	fakeProcessController.StopSaveArgs = append(fakeProcessController.StopSaveArgs, save)
+	if fakeProcessController.DoOnInstanceStop != nil {
+		fakeProcessController.DoOnInstanceStop()
+	}
+	return fakeProcessController.StopErr
+}
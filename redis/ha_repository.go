@@ -0,0 +1,305 @@
+package redis
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/pivotal-cf/brokerapi"
+	"github.com/pivotal-cf/cf-redis-broker/agentapi"
+	"github.com/pivotal-cf/cf-redis-broker/broker"
+	"github.com/pivotal-cf/cf-redis-broker/brokerconfig"
+)
+
+const defaultSentinelPort = 26379
+
+type HAAgentClient interface {
+	AgentClient
+	Info(host string) (agentapi.InfoResponse, error)
+	ConfigureReplication(host string, request agentapi.ReplicationRequest) error
+	RemoveReplication(host string, masterName string) error
+	Failover(host string, masterName string) error
+}
+
+// HARepository provisions high availability instances from the dedicated
+// node pool of a RemoteRepository, with which it shares the statefile and
+// bindings.
+type HARepository struct {
+	repo         *RemoteRepository
+	agentClient  HAAgentClient
+	groupSize    int
+	sentinelPort int
+	logger       lager.Logger
+}
+
+func NewHARepository(repo *RemoteRepository, agentClient HAAgentClient, config brokerconfig.Config, logger lager.Logger) *HARepository {
+	sentinelPort := config.RedisConfiguration.HA.SentinelPort
+	if sentinelPort == 0 {
+		sentinelPort = defaultSentinelPort
+	}
+
+	return &HARepository{
+		repo:         repo,
+		agentClient:  agentClient,
+		groupSize:    config.RedisConfiguration.HA.GroupSize,
+		sentinelPort: sentinelPort,
+		logger:       logger,
+	}
+}
+
+func (ha *HARepository) FindByID(instanceID string) (*InstanceGroup, error) {
+	return ha.repo.findPlanGroup(broker.PlanNameHA, instanceID)
+}
+
+func (ha *HARepository) InstanceExists(instanceID string) (bool, error) {
+	_, err := ha.FindByID(instanceID)
+	return err == nil, nil
+}
+
+// Create reserves the nodes under the repository lock and configures
+// replication on them without it, so that slow agents hold up no other
+// request. The group is only persisted once every node is configured.
+func (ha *HARepository) Create(instanceID string, parameters broker.ProvisionParameters) error {
+	if parameters.RedisVersion != "" {
+		return errors.New("redis_version can only be chosen for shared-vm instances")
+	}
+
+	ha.repo.Lock()
+
+	if ha.repo.instanceIDTaken(instanceID) {
+		ha.repo.Unlock()
+		return brokerapi.ErrInstanceAlreadyExists
+	}
+
+	if ha.groupSize <= 0 {
+		ha.repo.Unlock()
+		return brokerapi.ErrInstanceLimitMet
+	}
+
+	nodes, err := ha.repo.chooseHealthyNodes(instanceID, ha.groupSize)
+	if err != nil {
+		ha.repo.Unlock()
+		return err
+	}
+
+	group := ha.repo.allocateGroup(instanceID, broker.PlanNameHA, nodes)
+	ha.repo.Unlock()
+
+	err = ha.configureGroup(group)
+	if err == nil {
+		ha.repo.Lock()
+		err = ha.repo.PersistStatefile()
+		ha.repo.Unlock()
+	}
+
+	if err != nil {
+		ha.teardownGroup(group)
+		ha.repo.abandonGroup(group)
+		return err
+	}
+
+	ha.logger.Info("provision-instance", lager.Data{
+		"instance_id": instanceID,
+		"plan":        broker.PlanNameHA,
+		"hosts":       group.Hosts,
+		"message":     "Successfully provisioned Redis instance",
+	})
+
+	return nil
+}
+
+// Destroy removes the group from the Sentinels on every node before
+// resetting any of them, so that no Sentinel fails over to a node that is
+// being reset. A node whose agent cannot remove the group is still reset
+// and released, as the instance is gone either way. The agents are called
+// after the nodes have been released, without holding the repository lock.
+func (ha *HARepository) Destroy(instanceID string) error {
+	ha.repo.Lock()
+
+	group, err := ha.FindByID(instanceID)
+	if err == nil {
+		err = ha.repo.releaseGroup(group)
+	}
+	ha.repo.Unlock()
+	if err != nil {
+		return err
+	}
+
+	for _, host := range group.Hosts {
+		err := ha.agentClient.RemoveReplication(host, group.MasterName())
+		if err != nil {
+			ha.logger.Error("deprovision-remove-replication-failed", err, lager.Data{
+				"instance_id": instanceID,
+				"host":        host,
+			})
+		}
+	}
+
+	ha.repo.resetReleased(instanceID, group.Hosts)
+
+	ha.logger.Info("deprovision-instance", lager.Data{
+		"instance_id": instanceID,
+		"plan":        broker.PlanNameHA,
+		"message":     "Successfully deprovisioned Redis instance",
+	})
+
+	return nil
+}
+
+// Bind looks up the current master and its credentials without holding the
+// repository lock, and records the binding once relocked, provided the
+// instance has not been deprovisioned meanwhile.
+func (ha *HARepository) Bind(instanceID string, bindingID string) (broker.InstanceCredentials, error) {
+	ha.repo.RLock()
+	group, err := ha.FindByID(instanceID)
+	if err == nil && ha.repo.bindingExists(instanceID, bindingID) {
+		err = brokerapi.ErrBindingAlreadyExists
+	}
+	if err == nil {
+		group = copyGroup(group)
+	}
+	ha.repo.RUnlock()
+	if err != nil {
+		return broker.InstanceCredentials{}, err
+	}
+
+	master := ha.currentMaster(group)
+
+	credentials, err := ha.agentClient.Credentials(master)
+	if err != nil {
+		return broker.InstanceCredentials{}, err
+	}
+
+	ha.repo.Lock()
+	defer ha.repo.Unlock()
+
+	if _, err := ha.FindByID(instanceID); err != nil {
+		return broker.InstanceCredentials{}, err
+	}
+
+	if ha.repo.bindingExists(instanceID, bindingID) {
+		return broker.InstanceCredentials{}, brokerapi.ErrBindingAlreadyExists
+	}
+
+	err = ha.repo.recordBinding(instanceID, bindingID)
+	if err != nil {
+		return broker.InstanceCredentials{}, err
+	}
+
+	return broker.InstanceCredentials{
+		Host:       master,
+		Port:       credentials.Port,
+		Password:   credentials.Password,
+		Version:    credentials.Version,
+		Sentinels:  ha.sentinels(group),
+		MasterName: group.MasterName(),
+	}, nil
+}
+
+func (ha *HARepository) Unbind(instanceID string, bindingID string) error {
+	ha.repo.Lock()
+	defer ha.repo.Unlock()
+
+	if _, err := ha.FindByID(instanceID); err != nil {
+		return err
+	}
+
+	return ha.repo.forgetBinding(instanceID, bindingID)
+}
+
+// Failover asks the Sentinels to promote a replica. Any node's Sentinel can
+// coordinate it, so the nodes are tried in turn.
+func (ha *HARepository) Failover(instanceID string) error {
+	ha.repo.RLock()
+	defer ha.repo.RUnlock()
+
+	group, err := ha.FindByID(instanceID)
+	if err != nil {
+		return err
+	}
+
+	for _, host := range group.Hosts {
+		err = ha.agentClient.Failover(host, group.MasterName())
+		if err == nil {
+			ha.logger.Info("failover", lager.Data{
+				"instance_id": instanceID,
+				"host":        host,
+			})
+			return nil
+		}
+
+		ha.logger.Error("failover", err, lager.Data{
+			"instance_id": instanceID,
+			"host":        host,
+		})
+	}
+
+	return err
+}
+
+func (ha *HARepository) configureGroup(group *InstanceGroup) error {
+	master := group.Hosts[0]
+
+	credentials, err := ha.agentClient.Credentials(master)
+	if err != nil {
+		return err
+	}
+
+	// Sentinels only monitor masters by IP.
+	masterIP, err := resolveIP(master)
+	if err != nil {
+		return fmt.Errorf("resolving %s: %s", master, err)
+	}
+
+	for index, host := range group.Hosts {
+		err := ha.agentClient.ConfigureReplication(host, agentapi.ReplicationRequest{
+			MasterName: group.MasterName(),
+			MasterHost: masterIP,
+			MasterPort: credentials.Port,
+			Password:   credentials.Password,
+			Quorum:     len(group.Hosts)/2 + 1,
+			Replica:    index > 0,
+		})
+		if err != nil {
+			return fmt.Errorf("configuring replication on %s: %s", host, err)
+		}
+	}
+
+	return nil
+}
+
+// teardownGroup returns the nodes of a group that could not be provisioned
+// to a clean state. It carries on past errors, which are only logged.
+func (ha *HARepository) teardownGroup(group *InstanceGroup) {
+	for _, host := range group.Hosts {
+		if err := ha.agentClient.RemoveReplication(host, group.MasterName()); err != nil {
+			ha.logger.Error("teardown-remove-replication", err, lager.Data{"host": host})
+		}
+
+		if err := ha.agentClient.Reset(host); err != nil {
+			ha.logger.Error("teardown-reset", err, lager.Data{"host": host})
+		}
+	}
+}
+
+// currentMaster asks the nodes for their replication role, falling back to
+// the initial master when none of them answers as master.
+func (ha *HARepository) currentMaster(group *InstanceGroup) string {
+	for _, host := range group.Hosts {
+		info, err := ha.agentClient.Info(host)
+		if err == nil && info["replication"]["role"] == "master" {
+			return host
+		}
+	}
+	return group.Hosts[0]
+}
+
+func (ha *HARepository) sentinels(group *InstanceGroup) []string {
+	sentinels := []string{}
+	for _, host := range group.Hosts {
+		sentinels = append(sentinels, net.JoinHostPort(host, strconv.Itoa(ha.sentinelPort)))
+	}
+	return sentinels
+}
@@ -0,0 +1,377 @@
+package redis_test
+
+import (
+	"errors"
+	"os"
+
+	"code.cloudfoundry.org/lager/lagertest"
+	"github.com/pivotal-cf/brokerapi"
+	"github.com/pivotal-cf/cf-redis-broker/agentapi"
+	"github.com/pivotal-cf/cf-redis-broker/broker"
+	"github.com/pivotal-cf/cf-redis-broker/brokerconfig"
+	"github.com/pivotal-cf/cf-redis-broker/redis"
+	"github.com/pivotal-cf/cf-redis-broker/redis/fakes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+)
+
+var _ = Describe("HARepository", func() {
+	var (
+		remoteRepo      *redis.RemoteRepository
+		repo            *redis.HARepository
+		statefilePath   string
+		tmpDir          string
+		config          brokerconfig.Config
+		fakeAgentClient *fakes.FakeAgentClient
+		logger          *lagertest.TestLogger
+	)
+
+	BeforeEach(func() {
+		logger = lagertest.NewTestLogger("ha-repo")
+		config, tmpDir = dedicatedConfig("10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.4")
+		statefilePath = config.RedisConfiguration.Dedicated.StatefilePath
+		config.RedisConfiguration.HAVMPlanID = "ha-plan"
+		config.RedisConfiguration.HA.GroupSize = 3
+
+		fakeAgentClient = &fakes.FakeAgentClient{}
+		fakeAgentClient.CredentialsFunc = func(host string) (redis.Credentials, error) {
+			return redis.Credentials{
+				Port:     6379,
+				Password: "password-of-" + host,
+			}, nil
+		}
+	})
+
+	JustBeforeEach(func() {
+		remoteRepo = newRemoteRepository(fakeAgentClient, config, logger)
+
+		repo = redis.NewHARepository(remoteRepo, fakeAgentClient, config, logger)
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(tmpDir)
+	})
+
+	Describe("#Create", func() {
+		It("allocates a group of nodes", func() {
+			Expect(repo.Create("ha-instance", broker.ProvisionParameters{})).To(Succeed())
+
+			group, err := repo.FindByID("ha-instance")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(group.Hosts).To(Equal([]string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}))
+
+			Expect(remoteRepo.AvailableInstances()).To(HaveLen(1))
+			Expect(remoteRepo.AvailableInstances()[0].Host).To(Equal("10.0.0.4"))
+		})
+
+		It("makes the first node the master and the others its replicas", func() {
+			Expect(repo.Create("ha-instance", broker.ProvisionParameters{})).To(Succeed())
+
+			expected := agentapi.ReplicationRequest{
+				MasterName: "ha-instance",
+				MasterHost: "10.0.0.1",
+				MasterPort: 6379,
+				Password:   "password-of-10.0.0.1",
+				Quorum:     2,
+			}
+			Expect(fakeAgentClient.ReplicationRequests["10.0.0.1"]).To(Equal(expected))
+
+			expected.Replica = true
+			Expect(fakeAgentClient.ReplicationRequests["10.0.0.2"]).To(Equal(expected))
+			Expect(fakeAgentClient.ReplicationRequests["10.0.0.3"]).To(Equal(expected))
+		})
+
+		It("persists the group in the statefile", func() {
+			Expect(repo.Create("ha-instance", broker.ProvisionParameters{})).To(Succeed())
+
+			state := getStatefileContents(statefilePath)
+			Expect(state.AllocatedGroups).To(HaveLen(1))
+			Expect(state.AllocatedGroups[0].ID).To(Equal("ha-instance"))
+			Expect(state.AvailableInstances).To(HaveLen(1))
+		})
+
+		It("keeps the group's nodes out of the pool after a restart", func() {
+			Expect(repo.Create("ha-instance", broker.ProvisionParameters{})).To(Succeed())
+
+			reloaded := newRemoteRepository(fakeAgentClient, config, logger)
+			Expect(reloaded.AvailableInstances()).To(HaveLen(1))
+			Expect(reloaded.IDForHost("10.0.0.2")).To(Equal("ha-instance"))
+		})
+
+		It("rejects a redis_version", func() {
+			err := repo.Create("ha-instance", broker.ProvisionParameters{RedisVersion: "4.0"})
+			Expect(err).To(MatchError("redis_version can only be chosen for shared-vm instances"))
+		})
+
+		It("configures the nodes without holding the repo lock", func() {
+			fakeAgentClient.ConfigureReplicationFunc = func(string, agentapi.ReplicationRequest) error {
+				unlocked := make(chan struct{})
+				go func() {
+					remoteRepo.Lock()
+					remoteRepo.Unlock()
+					close(unlocked)
+				}()
+				Eventually(unlocked).Should(BeClosed())
+				return nil
+			}
+
+			Expect(repo.Create("ha-instance", broker.ProvisionParameters{})).To(Succeed())
+			Expect(remoteRepo.AllGroups()).To(HaveLen(1))
+		})
+
+		It("keeps the nodes reserved while they are configured", func() {
+			fakeAgentClient.ConfigureReplicationFunc = func(string, agentapi.ReplicationRequest) error {
+				Expect(remoteRepo.AvailableInstances()).To(HaveLen(1))
+				return nil
+			}
+
+			Expect(repo.Create("ha-instance", broker.ProvisionParameters{})).To(Succeed())
+		})
+
+		Context("when the instance already exists", func() {
+			It("returns brokerapi.ErrInstanceAlreadyExists", func() {
+				Expect(repo.Create("ha-instance", broker.ProvisionParameters{})).To(Succeed())
+				Expect(repo.Create("ha-instance", broker.ProvisionParameters{})).To(Equal(brokerapi.ErrInstanceAlreadyExists))
+			})
+		})
+
+		Context("when there are not enough nodes for a group", func() {
+			It("returns brokerapi.ErrInstanceLimitMet", func() {
+				Expect(repo.Create("ha-instance", broker.ProvisionParameters{})).To(Succeed())
+				Expect(repo.Create("other-instance", broker.ProvisionParameters{})).To(Equal(brokerapi.ErrInstanceLimitMet))
+			})
+		})
+
+		Context("when a node cannot be configured", func() {
+			BeforeEach(func() {
+				fakeAgentClient.ConfigureReplicationFunc = func(host string, request agentapi.ReplicationRequest) error {
+					if host == "10.0.0.3" {
+						return errors.New("Agent error: 500")
+					}
+					return nil
+				}
+			})
+
+			It("returns the error", func() {
+				err := repo.Create("ha-instance", broker.ProvisionParameters{})
+				Expect(err).To(MatchError("configuring replication on 10.0.0.3: Agent error: 500"))
+			})
+
+			It("resets the nodes and returns them to the pool", func() {
+				repo.Create("ha-instance", broker.ProvisionParameters{})
+
+				Expect(fakeAgentClient.RemovedReplicationHosts).To(Equal([]string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}))
+				Expect(fakeAgentClient.ResetHosts).To(Equal([]string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}))
+				Expect(remoteRepo.AvailableInstances()).To(HaveLen(4))
+
+				exists, _ := repo.InstanceExists("ha-instance")
+				Expect(exists).To(BeFalse())
+			})
+		})
+	})
+
+	Context("when a group is allocated", func() {
+		JustBeforeEach(func() {
+			Expect(repo.Create("ha-instance", broker.ProvisionParameters{})).To(Succeed())
+		})
+
+		Describe("#Bind", func() {
+			It("returns the sentinels and master name", func() {
+				credentials, err := repo.Bind("ha-instance", "binding-id")
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(credentials).To(Equal(broker.InstanceCredentials{
+					Host:       "10.0.0.1",
+					Port:       6379,
+					Password:   "password-of-10.0.0.1",
+					Sentinels:  []string{"10.0.0.1:26379", "10.0.0.2:26379", "10.0.0.3:26379"},
+					MasterName: "ha-instance",
+				}))
+			})
+
+			It("records the binding", func() {
+				_, err := repo.Bind("ha-instance", "binding-id")
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(remoteRepo.BindingsForInstance("ha-instance")).To(Equal([]string{"binding-id"}))
+			})
+
+			It("rejects a binding that already exists", func() {
+				_, err := repo.Bind("ha-instance", "binding-id")
+				Expect(err).NotTo(HaveOccurred())
+
+				_, err = repo.Bind("ha-instance", "binding-id")
+				Expect(err).To(Equal(brokerapi.ErrBindingAlreadyExists))
+			})
+
+			Context("after a failover", func() {
+				BeforeEach(func() {
+					fakeAgentClient.InfoFunc = func(host string) (agentapi.InfoResponse, error) {
+						role := "slave"
+						if host == "10.0.0.2" {
+							role = "master"
+						}
+						return agentapi.InfoResponse{"replication": {"role": role}}, nil
+					}
+				})
+
+				It("returns the current master as the host", func() {
+					credentials, err := repo.Bind("ha-instance", "binding-id")
+					Expect(err).NotTo(HaveOccurred())
+					Expect(credentials.Host).To(Equal("10.0.0.2"))
+				})
+			})
+
+			It("looks up the master without holding the repo lock", func() {
+				fakeAgentClient.InfoFunc = func(string) (agentapi.InfoResponse, error) {
+					unlocked := make(chan struct{})
+					go func() {
+						remoteRepo.Lock()
+						remoteRepo.Unlock()
+						close(unlocked)
+					}()
+					Eventually(unlocked).Should(BeClosed())
+					return agentapi.InfoResponse{"replication": {"role": "master"}}, nil
+				}
+
+				_, err := repo.Bind("ha-instance", "binding-id")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(remoteRepo.BindingsForInstance("ha-instance")).To(Equal([]string{"binding-id"}))
+			})
+
+			Context("when the instance is deprovisioned while the master is looked up", func() {
+				BeforeEach(func() {
+					fakeAgentClient.InfoFunc = func(string) (agentapi.InfoResponse, error) {
+						Expect(repo.Destroy("ha-instance")).To(Succeed())
+						return agentapi.InfoResponse{"replication": {"role": "master"}}, nil
+					}
+				})
+
+				It("does not record the binding", func() {
+					_, err := repo.Bind("ha-instance", "binding-id")
+					Expect(err).To(Equal(brokerapi.ErrInstanceDoesNotExist))
+
+					_, err = remoteRepo.BindingsForInstance("ha-instance")
+					Expect(err).To(HaveOccurred())
+				})
+			})
+
+			It("returns brokerapi.ErrInstanceDoesNotExist for unknown instances", func() {
+				_, err := repo.Bind("unknown", "binding-id")
+				Expect(err).To(Equal(brokerapi.ErrInstanceDoesNotExist))
+			})
+		})
+
+		Describe("#Unbind", func() {
+			It("removes the binding", func() {
+				_, err := repo.Bind("ha-instance", "binding-id")
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(repo.Unbind("ha-instance", "binding-id")).To(Succeed())
+				Expect(remoteRepo.BindingsForInstance("ha-instance")).To(BeEmpty())
+			})
+
+			It("returns brokerapi.ErrBindingDoesNotExist for unknown bindings", func() {
+				Expect(repo.Unbind("ha-instance", "unknown")).To(Equal(brokerapi.ErrBindingDoesNotExist))
+			})
+		})
+
+		Describe("#Destroy", func() {
+			It("removes replication from every node before resetting them", func() {
+				Expect(repo.Destroy("ha-instance")).To(Succeed())
+
+				Expect(fakeAgentClient.RemovedReplicationHosts).To(Equal([]string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}))
+				Expect(fakeAgentClient.ResetHosts).To(Equal([]string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}))
+			})
+
+			It("returns the nodes to the pool", func() {
+				Expect(repo.Destroy("ha-instance")).To(Succeed())
+
+				Expect(remoteRepo.AvailableInstances()).To(HaveLen(4))
+				Expect(getStatefileContents(statefilePath).AllocatedGroups).To(BeEmpty())
+			})
+
+			It("calls the agents without holding the repo lock", func() {
+				fakeAgentClient.ResetHandler = func(string) error {
+					unlocked := make(chan struct{})
+					go func() {
+						remoteRepo.Lock()
+						remoteRepo.Unlock()
+						close(unlocked)
+					}()
+					Eventually(unlocked).Should(BeClosed())
+					return nil
+				}
+
+				Expect(repo.Destroy("ha-instance")).To(Succeed())
+				Expect(remoteRepo.PendingResets()).To(BeEmpty())
+			})
+
+			Context("when replication cannot be removed from a node", func() {
+				BeforeEach(func() {
+					fakeAgentClient.RemoveReplicationFunc = func(host, masterName string) error {
+						if host == "10.0.0.1" {
+							return errors.New("Agent error: 500")
+						}
+						return nil
+					}
+				})
+
+				It("logs it and still resets and releases every node", func() {
+					Expect(repo.Destroy("ha-instance")).To(Succeed())
+
+					Expect(fakeAgentClient.RemovedReplicationHosts).To(Equal([]string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}))
+					Expect(fakeAgentClient.ResetHosts).To(Equal([]string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}))
+					Expect(remoteRepo.AvailableInstances()).To(HaveLen(4))
+					Expect(logger).To(gbytes.Say("deprovision-remove-replication-failed"))
+				})
+			})
+
+			Context("when a node cannot be reset", func() {
+				BeforeEach(func() {
+					fakeAgentClient.ResetHandler = func(host string) error {
+						return errors.New("Agent error: 500")
+					}
+				})
+
+				It("deprovisions the group and keeps its nodes out of the pool until they are reset", func() {
+					Expect(repo.Destroy("ha-instance")).To(Succeed())
+
+					exists, _ := repo.InstanceExists("ha-instance")
+					Expect(exists).To(BeFalse())
+					Expect(remoteRepo.AvailableInstances()).To(HaveLen(1))
+					Expect(remoteRepo.PendingResets()).To(HaveLen(3))
+				})
+			})
+		})
+
+		Describe("#Failover", func() {
+			It("asks a node's agent to fail over", func() {
+				Expect(repo.Failover("ha-instance")).To(Succeed())
+				Expect(fakeAgentClient.FailoverHosts).To(Equal([]string{"10.0.0.1"}))
+			})
+
+			Context("when a node's agent is unreachable", func() {
+				BeforeEach(func() {
+					fakeAgentClient.FailoverFunc = func(host, masterName string) error {
+						if host == "10.0.0.1" {
+							return errors.New("connection refused")
+						}
+						return nil
+					}
+				})
+
+				It("tries the next node", func() {
+					Expect(repo.Failover("ha-instance")).To(Succeed())
+					Expect(fakeAgentClient.FailoverHosts).To(Equal([]string{"10.0.0.1", "10.0.0.2"}))
+				})
+			})
+
+			It("returns brokerapi.ErrInstanceDoesNotExist for unknown instances", func() {
+				Expect(repo.Failover("unknown")).To(Equal(brokerapi.ErrInstanceDoesNotExist))
+			})
+		})
+	})
+})
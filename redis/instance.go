@@ -1,17 +1,29 @@
 package redis
 
-import "net"
+import (
+	"net"
+	"strconv"
+)
 
 type Instance struct {
-	ID       string
-	Host     string
-	Port     int
-	Password string
+	ID         string
+	Host       string
+	Port       int
+	Password   string
+	UnixSocket string
+
+	// RedisBinary names the brokerconfig.RedisBinary the instance runs
+	// with. Empty means the default redis-server.
+	RedisBinary string `json:",omitempty"`
+
+	// CommandAliases are the rename-command directives of a shared
+	// instance's redis.conf, which clients must send commands under.
+	CommandAliases map[string]string `json:"-"`
 }
 
-func (instance Instance) Address() *net.TCPAddr {
-	return &net.TCPAddr{
-		IP:   net.ParseIP(instance.Host),
-		Port: instance.Port,
-	}
+// Address resolves the instance's host, which may be an IPv4 or IPv6
+// address or a DNS name, each time it is called, so that a node whose name
+// moves to a new IP is still reached.
+func (instance Instance) Address() (*net.TCPAddr, error) {
+	return net.ResolveTCPAddr("tcp", net.JoinHostPort(instance.Host, strconv.Itoa(instance.Port)))
 }
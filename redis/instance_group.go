@@ -0,0 +1,182 @@
+package redis
+
+import (
+	"time"
+
+	"github.com/pivotal-cf/brokerapi"
+)
+
+// InstanceGroup is a service instance spanning several dedicated nodes:
+// either a high availability master with its replicas, where Hosts[0] is
+// the initial master, or the masters of a Redis Cluster, where Slots records
+// the hash slots assigned to each host.
+type InstanceGroup struct {
+	ID    string
+	Plan  string
+	Hosts []string
+	Slots map[string]string `json:",omitempty"`
+}
+
+// MasterName is the name the Sentinels know a high availability group's
+// master by.
+func (group InstanceGroup) MasterName() string {
+	return group.ID
+}
+
+func copyGroup(group *InstanceGroup) *InstanceGroup {
+	groupCopy := *group
+	groupCopy.Hosts = append([]string{}, group.Hosts...)
+	if group.Slots != nil {
+		groupCopy.Slots = map[string]string{}
+		for host, slots := range group.Slots {
+			groupCopy.Slots[host] = slots
+		}
+	}
+	return &groupCopy
+}
+
+func (repo *RemoteRepository) findGroup(instanceID string) *InstanceGroup {
+	for _, group := range repo.allocatedGroups {
+		if group.ID == instanceID {
+			return group
+		}
+	}
+	return nil
+}
+
+func (repo *RemoteRepository) findGroupByHost(host string) *InstanceGroup {
+	for _, group := range repo.allocatedGroups {
+		for _, groupHost := range group.Hosts {
+			if groupHost == host {
+				return group
+			}
+		}
+	}
+	return nil
+}
+
+// findPlanGroup only finds groups of the given plan, so that each plan's
+// repository only answers for its own instances.
+func (repo *RemoteRepository) findPlanGroup(plan string, instanceID string) (*InstanceGroup, error) {
+	group := repo.findGroup(instanceID)
+	if group == nil || group.Plan != plan {
+		return nil, brokerapi.ErrInstanceDoesNotExist
+	}
+	return group, nil
+}
+
+func (repo *RemoteRepository) instanceIDTaken(instanceID string) bool {
+	if existingInstance, _ := repo.FindByID(instanceID); existingInstance != nil {
+		return true
+	}
+	return repo.findGroup(instanceID) != nil
+}
+
+func (repo *RemoteRepository) allocateGroup(instanceID string, plan string, nodes []*Instance) *InstanceGroup {
+	group := &InstanceGroup{ID: instanceID, Plan: plan}
+	for _, instance := range nodes {
+		group.Hosts = append(group.Hosts, instance.Host)
+	}
+
+	repo.claimGroup(group)
+
+	return group
+}
+
+// claimGroup takes the group's hosts out of the pool and records the group
+// as allocated.
+func (repo *RemoteRepository) claimGroup(group *InstanceGroup) {
+	stillAvailable := []*Instance{}
+	for _, instance := range repo.availableInstances {
+		if !containsHost(group.Hosts, instance.Host) {
+			stillAvailable = append(stillAvailable, instance)
+		}
+	}
+
+	repo.availableInstances = stillAvailable
+	repo.allocatedGroups = append(repo.allocatedGroups, group)
+	repo.instanceBindings[group.ID] = []string{}
+}
+
+func (repo *RemoteRepository) deallocateGroup(group *InstanceGroup) {
+	nowAllocatedGroups := []*InstanceGroup{}
+	for _, allocatedGroup := range repo.allocatedGroups {
+		if allocatedGroup.ID != group.ID {
+			nowAllocatedGroups = append(nowAllocatedGroups, allocatedGroup)
+		}
+	}
+
+	repo.allocatedGroups = nowAllocatedGroups
+
+	released := []*Instance{}
+	for _, host := range group.Hosts {
+		released = append(released, &Instance{Host: host})
+		repo.freedAt[host] = time.Now()
+	}
+	repo.availableInstances = append(released, repo.availableInstances...)
+
+	delete(repo.instanceBindings, group.ID)
+}
+
+// abandonGroup gives back the nodes of a group whose provisioning failed
+// after they were reserved. It leaves the group alone if it was
+// deprovisioned meanwhile, since its nodes have been released already.
+func (repo *RemoteRepository) abandonGroup(group *InstanceGroup) {
+	repo.Lock()
+	defer repo.Unlock()
+
+	if repo.findGroup(group.ID) != group {
+		return
+	}
+
+	repo.deallocateGroup(group)
+}
+
+func containsHost(hosts []string, host string) bool {
+	for _, candidate := range hosts {
+		if candidate == host {
+			return true
+		}
+	}
+	return false
+}
+
+func (repo *RemoteRepository) bindingExists(instanceID string, bindingID string) bool {
+	for _, binding := range repo.instanceBindings[instanceID] {
+		if binding == bindingID {
+			return true
+		}
+	}
+	return false
+}
+
+func (repo *RemoteRepository) recordBinding(instanceID string, bindingID string) error {
+	repo.instanceBindings[instanceID] = append(repo.instanceBindings[instanceID], bindingID)
+
+	err := repo.PersistStatefile()
+	if err != nil {
+		repo.removeBinding(instanceID, bindingID)
+		return err
+	}
+
+	return nil
+}
+
+func (repo *RemoteRepository) forgetBinding(instanceID string, bindingID string) error {
+	if !repo.bindingExists(instanceID, bindingID) {
+		return brokerapi.ErrBindingDoesNotExist
+	}
+
+	err := repo.removeBinding(instanceID, bindingID)
+	if err != nil {
+		return err
+	}
+
+	err = repo.PersistStatefile()
+	if err != nil {
+		repo.instanceBindings[instanceID] = append(repo.instanceBindings[instanceID], bindingID)
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,38 @@
+package redis_test
+
+import (
+	"net"
+
+	"github.com/pivotal-cf/cf-redis-broker/redis"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Instance", func() {
+	Describe("#Address", func() {
+		It("handles IPv4 addresses", func() {
+			address, err := redis.Instance{Host: "10.0.0.1", Port: 6379}.Address()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(address.String()).To(Equal("10.0.0.1:6379"))
+		})
+
+		It("handles IPv6 addresses", func() {
+			address, err := redis.Instance{Host: "fd00::10", Port: 6379}.Address()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(address.String()).To(Equal("[fd00::10]:6379"))
+		})
+
+		It("resolves hostnames", func() {
+			address, err := redis.Instance{Host: "localhost", Port: 6379}.Address()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(address.IP.IsLoopback()).To(BeTrue())
+			Expect(address.Port).To(Equal(6379))
+		})
+
+		It("returns an error for hostnames that do not resolve", func() {
+			_, err := redis.Instance{Host: "does-not-exist.invalid", Port: 6379}.Address()
+			Expect(err).To(BeAssignableToTypeOf(&net.DNSError{}))
+		})
+	})
+})
@@ -2,17 +2,22 @@ package redis
 
 import (
 	"errors"
+	"fmt"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/pborman/uuid"
 
 	"github.com/pivotal-cf/brokerapi"
+	"github.com/pivotal-cf/cf-redis-broker/broker"
 	"github.com/pivotal-cf/cf-redis-broker/brokerconfig"
 )
 
 type ProcessController interface {
 	StartAndWaitUntilReady(instance *Instance, configPath, instanceDataDir, logfilePath string, timeout time.Duration) error
 	Kill(instance *Instance) error
+	Stop(instance *Instance, save bool) error
 }
 
 type LocalInstanceRepository interface {
@@ -27,53 +32,102 @@ type LocalInstanceRepository interface {
 	InstanceCount() (int, []error)
 	Lock(instance *Instance) error
 	Unlock(instance *Instance) error
+	LockGlobal() error
+	UnlockGlobal() error
+}
+
+type PortAllocator interface {
+	AllocatePort(instanceID string) (int, error)
+	ReleasePort(instanceID string)
 }
 
 type LocalInstanceCreator struct {
 	LocalInstanceRepository
-	FindFreePort       func() (int, error)
+	PortAllocator      PortAllocator
 	ProcessController  ProcessController
 	RedisConfiguration brokerconfig.ServiceConfiguration
 }
 
-func (localInstanceCreator *LocalInstanceCreator) Create(instanceID string) error {
+func (localInstanceCreator *LocalInstanceCreator) Create(instanceID string, parameters broker.ProvisionParameters) error {
+	redisBinary, err := localInstanceCreator.redisBinary(parameters.RedisVersion)
+	if err != nil {
+		return err
+	}
+
+	instance, err := localInstanceCreator.setupInstance(instanceID, redisBinary)
+	if err != nil {
+		return err
+	}
+
+	err = localInstanceCreator.startLocalInstance(instance)
+	if err != nil {
+		localInstanceCreator.Unlock(instance)
+		return err
+	}
+
+	return localInstanceCreator.Unlock(instance)
+}
+
+// setupInstance checks capacity and sets the instance up while holding the
+// global lock, so that concurrent calls cannot exceed ServiceInstanceLimit.
+// The instance is returned locked.
+func (localInstanceCreator *LocalInstanceCreator) setupInstance(instanceID, redisBinary string) (*Instance, error) {
+	err := localInstanceCreator.LockGlobal()
+	if err != nil {
+		return nil, err
+	}
+	defer localInstanceCreator.UnlockGlobal()
+
 	instanceCount, errs := localInstanceCreator.InstanceCount()
 	if len(errs) > 0 {
-		return errors.New("Failed to determine current instance count, view broker logs for details")
+		return nil, errors.New("Failed to determine current instance count, view broker logs for details")
 	}
 
 	if instanceCount >= localInstanceCreator.RedisConfiguration.ServiceInstanceLimit {
-		return brokerapi.ErrInstanceLimitMet
+		return nil, brokerapi.ErrInstanceLimitMet
 	}
 
-	port, err := localInstanceCreator.FindFreePort()
+	port, err := localInstanceCreator.PortAllocator.AllocatePort(instanceID)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	instance := &Instance{
-		ID:       instanceID,
-		Port:     port,
-		Host:     localInstanceCreator.RedisConfiguration.Host,
-		Password: uuid.NewRandom().String(),
+		ID:          instanceID,
+		Port:        port,
+		Host:        localInstanceCreator.RedisConfiguration.SharedHost(),
+		Password:    uuid.NewRandom().String(),
+		RedisBinary: redisBinary,
 	}
 
 	err = localInstanceCreator.Setup(instance)
 	if err != nil {
-		return err
+		localInstanceCreator.PortAllocator.ReleasePort(instanceID)
+		return nil, err
 	}
 
-	err = localInstanceCreator.startLocalInstance(instance)
-	if err != nil {
-		return err
+	return instance, nil
+}
+
+// redisBinary resolves the requested redis_version to a configured binary,
+// defaulting to the plan's default binary.
+func (localInstanceCreator *LocalInstanceCreator) redisBinary(requested string) (string, error) {
+	if requested == "" {
+		return localInstanceCreator.RedisConfiguration.DefaultRedisBinary, nil
 	}
 
-	err = localInstanceCreator.Unlock(instance)
-	if err != nil {
-		return err
+	binaries := localInstanceCreator.RedisConfiguration.RedisBinaryPaths()
+	if _, found := binaries[requested]; found {
+		return requested, nil
+	}
+
+	available := []string{}
+	for name := range binaries {
+		available = append(available, name)
 	}
+	sort.Strings(available)
 
-	return nil
+	return "", fmt.Errorf("Unknown redis_version '%s', available versions: [%s]", requested, strings.Join(available, ", "))
 }
 
 func (localInstanceCreator *LocalInstanceCreator) Destroy(instanceID string) error {
@@ -86,8 +140,11 @@ func (localInstanceCreator *LocalInstanceCreator) Destroy(instanceID string) err
 	if err != nil {
 		return err
 	}
+	// Delete releases the lock, so this only matters when Destroy fails, in
+	// which case the lock must not outlive it.
+	defer localInstanceCreator.Unlock(instance)
 
-	err = localInstanceCreator.ProcessController.Kill(instance)
+	err = localInstanceCreator.ProcessController.Stop(instance, false)
 	if err != nil {
 		return err
 	}
@@ -2,29 +2,42 @@ package redis_test
 
 import (
 	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 
+	"code.cloudfoundry.org/lager/lagertest"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"github.com/pborman/uuid"
 	"github.com/pivotal-cf/brokerapi"
 
+	"github.com/pivotal-cf/cf-redis-broker/broker"
 	"github.com/pivotal-cf/cf-redis-broker/brokerconfig"
 	"github.com/pivotal-cf/cf-redis-broker/redis"
 	"github.com/pivotal-cf/cf-redis-broker/redis/fakes"
 )
 
-var freePortsFound int
+type fakePortAllocator struct {
+	allocatedFor []string
+	releasedFor  []string
+}
 
-func fakeFreePortFinder() (int, error) {
-	freePortsFound++
+func (allocator *fakePortAllocator) AllocatePort(instanceID string) (int, error) {
+	allocator.allocatedFor = append(allocator.allocatedFor, instanceID)
 	return 8080, nil
 }
 
+func (allocator *fakePortAllocator) ReleasePort(instanceID string) {
+	allocator.releasedFor = append(allocator.releasedFor, instanceID)
+}
+
 var _ = Describe("Local Redis Creator", func() {
 	var (
 		instanceID            string
 		fakeProcessController *fakes.FakeProcessController
 		fakeLocalRepository   *fakes.FakeLocalRepository
+		portAllocator         *fakePortAllocator
 		localInstanceCreator  *redis.LocalInstanceCreator
 	)
 
@@ -32,9 +45,10 @@ var _ = Describe("Local Redis Creator", func() {
 		instanceID = uuid.NewRandom().String()
 		fakeProcessController = new(fakes.FakeProcessController)
 		fakeLocalRepository = new(fakes.FakeLocalRepository)
+		portAllocator = new(fakePortAllocator)
 
 		localInstanceCreator = &redis.LocalInstanceCreator{
-			FindFreePort:            fakeFreePortFinder,
+			PortAllocator:           portAllocator,
 			ProcessController:       fakeProcessController,
 			LocalInstanceRepository: fakeLocalRepository,
 			RedisConfiguration: brokerconfig.ServiceConfiguration{
@@ -50,24 +64,20 @@ var _ = Describe("Local Redis Creator", func() {
 			})
 
 			It("should return an error if unable to retrieve instance count", func() {
-				err := localInstanceCreator.Create(instanceID)
+				err := localInstanceCreator.Create(instanceID, broker.ProvisionParameters{})
 				Expect(err).To(HaveOccurred())
 			})
 		})
 
 		Context("when the service instance limit has not been met", func() {
-			BeforeEach(func() {
-				freePortsFound = 0
-			})
-
-			It("finds a free port", func() {
-				err := localInstanceCreator.Create(instanceID)
+			It("allocates a port for the instance", func() {
+				err := localInstanceCreator.Create(instanceID, broker.ProvisionParameters{})
 				Expect(err).NotTo(HaveOccurred())
-				Expect(freePortsFound).To(Equal(1))
+				Expect(portAllocator.allocatedFor).To(Equal([]string{instanceID}))
 			})
 
 			It("starts a new Redis instance", func() {
-				err := localInstanceCreator.Create(instanceID)
+				err := localInstanceCreator.Create(instanceID, broker.ProvisionParameters{})
 				Expect(err).NotTo(HaveOccurred())
 
 				Expect(len(fakeProcessController.StartedInstances)).To(Equal(1))
@@ -75,11 +85,98 @@ var _ = Describe("Local Redis Creator", func() {
 			})
 
 			It("calls Unlock on local repository with correct instance ID", func() {
-				err := localInstanceCreator.Create(instanceID)
+				err := localInstanceCreator.Create(instanceID, broker.ProvisionParameters{})
 				Expect(err).ShouldNot(HaveOccurred())
 				Expect(fakeLocalRepository.UnlockCallCount()).To(Equal(1))
 				Expect(fakeLocalRepository.UnlockArgsForCall(0).ID).To(Equal(instanceID))
 			})
+
+			It("holds the global lock while checking capacity and setting up the instance", func() {
+				fakeLocalRepository.InstanceCountStub = func() (int, []error) {
+					Expect(fakeLocalRepository.LockGlobalCallCount()).To(Equal(1))
+					Expect(fakeLocalRepository.UnlockGlobalCallCount()).To(Equal(0))
+					return 0, nil
+				}
+				fakeLocalRepository.SetupStub = func(*redis.Instance) error {
+					Expect(fakeLocalRepository.UnlockGlobalCallCount()).To(Equal(0))
+					return nil
+				}
+
+				Expect(localInstanceCreator.Create(instanceID, broker.ProvisionParameters{})).To(Succeed())
+				Expect(fakeLocalRepository.UnlockGlobalCallCount()).To(Equal(1))
+			})
+
+			It("keeps the port of the instance", func() {
+				Expect(localInstanceCreator.Create(instanceID, broker.ProvisionParameters{})).To(Succeed())
+				Expect(portAllocator.releasedFor).To(BeEmpty())
+			})
+
+			Context("when starting the instance fails", func() {
+				BeforeEach(func() {
+					fakeProcessController.StartErr = errors.New("redis failed to start")
+				})
+
+				It("unlocks the instance", func() {
+					err := localInstanceCreator.Create(instanceID, broker.ProvisionParameters{})
+					Expect(err).To(MatchError("redis failed to start"))
+					Expect(fakeLocalRepository.UnlockCallCount()).To(Equal(1))
+					Expect(fakeLocalRepository.UnlockArgsForCall(0).ID).To(Equal(instanceID))
+				})
+			})
+
+			Context("when setting up the instance fails", func() {
+				BeforeEach(func() {
+					fakeLocalRepository.SetupReturns(errors.New("disk full"))
+				})
+
+				It("releases the port", func() {
+					err := localInstanceCreator.Create(instanceID, broker.ProvisionParameters{})
+					Expect(err).To(MatchError("disk full"))
+					Expect(portAllocator.releasedFor).To(Equal([]string{instanceID}))
+				})
+			})
+		})
+
+		Context("when redis binaries are configured", func() {
+			BeforeEach(func() {
+				localInstanceCreator.RedisConfiguration.RedisBinaries = []brokerconfig.RedisBinary{
+					{Name: "3.2", ExecutablePath: "/redis-3.2/redis-server"},
+					{Name: "4.0", ExecutablePath: "/redis-4.0/redis-server"},
+				}
+				localInstanceCreator.RedisConfiguration.DefaultRedisBinary = "3.2"
+			})
+
+			It("sets the instance up with the default binary", func() {
+				Expect(localInstanceCreator.Create(instanceID, broker.ProvisionParameters{})).To(Succeed())
+				Expect(fakeLocalRepository.SetupArgsForCall(0).RedisBinary).To(Equal("3.2"))
+			})
+
+			It("sets the instance up with the requested binary", func() {
+				parameters := broker.ProvisionParameters{RedisVersion: "4.0"}
+				Expect(localInstanceCreator.Create(instanceID, parameters)).To(Succeed())
+				Expect(fakeLocalRepository.SetupArgsForCall(0).RedisBinary).To(Equal("4.0"))
+			})
+
+			Context("when the requested version is unknown", func() {
+				It("returns an error without creating an instance", func() {
+					parameters := broker.ProvisionParameters{RedisVersion: "5.0"}
+					err := localInstanceCreator.Create(instanceID, parameters)
+					Expect(err).To(MatchError("Unknown redis_version '5.0', available versions: [3.2, 4.0]"))
+					Expect(fakeLocalRepository.SetupCallCount()).To(Equal(0))
+				})
+			})
+		})
+
+		Context("when the global lock cannot be taken", func() {
+			BeforeEach(func() {
+				fakeLocalRepository.LockGlobalReturns(errors.New("lock is held by another process"))
+			})
+
+			It("returns the error without creating an instance", func() {
+				err := localInstanceCreator.Create(instanceID, broker.ProvisionParameters{})
+				Expect(err).To(MatchError("lock is held by another process"))
+				Expect(fakeLocalRepository.SetupCallCount()).To(Equal(0))
+			})
 		})
 
 		Context("when the service instance limit has been met", func() {
@@ -88,15 +185,20 @@ var _ = Describe("Local Redis Creator", func() {
 			})
 
 			It("does not start a new Redis instance", func() {
-				localInstanceCreator.Create(instanceID)
+				localInstanceCreator.Create(instanceID, broker.ProvisionParameters{})
 
 				Expect(len(fakeProcessController.StartedInstances)).To(Equal(0))
 			})
 
 			It("returns an InstanceLimitMet error", func() {
-				err := localInstanceCreator.Create(instanceID)
+				err := localInstanceCreator.Create(instanceID, broker.ProvisionParameters{})
 				Expect(err).To(Equal(brokerapi.ErrInstanceLimitMet))
 			})
+
+			It("releases the global lock", func() {
+				localInstanceCreator.Create(instanceID, broker.ProvisionParameters{})
+				Expect(fakeLocalRepository.UnlockGlobalCallCount()).To(Equal(1))
+			})
 		})
 	})
 
@@ -121,9 +223,10 @@ var _ = Describe("Local Redis Creator", func() {
 				Expect(fakeLocalRepository.LockArgsForCall(0).ID).To(Equal(instanceID))
 			})
 
-			It("kills the instance", func() {
-				Expect(len(fakeProcessController.KilledInstances)).To(Equal(1))
-				Expect(fakeProcessController.KilledInstances[0].ID).To(Equal(instanceID))
+			It("stops the instance without saving its data", func() {
+				Expect(len(fakeProcessController.StoppedInstances)).To(Equal(1))
+				Expect(fakeProcessController.StoppedInstances[0].ID).To(Equal(instanceID))
+				Expect(fakeProcessController.StopSaveArgs).To(Equal([]bool{false}))
 			})
 
 			It("deletes the instance data directory", func() {
@@ -132,6 +235,19 @@ var _ = Describe("Local Redis Creator", func() {
 			})
 		})
 
+		Context("when stopping the instance fails", func() {
+			BeforeEach(func() {
+				fakeLocalRepository.FindByIDReturns(&redis.Instance{ID: instanceID}, nil)
+				fakeProcessController.StopErr = errors.New("redis did not stop")
+			})
+
+			It("unlocks the instance without deleting it", func() {
+				Expect(localInstanceCreator.Destroy(instanceID)).To(MatchError("redis did not stop"))
+				Expect(fakeLocalRepository.UnlockCallCount()).To(Equal(1))
+				Expect(fakeLocalRepository.DeleteCallCount()).To(Equal(0))
+			})
+		})
+
 		Context("When the instance does not exist", func() {
 			var destroyErr error
 
@@ -148,7 +264,7 @@ var _ = Describe("Local Redis Creator", func() {
 			})
 
 			It("does not try to kill instance processes", func() {
-				Expect(fakeProcessController.KilledInstances).To(BeEmpty())
+				Expect(fakeProcessController.StoppedInstances).To(BeEmpty())
 			})
 
 			It("does not try to delete instances from the instance repository", func() {
@@ -156,4 +272,54 @@ var _ = Describe("Local Redis Creator", func() {
 			})
 		})
 	})
+
+	Context("with a local repository", func() {
+		var tmpDir string
+
+		BeforeEach(func() {
+			var err error
+			tmpDir, err = ioutil.TempDir("", "local-instance-creator")
+			Expect(err).NotTo(HaveOccurred())
+
+			defaultConfigPath := filepath.Join(tmpDir, "redis.conf")
+			Expect(ioutil.WriteFile(defaultConfigPath, []byte{}, 0644)).To(Succeed())
+			Expect(os.Mkdir(filepath.Join(tmpDir, "data"), 0755)).To(Succeed())
+
+			localInstanceCreator.RedisConfiguration = brokerconfig.ServiceConfiguration{
+				Host:                  "127.0.0.1",
+				ServiceInstanceLimit:  1,
+				DefaultConfigPath:     defaultConfigPath,
+				InstanceDataDirectory: filepath.Join(tmpDir, "data"),
+				PidfileDirectory:      filepath.Join(tmpDir, "pids"),
+				InstanceLogDirectory:  filepath.Join(tmpDir, "log"),
+			}
+			localInstanceCreator.LocalInstanceRepository = redis.NewLocalRepository(localInstanceCreator.RedisConfiguration, lagertest.NewTestLogger("local-instance-creator"))
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(tmpDir)).To(Succeed())
+		})
+
+		Context("when the instance fails to start", func() {
+			BeforeEach(func() {
+				fakeProcessController.StartErr = errors.New("redis failed to start")
+				Expect(localInstanceCreator.Create(instanceID, broker.ProvisionParameters{})).To(MatchError("redis failed to start"))
+			})
+
+			It("can be destroyed straight away", func() {
+				done := make(chan error, 1)
+				go func() {
+					done <- localInstanceCreator.Destroy(instanceID)
+				}()
+
+				var err error
+				Eventually(done, "5s").Should(Receive(&err))
+				Expect(err).NotTo(HaveOccurred())
+
+				exists, err := localInstanceCreator.InstanceExists(instanceID)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(exists).To(BeFalse())
+			})
+		})
+	})
 })
@@ -1,33 +1,69 @@
 package redis
 
 import (
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"os"
 	"path"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"code.cloudfoundry.org/lager"
 	"github.com/pivotal-cf/cf-redis-broker/broker"
 	"github.com/pivotal-cf/cf-redis-broker/brokerconfig"
+	"github.com/pivotal-cf/cf-redis-broker/cgroup"
+	"github.com/pivotal-cf/cf-redis-broker/filelock"
 	"github.com/pivotal-cf/cf-redis-broker/redisconf"
 )
 
+const (
+	lockTimeout  = 30 * time.Second
+	staleLockAge = 10 * time.Minute
+
+	// An instance's cgroup allows twice its maxmemory, leaving room for
+	// fragmentation and the copy-on-write pages of a BGSAVE or AOF rewrite.
+	cgroupMemoryPerMaxmemory = 2
+)
+
+type CgroupManager interface {
+	Create(name string, limits cgroup.Limits) error
+	AddProcess(name string, pid int) error
+	Remove(name string) error
+}
+
 type LocalRepository struct {
-	RedisConf brokerconfig.ServiceConfiguration
-	Logger    lager.Logger
+	RedisConf    brokerconfig.ServiceConfiguration
+	Logger       lager.Logger
+	Cgroups      CgroupManager
+	RedisVersion redisconf.Version
+
+	// BinaryVersions holds the detected version of each named redis binary.
+	BinaryVersions map[string]redisconf.Version
+
+	locks      map[string]*filelock.Lock
+	locksMutex sync.Mutex
 }
 
 func NewLocalRepository(redisConf brokerconfig.ServiceConfiguration, logger lager.Logger) *LocalRepository {
 	if redisConf.PidfileDirectory == "" {
 		redisConf.PidfileDirectory = "/var/vcap/sys/run/shared-instance-pidfiles"
 	}
-	return &LocalRepository{
+
+	repo := &LocalRepository{
 		RedisConf: redisConf,
 		Logger:    logger,
 	}
+
+	if redisConf.Cgroup.Root != "" {
+		repo.Cgroups = cgroup.NewManager(redisConf.Cgroup.Root)
+	}
+
+	return repo
 }
 
 func (repo *LocalRepository) FindByID(instanceID string) (*Instance, error) {
@@ -42,15 +78,50 @@ func (repo *LocalRepository) FindByID(instanceID string) (*Instance, error) {
 	}
 
 	instance := &Instance{
-		ID:       instanceID,
-		Password: conf.Get("requirepass"),
-		Port:     port,
-		Host:     repo.RedisConf.Host,
+		ID:         instanceID,
+		Password:   conf.Get("requirepass"),
+		Port:       port,
+		Host:       repo.instanceHost(conf.BindAddresses()),
+		UnixSocket: conf.Get("unixsocket"),
+	}
+
+	if aliases := conf.CommandAliases(); len(aliases) > 0 {
+		instance.CommandAliases = aliases
+	}
+
+	instance.RedisBinary, err = repo.instanceRedisBinary(instanceID)
+	if err != nil {
+		return nil, err
 	}
 
 	return instance, nil
 }
 
+// instanceHost returns the plan's host if the instance listens on it,
+// otherwise the first address the instance is bound to that other VMs can
+// reach. Loopback addresses are only returned when there is nothing else.
+func (repo *LocalRepository) instanceHost(bindAddresses []string) string {
+	host := repo.RedisConf.SharedHost()
+	if len(bindAddresses) == 0 {
+		return host
+	}
+
+	for _, address := range bindAddresses {
+		switch address {
+		case host, "0.0.0.0", "::", "*":
+			return host
+		}
+	}
+
+	for _, address := range bindAddresses {
+		if ip := net.ParseIP(address); ip == nil || !ip.IsLoopback() {
+			return address
+		}
+	}
+
+	return bindAddresses[0]
+}
+
 func (repo *LocalRepository) InstanceExists(instanceID string) (bool, error) {
 	if _, err := os.Stat(repo.InstanceBaseDir(instanceID)); os.IsNotExist(err) {
 		return false, nil
@@ -81,6 +152,31 @@ func (repo *LocalRepository) Setup(instance *Instance) error {
 		return err
 	}
 
+	// The instance is returned locked only when it has been set up.
+	defer func() {
+		if err != nil {
+			repo.Unlock(instance)
+		}
+	}()
+
+	err = repo.ReservePort(instance)
+	if err != nil {
+		repo.Logger.Error("reserve-port", err, lager.Data{
+			"instance_id": instance.ID,
+			"port":        instance.Port,
+		})
+		return err
+	}
+
+	err = repo.RecordRedisBinary(instance)
+	if err != nil {
+		repo.Logger.Error("record-redis-binary", err, lager.Data{
+			"instance_id":  instance.ID,
+			"redis_binary": instance.RedisBinary,
+		})
+		return err
+	}
+
 	err = repo.WriteConfigFile(instance)
 	if err != nil {
 		repo.Logger.Error("write-config-file", err, lager.Data{
@@ -89,6 +185,14 @@ func (repo *LocalRepository) Setup(instance *Instance) error {
 		return err
 	}
 
+	err = repo.EnsureCgroup(instance)
+	if err != nil {
+		repo.Logger.Error("create-cgroup", err, lager.Data{
+			"instance_id": instance.ID,
+		})
+		return err
+	}
+
 	repo.Logger.Info("provision-instance", lager.Data{
 		"instance_id": instance.ID,
 		"plan":        "shared-vm",
@@ -98,31 +202,168 @@ func (repo *LocalRepository) Setup(instance *Instance) error {
 	return nil
 }
 
+// Lock takes the instance's file lock, waiting for another holder such as
+// the process monitor to release it. The lock is held until Unlock or Delete.
 func (repo *LocalRepository) Lock(instance *Instance) error {
-	lockFilePath := repo.lockFilePath(instance)
-	lockFile, err := os.Create(lockFilePath)
+	lock, err := filelock.Acquire(repo.lockFilePath(instance), staleLockAge, lockTimeout)
 	if err != nil {
 		return err
 	}
-	lockFile.Close()
 
+	repo.holdLock(instance.ID, lock)
+	return nil
+}
+
+// TryLock takes the instance's file lock if nobody else holds it, returning
+// filelock.ErrLocked otherwise.
+func (repo *LocalRepository) TryLock(instance *Instance) error {
+	lock, err := filelock.TryAcquire(repo.lockFilePath(instance), staleLockAge)
+	if err != nil {
+		return err
+	}
+
+	repo.holdLock(instance.ID, lock)
 	return nil
 }
 
 func (repo *LocalRepository) Unlock(instance *Instance) error {
-	lockFilePath := repo.lockFilePath(instance)
-	err := os.Remove(lockFilePath)
+	return repo.releaseLock(instance.ID)
+}
+
+// LockGlobal serialises capacity checks and instance creation across
+// concurrent Create calls.
+func (repo *LocalRepository) LockGlobal() error {
+	err := os.MkdirAll(repo.RedisConf.PidfileDirectory, 0755)
+	if err != nil {
+		return err
+	}
+
+	lock, err := filelock.Acquire(repo.globalLockFilePath(), staleLockAge, lockTimeout)
 	if err != nil {
 		return err
 	}
 
+	repo.holdLock("", lock)
 	return nil
 }
 
+func (repo *LocalRepository) UnlockGlobal() error {
+	return repo.releaseLock("")
+}
+
+func (repo *LocalRepository) holdLock(key string, lock *filelock.Lock) {
+	repo.locksMutex.Lock()
+	defer repo.locksMutex.Unlock()
+
+	if repo.locks == nil {
+		repo.locks = map[string]*filelock.Lock{}
+	}
+	repo.locks[key] = lock
+}
+
+func (repo *LocalRepository) releaseLock(key string) error {
+	repo.locksMutex.Lock()
+	lock, found := repo.locks[key]
+	delete(repo.locks, key)
+	repo.locksMutex.Unlock()
+
+	if !found {
+		return errors.New("lock is not held")
+	}
+
+	return lock.Release()
+}
+
 func (repo *LocalRepository) lockFilePath(instance *Instance) string {
 	return filepath.Join(repo.InstanceBaseDir(instance.ID), "lock")
 }
 
+func (repo *LocalRepository) globalLockFilePath() string {
+	return filepath.Join(repo.RedisConf.PidfileDirectory, "shared-instances.lock")
+}
+
+// ReservePort records the instance's port next to its config, so that the
+// port stays allocated even if the config is rewritten.
+func (repo *LocalRepository) ReservePort(instance *Instance) error {
+	return ioutil.WriteFile(
+		repo.InstancePortReservationPath(instance.ID),
+		[]byte(strconv.Itoa(instance.Port)),
+		0644,
+	)
+}
+
+// RecordRedisBinary stores the name of the instance's redis binary in its
+// base dir, so that it is started with the same binary after a restart.
+func (repo *LocalRepository) RecordRedisBinary(instance *Instance) error {
+	if instance.RedisBinary == "" {
+		err := os.Remove(repo.InstanceRedisBinaryPath(instance.ID))
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	return ioutil.WriteFile(
+		repo.InstanceRedisBinaryPath(instance.ID),
+		[]byte(instance.RedisBinary),
+		0644,
+	)
+}
+
+func (repo *LocalRepository) instanceRedisBinary(instanceID string) (string, error) {
+	data, err := ioutil.ReadFile(repo.InstanceRedisBinaryPath(instanceID))
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(data)), nil
+}
+
+// redisVersion is the version of the binary the instance runs with, or the
+// zero Version if it is unknown.
+func (repo *LocalRepository) redisVersion(instance *Instance) redisconf.Version {
+	if instance.RedisBinary != "" {
+		return repo.BinaryVersions[instance.RedisBinary]
+	}
+
+	return repo.RedisVersion
+}
+
+// AllocatedPorts maps every port reserved by, or configured for, an existing
+// instance to the instance ID.
+func (repo *LocalRepository) AllocatedPorts() (map[int]string, error) {
+	ports := map[int]string{}
+
+	instanceDirs, err := ioutil.ReadDir(repo.RedisConf.InstanceDataDirectory)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, instanceDir := range instanceDirs {
+		instanceID := instanceDir.Name()
+
+		reservation, err := ioutil.ReadFile(repo.InstancePortReservationPath(instanceID))
+		if err == nil {
+			if port, err := strconv.Atoi(strings.TrimSpace(string(reservation))); err == nil {
+				ports[port] = instanceID
+			}
+		}
+
+		conf, err := redisconf.Load(repo.InstanceConfigPath(instanceID))
+		if err == nil {
+			if port, err := strconv.Atoi(conf.Get("port")); err == nil {
+				ports[port] = instanceID
+			}
+		}
+	}
+
+	return ports, nil
+}
+
 func (repo *LocalRepository) allInstances(verbose bool) ([]*Instance, []error) {
 	if verbose {
 		repo.Logger.Info("all-instances", lager.Data{
@@ -200,9 +441,11 @@ func (repo *LocalRepository) Bind(instanceID string, bindingID string) (broker.I
 		return broker.InstanceCredentials{}, err
 	}
 	return broker.InstanceCredentials{
-		Host:     instance.Host,
-		Port:     instance.Port,
-		Password: instance.Password,
+		Host:       instance.Host,
+		Port:       instance.Port,
+		Password:   instance.Password,
+		UnixSocket: instance.UnixSocket,
+		Version:    repo.redisVersion(instance).String(),
 	}, nil
 }
 
@@ -210,7 +453,16 @@ func (repo *LocalRepository) Unbind(instanceID string, bindingID string) error {
 	return nil
 }
 
+// Delete removes the instance's cgroup, which fails while any of its
+// processes is still running, before its data, logs and pidfile.
 func (repo *LocalRepository) Delete(instanceID string) error {
+	if repo.Cgroups != nil {
+		err := repo.Cgroups.Remove(instanceID)
+		if err != nil {
+			return err
+		}
+	}
+
 	err := os.RemoveAll(repo.InstanceBaseDir(instanceID))
 	if err != nil {
 		return err
@@ -221,11 +473,14 @@ func (repo *LocalRepository) Delete(instanceID string) error {
 		return err
 	}
 
+	// An instance that failed to start may never have written its pidfile.
 	err = os.Remove(repo.InstancePidFilePath(instanceID))
-	if err != nil {
+	if err != nil && !os.IsNotExist(err) {
 		return err
 	}
 
+	repo.releaseLock(instanceID)
+
 	repo.Logger.Info("deprovision-instance", lager.Data{
 		"instance_id": instanceID,
 		"plan":        "shared-vm",
@@ -251,9 +506,62 @@ func (repo *LocalRepository) EnsureDirectoriesExist(instance *Instance) error {
 		return err
 	}
 
+	if repo.RedisConf.Shared.Network.UnixSocketDirectory != "" {
+		err = os.MkdirAll(repo.RedisConf.Shared.Network.UnixSocketDirectory, 0755)
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
+// EnsureCgroup creates the instance's cgroup when cgroups are enabled.
+func (repo *LocalRepository) EnsureCgroup(instance *Instance) error {
+	if repo.Cgroups == nil {
+		return nil
+	}
+
+	limits, err := repo.CgroupLimits(instance.ID)
+	if err != nil {
+		return err
+	}
+
+	return repo.Cgroups.Create(instance.ID, limits)
+}
+
+// CgroupLimits derives the instance's cgroup limits from its redis.conf:
+// the memory limit follows the plan's maxmemory unless memory_max_mb
+// overrides it, and an instance without maxmemory gets no memory limit.
+func (repo *LocalRepository) CgroupLimits(instanceID string) (cgroup.Limits, error) {
+	limits := cgroup.Limits{
+		MemoryMaxBytes: int64(repo.RedisConf.Cgroup.MemoryMaxMB) * 1024 * 1024,
+		CPUWeight:      repo.RedisConf.Cgroup.CPUWeight,
+		PidsMax:        repo.RedisConf.Cgroup.PidsMax,
+	}
+
+	if limits.MemoryMaxBytes > 0 {
+		return limits, nil
+	}
+
+	conf, err := redisconf.Load(repo.InstanceConfigPath(instanceID))
+	if err != nil {
+		return cgroup.Limits{}, err
+	}
+
+	if conf.Get("maxmemory") == "" {
+		return limits, nil
+	}
+
+	maxmemory, err := redisconf.MemoryBytes(conf.Get("maxmemory"))
+	if err != nil {
+		return cgroup.Limits{}, fmt.Errorf("maxmemory of instance %s: %s", instanceID, err)
+	}
+
+	limits.MemoryMaxBytes = maxmemory * cgroupMemoryPerMaxmemory
+	return limits, nil
+}
+
 func (repo *LocalRepository) WriteConfigFile(instance *Instance) error {
 	return redisconf.CopyWithInstanceAdditions(
 		repo.RedisConf.DefaultConfigPath,
@@ -262,9 +570,25 @@ func (repo *LocalRepository) WriteConfigFile(instance *Instance) error {
 		strconv.Itoa(instance.Port),
 		instance.Password,
 		repo.RedisConf.PidfileDirectory,
+		redisconf.InstanceNetwork{
+			BindAddresses:  repo.RedisConf.Shared.Network.BindAddresses,
+			ProtectedMode:  repo.RedisConf.Shared.Network.ProtectedMode,
+			UnixSocket:     repo.InstanceUnixSocketPath(instance.ID),
+			UnixSocketPerm: repo.RedisConf.Shared.Network.UnixSocketPerm,
+		},
+		repo.redisVersion(instance),
 	)
 }
 
+// InstanceUnixSocketPath is empty unless a unix socket directory is configured.
+func (repo *LocalRepository) InstanceUnixSocketPath(instanceID string) string {
+	if repo.RedisConf.Shared.Network.UnixSocketDirectory == "" {
+		return ""
+	}
+
+	return path.Join(repo.RedisConf.Shared.Network.UnixSocketDirectory, instanceID+".sock")
+}
+
 func (repo *LocalRepository) InstanceBaseDir(instanceID string) string {
 	return path.Join(repo.RedisConf.InstanceDataDirectory, instanceID)
 }
@@ -286,6 +610,18 @@ func (repo *LocalRepository) InstanceConfigPath(instanceID string) string {
 	return path.Join(repo.InstanceBaseDir(instanceID), "redis.conf")
 }
 
+func (repo *LocalRepository) InstancePortReservationPath(instanceID string) string {
+	return path.Join(repo.InstanceBaseDir(instanceID), "port")
+}
+
+func (repo *LocalRepository) InstanceRedisBinaryPath(instanceID string) string {
+	return path.Join(repo.InstanceBaseDir(instanceID), "redis_binary")
+}
+
+func (repo *LocalRepository) InstanceRestartHistoryPath(instanceID string) string {
+	return path.Join(repo.InstanceBaseDir(instanceID), "restart_history.json")
+}
+
 func (repo *LocalRepository) InstancePidFilePath(instanceID string) string {
 	return path.Join(repo.RedisConf.PidfileDirectory, instanceID+".pid")
 }
@@ -12,13 +12,39 @@ import (
 	"github.com/pborman/uuid"
 
 	"github.com/pivotal-cf/cf-redis-broker/brokerconfig"
+	"github.com/pivotal-cf/cf-redis-broker/cgroup"
+	"github.com/pivotal-cf/cf-redis-broker/filelock"
 	"github.com/pivotal-cf/cf-redis-broker/redis"
+	"github.com/pivotal-cf/cf-redis-broker/redisconf"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"github.com/onsi/gomega/gbytes"
 )
 
+// fakeCgroupfs keeps cgroups in a plain directory, from which the interface
+// files have to be removed by hand before a group, where the kernel removes
+// them along with it. onRemove is called first.
+type fakeCgroupfs struct {
+	*cgroup.Manager
+	onRemove func(name string)
+}
+
+func (cgroupfs fakeCgroupfs) Remove(name string) error {
+	if cgroupfs.onRemove != nil {
+		cgroupfs.onRemove(name)
+	}
+
+	files, _ := ioutil.ReadDir(cgroupfs.Path(name))
+	for _, file := range files {
+		if !file.IsDir() {
+			os.Remove(filepath.Join(cgroupfs.Path(name), file.Name()))
+		}
+	}
+
+	return cgroupfs.Manager.Remove(name)
+}
+
 var _ = Describe("Local Repository", func() {
 	var (
 		instanceID                string
@@ -191,6 +217,184 @@ var _ = Describe("Local Repository", func() {
 				Expect(os.IsNotExist(err)).To(BeTrue())
 			})
 		})
+
+		Context("when the instance exists", func() {
+			It("uses the configured host", func() {
+				newTestInstance(instanceID, repo)
+
+				instance, err := repo.FindByID(instanceID)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(instance.Host).To(Equal("127.0.0.1"))
+				Expect(instance.UnixSocket).To(BeEmpty())
+				Expect(instance.CommandAliases).To(BeEmpty())
+			})
+
+			It("reads the command aliases of its redis.conf", func() {
+				newTestInstance(instanceID, repo)
+
+				conf, err := redisconf.Load(repo.InstanceConfigPath(instanceID))
+				Expect(err).NotTo(HaveOccurred())
+				conf.Add("rename-command", "SHUTDOWN shutdown-alias")
+				Expect(conf.Save(repo.InstanceConfigPath(instanceID))).To(Succeed())
+
+				instance, err := repo.FindByID(instanceID)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(instance.CommandAliases).To(Equal(map[string]string{"SHUTDOWN": "shutdown-alias"}))
+			})
+		})
+	})
+
+	Describe("Bind", func() {
+		var socketDir string
+
+		BeforeEach(func() {
+			var err error
+			socketDir, err = ioutil.TempDir("", "sockets")
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(socketDir)).To(Succeed())
+		})
+
+		Context("when the instance is bound to the configured host", func() {
+			BeforeEach(func() {
+				repo.RedisConf.Shared.Network = brokerconfig.SharedNetwork{
+					BindAddresses:       []string{"10.0.0.5", "127.0.0.1"},
+					ProtectedMode:       "yes",
+					UnixSocketDirectory: socketDir,
+					UnixSocketPerm:      "770",
+				}
+				newTestInstance(instanceID, repo)
+			})
+
+			It("writes the network settings to the instance config", func() {
+				conf, err := redisconf.Load(repo.InstanceConfigPath(instanceID))
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(conf.Get("bind")).To(Equal("10.0.0.5 127.0.0.1"))
+				Expect(conf.Get("protected-mode")).To(Equal("yes"))
+				Expect(conf.Get("unixsocket")).To(Equal(path.Join(socketDir, instanceID+".sock")))
+				Expect(conf.Get("unixsocketperm")).To(Equal("770"))
+			})
+
+			It("returns the configured host and the unix socket", func() {
+				credentials, err := repo.Bind(instanceID, "some-binding")
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(credentials.Host).To(Equal("127.0.0.1"))
+				Expect(credentials.UnixSocket).To(Equal(path.Join(socketDir, instanceID+".sock")))
+			})
+		})
+
+		Context("when the instance is not bound to the configured host", func() {
+			BeforeEach(func() {
+				repo.RedisConf.Shared.Network.BindAddresses = []string{"10.0.0.5", "10.0.1.5"}
+				newTestInstance(instanceID, repo)
+			})
+
+			It("returns the first bind address", func() {
+				credentials, err := repo.Bind(instanceID, "some-binding")
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(credentials.Host).To(Equal("10.0.0.5"))
+				Expect(credentials.UnixSocket).To(BeEmpty())
+			})
+		})
+
+		Context("when the instance is also bound to a loopback address", func() {
+			BeforeEach(func() {
+				repo.RedisConf.Shared.Host = "10.0.0.9"
+				repo.RedisConf.Shared.Network.BindAddresses = []string{"127.0.0.1", "::1", "10.0.0.5"}
+				newTestInstance(instanceID, repo)
+			})
+
+			It("returns the first address other VMs can reach", func() {
+				credentials, err := repo.Bind(instanceID, "some-binding")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(credentials.Host).To(Equal("10.0.0.5"))
+			})
+		})
+
+		Context("when the instance is only bound to loopback addresses", func() {
+			BeforeEach(func() {
+				repo.RedisConf.Shared.Host = "10.0.0.9"
+				repo.RedisConf.Shared.Network.BindAddresses = []string{"127.0.0.1"}
+				newTestInstance(instanceID, repo)
+			})
+
+			It("returns the loopback address", func() {
+				credentials, err := repo.Bind(instanceID, "some-binding")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(credentials.Host).To(Equal("127.0.0.1"))
+			})
+		})
+
+		Context("when the shared plan has its own host", func() {
+			BeforeEach(func() {
+				repo.RedisConf.Shared.Host = "10.0.0.9"
+				repo.RedisConf.Shared.Network.BindAddresses = []string{"0.0.0.0"}
+				newTestInstance(instanceID, repo)
+			})
+
+			It("returns the plan's host", func() {
+				credentials, err := repo.Bind(instanceID, "some-binding")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(credentials.Host).To(Equal("10.0.0.9"))
+			})
+		})
+
+		Context("when the instance is bound to all interfaces", func() {
+			BeforeEach(func() {
+				repo.RedisConf.Shared.Network.BindAddresses = []string{"0.0.0.0"}
+				newTestInstance(instanceID, repo)
+			})
+
+			It("returns the configured host", func() {
+				credentials, err := repo.Bind(instanceID, "some-binding")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(credentials.Host).To(Equal("127.0.0.1"))
+			})
+		})
+
+		Context("when the redis version is known", func() {
+			BeforeEach(func() {
+				repo.RedisVersion = redisconf.Version{Major: 3, Minor: 0, Patch: 7}
+				repo.RedisConf.Shared.Network.ProtectedMode = "yes"
+				newTestInstance(instanceID, repo)
+			})
+
+			It("leaves unsupported settings out of the instance config", func() {
+				conf, err := redisconf.Load(repo.InstanceConfigPath(instanceID))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(conf.HasKey("protected-mode")).To(BeFalse())
+			})
+
+			It("returns the version", func() {
+				credentials, err := repo.Bind(instanceID, "some-binding")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(credentials.Version).To(Equal("3.0.7"))
+			})
+		})
+
+		Context("when the instance runs a named redis binary", func() {
+			BeforeEach(func() {
+				repo.RedisVersion = redisconf.Version{Major: 3, Minor: 0, Patch: 7}
+				repo.BinaryVersions = map[string]redisconf.Version{
+					"4.0": {Major: 4, Minor: 0, Patch: 14},
+				}
+				instance := &redis.Instance{ID: instanceID, Host: "127.0.0.1", Port: 8080, RedisBinary: "4.0"}
+				Expect(repo.EnsureDirectoriesExist(instance)).To(Succeed())
+				Expect(repo.RecordRedisBinary(instance)).To(Succeed())
+				writeInstance(instance, repo)
+			})
+
+			It("returns that binary's version", func() {
+				credentials, err := repo.Bind(instanceID, "some-binding")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(credentials.Version).To(Equal("4.0.14"))
+			})
+		})
 	})
 
 	Describe("InstanceExists", func() {
@@ -249,6 +453,143 @@ var _ = Describe("Local Repository", func() {
 				)
 				Expect(logger).To(gbytes.Say(expectedData))
 			})
+
+			Context("when cgroups are enabled", func() {
+				var (
+					cgroupRoot string
+					cgroupfs   *fakeCgroupfs
+				)
+
+				BeforeEach(func() {
+					var err error
+					cgroupRoot, err = ioutil.TempDir("", "cgroupfs")
+					Expect(err).NotTo(HaveOccurred())
+
+					cgroupfs = &fakeCgroupfs{Manager: cgroup.NewManager(cgroupRoot)}
+					repo.Cgroups = cgroupfs
+					Expect(repo.Cgroups.Create(instanceID, cgroup.Limits{})).To(Succeed())
+				})
+
+				AfterEach(func() {
+					Expect(os.RemoveAll(cgroupRoot)).To(Succeed())
+				})
+
+				It("removes the instance cgroup", func() {
+					Expect(repo.Delete(instanceID)).To(Succeed())
+					Expect(path.Join(cgroupRoot, instanceID)).NotTo(BeAnExistingFile())
+				})
+
+				It("removes the instance cgroup before the instance data", func() {
+					cgroupfs.onRemove = func(name string) {
+						Expect(path.Join(tmpInstanceDataDir, name)).To(BeADirectory())
+					}
+
+					Expect(repo.Delete(instanceID)).To(Succeed())
+					Expect(path.Join(tmpInstanceDataDir, instanceID)).NotTo(BeAnExistingFile())
+				})
+
+				Context("when the cgroup cannot be removed", func() {
+					BeforeEach(func() {
+						Expect(os.Mkdir(path.Join(cgroupRoot, instanceID, "child"), 0755)).To(Succeed())
+					})
+
+					It("returns the error and keeps the instance data", func() {
+						Expect(repo.Delete(instanceID)).NotTo(Succeed())
+						Expect(path.Join(tmpInstanceDataDir, instanceID)).To(BeADirectory())
+					})
+				})
+			})
+		})
+	})
+
+	Describe("locking", func() {
+		var instance *redis.Instance
+
+		BeforeEach(func() {
+			instance = newTestInstance(instanceID, repo)
+		})
+
+		It("records the owner in the lock file", func() {
+			Expect(repo.Lock(instance)).To(Succeed())
+
+			owner, err := filelock.ReadOwner(path.Join(tmpInstanceDataDir, instanceID, "lock"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(owner.PID).To(Equal(os.Getpid()))
+
+			Expect(repo.Unlock(instance)).To(Succeed())
+			Expect(path.Join(tmpInstanceDataDir, instanceID, "lock")).NotTo(BeAnExistingFile())
+		})
+
+		It("excludes other holders until unlocked", func() {
+			otherRepo := redis.NewLocalRepository(repo.RedisConf, logger)
+
+			Expect(repo.Lock(instance)).To(Succeed())
+			Expect(otherRepo.TryLock(instance)).To(Equal(filelock.ErrLocked))
+
+			Expect(repo.Unlock(instance)).To(Succeed())
+			Expect(otherRepo.TryLock(instance)).To(Succeed())
+			Expect(otherRepo.Unlock(instance)).To(Succeed())
+		})
+
+		It("takes over a lock left by a process that has exited", func() {
+			lockPath := path.Join(tmpInstanceDataDir, instanceID, "lock")
+			Expect(ioutil.WriteFile(lockPath, []byte(`{"pid":999999999,"acquired":"2017-01-01T00:00:00Z"}`), 0644)).To(Succeed())
+
+			Expect(repo.TryLock(instance)).To(Succeed())
+			Expect(repo.Unlock(instance)).To(Succeed())
+		})
+
+		It("releases the lock when the instance is deleted", func() {
+			Expect(repo.Lock(instance)).To(Succeed())
+			Expect(repo.Delete(instanceID)).To(Succeed())
+			Expect(repo.Unlock(instance)).NotTo(Succeed())
+		})
+
+		It("holds the global lock exclusively", func() {
+			otherRepo := redis.NewLocalRepository(repo.RedisConf, logger)
+
+			Expect(repo.LockGlobal()).To(Succeed())
+			Expect(path.Join(tmpPidFileDir, "shared-instances.lock")).To(BeAnExistingFile())
+
+			unlocked := make(chan struct{})
+			go func() {
+				defer GinkgoRecover()
+				Expect(otherRepo.LockGlobal()).To(Succeed())
+				close(unlocked)
+			}()
+
+			Consistently(unlocked, "300ms").ShouldNot(BeClosed())
+			Expect(repo.UnlockGlobal()).To(Succeed())
+			Eventually(unlocked).Should(BeClosed())
+			Expect(otherRepo.UnlockGlobal()).To(Succeed())
+		})
+	})
+
+	Describe("AllocatedPorts", func() {
+		It("returns the ports of existing instances", func() {
+			newTestInstance(instanceID, repo)
+
+			ports, err := repo.AllocatedPorts()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ports).To(Equal(map[int]string{8080: instanceID}))
+		})
+
+		It("includes port reservations", func() {
+			instance := newTestInstance(instanceID, repo)
+			instance.Port = 9090
+			Expect(repo.ReservePort(instance)).To(Succeed())
+
+			ports, err := repo.AllocatedPorts()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ports).To(Equal(map[int]string{8080: instanceID, 9090: instanceID}))
+		})
+
+		It("ignores instances without a readable config", func() {
+			Expect(os.MkdirAll(repo.InstanceBaseDir(instanceID), 0755)).To(Succeed())
+
+			ports, err := repo.AllocatedPorts()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ports).To(BeEmpty())
 		})
 	})
 
@@ -622,6 +963,46 @@ var _ = Describe("Setup", func() {
 			Expect(tmpInstanceLogDir).To(BeADirectory())
 		})
 
+		It("reserves the instance port", func() {
+			instance.Port = 3456
+			Expect(repo.Setup(&instance)).To(Succeed())
+
+			reservation, err := ioutil.ReadFile(path.Join(tmpDataDir, instanceID, "port"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(reservation)).To(Equal("3456"))
+		})
+
+		It("records the instance's redis binary", func() {
+			instance.RedisBinary = "4.0"
+			Expect(repo.Setup(&instance)).To(Succeed())
+
+			binary, err := ioutil.ReadFile(path.Join(tmpDataDir, instanceID, "redis_binary"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(binary)).To(Equal("4.0"))
+
+			foundInstance, err := repo.FindByID(instanceID)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(foundInstance.RedisBinary).To(Equal("4.0"))
+		})
+
+		It("forgets the recorded binary when moved to the default redis-server", func() {
+			instance.RedisBinary = "4.0"
+			Expect(repo.Setup(&instance)).To(Succeed())
+
+			instance.RedisBinary = ""
+			Expect(repo.RecordRedisBinary(&instance)).To(Succeed())
+			Expect(path.Join(tmpDataDir, instanceID, "redis_binary")).NotTo(BeAnExistingFile())
+		})
+
+		It("does not record a binary for the default redis-server", func() {
+			Expect(repo.Setup(&instance)).To(Succeed())
+			Expect(path.Join(tmpDataDir, instanceID, "redis_binary")).NotTo(BeAnExistingFile())
+
+			foundInstance, err := repo.FindByID(instanceID)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(foundInstance.RedisBinary).To(BeEmpty())
+		})
+
 		It("creates a lock file", func() {
 			Expect(repo.Setup(&instance)).To(Succeed())
 
@@ -650,6 +1031,90 @@ var _ = Describe("Setup", func() {
 			)
 			Expect(logger).To(gbytes.Say(expectedData))
 		})
+
+		It("does not create a cgroup", func() {
+			Expect(repo.Cgroups).To(BeNil())
+			Expect(repo.Setup(&instance)).To(Succeed())
+		})
+
+		Context("when cgroups are enabled", func() {
+			var cgroupRoot string
+
+			BeforeEach(func() {
+				var err error
+				cgroupRoot, err = ioutil.TempDir("", "cgroupfs")
+				Expect(err).NotTo(HaveOccurred())
+
+				repo.RedisConf.Cgroup = brokerconfig.Cgroup{
+					Root:        cgroupRoot,
+					MemoryMaxMB: 100,
+					CPUWeight:   50,
+					PidsMax:     64,
+				}
+				repo = redis.NewLocalRepository(repo.RedisConf, logger)
+			})
+
+			AfterEach(func() {
+				Expect(os.RemoveAll(cgroupRoot)).To(Succeed())
+			})
+
+			It("creates a cgroup for the instance with the plan limits", func() {
+				Expect(repo.Setup(&instance)).To(Succeed())
+
+				cgroupPath := path.Join(cgroupRoot, instanceID)
+				Expect(cgroupPath).To(BeADirectory())
+
+				memoryMax, err := ioutil.ReadFile(path.Join(cgroupPath, "memory.max"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(memoryMax)).To(Equal("104857600"))
+
+				cpuWeight, err := ioutil.ReadFile(path.Join(cgroupPath, "cpu.weight"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(cpuWeight)).To(Equal("50"))
+
+				pidsMax, err := ioutil.ReadFile(path.Join(cgroupPath, "pids.max"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(pidsMax)).To(Equal("64"))
+			})
+
+			Context("when memory_max_mb is not set", func() {
+				BeforeEach(func() {
+					repo.RedisConf.Cgroup.MemoryMaxMB = 0
+				})
+
+				AfterEach(func() {
+					Expect(ioutil.WriteFile(tmpConfigFilePath, []byte{}, 0644)).To(Succeed())
+				})
+
+				It("derives the memory limit from the plan's maxmemory", func() {
+					Expect(ioutil.WriteFile(tmpConfigFilePath, []byte("maxmemory 100mb\n"), 0644)).To(Succeed())
+					Expect(repo.Setup(&instance)).To(Succeed())
+
+					memoryMax, err := ioutil.ReadFile(path.Join(cgroupRoot, instanceID, "memory.max"))
+					Expect(err).NotTo(HaveOccurred())
+					Expect(string(memoryMax)).To(Equal("209715200"))
+				})
+
+				It("leaves memory unlimited when the plan has no maxmemory", func() {
+					Expect(repo.Setup(&instance)).To(Succeed())
+
+					memoryMax, err := ioutil.ReadFile(path.Join(cgroupRoot, instanceID, "memory.max"))
+					Expect(err).NotTo(HaveOccurred())
+					Expect(string(memoryMax)).To(Equal("max"))
+				})
+			})
+
+			Context("when the cgroup cannot be created", func() {
+				BeforeEach(func() {
+					Expect(ioutil.WriteFile(path.Join(cgroupRoot, instanceID), []byte{}, 0644)).To(Succeed())
+				})
+
+				It("returns an error", func() {
+					Expect(repo.Setup(&instance)).NotTo(Succeed())
+					Expect(logger).To(gbytes.Say("local-repo-setup.create-cgroup"))
+				})
+			})
+		})
 	})
 
 	Context("When setup is not successful", func() {
@@ -0,0 +1,132 @@
+package redis
+
+import (
+	"fmt"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/pivotal-cf/brokerapi"
+	"github.com/pivotal-cf/cf-redis-broker/brokerconfig"
+)
+
+// chooseHealthyNodes asks the placement strategy for count nodes for
+// instanceID and checks each through its agent. It is called with the repo
+// locked and unlocks it while the agents are checked, so that a slow agent
+// holds up no other request. Once relocked, nodes that failed and are still
+// allocatable are quarantined, and the choice starts over until enough
+// healthy nodes are found that no other request has taken meanwhile, or the
+// pool runs out.
+func (repo *RemoteRepository) chooseHealthyNodes(instanceID string, count int) ([]*Instance, error) {
+	for {
+		if repo.instanceIDTaken(instanceID) {
+			return nil, brokerapi.ErrInstanceAlreadyExists
+		}
+
+		if len(repo.allocatableInstances()) < count {
+			return nil, brokerapi.ErrInstanceLimitMet
+		}
+
+		nodes := repo.chooseNodes(count)
+
+		repo.Unlock()
+		failures := map[string]error{}
+		for _, node := range nodes {
+			if err := repo.checkNode(node.Host); err != nil {
+				failures[node.Host] = err
+			}
+		}
+		repo.Lock()
+
+		for host, err := range failures {
+			if repo.allocatable(host) {
+				repo.quarantine(host, err)
+			}
+		}
+
+		if len(failures) > 0 || repo.instanceIDTaken(instanceID) {
+			continue
+		}
+
+		if repo.allAllocatable(nodes) {
+			return nodes, nil
+		}
+	}
+}
+
+func (repo *RemoteRepository) allocatable(host string) bool {
+	for _, instance := range repo.allocatableInstances() {
+		if instance.Host == host {
+			return true
+		}
+	}
+	return false
+}
+
+func (repo *RemoteRepository) allAllocatable(nodes []*Instance) bool {
+	for _, node := range nodes {
+		if !repo.allocatable(node.Host) {
+			return false
+		}
+	}
+	return true
+}
+
+// checkNode makes sure a node is fit to hand out: its agent is reachable,
+// redis answers and holds no data left over from a previous instance.
+func (repo *RemoteRepository) checkNode(host string) error {
+	if err := repo.agentClient.Ping(host); err != nil {
+		return fmt.Errorf("ping failed: %s", err)
+	}
+
+	keycount, err := repo.agentClient.Keycount(host)
+	if err != nil {
+		return fmt.Errorf("keycount failed: %s", err)
+	}
+
+	if keycount != 0 {
+		return fmt.Errorf("node is not empty, it holds %d keys", keycount)
+	}
+
+	return nil
+}
+
+// quarantine keeps a node out of placement until an operator releases it.
+// Failing to persist the quarantine only costs another check after a
+// restart, so the error is logged rather than failing the provision.
+func (repo *RemoteRepository) quarantine(host string, reason error) {
+	repo.quarantined[host] = reason.Error()
+
+	repo.logger.Error("quarantine-node", reason, lager.Data{"host": host})
+
+	if err := repo.PersistStatefile(); err != nil {
+		repo.logger.Error("quarantine-node-persist", err, lager.Data{"host": host})
+	}
+}
+
+// ReleaseNode takes a node out of quarantine, e.g. once it has been
+// repaired or wiped.
+func (repo *RemoteRepository) ReleaseNode(host string) error {
+	host = brokerconfig.NormalizeDedicatedNode(host)
+
+	repo.Lock()
+	defer repo.Unlock()
+
+	if !repo.knowsHost(host) {
+		return ErrNodeDoesNotExist
+	}
+
+	reason, quarantined := repo.quarantined[host]
+	if !quarantined {
+		return nil
+	}
+
+	delete(repo.quarantined, host)
+
+	err := repo.PersistStatefile()
+	if err != nil {
+		repo.quarantined[host] = reason
+		return err
+	}
+
+	repo.logger.Info("release-node", lager.Data{"host": host})
+	return nil
+}
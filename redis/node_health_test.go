@@ -0,0 +1,184 @@
+package redis_test
+
+import (
+	"errors"
+	"os"
+
+	"code.cloudfoundry.org/lager/lagertest"
+	"github.com/pivotal-cf/brokerapi"
+	"github.com/pivotal-cf/cf-redis-broker/broker"
+	"github.com/pivotal-cf/cf-redis-broker/brokerconfig"
+	"github.com/pivotal-cf/cf-redis-broker/redis"
+	"github.com/pivotal-cf/cf-redis-broker/redis/fakes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+)
+
+var _ = Describe("Node health", func() {
+	var (
+		repo            *redis.RemoteRepository
+		statefilePath   string
+		tmpDir          string
+		config          brokerconfig.Config
+		fakeAgentClient *fakes.FakeAgentClient
+		logger          *lagertest.TestLogger
+	)
+
+	BeforeEach(func() {
+		logger = lagertest.NewTestLogger("node-health")
+		config, tmpDir = dedicatedConfig("10.0.0.1", "10.0.0.2", "10.0.0.3")
+		statefilePath = config.RedisConfiguration.Dedicated.StatefilePath
+
+		fakeAgentClient = &fakes.FakeAgentClient{}
+	})
+
+	JustBeforeEach(func() {
+		repo = newRemoteRepository(fakeAgentClient, config, logger)
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(tmpDir)
+	})
+
+	Context("when every node is healthy", func() {
+		It("allocates the first candidate", func() {
+			Expect(repo.Create("instance-id", broker.ProvisionParameters{})).To(Succeed())
+			Expect(repo.IDForHost("10.0.0.1")).To(Equal("instance-id"))
+		})
+
+		It("checks the nodes without holding the repo lock", func() {
+			fakeAgentClient.PingFunc = func(string) error {
+				unlocked := make(chan struct{})
+				go func() {
+					repo.Lock()
+					repo.Unlock()
+					close(unlocked)
+				}()
+				Eventually(unlocked).Should(BeClosed())
+				return nil
+			}
+
+			Expect(repo.Create("instance-id", broker.ProvisionParameters{})).To(Succeed())
+		})
+	})
+
+	Context("when another request takes a node while it is checked", func() {
+		JustBeforeEach(func() {
+			raced := false
+			fakeAgentClient.PingFunc = func(host string) error {
+				if host == "10.0.0.1" && !raced {
+					raced = true
+					Expect(repo.Create("other-instance", broker.ProvisionParameters{})).To(Succeed())
+				}
+				return nil
+			}
+		})
+
+		It("chooses again", func() {
+			Expect(repo.Create("instance-id", broker.ProvisionParameters{})).To(Succeed())
+			Expect(repo.IDForHost("10.0.0.1")).To(Equal("other-instance"))
+			Expect(repo.IDForHost("10.0.0.2")).To(Equal("instance-id"))
+		})
+	})
+
+	Context("when another request creates the same instance while its node is checked", func() {
+		JustBeforeEach(func() {
+			raced := false
+			fakeAgentClient.PingFunc = func(host string) error {
+				if !raced {
+					raced = true
+					Expect(repo.Create("instance-id", broker.ProvisionParameters{})).To(Succeed())
+				}
+				return nil
+			}
+		})
+
+		It("returns brokerapi.ErrInstanceAlreadyExists", func() {
+			Expect(repo.Create("instance-id", broker.ProvisionParameters{})).To(Equal(brokerapi.ErrInstanceAlreadyExists))
+			Expect(repo.AvailableInstances()).To(HaveLen(2))
+		})
+	})
+
+	Context("when the agent of a node cannot be reached", func() {
+		BeforeEach(func() {
+			fakeAgentClient.PingFunc = func(host string) error {
+				if host == "10.0.0.1" {
+					return errors.New("connection refused")
+				}
+				return nil
+			}
+		})
+
+		It("quarantines it and tries the next candidate", func() {
+			Expect(repo.Create("instance-id", broker.ProvisionParameters{})).To(Succeed())
+			Expect(repo.IDForHost("10.0.0.2")).To(Equal("instance-id"))
+
+			Expect(repo.Nodes()[0].Quarantine).To(Equal("ping failed: connection refused"))
+			Expect(logger).To(gbytes.Say("quarantine-node"))
+		})
+
+		It("persists the quarantine across restarts", func() {
+			Expect(repo.Create("instance-id", broker.ProvisionParameters{})).To(Succeed())
+			Expect(getStatefileContents(statefilePath).QuarantinedNodes).To(HaveKey("10.0.0.1"))
+
+			reloaded := newRemoteRepository(&fakes.FakeAgentClient{}, config, logger)
+			Expect(reloaded.Create("other-instance", broker.ProvisionParameters{})).To(Succeed())
+			Expect(reloaded.IDForHost("10.0.0.3")).To(Equal("other-instance"))
+		})
+
+		It("counts it out of the capacity", func() {
+			Expect(repo.Create("instance-1", broker.ProvisionParameters{})).To(Succeed())
+			Expect(repo.Create("instance-2", broker.ProvisionParameters{})).To(Succeed())
+			Expect(repo.Create("instance-3", broker.ProvisionParameters{})).To(Equal(brokerapi.ErrInstanceLimitMet))
+		})
+
+		It("allocates it again once it is released", func() {
+			Expect(repo.Create("instance-1", broker.ProvisionParameters{})).To(Succeed())
+			fakeAgentClient.PingFunc = nil
+
+			Expect(repo.ReleaseNode("10.0.0.1")).To(Succeed())
+			Expect(getStatefileContents(statefilePath).QuarantinedNodes).To(BeEmpty())
+
+			Expect(repo.Create("instance-2", broker.ProvisionParameters{})).To(Succeed())
+			Expect(repo.IDForHost("10.0.0.1")).To(Equal("instance-2"))
+		})
+	})
+
+	Context("when a node still holds data", func() {
+		BeforeEach(func() {
+			fakeAgentClient.KeycountFunc = func(host string) (int, error) {
+				if host == "10.0.0.1" {
+					return 12, nil
+				}
+				return 0, nil
+			}
+		})
+
+		It("quarantines it", func() {
+			Expect(repo.Create("instance-id", broker.ProvisionParameters{})).To(Succeed())
+			Expect(repo.IDForHost("10.0.0.2")).To(Equal("instance-id"))
+			Expect(repo.Nodes()[0].Quarantine).To(Equal("node is not empty, it holds 12 keys"))
+		})
+	})
+
+	Context("when no node is healthy", func() {
+		BeforeEach(func() {
+			fakeAgentClient.PingFunc = func(string) error {
+				return errors.New("connection refused")
+			}
+		})
+
+		It("returns brokerapi.ErrInstanceLimitMet", func() {
+			Expect(repo.Create("instance-id", broker.ProvisionParameters{})).To(Equal(brokerapi.ErrInstanceLimitMet))
+			Expect(repo.AvailableInstances()).To(HaveLen(3))
+		})
+	})
+
+	Describe("#ReleaseNode", func() {
+		It("returns ErrNodeDoesNotExist for unknown nodes", func() {
+			Expect(repo.ReleaseNode("10.0.0.9")).To(Equal(redis.ErrNodeDoesNotExist))
+		})
+	})
+})
@@ -0,0 +1,375 @@
+package redis
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/pivotal-cf/cf-redis-broker/brokerconfig"
+)
+
+var (
+	ErrNodeAlreadyExists = errors.New("node already exists")
+	ErrNodeDoesNotExist  = errors.New("node does not exist")
+	ErrNodeAllocated     = errors.New("node is allocated to an instance")
+)
+
+const (
+	NodeStateAvailable = "available"
+	NodeStateAllocated = "allocated"
+)
+
+// Node describes a dedicated node of the pool. A draining node keeps serving
+// the instance allocated to it, but is not handed out again. Quarantine holds
+// the reason a node failed its health check before provisioning.
+type Node struct {
+	Host       string `json:"host"`
+	State      string `json:"state"`
+	InstanceID string `json:"instance_id,omitempty"`
+	Draining   bool   `json:"draining"`
+	Quarantine string `json:"quarantine,omitempty"`
+}
+
+func (repo *RemoteRepository) Nodes() []Node {
+	repo.RLock()
+	defer repo.RUnlock()
+
+	nodes := []Node{}
+	for _, instance := range repo.availableInstances {
+		nodes = append(nodes, repo.node(instance.Host, NodeStateAvailable, ""))
+	}
+
+	for _, instance := range repo.allocatedInstances {
+		nodes = append(nodes, repo.node(instance.Host, NodeStateAllocated, instance.ID))
+	}
+
+	for _, group := range repo.allocatedGroups {
+		for _, host := range group.Hosts {
+			nodes = append(nodes, repo.node(host, NodeStateAllocated, group.ID))
+		}
+	}
+
+	for _, reset := range repo.pendingResets {
+		nodes = append(nodes, repo.node(reset.Host, NodeStatePendingReset, reset.InstanceID))
+	}
+
+	sort.Sort(byHost(nodes))
+	return nodes
+}
+
+// Drift lists the differences between the dedicated nodes config and the
+// statefile found at startup.
+func (repo *RemoteRepository) Drift() []string {
+	return repo.drift
+}
+
+// AddNode puts a node in the pool until the broker restarts. Operators need
+// to add it to the dedicated nodes config as well for it to stay.
+func (repo *RemoteRepository) AddNode(host string) error {
+	host = brokerconfig.NormalizeDedicatedNode(host)
+
+	repo.Lock()
+	defer repo.Unlock()
+
+	if repo.knowsHost(host) {
+		return ErrNodeAlreadyExists
+	}
+
+	instance := &Instance{Host: host}
+	repo.availableInstances = append(repo.availableInstances, instance)
+	repo.instanceLimit++
+
+	err := repo.PersistStatefile()
+	if err != nil {
+		repo.availableInstances = repo.availableInstances[:len(repo.availableInstances)-1]
+		repo.instanceLimit--
+		return err
+	}
+
+	repo.logger.Info("add-node", lager.Data{"host": host})
+	return nil
+}
+
+// RemoveNode takes a node out of the pool. Allocated nodes have to be
+// drained and their instance deprovisioned first. Nodes pending a reset can
+// be removed, for when their VM is gone for good.
+func (repo *RemoteRepository) RemoveNode(host string) error {
+	host = brokerconfig.NormalizeDedicatedNode(host)
+
+	repo.Lock()
+	defer repo.Unlock()
+
+	if repo.hostAllocated(host) {
+		return ErrNodeAllocated
+	}
+
+	previousInstances, previousPending := repo.availableInstances, repo.pendingResets
+	repo.cancelReset(host)
+
+	index := repo.availableIndex(host)
+	if index < 0 {
+		return ErrNodeDoesNotExist
+	}
+
+	wasDraining := repo.draining[host]
+
+	remaining := []*Instance{}
+	remaining = append(remaining, repo.availableInstances[:index]...)
+	remaining = append(remaining, repo.availableInstances[index+1:]...)
+	repo.availableInstances = remaining
+	repo.instanceLimit--
+	delete(repo.draining, host)
+
+	err := repo.PersistStatefile()
+	if err != nil {
+		repo.availableInstances, repo.pendingResets = previousInstances, previousPending
+		repo.instanceLimit++
+		if wasDraining {
+			repo.draining[host] = true
+		}
+		return err
+	}
+
+	delete(repo.freedAt, host)
+	delete(repo.quarantined, host)
+
+	repo.logger.Info("remove-node", lager.Data{"host": host})
+	return nil
+}
+
+func (repo *RemoteRepository) DrainNode(host string) error {
+	return repo.setDraining(host, true)
+}
+
+func (repo *RemoteRepository) UndrainNode(host string) error {
+	return repo.setDraining(host, false)
+}
+
+func (repo *RemoteRepository) setDraining(host string, draining bool) error {
+	host = brokerconfig.NormalizeDedicatedNode(host)
+
+	repo.Lock()
+	defer repo.Unlock()
+
+	if !repo.knowsHost(host) {
+		return ErrNodeDoesNotExist
+	}
+
+	wasDraining := repo.draining[host]
+	repo.markDraining(host, draining)
+
+	err := repo.PersistStatefile()
+	if err != nil {
+		repo.markDraining(host, wasDraining)
+		return err
+	}
+
+	repo.logger.Info("drain-node", lager.Data{"host": host, "draining": draining})
+	return nil
+}
+
+func (repo *RemoteRepository) markDraining(host string, draining bool) {
+	if draining {
+		repo.draining[host] = true
+	} else {
+		delete(repo.draining, host)
+	}
+}
+
+// allocatableInstances are the available nodes that are neither draining
+// nor quarantined, in pool order.
+func (repo *RemoteRepository) allocatableInstances() []*Instance {
+	allocatable := []*Instance{}
+	for _, instance := range repo.availableInstances {
+		if !repo.draining[instance.Host] && repo.quarantined[instance.Host] == "" {
+			allocatable = append(allocatable, instance)
+		}
+	}
+	return allocatable
+}
+
+// chooseNodes asks the placement strategy for count allocatable nodes.
+// Callers check that there are enough first.
+func (repo *RemoteRepository) chooseNodes(count int) []*Instance {
+	return repo.placement.Choose(Placement{
+		Candidates: repo.allocatableInstances(),
+		Allocated:  repo.allocatedHosts(),
+		FreedAt:    repo.freedAt,
+	}, count)
+}
+
+func (repo *RemoteRepository) allocatedHosts() []string {
+	hosts := []string{}
+	for _, instance := range repo.allocatedInstances {
+		hosts = append(hosts, instance.Host)
+	}
+	for _, group := range repo.allocatedGroups {
+		hosts = append(hosts, group.Hosts...)
+	}
+	return hosts
+}
+
+func (repo *RemoteRepository) drainingHosts() []string {
+	hosts := []string{}
+	for host := range repo.draining {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	return hosts
+}
+
+func (repo *RemoteRepository) knowsHost(host string) bool {
+	return repo.availableIndex(host) >= 0 || repo.hostAllocated(host) || repo.pendingResetIndex(host) >= 0
+}
+
+func (repo *RemoteRepository) hostAllocated(host string) bool {
+	for _, instance := range repo.allocatedInstances {
+		if instance.Host == host {
+			return true
+		}
+	}
+	return repo.findGroupByHost(host) != nil
+}
+
+func (repo *RemoteRepository) availableIndex(host string) int {
+	for index, instance := range repo.availableInstances {
+		if instance.Host == host {
+			return index
+		}
+	}
+	return -1
+}
+
+func (repo *RemoteRepository) node(host, state, instanceID string) Node {
+	return Node{
+		Host:       host,
+		State:      state,
+		InstanceID: instanceID,
+		Draining:   repo.draining[host],
+		Quarantine: repo.quarantined[host],
+	}
+}
+
+// detectDrift compares the configured nodes with the statefile, before the
+// pool is rebuilt from the config. Allocated nodes missing from the config
+// stay allocated, so that their instances keep working.
+func (repo *RemoteRepository) detectDrift(configNodes []string, previousPool []*Instance, statefileFound bool) {
+	known := map[string]bool{}
+	for _, instance := range previousPool {
+		known[instance.Host] = true
+		if !containsHost(configNodes, instance.Host) {
+			repo.reportDrift(instance.Host, "node in the statefile pool is not in the config, it has been dropped from the pool")
+		}
+	}
+
+	for _, instance := range repo.allocatedInstances {
+		known[instance.Host] = true
+		if !containsHost(configNodes, instance.Host) {
+			repo.reportDrift(instance.Host, fmt.Sprintf("node allocated to instance %s is not in the config", instance.ID))
+		}
+	}
+
+	for _, group := range repo.allocatedGroups {
+		for _, host := range group.Hosts {
+			known[host] = true
+			if !containsHost(configNodes, host) {
+				repo.reportDrift(host, fmt.Sprintf("node allocated to instance %s is not in the config", group.ID))
+			}
+		}
+	}
+
+	for _, reset := range repo.pendingResets {
+		known[reset.Host] = true
+		if !containsHost(configNodes, reset.Host) {
+			repo.reportDrift(reset.Host, "node pending a reset is not in the config")
+		}
+	}
+
+	if !statefileFound {
+		return
+	}
+
+	for _, host := range configNodes {
+		if !known[host] {
+			repo.reportDrift(host, "node in the config is not in the statefile, it has been added to the pool")
+		}
+	}
+}
+
+func (repo *RemoteRepository) reportDrift(host, message string) {
+	repo.drift = append(repo.drift, fmt.Sprintf("%s: %s", host, message))
+	repo.logger.Info("statefile-drift", lager.Data{
+		"host":    host,
+		"message": message,
+	})
+}
+
+// normalizeStatefileHosts migrates statefiles written before hostnames and
+// IPv6 were supported. IPv4 entries keep their spelling, apart from leading
+// zeros, so existing allocations still match their config entries.
+func normalizeStatefileHosts(statefile *Statefile) {
+	for _, instance := range statefile.AvailableInstances {
+		instance.Host = brokerconfig.NormalizeDedicatedNode(instance.Host)
+	}
+
+	for _, instance := range statefile.AllocatedInstances {
+		instance.Host = brokerconfig.NormalizeDedicatedNode(instance.Host)
+	}
+
+	for _, group := range statefile.AllocatedGroups {
+		for i, host := range group.Hosts {
+			group.Hosts[i] = brokerconfig.NormalizeDedicatedNode(host)
+		}
+
+		if group.Slots != nil {
+			slots := map[string]string{}
+			for host, slotRange := range group.Slots {
+				slots[brokerconfig.NormalizeDedicatedNode(host)] = slotRange
+			}
+			group.Slots = slots
+		}
+	}
+
+	for _, reset := range statefile.PendingResets {
+		reset.Host = brokerconfig.NormalizeDedicatedNode(reset.Host)
+	}
+
+	for i, host := range statefile.DrainingNodes {
+		statefile.DrainingNodes[i] = brokerconfig.NormalizeDedicatedNode(host)
+	}
+
+	if statefile.QuarantinedNodes != nil {
+		quarantined := map[string]string{}
+		for host, reason := range statefile.QuarantinedNodes {
+			quarantined[brokerconfig.NormalizeDedicatedNode(host)] = reason
+		}
+		statefile.QuarantinedNodes = quarantined
+	}
+
+	if statefile.FreedAt != nil {
+		freedAt := map[string]time.Time{}
+		for host, at := range statefile.FreedAt {
+			freedAt[brokerconfig.NormalizeDedicatedNode(host)] = at
+		}
+		statefile.FreedAt = freedAt
+	}
+}
+
+// resolveIP returns an IP address for a node, for the redis commands that
+// do not accept hostnames.
+func resolveIP(host string) (string, error) {
+	address, err := net.ResolveIPAddr("ip", host)
+	if err != nil {
+		return "", err
+	}
+	return address.IP.String(), nil
+}
+
+type byHost []Node
+
+func (nodes byHost) Len() int           { return len(nodes) }
+func (nodes byHost) Swap(i, j int)      { nodes[i], nodes[j] = nodes[j], nodes[i] }
+func (nodes byHost) Less(i, j int) bool { return nodes[i].Host < nodes[j].Host }
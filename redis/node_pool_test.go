@@ -0,0 +1,218 @@
+package redis_test
+
+import (
+	"os"
+
+	"code.cloudfoundry.org/lager/lagertest"
+	"github.com/pivotal-cf/brokerapi"
+	"github.com/pivotal-cf/cf-redis-broker/broker"
+	"github.com/pivotal-cf/cf-redis-broker/brokerconfig"
+	"github.com/pivotal-cf/cf-redis-broker/redis"
+	"github.com/pivotal-cf/cf-redis-broker/redis/fakes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+)
+
+var _ = Describe("Node pool", func() {
+	var (
+		repo            *redis.RemoteRepository
+		statefilePath   string
+		tmpDir          string
+		config          brokerconfig.Config
+		fakeAgentClient *fakes.FakeAgentClient
+		logger          *lagertest.TestLogger
+	)
+
+	BeforeEach(func() {
+		logger = lagertest.NewTestLogger("node-pool")
+		config, tmpDir = dedicatedConfig("10.0.0.1", "10.0.0.2")
+		statefilePath = config.RedisConfiguration.Dedicated.StatefilePath
+
+		fakeAgentClient = &fakes.FakeAgentClient{}
+	})
+
+	JustBeforeEach(func() {
+		repo = newRemoteRepository(fakeAgentClient, config, logger)
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(tmpDir)
+	})
+
+	Describe("#Nodes", func() {
+		JustBeforeEach(func() {
+			Expect(repo.Create("instance-id", broker.ProvisionParameters{})).To(Succeed())
+		})
+
+		It("lists every node with its state", func() {
+			Expect(repo.Nodes()).To(Equal([]redis.Node{
+				{Host: "10.0.0.1", State: redis.NodeStateAllocated, InstanceID: "instance-id"},
+				{Host: "10.0.0.2", State: redis.NodeStateAvailable},
+			}))
+		})
+	})
+
+	Describe("#AddNode", func() {
+		It("makes the node available for new instances", func() {
+			Expect(repo.AddNode("10.0.0.3")).To(Succeed())
+
+			Expect(repo.InstanceLimit()).To(Equal(3))
+			Expect(repo.Create("instance-1", broker.ProvisionParameters{})).To(Succeed())
+			Expect(repo.Create("instance-2", broker.ProvisionParameters{})).To(Succeed())
+			Expect(repo.Create("instance-3", broker.ProvisionParameters{})).To(Succeed())
+			Expect(repo.IDForHost("10.0.0.3")).To(Equal("instance-3"))
+		})
+
+		It("persists the node", func() {
+			Expect(repo.AddNode("10.0.0.3")).To(Succeed())
+
+			state := getStatefileContents(statefilePath)
+			Expect(state.AvailableInstances).To(HaveLen(3))
+			Expect(state.AvailableInstances[2].Host).To(Equal("10.0.0.3"))
+		})
+
+		It("refuses a node that is already in the pool", func() {
+			Expect(repo.AddNode("10.0.0.2")).To(Equal(redis.ErrNodeAlreadyExists))
+		})
+
+		It("refuses a node that is allocated", func() {
+			Expect(repo.Create("instance-id", broker.ProvisionParameters{})).To(Succeed())
+			Expect(repo.AddNode("10.0.0.1")).To(Equal(redis.ErrNodeAlreadyExists))
+		})
+	})
+
+	Describe("#RemoveNode", func() {
+		It("takes the node out of the pool", func() {
+			Expect(repo.RemoveNode("10.0.0.1")).To(Succeed())
+
+			Expect(repo.InstanceLimit()).To(Equal(1))
+			Expect(getStatefileContents(statefilePath).AvailableInstances).To(HaveLen(1))
+			Expect(repo.Create("instance-id", broker.ProvisionParameters{})).To(Succeed())
+			Expect(repo.IDForHost("10.0.0.2")).To(Equal("instance-id"))
+		})
+
+		It("refuses to remove an allocated node", func() {
+			Expect(repo.Create("instance-id", broker.ProvisionParameters{})).To(Succeed())
+
+			Expect(repo.RemoveNode("10.0.0.1")).To(Equal(redis.ErrNodeAllocated))
+			Expect(repo.IDForHost("10.0.0.1")).To(Equal("instance-id"))
+		})
+
+		It("returns ErrNodeDoesNotExist for unknown nodes", func() {
+			Expect(repo.RemoveNode("10.0.0.9")).To(Equal(redis.ErrNodeDoesNotExist))
+		})
+	})
+
+	Describe("#DrainNode", func() {
+		It("stops the node from being allocated", func() {
+			Expect(repo.DrainNode("10.0.0.1")).To(Succeed())
+
+			Expect(repo.Create("instance-id", broker.ProvisionParameters{})).To(Succeed())
+			Expect(repo.IDForHost("10.0.0.2")).To(Equal("instance-id"))
+
+			Expect(repo.Create("other-instance", broker.ProvisionParameters{})).To(Equal(brokerapi.ErrInstanceLimitMet))
+		})
+
+		It("keeps a drained node out of the pool once its instance is deprovisioned", func() {
+			Expect(repo.Create("instance-id", broker.ProvisionParameters{})).To(Succeed())
+			Expect(repo.DrainNode("10.0.0.1")).To(Succeed())
+			Expect(repo.Destroy("instance-id")).To(Succeed())
+
+			Expect(repo.Create("other-instance", broker.ProvisionParameters{})).To(Succeed())
+			Expect(repo.IDForHost("10.0.0.2")).To(Equal("other-instance"))
+		})
+
+		It("persists the draining state across restarts", func() {
+			Expect(repo.DrainNode("10.0.0.1")).To(Succeed())
+			Expect(getStatefileContents(statefilePath).DrainingNodes).To(Equal([]string{"10.0.0.1"}))
+
+			reloaded := newRemoteRepository(fakeAgentClient, config, logger)
+			Expect(reloaded.Nodes()[0].Draining).To(BeTrue())
+		})
+
+		It("can be undone", func() {
+			Expect(repo.DrainNode("10.0.0.1")).To(Succeed())
+			Expect(repo.UndrainNode("10.0.0.1")).To(Succeed())
+
+			Expect(repo.Nodes()[0].Draining).To(BeFalse())
+			Expect(getStatefileContents(statefilePath).DrainingNodes).To(BeEmpty())
+		})
+
+		It("returns ErrNodeDoesNotExist for unknown nodes", func() {
+			Expect(repo.DrainNode("10.0.0.9")).To(Equal(redis.ErrNodeDoesNotExist))
+		})
+	})
+
+	Describe("node addresses", func() {
+		BeforeEach(func() {
+			config.RedisConfiguration.Dedicated.Nodes = []string{"10.0.0.1", "FD00::10", "redis-0.example.com"}
+		})
+
+		It("accepts IPv6 addresses and hostnames", func() {
+			Expect(repo.Nodes()).To(ConsistOf(
+				redis.Node{Host: "10.0.0.1", State: redis.NodeStateAvailable},
+				redis.Node{Host: "fd00::10", State: redis.NodeStateAvailable},
+				redis.Node{Host: "redis-0.example.com", State: redis.NodeStateAvailable},
+			))
+		})
+
+		It("matches hosts however they are spelled", func() {
+			Expect(repo.AddNode("[fd00:0::10]")).To(Equal(redis.ErrNodeAlreadyExists))
+			Expect(repo.DrainNode("Redis-0.Example.com")).To(Succeed())
+		})
+
+		Context("when the statefile was written with other spellings", func() {
+			BeforeEach(func() {
+				putStatefileContents(statefilePath, Statefile{
+					AvailableInstances: []*redis.Instance{{Host: "fd00:0:0::10"}},
+					AllocatedInstances: []*redis.Instance{{Host: "010.000.000.001", ID: "ip-instance"}},
+					InstanceBindings:   map[string][]string{"ip-instance": {"binding-id"}},
+				})
+			})
+
+			It("keeps the existing allocations", func() {
+				Expect(repo.IDForHost("10.0.0.1")).To(Equal("ip-instance"))
+				Expect(repo.Drift()).To(Equal([]string{
+					"redis-0.example.com: node in the config is not in the statefile, it has been added to the pool",
+				}))
+
+				state := getStatefileContents(statefilePath)
+				Expect(state.AllocatedInstances[0].Host).To(Equal("10.0.0.1"))
+				Expect(state.AvailableInstances).To(HaveLen(2))
+			})
+		})
+	})
+
+	Describe("drift", func() {
+		It("reports nothing without a statefile", func() {
+			Expect(repo.Drift()).To(BeEmpty())
+		})
+
+		Context("when the statefile and config disagree", func() {
+			BeforeEach(func() {
+				putStatefileContents(statefilePath, Statefile{
+					AvailableInstances: []*redis.Instance{{Host: "10.0.0.5"}},
+					AllocatedInstances: []*redis.Instance{{Host: "10.0.0.6", ID: "orphan"}},
+					InstanceBindings:   map[string][]string{"orphan": {}},
+				})
+			})
+
+			It("reports each difference", func() {
+				Expect(repo.Drift()).To(ConsistOf(
+					"10.0.0.5: node in the statefile pool is not in the config, it has been dropped from the pool",
+					"10.0.0.6: node allocated to instance orphan is not in the config",
+					"10.0.0.1: node in the config is not in the statefile, it has been added to the pool",
+					"10.0.0.2: node in the config is not in the statefile, it has been added to the pool",
+				))
+				Expect(logger).To(gbytes.Say("statefile-drift"))
+			})
+
+			It("keeps the allocated node", func() {
+				Expect(repo.IDForHost("10.0.0.6")).To(Equal("orphan"))
+				Expect(repo.RemoveNode("10.0.0.6")).To(Equal(redis.ErrNodeAllocated))
+			})
+		})
+	})
+})
@@ -0,0 +1,214 @@
+package redis
+
+import (
+	"time"
+
+	"code.cloudfoundry.org/lager"
+)
+
+const NodeStatePendingReset = "pending-reset"
+
+// PendingReset is a node whose instance has been deprovisioned, but whose
+// agent has not reset it yet. The node stays out of the pool until a reset
+// succeeds, so that no tenant is handed another tenant's data. Resetting is
+// set while a reset is in flight, so that no second reset reaches the node
+// after it has been handed out again.
+type PendingReset struct {
+	Host       string    `json:"host"`
+	InstanceID string    `json:"instance_id"`
+	Since      time.Time `json:"since"`
+	Attempts   int       `json:"attempts"`
+	LastError  string    `json:"last_error"`
+	Resetting  bool      `json:"-"`
+}
+
+func (repo *RemoteRepository) PendingResets() []PendingReset {
+	repo.RLock()
+	defer repo.RUnlock()
+
+	pending := []PendingReset{}
+	for _, reset := range repo.pendingResets {
+		pending = append(pending, *reset)
+	}
+	return pending
+}
+
+// KeepResetting retries the pending resets every interval until stop is
+// closed.
+func (repo *RemoteRepository) KeepResetting(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			repo.RetryPendingResets()
+		}
+	}
+}
+
+// RetryPendingResets tries to reset each pending node once, returning the
+// ones that succeed to the pool. The agent is called without holding the
+// repository lock, so a hung agent does not block provisioning. Nodes whose
+// reset is already in flight are skipped.
+func (repo *RemoteRepository) RetryPendingResets() {
+	repo.Lock()
+	pending := []PendingReset{}
+	for _, reset := range repo.pendingResets {
+		if reset.Resetting {
+			continue
+		}
+		reset.Resetting = true
+		pending = append(pending, *reset)
+	}
+	repo.Unlock()
+
+	for _, reset := range pending {
+		resetErr := repo.agentClient.Reset(reset.Host)
+		if resetErr != nil {
+			repo.logger.Error("pending-reset-failed", resetErr, lager.Data{
+				"host":        reset.Host,
+				"instance_id": reset.InstanceID,
+				"attempts":    reset.Attempts + 1,
+			})
+		}
+		repo.completeReset(reset.Host, resetErr)
+	}
+}
+
+// resetReleased resets the nodes of a deprovisioned instance, which
+// releaseInstance or releaseGroup took out of the pool as pending resets. It
+// is called without holding the repository lock, as a reset can take as long
+// as the agent's ResetTimeout; nodes whose reset fails stay pending.
+func (repo *RemoteRepository) resetReleased(instanceID string, hosts []string) {
+	for _, host := range hosts {
+		resetErr := repo.agentClient.Reset(host)
+		if resetErr != nil {
+			repo.logResetFailure(instanceID, host, resetErr)
+		}
+		repo.completeReset(host, resetErr)
+	}
+}
+
+func (repo *RemoteRepository) completeReset(host string, resetErr error) {
+	repo.Lock()
+	defer repo.Unlock()
+
+	index := repo.pendingResetIndex(host)
+	if index < 0 {
+		return
+	}
+	reset := repo.pendingResets[index]
+	reset.Resetting = false
+
+	if resetErr != nil {
+		reset.Attempts++
+		reset.LastError = resetErr.Error()
+
+		if err := repo.PersistStatefile(); err != nil {
+			repo.logger.Error("pending-reset-persist", err, lager.Data{"host": host})
+		}
+		return
+	}
+
+	previousPool, previousPending := repo.availableInstances, repo.pendingResets
+	repo.cancelReset(host)
+	repo.freedAt[host] = time.Now()
+
+	if err := repo.PersistStatefile(); err != nil {
+		// Keeping the node pending is safe: the next retry resets it again.
+		repo.availableInstances, repo.pendingResets = previousPool, previousPending
+		repo.logger.Error("pending-reset-persist", err, lager.Data{"host": host})
+		return
+	}
+
+	repo.logger.Info("pending-reset-complete", lager.Data{
+		"host":        host,
+		"instance_id": reset.InstanceID,
+	})
+}
+
+// releaseInstance deallocates a deprovisioned instance, keeping its node out
+// of the pool until resetReleased has reset it.
+func (repo *RemoteRepository) releaseInstance(instance *Instance) error {
+	repo.deallocateInstance(instance)
+	repo.deferReset(instance.Host, instance.ID)
+
+	err := repo.PersistStatefile()
+	if err != nil {
+		repo.cancelReset(instance.Host)
+		repo.allocateInstance(instance.ID, instance)
+		return err
+	}
+
+	return nil
+}
+
+// releaseGroup deallocates a deprovisioned group, keeping its nodes out of
+// the pool until resetReleased has reset them.
+func (repo *RemoteRepository) releaseGroup(group *InstanceGroup) error {
+	repo.deallocateGroup(group)
+	for _, host := range group.Hosts {
+		repo.deferReset(host, group.ID)
+	}
+
+	err := repo.PersistStatefile()
+	if err != nil {
+		for _, host := range group.Hosts {
+			repo.cancelReset(host)
+		}
+		repo.claimGroup(group)
+		return err
+	}
+
+	return nil
+}
+
+func (repo *RemoteRepository) logResetFailure(instanceID, host string, err error) {
+	action := "deprovision-reset-failed"
+	if IsAgentUnreachable(err) {
+		action = "deprovision-agent-unreachable"
+	}
+	repo.logger.Error(action, err, lager.Data{
+		"instance_id": instanceID,
+		"host":        host,
+		"message":     "Reset deferred, the node stays out of the pool until it succeeds",
+	})
+}
+
+// deferReset takes a freed node out of the pool until it has been reset,
+// marking the reset in flight for resetReleased.
+func (repo *RemoteRepository) deferReset(host, instanceID string) {
+	if index := repo.availableIndex(host); index >= 0 {
+		repo.availableInstances = append(repo.availableInstances[:index:index], repo.availableInstances[index+1:]...)
+	}
+
+	repo.pendingResets = append(repo.pendingResets, &PendingReset{
+		Host:       host,
+		InstanceID: instanceID,
+		Since:      time.Now(),
+		Resetting:  true,
+	})
+}
+
+// cancelReset puts a pending node back at the front of the pool.
+func (repo *RemoteRepository) cancelReset(host string) {
+	index := repo.pendingResetIndex(host)
+	if index < 0 {
+		return
+	}
+
+	repo.pendingResets = append(repo.pendingResets[:index:index], repo.pendingResets[index+1:]...)
+	repo.availableInstances = append([]*Instance{{Host: host}}, repo.availableInstances...)
+}
+
+func (repo *RemoteRepository) pendingResetIndex(host string) int {
+	for index, reset := range repo.pendingResets {
+		if reset.Host == host {
+			return index
+		}
+	}
+	return -1
+}
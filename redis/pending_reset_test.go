@@ -0,0 +1,157 @@
+package redis_test
+
+import (
+	"errors"
+	"os"
+	"time"
+
+	"code.cloudfoundry.org/lager/lagertest"
+	"github.com/pivotal-cf/cf-redis-broker/broker"
+	"github.com/pivotal-cf/cf-redis-broker/brokerconfig"
+	"github.com/pivotal-cf/cf-redis-broker/redis"
+	"github.com/pivotal-cf/cf-redis-broker/redis/fakes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+)
+
+var _ = Describe("Pending resets", func() {
+	var (
+		repo            *redis.RemoteRepository
+		statefilePath   string
+		tmpDir          string
+		config          brokerconfig.Config
+		fakeAgentClient *fakes.FakeAgentClient
+		logger          *lagertest.TestLogger
+		resetErr        error
+	)
+
+	BeforeEach(func() {
+		logger = lagertest.NewTestLogger("pending-reset")
+		config, tmpDir = dedicatedConfig("10.0.0.1", "10.0.0.2")
+		statefilePath = config.RedisConfiguration.Dedicated.StatefilePath
+
+		resetErr = errors.New("connection refused")
+		fakeAgentClient = &fakes.FakeAgentClient{}
+		fakeAgentClient.ResetHandler = func(string) error {
+			return resetErr
+		}
+	})
+
+	JustBeforeEach(func() {
+		repo = newRemoteRepository(fakeAgentClient, config, logger)
+
+		Expect(repo.Create("instance-id", broker.ProvisionParameters{})).To(Succeed())
+		Expect(repo.Destroy("instance-id")).To(Succeed())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(tmpDir)
+	})
+
+	It("lists the node as pending a reset", func() {
+		Expect(repo.Nodes()).To(ContainElement(redis.Node{
+			Host:       "10.0.0.1",
+			State:      redis.NodeStatePendingReset,
+			InstanceID: "instance-id",
+		}))
+	})
+
+	It("does not hand the node out again", func() {
+		Expect(repo.Create("other-instance", broker.ProvisionParameters{})).To(Succeed())
+		Expect(repo.IDForHost("10.0.0.2")).To(Equal("other-instance"))
+	})
+
+	It("keeps the node pending across restarts", func() {
+		reloaded := newRemoteRepository(fakeAgentClient, config, logger)
+
+		Expect(reloaded.PendingResets()).To(HaveLen(1))
+		Expect(reloaded.AvailableInstances()).To(HaveLen(1))
+		Expect(reloaded.Drift()).To(BeEmpty())
+	})
+
+	Describe("#RetryPendingResets", func() {
+		It("counts the failed attempts", func() {
+			repo.RetryPendingResets()
+
+			Expect(repo.PendingResets()[0].Attempts).To(Equal(2))
+			Expect(getStatefileContents(statefilePath).PendingResets[0].Attempts).To(Equal(2))
+			Expect(logger).To(gbytes.Say("pending-reset-failed"))
+		})
+
+		It("returns the node to the pool once the reset succeeds", func() {
+			resetErr = nil
+			repo.RetryPendingResets()
+
+			Expect(repo.PendingResets()).To(BeEmpty())
+			Expect(repo.AvailableInstances()).To(HaveLen(2))
+			Expect(getStatefileContents(statefilePath).PendingResets).To(BeEmpty())
+			Expect(logger).To(gbytes.Say("pending-reset-complete"))
+		})
+	})
+
+	Context("when a retry runs while the deprovision reset is in flight", func() {
+		var resets int
+
+		BeforeEach(func() {
+			resets = 0
+			fakeAgentClient.ResetHandler = func(string) error {
+				resets++
+				if resets == 1 {
+					repo.RetryPendingResets()
+				}
+				return nil
+			}
+		})
+
+		It("resets the node only once", func() {
+			Expect(resets).To(Equal(1))
+			Expect(repo.PendingResets()).To(BeEmpty())
+			Expect(repo.AvailableInstances()).To(HaveLen(2))
+		})
+
+		It("resets the node again when its next instance is deprovisioned", func() {
+			Expect(repo.Create("other-instance", broker.ProvisionParameters{})).To(Succeed())
+			Expect(repo.Destroy("other-instance")).To(Succeed())
+			Expect(resets).To(Equal(2))
+		})
+	})
+
+	It("can be listed while the pending resets are retried", func() {
+		resetErr = nil
+		done := make(chan struct{})
+		go func() {
+			defer GinkgoRecover()
+			repo.RetryPendingResets()
+			close(done)
+		}()
+
+		for _, instance := range repo.AvailableInstances() {
+			Expect(instance.Host).NotTo(BeEmpty())
+		}
+		Eventually(done).Should(BeClosed())
+	})
+
+	Describe("#KeepResetting", func() {
+		It("retries until stopped", func() {
+			resetErr = nil
+			stop := make(chan struct{})
+			defer close(stop)
+
+			go repo.KeepResetting(10*time.Millisecond, stop)
+
+			Eventually(repo.PendingResets).Should(BeEmpty())
+		})
+	})
+
+	Describe("#RemoveNode", func() {
+		It("can remove a node pending a reset", func() {
+			Expect(repo.RemoveNode("10.0.0.1")).To(Succeed())
+
+			Expect(repo.PendingResets()).To(BeEmpty())
+			Expect(repo.AvailableInstances()).To(HaveLen(1))
+			Expect(repo.InstanceLimit()).To(Equal(1))
+		})
+	})
+})
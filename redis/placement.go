@@ -0,0 +1,113 @@
+package redis
+
+import (
+	"sort"
+	"time"
+
+	"github.com/pivotal-cf/cf-redis-broker/brokerconfig"
+)
+
+// Placement is what a PlacementStrategy chooses from: the nodes that can be
+// allocated, in pool order, and the nodes already allocated. Draining nodes
+// and nodes quarantined after a failed health check are no candidates.
+type Placement struct {
+	Candidates []*Instance
+	Allocated  []string
+	FreedAt    map[string]time.Time
+}
+
+// PlacementStrategy chooses count of the candidates for a new instance. It
+// is only called with at least count candidates.
+type PlacementStrategy interface {
+	Choose(placement Placement, count int) []*Instance
+}
+
+func NewPlacementStrategy(dedicated brokerconfig.Dedicated) PlacementStrategy {
+	switch dedicated.Placement {
+	case brokerconfig.PlacementLeastRecentlyFreed:
+		return LeastRecentlyFreed{}
+	case brokerconfig.PlacementSpreadZones:
+		zones := map[string]string{}
+		for host, metadata := range dedicated.NodeMetadata {
+			zones[brokerconfig.NormalizeDedicatedNode(host)] = metadata.Zone
+		}
+		return SpreadZones{Zones: zones}
+	default:
+		return FirstAvailable{}
+	}
+}
+
+// FirstAvailable takes nodes in pool order. Freed nodes go back to the
+// front of the pool, so they are the first to be reused.
+type FirstAvailable struct{}
+
+func (FirstAvailable) Choose(placement Placement, count int) []*Instance {
+	return placement.Candidates[:count]
+}
+
+// LeastRecentlyFreed takes nodes that have never been allocated first, then
+// the ones that were freed longest ago, giving wiped VMs time to cool down
+// and spreading wear over the pool.
+type LeastRecentlyFreed struct{}
+
+func (LeastRecentlyFreed) Choose(placement Placement, count int) []*Instance {
+	candidates := byFreedAt{
+		instances: append([]*Instance{}, placement.Candidates...),
+		freedAt:   placement.FreedAt,
+	}
+	sort.Stable(candidates)
+
+	return candidates.instances[:count]
+}
+
+// SpreadZones takes each node from the availability zone with the fewest
+// allocated nodes, so that instances, and the members of an HA or cluster
+// instance, end up in different zones. Nodes without a zone share the ""
+// zone. Ties go to the least recently freed node.
+type SpreadZones struct {
+	Zones map[string]string
+}
+
+func (strategy SpreadZones) Choose(placement Placement, count int) []*Instance {
+	load := map[string]int{}
+	for _, host := range placement.Allocated {
+		load[strategy.Zones[host]]++
+	}
+
+	remaining := LeastRecentlyFreed{}.Choose(placement, len(placement.Candidates))
+	chosen := []*Instance{}
+
+	for len(chosen) < count {
+		best := 0
+		for i, candidate := range remaining {
+			if load[strategy.Zones[candidate.Host]] < load[strategy.Zones[remaining[best].Host]] {
+				best = i
+			}
+		}
+
+		chosen = append(chosen, remaining[best])
+		load[strategy.Zones[remaining[best].Host]]++
+		remaining = append(remaining[:best:best], remaining[best+1:]...)
+	}
+
+	return chosen
+}
+
+type byFreedAt struct {
+	instances []*Instance
+	freedAt   map[string]time.Time
+}
+
+func (nodes byFreedAt) Len() int { return len(nodes.instances) }
+func (nodes byFreedAt) Swap(i, j int) {
+	nodes.instances[i], nodes.instances[j] = nodes.instances[j], nodes.instances[i]
+}
+
+func (nodes byFreedAt) Less(i, j int) bool {
+	freedI, okI := nodes.freedAt[nodes.instances[i].Host]
+	freedJ, okJ := nodes.freedAt[nodes.instances[j].Host]
+	if !okI || !okJ {
+		return !okI && okJ
+	}
+	return freedI.Before(freedJ)
+}
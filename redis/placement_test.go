@@ -0,0 +1,220 @@
+package redis_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"time"
+
+	"code.cloudfoundry.org/lager/lagertest"
+	"github.com/pivotal-cf/brokerapi"
+	"github.com/pivotal-cf/cf-redis-broker/broker"
+	"github.com/pivotal-cf/cf-redis-broker/brokerconfig"
+	"github.com/pivotal-cf/cf-redis-broker/redis"
+	"github.com/pivotal-cf/cf-redis-broker/redis/fakes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Placement", func() {
+	hosts := func(instances []*redis.Instance) []string {
+		result := []string{}
+		for _, instance := range instances {
+			result = append(result, instance.Host)
+		}
+		return result
+	}
+
+	candidates := []*redis.Instance{{Host: "a"}, {Host: "b"}, {Host: "c"}, {Host: "d"}}
+	now := time.Now()
+
+	Describe("FirstAvailable", func() {
+		It("takes the candidates in pool order", func() {
+			chosen := redis.FirstAvailable{}.Choose(redis.Placement{Candidates: candidates}, 2)
+			Expect(hosts(chosen)).To(Equal([]string{"a", "b"}))
+		})
+	})
+
+	Describe("LeastRecentlyFreed", func() {
+		It("takes never freed nodes first, then the longest freed", func() {
+			chosen := redis.LeastRecentlyFreed{}.Choose(redis.Placement{
+				Candidates: candidates,
+				FreedAt: map[string]time.Time{
+					"a": now,
+					"b": now.Add(-time.Hour),
+					"d": now.Add(-time.Minute),
+				},
+			}, 3)
+			Expect(hosts(chosen)).To(Equal([]string{"c", "b", "d"}))
+		})
+	})
+
+	Describe("SpreadZones", func() {
+		var strategy redis.SpreadZones
+
+		BeforeEach(func() {
+			strategy = redis.SpreadZones{Zones: map[string]string{
+				"a": "z1", "b": "z1", "c": "z2", "d": "z3", "e": "z2",
+			}}
+		})
+
+		It("takes nodes from the least loaded zone", func() {
+			chosen := strategy.Choose(redis.Placement{
+				Candidates: candidates,
+				Allocated:  []string{"e"},
+			}, 1)
+			Expect(hosts(chosen)).To(Equal([]string{"a"}))
+
+			chosen = strategy.Choose(redis.Placement{
+				Candidates: candidates,
+				Allocated:  []string{"e", "x"},
+			}, 1)
+			Expect(hosts(chosen)).To(Equal([]string{"a"}))
+		})
+
+		It("puts the nodes of a group in different zones", func() {
+			chosen := strategy.Choose(redis.Placement{Candidates: candidates}, 3)
+			Expect(hosts(chosen)).To(Equal([]string{"a", "c", "d"}))
+		})
+	})
+
+	Describe("NewPlacementStrategy", func() {
+		It("defaults to first available", func() {
+			Expect(redis.NewPlacementStrategy(brokerconfig.Dedicated{})).To(Equal(redis.FirstAvailable{}))
+		})
+
+		It("keys the zones by normalized host", func() {
+			strategy := redis.NewPlacementStrategy(brokerconfig.Dedicated{
+				Placement:    brokerconfig.PlacementSpreadZones,
+				NodeMetadata: map[string]brokerconfig.NodeMetadata{"Redis-0.Example.com": {Zone: "z1"}},
+			})
+			Expect(strategy).To(Equal(redis.SpreadZones{Zones: map[string]string{"redis-0.example.com": "z1"}}))
+		})
+	})
+
+	Context("in the remote repository", func() {
+		var (
+			repo            *redis.RemoteRepository
+			tmpDir          string
+			statefilePath   string
+			config          brokerconfig.Config
+			fakeAgentClient *fakes.FakeAgentClient
+			logger          *lagertest.TestLogger
+		)
+
+		BeforeEach(func() {
+			logger = lagertest.NewTestLogger("placement")
+			config = brokerconfig.Config{}
+			config.RedisConfiguration.Dedicated.Nodes = []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}
+
+			var err error
+			tmpDir, err = ioutil.TempDir("", "cf-redis-broker")
+			Expect(err).ToNot(HaveOccurred())
+			statefilePath = path.Join(tmpDir, "statefile.json")
+			config.RedisConfiguration.Dedicated.StatefilePath = statefilePath
+			fakeAgentClient = &fakes.FakeAgentClient{}
+		})
+
+		JustBeforeEach(func() {
+			var err error
+			repo, err = redis.NewRemoteRepository(fakeAgentClient, config, logger)
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(tmpDir)
+		})
+
+		Context("with the default strategy", func() {
+			It("reuses a freed node first", func() {
+				Expect(repo.Create("first", broker.ProvisionParameters{})).To(Succeed())
+				Expect(repo.Destroy("first")).To(Succeed())
+				Expect(repo.Create("second", broker.ProvisionParameters{})).To(Succeed())
+
+				Expect(repo.IDForHost("10.0.0.1")).To(Equal("second"))
+			})
+		})
+
+		Context("with least-recently-freed placement", func() {
+			BeforeEach(func() {
+				config.RedisConfiguration.Dedicated.Placement = brokerconfig.PlacementLeastRecentlyFreed
+			})
+
+			It("lets a freed node cool down", func() {
+				Expect(repo.Create("first", broker.ProvisionParameters{})).To(Succeed())
+				Expect(repo.Destroy("first")).To(Succeed())
+				Expect(repo.Create("second", broker.ProvisionParameters{})).To(Succeed())
+
+				Expect(repo.IDForHost("10.0.0.2")).To(Equal("second"))
+			})
+
+			It("remembers when nodes were freed across restarts", func() {
+				Expect(repo.Create("first", broker.ProvisionParameters{})).To(Succeed())
+				Expect(repo.Destroy("first")).To(Succeed())
+				Expect(getStatefileContents(statefilePath).FreedAt).To(HaveKey("10.0.0.1"))
+
+				reloaded, err := redis.NewRemoteRepository(&fakes.FakeAgentClient{}, config, logger)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(reloaded.Create("second", broker.ProvisionParameters{})).To(Succeed())
+				Expect(reloaded.IDForHost("10.0.0.2")).To(Equal("second"))
+			})
+		})
+
+		Context("with spread-zones placement", func() {
+			BeforeEach(func() {
+				config.RedisConfiguration.Dedicated.Placement = brokerconfig.PlacementSpreadZones
+				config.RedisConfiguration.Dedicated.NodeMetadata = map[string]brokerconfig.NodeMetadata{
+					"10.0.0.1": {Zone: "z1"},
+					"10.0.0.2": {Zone: "z1"},
+					"10.0.0.3": {Zone: "z2"},
+				}
+			})
+
+			It("spreads instances over the zones", func() {
+				Expect(repo.Create("first", broker.ProvisionParameters{})).To(Succeed())
+				Expect(repo.Create("second", broker.ProvisionParameters{})).To(Succeed())
+
+				Expect(repo.IDForHost("10.0.0.1")).To(Equal("first"))
+				Expect(repo.IDForHost("10.0.0.3")).To(Equal("second"))
+			})
+
+			Context("when a node fails its health check", func() {
+				BeforeEach(func() {
+					fakeAgentClient.PingFunc = func(host string) error {
+						if host == "10.0.0.3" {
+							return errors.New("connection refused")
+						}
+						return nil
+					}
+				})
+
+				It("does not allocate it", func() {
+					Expect(repo.Create("first", broker.ProvisionParameters{})).To(Succeed())
+					Expect(repo.Create("second", broker.ProvisionParameters{})).To(Succeed())
+
+					Expect(repo.IDForHost("10.0.0.2")).To(Equal("second"))
+					Expect(repo.Nodes()[2].Quarantine).To(Equal("ping failed: connection refused"))
+				})
+
+				It("counts it out of the capacity", func() {
+					Expect(repo.Create("first", broker.ProvisionParameters{})).To(Succeed())
+					Expect(repo.Create("second", broker.ProvisionParameters{})).To(Succeed())
+					Expect(repo.Create("third", broker.ProvisionParameters{})).To(Equal(brokerapi.ErrInstanceLimitMet))
+				})
+
+				It("allocates it again once it is released", func() {
+					Expect(repo.Create("first", broker.ProvisionParameters{})).To(Succeed())
+					Expect(repo.Create("second", broker.ProvisionParameters{})).To(Succeed())
+
+					fakeAgentClient.PingFunc = nil
+					Expect(repo.ReleaseNode("10.0.0.3")).To(Succeed())
+
+					Expect(repo.Create("third", broker.ProvisionParameters{})).To(Succeed())
+					Expect(repo.IDForHost("10.0.0.3")).To(Equal("third"))
+				})
+			})
+		})
+	})
+})
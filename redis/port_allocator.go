@@ -0,0 +1,115 @@
+package redis
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+)
+
+// The default range lies below the Linux ephemeral range, 32768-60999, so
+// that outgoing connections do not take the port of a stopped instance.
+const (
+	defaultMinPort = 16384
+	defaultMaxPort = 32767
+)
+
+var ErrNoPortsAvailable = errors.New("no ports available in the configured port range")
+
+type PortRepository interface {
+	AllocatedPorts() (map[int]string, error)
+}
+
+type PortFreeFunc func(port int) bool
+
+// LocalPortAllocator hands out the lowest port in [MinPort, MaxPort] that is
+// neither reserved by an existing instance nor bound by another process.
+// Ports handed out but not yet persisted by Setup are tracked in memory so
+// that concurrent Create calls never receive the same port.
+type LocalPortAllocator struct {
+	Repo     PortRepository
+	MinPort  int
+	MaxPort  int
+	PortFree PortFreeFunc
+
+	pending map[int]string
+	mutex   sync.Mutex
+}
+
+func NewLocalPortAllocator(repo PortRepository, minPort, maxPort int) *LocalPortAllocator {
+	if minPort == 0 && maxPort == 0 {
+		minPort = defaultMinPort
+		maxPort = defaultMaxPort
+	}
+
+	return &LocalPortAllocator{
+		Repo:     repo,
+		MinPort:  minPort,
+		MaxPort:  maxPort,
+		PortFree: PortFree,
+		pending:  map[int]string{},
+	}
+}
+
+func (allocator *LocalPortAllocator) AllocatePort(instanceID string) (int, error) {
+	allocator.mutex.Lock()
+	defer allocator.mutex.Unlock()
+
+	allocated, err := allocator.Repo.AllocatedPorts()
+	if err != nil {
+		return 0, err
+	}
+
+	persisted := map[string]bool{}
+	for _, id := range allocated {
+		persisted[id] = true
+	}
+
+	for port, id := range allocator.pending {
+		if persisted[id] {
+			delete(allocator.pending, port)
+		}
+	}
+
+	for port := allocator.MinPort; port <= allocator.MaxPort; port++ {
+		if _, found := allocated[port]; found {
+			continue
+		}
+
+		if _, found := allocator.pending[port]; found {
+			continue
+		}
+
+		if !allocator.PortFree(port) {
+			continue
+		}
+
+		allocator.pending[port] = instanceID
+		return port, nil
+	}
+
+	return 0, ErrNoPortsAvailable
+}
+
+// ReleasePort forgets the port handed out to an instance whose setup failed,
+// so that the port can be handed out again.
+func (allocator *LocalPortAllocator) ReleasePort(instanceID string) {
+	allocator.mutex.Lock()
+	defer allocator.mutex.Unlock()
+
+	for port, id := range allocator.pending {
+		if id == instanceID {
+			delete(allocator.pending, port)
+		}
+	}
+}
+
+func PortFree(port int) bool {
+	listener, err := net.Listen("tcp", ":"+strconv.Itoa(port))
+	if err != nil {
+		return false
+	}
+
+	listener.Close()
+	return true
+}
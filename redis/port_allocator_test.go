@@ -0,0 +1,150 @@
+package redis_test
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/pivotal-cf/cf-redis-broker/redis"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type fakePortRepository struct {
+	ports map[int]string
+	err   error
+	mutex sync.Mutex
+}
+
+func (repo *fakePortRepository) AllocatedPorts() (map[int]string, error) {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	ports := map[int]string{}
+	for port, instanceID := range repo.ports {
+		ports[port] = instanceID
+	}
+	return ports, repo.err
+}
+
+func (repo *fakePortRepository) persist(port int, instanceID string) {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+	repo.ports[port] = instanceID
+}
+
+var _ = Describe("LocalPortAllocator", func() {
+	var (
+		repo      *fakePortRepository
+		allocator *redis.LocalPortAllocator
+		busyPorts map[int]bool
+	)
+
+	BeforeEach(func() {
+		repo = &fakePortRepository{ports: map[int]string{}}
+		busyPorts = map[int]bool{}
+
+		allocator = redis.NewLocalPortAllocator(repo, 7000, 7004)
+		allocator.PortFree = func(port int) bool {
+			return !busyPorts[port]
+		}
+	})
+
+	It("allocates the lowest port in the range", func() {
+		Expect(allocator.AllocatePort("instance-1")).To(Equal(7000))
+	})
+
+	It("skips ports allocated to existing instances", func() {
+		repo.ports[7000] = "existing-1"
+		repo.ports[7001] = "existing-2"
+
+		Expect(allocator.AllocatePort("instance-1")).To(Equal(7002))
+	})
+
+	It("skips ports bound by other processes", func() {
+		busyPorts[7000] = true
+
+		Expect(allocator.AllocatePort("instance-1")).To(Equal(7001))
+	})
+
+	It("does not hand out a port twice before it has been persisted", func() {
+		Expect(allocator.AllocatePort("instance-1")).To(Equal(7000))
+		Expect(allocator.AllocatePort("instance-2")).To(Equal(7001))
+	})
+
+	It("makes a port available again once its instance is gone", func() {
+		Expect(allocator.AllocatePort("instance-1")).To(Equal(7000))
+		repo.persist(7000, "instance-1")
+		Expect(allocator.AllocatePort("instance-2")).To(Equal(7001))
+
+		delete(repo.ports, 7000)
+		Expect(allocator.AllocatePort("instance-3")).To(Equal(7000))
+	})
+
+	It("allocates distinct ports to concurrent callers", func() {
+		ports := make(chan int, 5)
+		wg := sync.WaitGroup{}
+
+		for i := 0; i < 5; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer GinkgoRecover()
+				defer wg.Done()
+
+				port, err := allocator.AllocatePort(string(rune('a' + i)))
+				Expect(err).NotTo(HaveOccurred())
+				ports <- port
+			}(i)
+		}
+
+		wg.Wait()
+		close(ports)
+
+		seen := map[int]bool{}
+		for port := range ports {
+			Expect(seen).NotTo(HaveKey(port))
+			seen[port] = true
+		}
+		Expect(seen).To(HaveLen(5))
+	})
+
+	Context("when the range is exhausted", func() {
+		BeforeEach(func() {
+			for port := 7000; port <= 7004; port++ {
+				repo.ports[port] = "existing"
+			}
+		})
+
+		It("returns an error", func() {
+			_, err := allocator.AllocatePort("instance-1")
+			Expect(err).To(Equal(redis.ErrNoPortsAvailable))
+		})
+	})
+
+	Context("when the allocated ports cannot be read", func() {
+		BeforeEach(func() {
+			repo.err = errors.New("permission denied")
+		})
+
+		It("returns the error", func() {
+			_, err := allocator.AllocatePort("instance-1")
+			Expect(err).To(MatchError("permission denied"))
+		})
+	})
+
+	Context("when no range is configured", func() {
+		It("uses a default range below the ephemeral range", func() {
+			allocator = redis.NewLocalPortAllocator(repo, 0, 0)
+			Expect(allocator.MinPort).To(Equal(16384))
+			Expect(allocator.MaxPort).To(Equal(32767))
+		})
+	})
+
+	Context("when the setup of an instance fails", func() {
+		It("hands its port out again once released", func() {
+			Expect(allocator.AllocatePort("instance-1")).To(Equal(7000))
+			allocator.ReleasePort("instance-1")
+			Expect(allocator.AllocatePort("instance-2")).To(Equal(7000))
+		})
+	})
+})
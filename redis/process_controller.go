@@ -15,7 +15,12 @@ import (
 	"github.com/pivotal-cf/cf-redis-broker/redis/client"
 )
 
-const redisStartTimeout time.Duration = 10 * time.Second
+const (
+	redisStartTimeout     time.Duration = 10 * time.Second
+	redisShutdownTimeout  time.Duration = 30 * time.Second
+	redisTerminateTimeout time.Duration = 10 * time.Second
+	processExitPollDelay  time.Duration = 100 * time.Millisecond
+)
 
 type ProcessChecker interface {
 	Alive(pid int) bool
@@ -23,6 +28,7 @@ type ProcessChecker interface {
 
 type ProcessKiller interface {
 	Kill(pid int) error
+	Terminate(pid int) error
 }
 
 type InstanceInformer interface {
@@ -37,6 +43,11 @@ type OSProcessController struct {
 	PingFunc                  PingServerFunc
 	WaitUntilConnectableFunc  WaitUntilConnectableFunc
 	RedisServerExecutablePath string
+	RedisBinaries             map[string]string
+	ShutdownFunc              ShutdownServerFunc
+	ShutdownTimeout           time.Duration
+	TerminateTimeout          time.Duration
+	Cgroups                   CgroupManager
 
 	exec iexec.Exec
 }
@@ -58,6 +69,9 @@ func NewOSProcessController(
 		PingFunc:                  pingFunc,
 		WaitUntilConnectableFunc:  waitUntilConnectableFunc,
 		RedisServerExecutablePath: redisServerExecutablePath,
+		ShutdownFunc:              ShutdownServer,
+		ShutdownTimeout:           redisShutdownTimeout,
+		TerminateTimeout:          redisTerminateTimeout,
 		exec: iexec.New(),
 	}
 }
@@ -67,6 +81,7 @@ func PingServer(instance *Instance) error {
 		client.Host(instance.Host),
 		client.Port(instance.Port),
 		client.Password(instance.Password),
+		client.CmdAliases(instance.CommandAliases),
 	)
 	if err != nil {
 		return err
@@ -75,7 +90,86 @@ func PingServer(instance *Instance) error {
 	return client.Ping()
 }
 
+func ShutdownServer(instance *Instance, save bool) error {
+	client, err := client.Connect(
+		client.Host(instance.Host),
+		client.Port(instance.Port),
+		client.Password(instance.Password),
+		client.CmdAliases(instance.CommandAliases),
+	)
+	if err != nil {
+		return err
+	}
+	defer client.Disconnect()
+
+	return client.Shutdown(save)
+}
+
+type InstanceStats struct {
+	UsedMemory int64
+	Keycount   int
+	Version    string
+}
+
+func ReadInstanceStats(instance *Instance) (InstanceStats, error) {
+	stats := InstanceStats{}
+
+	client, err := client.Connect(
+		client.Host(instance.Host),
+		client.Port(instance.Port),
+		client.Password(instance.Password),
+		client.CmdAliases(instance.CommandAliases),
+	)
+	if err != nil {
+		return stats, err
+	}
+	defer client.Disconnect()
+
+	usedMemory, err := client.InfoField("used_memory")
+	if err != nil {
+		return stats, err
+	}
+
+	stats.UsedMemory, err = strconv.ParseInt(usedMemory, 10, 64)
+	if err != nil {
+		return stats, err
+	}
+
+	stats.Keycount, err = client.GlobalKeyCount()
+	if err != nil {
+		return stats, err
+	}
+
+	stats.Version, err = client.InfoField("redis_version")
+	if err != nil {
+		return stats, err
+	}
+
+	return stats, nil
+}
+
+// ExecutablePath picks the redis-server for the instance: its named binary if
+// it has one, otherwise defaultPath or redis-server from the PATH. A named
+// binary that is no longer configured is an error, rather than starting the
+// instance's data with another redis version.
+func ExecutablePath(instance *Instance, binaries map[string]string, defaultPath string) (string, error) {
+	if instance.RedisBinary != "" {
+		path, found := binaries[instance.RedisBinary]
+		if !found {
+			return "", fmt.Errorf("instance %s uses redis binary '%s', which is not configured", instance.ID, instance.RedisBinary)
+		}
+		return path, nil
+	}
+
+	if defaultPath != "" {
+		return defaultPath, nil
+	}
+
+	return "redis-server", nil
+}
+
 type PingServerFunc func(instance *Instance) error
+type ShutdownServerFunc func(instance *Instance, save bool) error
 type WaitUntilConnectableFunc func(address *net.TCPAddr, timeout time.Duration) error
 
 func (controller *OSProcessController) StartAndWaitUntilReady(instance *Instance, configPath, instanceDataDir, logfilePath string, timeout time.Duration) error {
@@ -88,17 +182,51 @@ func (controller *OSProcessController) StartAndWaitUntilReady(instance *Instance
 }
 
 func (controller *OSProcessController) StartAndWaitUntilReadyWithConfig(instance *Instance, instanceCommandArgs []string, timeout time.Duration) error {
-	executable := "redis-server"
-	if controller.RedisServerExecutablePath != "" {
-		executable = controller.RedisServerExecutablePath
+	executable, err := ExecutablePath(instance, controller.RedisBinaries, controller.RedisServerExecutablePath)
+	if err != nil {
+		return err
 	}
 
-	err := controller.exec.Command(executable, instanceCommandArgs...).Run()
+	err = controller.exec.Command(executable, instanceCommandArgs...).Run()
 	if err != nil {
 		return fmt.Errorf("redis failed to start: %s", err)
 	}
 
-	return controller.WaitUntilConnectableFunc(instance.Address(), timeout)
+	address, err := instance.Address()
+	if err != nil {
+		return err
+	}
+
+	err = controller.WaitUntilConnectableFunc(address, timeout)
+	if err != nil {
+		return err
+	}
+
+	return controller.addToCgroup(instance)
+}
+
+// addToCgroup moves the daemonized redis-server into the instance's cgroup.
+// Processes it forks later, e.g. for BGSAVE, inherit the cgroup.
+func (controller *OSProcessController) addToCgroup(instance *Instance) error {
+	if controller.Cgroups == nil {
+		return nil
+	}
+
+	pid, err := controller.InstanceInformer.InstancePid(instance.ID)
+	if err != nil {
+		return err
+	}
+
+	err = controller.Cgroups.AddProcess(instance.ID, pid)
+	if err != nil {
+		controller.Logger.Error("add-to-cgroup", err, lager.Data{
+			"instance": instance.ID,
+			"pid":      pid,
+		})
+		return err
+	}
+
+	return nil
 }
 
 func (controller *OSProcessController) Kill(instance *Instance) error {
@@ -110,6 +238,87 @@ func (controller *OSProcessController) Kill(instance *Instance) error {
 	return controller.ProcessKiller.Kill(pid)
 }
 
+// Stop shuts the instance down with SHUTDOWN SAVE or SHUTDOWN NOSAVE,
+// escalating to SIGTERM and then SIGKILL if it does not exit in time.
+func (controller *OSProcessController) Stop(instance *Instance, save bool) error {
+	pid, err := controller.InstanceInformer.InstancePid(instance.ID)
+	if err != nil {
+		return err
+	}
+
+	return controller.StopProcess(instance, pid, save)
+}
+
+// StopProcess stops the instance's redis-server running as pid the way Stop
+// does, for callers that know the pid without a pidfile. It only returns
+// once the process has exited.
+func (controller *OSProcessController) StopProcess(instance *Instance, pid int, save bool) error {
+	if !controller.ProcessChecker.Alive(pid) {
+		return nil
+	}
+
+	err := controller.ShutdownFunc(instance, save)
+	if err != nil {
+		controller.Logger.Error("redis-shutdown-failed", err, lager.Data{
+			"instance": instance.ID,
+			"save":     save,
+		})
+	} else if controller.waitForExit(pid, controller.ShutdownTimeout) {
+		return nil
+	}
+
+	controller.Logger.Info("terminating-redis-server", lager.Data{
+		"instance": instance.ID,
+		"pid":      pid,
+	})
+
+	err = controller.ProcessKiller.Terminate(pid)
+	if err == nil && controller.waitForExit(pid, controller.TerminateTimeout) {
+		return nil
+	}
+
+	controller.Logger.Info("killing-redis-server", lager.Data{
+		"instance": instance.ID,
+		"pid":      pid,
+	})
+
+	err = controller.ProcessKiller.Kill(pid)
+	if err != nil {
+		return err
+	}
+
+	// The instance's cgroup and files are only released once it has gone.
+	if !controller.waitForExit(pid, controller.TerminateTimeout) {
+		return fmt.Errorf("redis-server pid %d did not exit after SIGKILL", pid)
+	}
+
+	return nil
+}
+
+func (controller *OSProcessController) waitForExit(pid int, timeout time.Duration) bool {
+	for elapsed := time.Duration(0); elapsed < timeout; elapsed += processExitPollDelay {
+		if !controller.ProcessChecker.Alive(pid) {
+			return true
+		}
+		time.Sleep(processExitPollDelay)
+	}
+
+	return !controller.ProcessChecker.Alive(pid)
+}
+
+func (controller *OSProcessController) Alive(instance *Instance) bool {
+	pid, err := controller.InstanceInformer.InstancePid(instance.ID)
+	if err != nil {
+		return false
+	}
+
+	if !controller.ProcessChecker.Alive(pid) {
+		return false
+	}
+
+	return controller.PingFunc(instance) == nil
+}
+
 func (controller *OSProcessController) EnsureRunning(instance *Instance, configPath, instanceDataDir, pidfilePath, logfilePath string) error {
 	pid, err := controller.InstanceInformer.InstancePid(instance.ID)
 
@@ -14,6 +14,7 @@ import (
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"github.com/onsi/gomega/gbytes"
+	"github.com/pivotal-cf/cf-redis-broker/cgroup"
 )
 
 type fakeProcessChecker struct {
@@ -29,11 +30,25 @@ func (fakeProcessChecker *fakeProcessChecker) Alive(pid int) bool {
 type fakeProcessKiller struct {
 	killed        bool
 	lastPidKilled int
+	terminated    bool
+	onTerminate   func()
+	onKill        func()
+}
+
+func (fakeProcessKiller *fakeProcessKiller) Terminate(pid int) error {
+	fakeProcessKiller.terminated = true
+	if fakeProcessKiller.onTerminate != nil {
+		fakeProcessKiller.onTerminate()
+	}
+	return nil
 }
 
 func (fakeProcessKiller *fakeProcessKiller) Kill(pid int) error {
 	fakeProcessKiller.lastPidKilled = pid
 	fakeProcessKiller.killed = true
+	if fakeProcessKiller.onKill != nil {
+		fakeProcessKiller.onKill()
+	}
 	return nil
 }
 
@@ -43,6 +58,26 @@ func (*fakeInstanceInformer) InstancePid(instanceID string) (int, error) {
 	return 123, nil
 }
 
+type fakeCgroupManager struct {
+	addedName string
+	addedPid  int
+	addErr    error
+}
+
+func (*fakeCgroupManager) Create(name string, limits cgroup.Limits) error {
+	return nil
+}
+
+func (cgroups *fakeCgroupManager) AddProcess(name string, pid int) error {
+	cgroups.addedName = name
+	cgroups.addedPid = pid
+	return cgroups.addErr
+}
+
+func (*fakeCgroupManager) Remove(name string) error {
+	return nil
+}
+
 var _ = Describe("Redis Process Controller", func() {
 	var (
 		processController    *OSProcessController
@@ -110,6 +145,38 @@ var _ = Describe("Redis Process Controller", func() {
 	})
 
 	Describe("StartAndWaitUntilReadyWithConfig", func() {
+		Context("when cgroups are enabled", func() {
+			var cgroups *fakeCgroupManager
+
+			BeforeEach(func() {
+				cgroups = new(fakeCgroupManager)
+			})
+
+			JustBeforeEach(func() {
+				processController.Cgroups = cgroups
+			})
+
+			It("adds the redis process to the instance cgroup", func() {
+				instance := &Instance{ID: "some-instance"}
+				Expect(processController.StartAndWaitUntilReadyWithConfig(instance, []string{}, time.Second)).To(Succeed())
+
+				Expect(cgroups.addedName).To(Equal("some-instance"))
+				Expect(cgroups.addedPid).To(Equal(123))
+			})
+
+			Context("when the process cannot be added to the cgroup", func() {
+				BeforeEach(func() {
+					cgroups.addErr = errors.New("no such cgroup")
+				})
+
+				It("returns the error", func() {
+					err := processController.StartAndWaitUntilReadyWithConfig(instance, []string{}, time.Second)
+					Expect(err).To(MatchError("no such cgroup"))
+					Expect(logger).To(gbytes.Say("add-to-cgroup"))
+				})
+			})
+		})
+
 		Context("When using a custom redis-server executable", func() {
 			It("runs the right command to start redis", func() {
 				processController.RedisServerExecutablePath = "custom/path/to/redis"
@@ -124,6 +191,38 @@ var _ = Describe("Redis Process Controller", func() {
 			})
 		})
 
+		Context("When the instance uses a named redis binary", func() {
+			JustBeforeEach(func() {
+				processController.RedisServerExecutablePath = "custom/path/to/redis"
+				processController.RedisBinaries = map[string]string{
+					"4.0": "/var/vcap/packages/redis-4.0/bin/redis-server",
+				}
+			})
+
+			It("runs that binary", func() {
+				namedInstance := &Instance{ID: "some-instance", RedisBinary: "4.0"}
+				args := []string{
+					"configFilePath",
+					"--dir", "instanceDataDir",
+					"--logfile", "logFilePath",
+				}
+				processController.StartAndWaitUntilReadyWithConfig(namedInstance, args, time.Second*1)
+				itStartsARedisProcess("/var/vcap/packages/redis-4.0/bin/redis-server")
+			})
+
+			It("refuses names that are not configured", func() {
+				namedInstance := &Instance{ID: "some-instance", RedisBinary: "9.9"}
+				args := []string{
+					"configFilePath",
+					"--dir", "instanceDataDir",
+					"--logfile", "logFilePath",
+				}
+				err := processController.StartAndWaitUntilReadyWithConfig(namedInstance, args, time.Second*1)
+				Expect(err).To(MatchError("instance some-instance uses redis binary '9.9', which is not configured"))
+				Expect(fakes.Exec.CommandCallCount()).To(Equal(0))
+			})
+		})
+
 		It("runs the right command to start redis", func() {
 			args := []string{
 				"configFilePath",
@@ -161,6 +260,130 @@ var _ = Describe("Redis Process Controller", func() {
 		})
 	})
 
+	Describe("Stop", func() {
+		var (
+			shutdownSave  bool
+			shutdownCalls int
+			shutdownErr   error
+		)
+
+		BeforeEach(func() {
+			fakeProcessChecker.alive = true
+			fakeProcessKiller.killed = false
+			fakeProcessKiller.terminated = false
+			fakeProcessKiller.onTerminate = nil
+			fakeProcessKiller.onKill = func() {
+				fakeProcessChecker.alive = false
+			}
+			shutdownCalls = 0
+			shutdownErr = nil
+		})
+
+		JustBeforeEach(func() {
+			processController.ShutdownTimeout = 200 * time.Millisecond
+			processController.TerminateTimeout = 200 * time.Millisecond
+			processController.ShutdownFunc = func(instance *Instance, save bool) error {
+				shutdownCalls++
+				shutdownSave = save
+				if shutdownErr == nil {
+					fakeProcessChecker.alive = false
+				}
+				return shutdownErr
+			}
+		})
+
+		It("shuts redis down with the requested save mode", func() {
+			Expect(processController.Stop(instance, true)).To(Succeed())
+
+			Expect(shutdownCalls).To(Equal(1))
+			Expect(shutdownSave).To(BeTrue())
+			Expect(fakeProcessKiller.terminated).To(BeFalse())
+			Expect(fakeProcessKiller.killed).To(BeFalse())
+		})
+
+		Context("when the process is not running", func() {
+			BeforeEach(func() {
+				fakeProcessChecker.alive = false
+			})
+
+			It("does nothing", func() {
+				Expect(processController.Stop(instance, false)).To(Succeed())
+				Expect(shutdownCalls).To(Equal(0))
+			})
+		})
+
+		Context("when SHUTDOWN fails", func() {
+			BeforeEach(func() {
+				shutdownErr = errors.New("ERR Errors trying to SHUTDOWN")
+				fakeProcessKiller.onTerminate = func() {
+					fakeProcessChecker.alive = false
+				}
+			})
+
+			It("falls back to SIGTERM", func() {
+				Expect(processController.Stop(instance, true)).To(Succeed())
+
+				Expect(fakeProcessKiller.terminated).To(BeTrue())
+				Expect(fakeProcessKiller.killed).To(BeFalse())
+			})
+
+			Context("and the process ignores SIGTERM", func() {
+				BeforeEach(func() {
+					fakeProcessKiller.onTerminate = nil
+				})
+
+				It("falls back to SIGKILL", func() {
+					Expect(processController.Stop(instance, true)).To(Succeed())
+
+					Expect(fakeProcessKiller.terminated).To(BeTrue())
+					Expect(fakeProcessKiller.killed).To(BeTrue())
+					Expect(fakeProcessKiller.lastPidKilled).To(Equal(123))
+				})
+
+				Context("and the process outlives SIGKILL", func() {
+					BeforeEach(func() {
+						fakeProcessKiller.onKill = nil
+					})
+
+					It("returns an error", func() {
+						err := processController.Stop(instance, true)
+						Expect(err).To(MatchError("redis-server pid 123 did not exit after SIGKILL"))
+					})
+				})
+			})
+		})
+	})
+
+	Describe("Alive", func() {
+		Context("when the process is not running", func() {
+			BeforeEach(func() {
+				fakeProcessChecker.alive = false
+			})
+
+			It("returns false", func() {
+				Expect(processController.Alive(instance)).To(BeFalse())
+				Expect(fakeProcessChecker.lastCheckedPid).To(Equal(123))
+			})
+		})
+
+		Context("when the process is running", func() {
+			BeforeEach(func() {
+				fakeProcessChecker.alive = true
+			})
+
+			It("returns false if redis does not respond to PING", func() {
+				Expect(processController.Alive(instance)).To(BeFalse())
+			})
+
+			It("returns true if redis responds to PING", func() {
+				processController.PingFunc = func(instance *Instance) error {
+					return nil
+				}
+				Expect(processController.Alive(instance)).To(BeTrue())
+			})
+		})
+	})
+
 	Describe("EnsureRunning", func() {
 		Context("if the process is already running", func() {
 			var (
@@ -4,18 +4,27 @@ import (
 	"encoding/json"
 	"io/ioutil"
 	"net"
+	"path"
 	"testing"
+	"time"
 
+	"code.cloudfoundry.org/lager"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 
+	"github.com/pivotal-cf/cf-redis-broker/brokerconfig"
 	"github.com/pivotal-cf/cf-redis-broker/redis"
 )
 
 type Statefile struct {
-	AvailableInstances []*redis.Instance   `json:"available_instances"`
-	AllocatedInstances []*redis.Instance   `json:"allocated_instances"`
-	InstanceBindings   map[string][]string `json:"instance_bindings"`
+	AvailableInstances []*redis.Instance      `json:"available_instances"`
+	AllocatedInstances []*redis.Instance      `json:"allocated_instances"`
+	AllocatedGroups    []*redis.InstanceGroup `json:"allocated_groups,omitempty"`
+	PendingResets      []*redis.PendingReset  `json:"pending_resets,omitempty"`
+	DrainingNodes      []string               `json:"draining_nodes,omitempty"`
+	FreedAt            map[string]time.Time   `json:"freed_at,omitempty"`
+	QuarantinedNodes   map[string]string      `json:"quarantined_nodes,omitempty"`
+	InstanceBindings   map[string][]string    `json:"instance_bindings"`
 }
 
 func TestRedis(t *testing.T) {
@@ -23,6 +32,24 @@ func TestRedis(t *testing.T) {
 	RunSpecs(t, "Redis Suite")
 }
 
+// dedicatedConfig returns a config for the given dedicated nodes, with the
+// statefile in a new temporary directory that the caller removes.
+func dedicatedConfig(nodes ...string) (brokerconfig.Config, string) {
+	tmpDir, err := ioutil.TempDir("", "cf-redis-broker")
+	Expect(err).ToNot(HaveOccurred())
+
+	config := brokerconfig.Config{}
+	config.RedisConfiguration.Dedicated.Nodes = nodes
+	config.RedisConfiguration.Dedicated.StatefilePath = path.Join(tmpDir, "statefile.json")
+	return config, tmpDir
+}
+
+func newRemoteRepository(agentClient redis.AgentClient, config brokerconfig.Config, logger lager.Logger) *redis.RemoteRepository {
+	repo, err := redis.NewRemoteRepository(agentClient, config, logger)
+	Expect(err).ToNot(HaveOccurred())
+	return repo
+}
+
 func getStatefileContents(path string) Statefile {
 	statefileBytes, _ := ioutil.ReadFile(path)
 	statefileContents := Statefile{}
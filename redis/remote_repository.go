@@ -7,6 +7,7 @@ import (
 	"io/ioutil"
 	"os"
 	"sync"
+	"time"
 
 	"github.com/pivotal-cf/brokerapi"
 	"github.com/pivotal-cf/cf-redis-broker/broker"
@@ -17,6 +18,13 @@ import (
 type RemoteRepository struct {
 	availableInstances []*Instance
 	allocatedInstances []*Instance
+	allocatedGroups    []*InstanceGroup
+	pendingResets      []*PendingReset
+	draining           map[string]bool
+	quarantined        map[string]string
+	freedAt            map[string]time.Time
+	placement          PlacementStrategy
+	drift              []string
 	instanceLimit      int
 	instanceBindings   map[string][]string
 	agentClient        AgentClient
@@ -28,23 +36,38 @@ type RemoteRepository struct {
 type AgentClient interface {
 	Reset(hostIP string) error
 	Credentials(hostIP string) (Credentials, error)
+	Ping(hostIP string) error
+	Keycount(hostIP string) (int, error)
 }
 
 func NewRemoteRepository(agentClient AgentClient, config brokerconfig.Config, logger lager.Logger) (*RemoteRepository, error) {
 	repo := RemoteRepository{
 		instanceLimit:    len(config.RedisConfiguration.Dedicated.Nodes),
 		instanceBindings: make(map[string][]string),
+		draining:         make(map[string]bool),
+		quarantined:      make(map[string]string),
+		freedAt:          make(map[string]time.Time),
+		placement:        NewPlacementStrategy(config.RedisConfiguration.Dedicated),
 		statefilePath:    config.RedisConfiguration.Dedicated.StatefilePath,
 		agentClient:      agentClient,
 		logger:           logger,
 	}
 
-	err := repo.loadStateFromFile()
+	_, statErr := os.Stat(repo.statefilePath)
+
+	statefileContents, err := repo.loadStateFromFile()
 	if err != nil {
 		return nil, err
 	}
 
-	for _, ip := range config.RedisConfiguration.Dedicated.Nodes {
+	configNodes := []string{}
+	for _, node := range config.RedisConfiguration.Dedicated.Nodes {
+		configNodes = append(configNodes, brokerconfig.NormalizeDedicatedNode(node))
+	}
+
+	repo.detectDrift(configNodes, statefileContents.AvailableInstances, statErr == nil)
+
+	for _, ip := range configNodes {
 		available := true
 		for _, allocatedInstance := range repo.allocatedInstances {
 			if ip == allocatedInstance.Host {
@@ -52,6 +75,9 @@ func NewRemoteRepository(agentClient AgentClient, config brokerconfig.Config, lo
 				break
 			}
 		}
+		if repo.findGroupByHost(ip) != nil || repo.pendingResetIndex(ip) >= 0 {
+			available = false
+		}
 		if available {
 			instance := Instance{
 				Host: ip,
@@ -60,6 +86,24 @@ func NewRemoteRepository(agentClient AgentClient, config brokerconfig.Config, lo
 		}
 	}
 
+	for _, host := range statefileContents.DrainingNodes {
+		if repo.knowsHost(host) {
+			repo.draining[host] = true
+		}
+	}
+
+	for host, reason := range statefileContents.QuarantinedNodes {
+		if repo.knowsHost(host) {
+			repo.quarantined[host] = reason
+		}
+	}
+
+	for host, freedAt := range statefileContents.FreedAt {
+		if repo.knowsHost(host) {
+			repo.freedAt[host] = freedAt
+		}
+	}
+
 	err = repo.PersistStatefile()
 	if err != nil {
 		return nil, err
@@ -85,29 +129,26 @@ func (repo *RemoteRepository) InstanceExists(instanceID string) (bool, error) {
 	return true, nil
 }
 
+// Destroy releases the instance's node before resetting it, so that the
+// repository is not locked while the agent wipes the node.
 func (repo *RemoteRepository) Destroy(instanceID string) error {
 	repo.Lock()
-	defer repo.Unlock()
 
 	instance, err := repo.FindByID(instanceID)
 	if err != nil {
+		repo.Unlock()
 		return err
 	}
 
 	instance.ID = instanceID
 
-	err = repo.agentClient.Reset(instance.Host)
+	err = repo.releaseInstance(instance)
+	repo.Unlock()
 	if err != nil {
 		return err
 	}
 
-	repo.deallocateInstance(instance)
-
-	err = repo.PersistStatefile()
-	if err != nil {
-		repo.allocateInstance(instanceID)
-		return err
-	}
+	repo.resetReleased(instanceID, []string{instance.Host})
 
 	repo.logger.Info("deprovision-instance", lager.Data{
 		"instance_id": instanceID,
@@ -118,19 +159,31 @@ func (repo *RemoteRepository) Destroy(instanceID string) error {
 	return nil
 }
 
+// AllInstances returns copies of the allocated instances, which the pending
+// reset worker may change meanwhile.
 func (repo *RemoteRepository) AllInstances() ([]*Instance, error) {
-	return repo.allocatedInstances, nil
+	repo.RLock()
+	defer repo.RUnlock()
+
+	return copyInstances(repo.allocatedInstances), nil
 }
 
 func (repo *RemoteRepository) InstanceCount() (int, error) {
+	repo.RLock()
+	defer repo.RUnlock()
+
 	return len(repo.allocatedInstances), nil
 }
 
-func (repo *RemoteRepository) Create(instanceID string) error {
+func (repo *RemoteRepository) Create(instanceID string, parameters broker.ProvisionParameters) error {
+	if parameters.RedisVersion != "" {
+		return errors.New("redis_version can only be chosen for shared-vm instances")
+	}
+
 	repo.Lock()
 	defer repo.Unlock()
 
-	if len(repo.availableInstances) <= 0 {
+	if len(repo.allocatableInstances()) <= 0 {
 		return brokerapi.ErrInstanceLimitMet
 	}
 
@@ -139,9 +192,14 @@ func (repo *RemoteRepository) Create(instanceID string) error {
 		return brokerapi.ErrInstanceAlreadyExists
 	}
 
-	instance := repo.allocateInstance(instanceID)
+	nodes, err := repo.chooseHealthyNodes(instanceID, 1)
+	if err != nil {
+		return err
+	}
+
+	instance := repo.allocateInstance(instanceID, nodes[0])
 
-	err := repo.PersistStatefile()
+	err = repo.PersistStatefile()
 	if err != nil {
 		repo.deallocateInstance(instance)
 		return err
@@ -192,6 +250,8 @@ func (repo *RemoteRepository) Bind(instanceID string, bindingID string) (broker.
 		Host:     instance.Host,
 		Port:     instance.Port,
 		Password: instance.Password,
+		Version:  credentials.Version,
+		TLSPort:  credentials.TLSPort,
 	}, nil
 }
 
@@ -228,26 +288,60 @@ func (repo *RemoteRepository) Unbind(instanceID string, bindingID string) error
 }
 
 func (repo *RemoteRepository) InstanceLimit() int {
+	repo.RLock()
+	defer repo.RUnlock()
+
 	return repo.instanceLimit
 }
 
 func (repo *RemoteRepository) AvailableInstances() []*Instance {
-	return repo.availableInstances
+	repo.RLock()
+	defer repo.RUnlock()
+
+	return copyInstances(repo.availableInstances)
+}
+
+func (repo *RemoteRepository) AllGroups() []*InstanceGroup {
+	repo.RLock()
+	defer repo.RUnlock()
+
+	groups := []*InstanceGroup{}
+	for _, group := range repo.allocatedGroups {
+		groups = append(groups, copyGroup(group))
+	}
+	return groups
 }
 
 func (repo *RemoteRepository) BindingsForInstance(instanceID string) ([]string, error) {
+	repo.RLock()
+	defer repo.RUnlock()
+
 	bindings, ok := repo.instanceBindings[instanceID]
 	if !ok {
 		return nil, brokerapi.ErrInstanceDoesNotExist
 	}
 
-	return bindings, nil
+	return append([]string{}, bindings...), nil
+}
+
+func copyInstances(instances []*Instance) []*Instance {
+	copies := []*Instance{}
+	for _, instance := range instances {
+		instanceCopy := *instance
+		copies = append(copies, &instanceCopy)
+	}
+	return copies
 }
 
 type Statefile struct {
-	AvailableInstances []*Instance         `json:"available_instances"`
-	AllocatedInstances []*Instance         `json:"allocated_instances"`
-	InstanceBindings   map[string][]string `json:"instance_bindings"`
+	AvailableInstances []*Instance          `json:"available_instances"`
+	AllocatedInstances []*Instance          `json:"allocated_instances"`
+	AllocatedGroups    []*InstanceGroup     `json:"allocated_groups,omitempty"`
+	PendingResets      []*PendingReset      `json:"pending_resets,omitempty"`
+	DrainingNodes      []string             `json:"draining_nodes,omitempty"`
+	FreedAt            map[string]time.Time `json:"freed_at,omitempty"`
+	QuarantinedNodes   map[string]string    `json:"quarantined_nodes,omitempty"`
+	InstanceBindings   map[string][]string  `json:"instance_bindings"`
 }
 
 func newStatefile() Statefile {
@@ -258,6 +352,11 @@ func (repo *RemoteRepository) PersistStatefile() error {
 	statefileContents := Statefile{
 		AvailableInstances: repo.availableInstances,
 		AllocatedInstances: repo.allocatedInstances,
+		AllocatedGroups:    repo.allocatedGroups,
+		PendingResets:      repo.pendingResets,
+		DrainingNodes:      repo.drainingHosts(),
+		FreedAt:            repo.freedAt,
+		QuarantinedNodes:   repo.quarantined,
 		InstanceBindings:   repo.instanceBindings,
 	}
 
@@ -270,11 +369,18 @@ func (repo *RemoteRepository) PersistStatefile() error {
 }
 
 func (repo *RemoteRepository) IDForHost(host string) string {
+	host = brokerconfig.NormalizeDedicatedNode(host)
+
 	for _, instance := range repo.allocatedInstances {
 		if instance.Host == host {
 			return instance.ID
 		}
 	}
+
+	if group := repo.findGroupByHost(host); group != nil {
+		return group.ID
+	}
+
 	return ""
 }
 
@@ -337,27 +443,29 @@ func (repo *RemoteRepository) StateFromFile() (Statefile, error) {
 	return statefileContents, nil
 }
 
-func (repo *RemoteRepository) loadStateFromFile() error {
+func (repo *RemoteRepository) loadStateFromFile() (Statefile, error) {
 	statefileContents, err := repo.StateFromFile()
 	if err != nil {
-		return err
+		return statefileContents, err
 	}
 
+	normalizeStatefileHosts(&statefileContents)
+
 	repo.allocatedInstances = statefileContents.AllocatedInstances
+	repo.allocatedGroups = statefileContents.AllocatedGroups
+	repo.pendingResets = statefileContents.PendingResets
 	repo.instanceBindings = statefileContents.InstanceBindings
 
-	return nil
+	return statefileContents, nil
 }
 
 func (repo *RemoteRepository) removeBinding(instanceID, bindingID string) error {
 	var newInstanceBindings []string
 
-	_, err := repo.FindByID(instanceID)
-	if err != nil {
-		return err
+	bindings, ok := repo.instanceBindings[instanceID]
+	if !ok {
+		return brokerapi.ErrInstanceDoesNotExist
 	}
-
-	bindings, _ := repo.instanceBindings[instanceID]
 	found := false
 	for _, binding := range bindings {
 		if binding != bindingID {
@@ -376,10 +484,9 @@ func (repo *RemoteRepository) removeBinding(instanceID, bindingID string) error
 	return nil
 }
 
-func (repo *RemoteRepository) allocateInstance(instanceID string) *Instance {
-
-	instance := repo.availableInstances[0]
-	repo.availableInstances = repo.availableInstances[1:]
+func (repo *RemoteRepository) allocateInstance(instanceID string, instance *Instance) *Instance {
+	index := repo.availableIndex(instance.Host)
+	repo.availableInstances = append(repo.availableInstances[:index:index], repo.availableInstances[index+1:]...)
 
 	instance.ID = instanceID
 	repo.allocatedInstances = append(repo.allocatedInstances, instance)
@@ -400,6 +507,7 @@ func (repo *RemoteRepository) deallocateInstance(instance *Instance) {
 	repo.allocatedInstances = nowAllocatedInstances
 
 	repo.availableInstances = append([]*Instance{instance}, repo.availableInstances...)
+	repo.freedAt[instance.Host] = time.Now()
 
 	delete(repo.instanceBindings, instance.ID)
 }
@@ -8,6 +8,7 @@ import (
 	"path"
 
 	"github.com/pivotal-cf/brokerapi"
+	"github.com/pivotal-cf/cf-redis-broker/broker"
 	"github.com/pivotal-cf/cf-redis-broker/brokerconfig"
 	"github.com/pivotal-cf/cf-redis-broker/redis"
 	"github.com/pivotal-cf/cf-redis-broker/redis/fakes"
@@ -224,7 +225,7 @@ var _ = Describe("RemoteRepository", func() {
 
 	Context("When one node is allocated", func() {
 		BeforeEach(func() {
-			err := repo.Create("foo")
+			err := repo.Create("foo", broker.ProvisionParameters{})
 			Expect(err).ToNot(HaveOccurred())
 		})
 
@@ -234,7 +235,9 @@ var _ = Describe("RemoteRepository", func() {
 					if host == "10.0.0.1" {
 						return redis.Credentials{
 							Port:     123456,
+							TLSPort:  16379,
 							Password: "super-secret",
+							Version:  "3.2.8",
 						}, nil
 					} else {
 						return redis.Credentials{}, errors.New("wrong url")
@@ -248,6 +251,8 @@ var _ = Describe("RemoteRepository", func() {
 				Expect(instanceCredentials.Host).To(Equal("10.0.0.1"))
 				Expect(instanceCredentials.Port).To(Equal(123456))
 				Expect(instanceCredentials.Password).To(Equal("super-secret"))
+				Expect(instanceCredentials.Version).To(Equal("3.2.8"))
+				Expect(instanceCredentials.TLSPort).To(Equal(16379))
 			})
 
 			It("writes the new state to the statefile", func() {
@@ -381,8 +386,14 @@ var _ = Describe("RemoteRepository", func() {
 		})
 
 		Describe("#Create", func() {
+			It("rejects a redis_version parameter", func() {
+				err := repo.Create("bar", broker.ProvisionParameters{RedisVersion: "4.0"})
+				Expect(err).To(MatchError("redis_version can only be chosen for shared-vm instances"))
+				Expect(repo.AvailableInstances()).To(HaveLen(2))
+			})
+
 			It("allocates the next available node", func() {
-				err := repo.Create("bar")
+				err := repo.Create("bar", broker.ProvisionParameters{})
 				Expect(err).ToNot(HaveOccurred())
 
 				hosts := []string{}
@@ -397,7 +408,7 @@ var _ = Describe("RemoteRepository", func() {
 			})
 
 			It("writes the new state to the statefile", func() {
-				err := repo.Create("bar")
+				err := repo.Create("bar", broker.ProvisionParameters{})
 				Expect(err).ToNot(HaveOccurred())
 
 				statefileContents := getStatefileContents(statefilePath)
@@ -406,7 +417,7 @@ var _ = Describe("RemoteRepository", func() {
 			})
 
 			It("logs that the instance was provisioned", func() {
-				err := repo.Create("bar")
+				err := repo.Create("bar", broker.ProvisionParameters{})
 				Expect(err).ToNot(HaveOccurred())
 
 				Expect(logger).To(gbytes.Say("provision-instance"))
@@ -426,7 +437,7 @@ var _ = Describe("RemoteRepository", func() {
 				})
 
 				It("does not allocate an instance", func() {
-					err := repo.Create("bar")
+					err := repo.Create("bar", broker.ProvisionParameters{})
 					Expect(err).To(HaveOccurred())
 
 					_, err = repo.FindByID("bar")
@@ -436,7 +447,7 @@ var _ = Describe("RemoteRepository", func() {
 
 			Context("when the instanceID is already allocated", func() {
 				It("returns brokerapi.ErrInstanceAlreadyExists", func() {
-					err := repo.Create("foo")
+					err := repo.Create("foo", broker.ProvisionParameters{})
 					Expect(err).To(HaveOccurred())
 					Expect(err).To(Equal(brokerapi.ErrInstanceAlreadyExists))
 				})
@@ -444,12 +455,12 @@ var _ = Describe("RemoteRepository", func() {
 
 			Context("when instance capacity has been reached", func() {
 				BeforeEach(func() {
-					repo.Create("bar")
-					repo.Create("baz")
+					repo.Create("bar", broker.ProvisionParameters{})
+					repo.Create("baz", broker.ProvisionParameters{})
 				})
 
 				It("returns brokerapi.ErrInstanceLimitMet", func() {
-					err := repo.Create("another")
+					err := repo.Create("another", broker.ProvisionParameters{})
 					Expect(err).To(HaveOccurred())
 					Expect(err).To(Equal(brokerapi.ErrInstanceLimitMet))
 				})
@@ -523,6 +534,34 @@ var _ = Describe("RemoteRepository", func() {
 					Expect(fakeAgentClient.ResetHosts).To(ConsistOf(instance.Host))
 				})
 
+				It("resets the node without holding the repo lock", func() {
+					fakeAgentClient.ResetHandler = func(string) error {
+						unlocked := make(chan struct{})
+						go func() {
+							repo.Lock()
+							repo.Unlock()
+							close(unlocked)
+						}()
+						Eventually(unlocked).Should(BeClosed())
+						return nil
+					}
+
+					Expect(repo.Destroy("foo")).To(Succeed())
+				})
+
+				It("keeps the node out of the pool while it is reset", func() {
+					fakeAgentClient.ResetHandler = func(host string) error {
+						Expect(repo.AvailableInstances()).To(HaveLen(2))
+						Expect(repo.PendingResets()).To(HaveLen(1))
+						Expect(repo.PendingResets()[0].Host).To(Equal(host))
+						Expect(getStatefileContents(statefilePath).AllocatedInstances).To(BeEmpty())
+						return nil
+					}
+
+					Expect(repo.Destroy("foo")).To(Succeed())
+					Expect(repo.PendingResets()).To(BeEmpty())
+				})
+
 				It("logs that the instance was deprovisioned", func() {
 					err := repo.Destroy("foo")
 					Expect(err).ToNot(HaveOccurred())
@@ -564,21 +603,42 @@ var _ = Describe("RemoteRepository", func() {
 						}
 					})
 
-					It("does not deallocate the instance", func() {
-						repo.Destroy("foo")
+					It("deprovisions the instance", func() {
+						Expect(repo.Destroy("foo")).To(Succeed())
 						_, err := repo.FindByID("foo")
-						Expect(err).ToNot(HaveOccurred())
+						Expect(err).To(Equal(brokerapi.ErrInstanceDoesNotExist))
+					})
+
+					It("keeps the node out of the pool until it is reset", func() {
+						Expect(repo.Destroy("foo")).To(Succeed())
+
+						Expect(repo.AvailableInstances()).To(HaveLen(2))
+						Expect(repo.PendingResets()).To(HaveLen(1))
+						Expect(repo.PendingResets()[0].InstanceID).To(Equal("foo"))
+						Expect(repo.PendingResets()[0].LastError).To(Equal("Internal server error"))
+
+						state := getStatefileContents(statefilePath)
+						Expect(state.AllocatedInstances).To(BeEmpty())
+						Expect(state.AvailableInstances).To(HaveLen(2))
+						Expect(state.PendingResets).To(HaveLen(1))
 					})
 
-					It("does not modify the state file", func() {
-						initialStatefileContents := getStatefileContents(statefilePath)
+					It("logs that the reset failed", func() {
 						repo.Destroy("foo")
-						Expect(getStatefileContents(statefilePath)).To(Equal(initialStatefileContents))
+						Expect(logger).To(gbytes.Say("deprovision-reset-failed"))
+					})
+				})
+
+				Context("and the agent is unreachable", func() {
+					BeforeEach(func() {
+						fakeAgentClient.ResetHandler = func(host string) error {
+							return &redis.AgentUnreachableError{Host: host, Err: errors.New("i/o timeout")}
+						}
 					})
 
-					It("returns the error", func() {
-						err := repo.Destroy("foo")
-						Expect(err).To(Equal(clientError))
+					It("logs that the agent is unreachable", func() {
+						Expect(repo.Destroy("foo")).To(Succeed())
+						Expect(logger).To(gbytes.Say("deprovision-agent-unreachable"))
 					})
 				})
 			})
@@ -595,11 +655,11 @@ var _ = Describe("RemoteRepository", func() {
 
 	Context("When all nodes are allocated", func() {
 		BeforeEach(func() {
-			err := repo.Create("foo")
+			err := repo.Create("foo", broker.ProvisionParameters{})
 			Expect(err).ToNot(HaveOccurred())
-			err = repo.Create("bar")
+			err = repo.Create("bar", broker.ProvisionParameters{})
 			Expect(err).ToNot(HaveOccurred())
-			err = repo.Create("baz")
+			err = repo.Create("baz", broker.ProvisionParameters{})
 			Expect(err).ToNot(HaveOccurred())
 		})
 
@@ -629,7 +689,7 @@ var _ = Describe("RemoteRepository", func() {
 
 		Describe("#Create", func() {
 			It("returns an error", func() {
-				err := repo.Create("foo")
+				err := repo.Create("foo", broker.ProvisionParameters{})
 				Expect(err).To(HaveOccurred())
 				Expect(err).To(Equal(brokerapi.ErrInstanceLimitMet))
 			})
@@ -644,7 +704,7 @@ var _ = Describe("RemoteRepository", func() {
 
 	Describe("#PersistStatefile", func() {
 		BeforeEach(func() {
-			err := repo.Create("foo")
+			err := repo.Create("foo", broker.ProvisionParameters{})
 			Expect(err).ToNot(HaveOccurred())
 
 			_, err = repo.Bind("foo", "foo-binding")
@@ -670,7 +730,7 @@ var _ = Describe("RemoteRepository", func() {
 
 	Describe("#IDForHost", func() {
 		It("returns the corresponding instance ID", func() {
-			err := repo.Create("foo")
+			err := repo.Create("foo", broker.ProvisionParameters{})
 			Expect(err).ToNot(HaveOccurred())
 
 			Expect(repo.IDForHost(config.RedisConfiguration.Dedicated.Nodes[0])).To(Equal("foo"))
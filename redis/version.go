@@ -0,0 +1,37 @@
+package redis
+
+import (
+	"fmt"
+	"os/exec"
+	"regexp"
+
+	"github.com/pivotal-cf/cf-redis-broker/redisconf"
+)
+
+var serverVersionPattern = regexp.MustCompile(`v=(\d+\.\d+(?:\.\d+)?)`)
+
+// DetectServerVersion asks the redis-server binary for its version. An empty
+// executablePath means redis-server from the PATH.
+func DetectServerVersion(executablePath string) (redisconf.Version, error) {
+	if executablePath == "" {
+		executablePath = "redis-server"
+	}
+
+	output, err := exec.Command(executablePath, "--version").Output()
+	if err != nil {
+		return redisconf.Version{}, fmt.Errorf("running %s --version: %s", executablePath, err)
+	}
+
+	return ParseServerVersion(string(output))
+}
+
+// ParseServerVersion reads the version from the output of
+// redis-server --version, e.g. "Redis server v=3.2.8 sha=00000000:0 ...".
+func ParseServerVersion(output string) (redisconf.Version, error) {
+	match := serverVersionPattern.FindStringSubmatch(output)
+	if match == nil {
+		return redisconf.Version{}, fmt.Errorf("no version in redis-server output '%s'", output)
+	}
+
+	return redisconf.ParseVersion(match[1])
+}
@@ -0,0 +1,57 @@
+package redis_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pivotal-cf/cf-redis-broker/redis"
+	"github.com/pivotal-cf/cf-redis-broker/redisconf"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Server version", func() {
+	Describe("ParseServerVersion", func() {
+		It("reads the version from redis-server --version", func() {
+			version, err := redis.ParseServerVersion("Redis server v=3.2.8 sha=00000000:0 malloc=jemalloc-4.0.3 bits=64 build=b0b2c4fa4cb5\n")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(version).To(Equal(redisconf.Version{Major: 3, Minor: 2, Patch: 8}))
+		})
+
+		It("fails when there is no version", func() {
+			_, err := redis.ParseServerVersion("command not found")
+			Expect(err).To(MatchError("no version in redis-server output 'command not found'"))
+		})
+	})
+
+	Describe("DetectServerVersion", func() {
+		var dir string
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "redis-version")
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		It("runs the executable with --version", func() {
+			executable := filepath.Join(dir, "redis-server")
+			script := "#!/bin/sh\n[ \"$1\" = --version ] && echo 'Redis server v=6.0.9 sha=00000000:0'\n"
+			Expect(ioutil.WriteFile(executable, []byte(script), 0755)).To(Succeed())
+
+			version, err := redis.DetectServerVersion(executable)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(version.String()).To(Equal("6.0.9"))
+		})
+
+		It("fails when the executable cannot be run", func() {
+			_, err := redis.DetectServerVersion(filepath.Join(dir, "missing"))
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
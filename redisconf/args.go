@@ -0,0 +1,116 @@
+package redisconf
+
+import (
+	"errors"
+	"strconv"
+)
+
+var errUnbalancedQuotes = errors.New("unbalanced quotes")
+
+// splitArgs mirrors sdssplitargs in redis-server: arguments are separated
+// by whitespace, "double quoted" arguments support \n, \r, \t, \b, \a, \",
+// \\ and \xHH escapes and 'single quoted' arguments support \'.
+func splitArgs(line string) ([]string, error) {
+	args := []string{}
+	i := 0
+
+	for {
+		for i < len(line) && isSpace(line[i]) {
+			i++
+		}
+
+		if i == len(line) {
+			return args, nil
+		}
+
+		arg := []byte{}
+		inDoubleQuotes := false
+		inSingleQuotes := false
+
+		for done := false; !done; {
+			switch {
+			case inDoubleQuotes:
+				if i == len(line) {
+					return nil, errUnbalancedQuotes
+				}
+
+				if line[i] == '\\' && i+3 < len(line) && line[i+1] == 'x' && isHex(line[i+2]) && isHex(line[i+3]) {
+					b, _ := strconv.ParseUint(line[i+2:i+4], 16, 8)
+					arg = append(arg, byte(b))
+					i += 3
+				} else if line[i] == '\\' && i+1 < len(line) {
+					i++
+					arg = append(arg, unescape(line[i]))
+				} else if line[i] == '"' {
+					if i+1 < len(line) && !isSpace(line[i+1]) {
+						return nil, errUnbalancedQuotes
+					}
+					done = true
+				} else {
+					arg = append(arg, line[i])
+				}
+			case inSingleQuotes:
+				if i == len(line) {
+					return nil, errUnbalancedQuotes
+				}
+
+				if line[i] == '\\' && i+1 < len(line) && line[i+1] == '\'' {
+					i++
+					arg = append(arg, '\'')
+				} else if line[i] == '\'' {
+					if i+1 < len(line) && !isSpace(line[i+1]) {
+						return nil, errUnbalancedQuotes
+					}
+					done = true
+				} else {
+					arg = append(arg, line[i])
+				}
+			default:
+				if i == len(line) || isSpace(line[i]) {
+					done = true
+					continue
+				}
+
+				switch line[i] {
+				case '"':
+					inDoubleQuotes = true
+				case '\'':
+					inSingleQuotes = true
+				default:
+					arg = append(arg, line[i])
+				}
+			}
+
+			if i < len(line) {
+				i++
+			}
+		}
+
+		args = append(args, string(arg))
+	}
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+func isHex(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+func unescape(c byte) byte {
+	switch c {
+	case 'n':
+		return '\n'
+	case 'r':
+		return '\r'
+	case 't':
+		return '\t'
+	case 'b':
+		return '\b'
+	case 'a':
+		return '\a'
+	default:
+		return c
+	}
+}
@@ -15,9 +15,26 @@ import (
 	"github.com/cloudfoundry/gosigar"
 )
 
+// Param is a single line of a redis.conf. Directives have a Key; comment
+// and blank lines have an empty Key and keep their text in Comment so that
+// they survive a Load/Save round trip. Loaded directives remember their line,
+// which is written back as it was while Key and Value are unchanged.
 type Param struct {
-	Key   string
-	Value string
+	Key     string
+	Value   string
+	Comment string
+
+	line string
+}
+
+func (param Param) IsDirective() bool {
+	return param.Key != ""
+}
+
+// Args splits Value into arguments the way redis-server does, honouring
+// double quotes with escapes and single quotes.
+func (param Param) Args() ([]string, error) {
+	return splitArgs(param.Value)
 }
 
 const (
@@ -31,6 +48,9 @@ func New(params ...Param) Conf {
 	return Conf(params)
 }
 
+// Load reads a single redis.conf without validating its values, see
+// Validate. include directives are kept as they are; use LoadWithIncludes to
+// read the effective configuration.
 func Load(path string) (Conf, error) {
 	data, err := ioutil.ReadFile(path)
 	if err != nil {
@@ -40,6 +60,58 @@ func Load(path string) (Conf, error) {
 	return decode(data)
 }
 
+// LoadWithIncludes reads path and replaces every include directive with the
+// contents of the included file, as redis-server would. Relative include
+// paths are resolved against the directory of the including file.
+func LoadWithIncludes(path string) (Conf, error) {
+	return loadWithIncludes(path, map[string]bool{})
+}
+
+func loadWithIncludes(path string, seen map[string]bool) (Conf, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if seen[absPath] {
+		return nil, fmt.Errorf("include cycle at %s", absPath)
+	}
+	seen[absPath] = true
+	defer delete(seen, absPath)
+
+	conf, err := Load(absPath)
+	if err != nil {
+		return nil, err
+	}
+
+	resolved := Conf{}
+	for _, param := range conf {
+		if param.Key != "include" {
+			resolved = append(resolved, param)
+			continue
+		}
+
+		args, err := param.Args()
+		if err != nil {
+			return nil, err
+		}
+
+		includePath := args[0]
+		if !filepath.IsAbs(includePath) {
+			includePath = filepath.Join(filepath.Dir(absPath), includePath)
+		}
+
+		included, err := loadWithIncludes(includePath, seen)
+		if err != nil {
+			return nil, err
+		}
+
+		resolved = append(resolved, included...)
+	}
+
+	return resolved, nil
+}
+
 func (conf Conf) Save(path string) error {
 	data := conf.Encode()
 	return ioutil.WriteFile(path, data, 0644)
@@ -54,18 +126,10 @@ func decode(data []byte) (Conf, error) {
 
 	for scanner.Scan() {
 		line := scanner.Text()
-		if len(line) == 0 {
-			continue
-		}
+		trimmed := strings.TrimSpace(line)
 
-		switch line[:1] {
-		case "#":
-			continue
-		case " ":
-			continue
-		case "\t":
-			continue
-		case "\n":
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			conf = append(conf, Param{Comment: line})
 			continue
 		}
 
@@ -74,6 +138,7 @@ func decode(data []byte) (Conf, error) {
 			return nil, err
 		}
 
+		param.line = line
 		conf = append(conf, param)
 	}
 
@@ -81,11 +146,15 @@ func decode(data []byte) (Conf, error) {
 }
 
 func (conf Conf) Host() string {
-	host := conf.Get("bind")
-	if host == "" {
+	addresses := conf.BindAddresses()
+	if len(addresses) == 0 {
 		return DefaultHost
 	}
-	return host
+	return addresses[0]
+}
+
+func (conf Conf) BindAddresses() []string {
+	return strings.Fields(conf.Get("bind"))
 }
 
 func (conf Conf) Port() int {
@@ -96,16 +165,88 @@ func (conf Conf) Port() int {
 	return port
 }
 
+// Password unquotes requirepass, which CONFIG REWRITE writes in double
+// quotes.
 func (conf Conf) Password() string {
-	return conf.Get("requirepass")
+	password := conf.Get("requirepass")
+	args, err := Param{Value: password}.Args()
+	if err != nil || len(args) != 1 {
+		return password
+	}
+	return args[0]
 }
 
+// Get returns the value of the last occurrence of key, which is the one
+// redis-server uses.
 func (conf Conf) Get(key string) string {
 	params := conf.getAll(key)
 	if len(params) < 1 {
 		return ""
 	}
-	return params[0].Value
+	return params[len(params)-1].Value
+}
+
+// GetAll returns the values of every occurrence of a repeatable directive
+// such as save or rename-command, in order.
+func (conf Conf) GetAll(key string) []string {
+	values := []string{}
+	for _, param := range conf.getAll(key) {
+		values = append(values, param.Value)
+	}
+	return values
+}
+
+// Keys returns the key of each directive once, in lower case and in the
+// order of conf.
+func (conf Conf) Keys() []string {
+	keys := []string{}
+	seen := map[string]bool{}
+	for _, param := range conf {
+		key := strings.ToLower(param.Key)
+		if !param.IsDirective() || seen[key] {
+			continue
+		}
+		seen[key] = true
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// Setting returns the arguments of every occurrence of key, the way CONFIG
+// GET returns a setting such as save that spans several lines.
+func (conf Conf) Setting(key string) ([]string, error) {
+	values := []string{}
+	for _, param := range conf {
+		if !strings.EqualFold(param.Key, key) {
+			continue
+		}
+
+		args, err := param.Args()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, args...)
+	}
+	return values, nil
+}
+
+// NormalizeSetting puts setting values in the form CONFIG GET returns them
+// in: lower case, memory sizes in bytes and replica rather than slave.
+func NormalizeSetting(values []string) string {
+	normalized := []string{}
+	for _, value := range values {
+		value = strings.ToLower(value)
+		if value == "slave" {
+			value = "replica"
+		}
+		if size, err := MemoryBytes(value); err == nil {
+			value = strconv.FormatInt(size, 10)
+		}
+		if value != "" {
+			normalized = append(normalized, value)
+		}
+	}
+	return strings.Join(normalized, " ")
 }
 
 func (conf Conf) HasKey(key string) bool {
@@ -128,65 +269,164 @@ func (conf Conf) getAll(key string) []Param {
 }
 
 func (conf *Conf) CommandAliases() map[string]string {
-	renamedCommands := conf.getAll("rename-command")
 	commandAliases := make(map[string]string)
-	for _, param := range renamedCommands {
-		args := strings.Split(param.Value, " ")
-		original := args[0]
-		alias := strings.Replace(args[1], "\"", "", -1)
-		commandAliases[original] = alias
+	for _, param := range conf.getAll("rename-command") {
+		args, err := param.Args()
+		if err != nil || len(args) != 2 {
+			continue
+		}
+		commandAliases[args[0]] = args[1]
 	}
 	return commandAliases
 }
 
+// Set replaces the first occurrence of key with value and removes any
+// further occurrences, or appends key if it is not present.
 func (conf *Conf) Set(key string, value string) {
-	newParam := Param{
-		Key:   key,
-		Value: value,
+	conf.SetAll(key, value)
+}
+
+// SetAll replaces every occurrence of key with one line per value. The new
+// lines take the place of the first existing occurrence so that the
+// surrounding comments stay with them.
+func (conf *Conf) SetAll(key string, values ...string) {
+	updated := Conf{}
+	inserted := false
+
+	for _, param := range *conf {
+		if param.Key != key {
+			updated = append(updated, param)
+			continue
+		}
+
+		if !inserted {
+			for _, value := range values {
+				updated = append(updated, Param{Key: key, Value: value})
+			}
+			inserted = true
+		}
 	}
 
-	// update
-	for index, param := range *conf {
-		if key == param.Key {
-			(*conf)[index] = newParam
+	if !inserted {
+		for _, value := range values {
+			updated = append(updated, Param{Key: key, Value: value})
+		}
+	}
+
+	*conf = updated
+}
+
+// Add appends another occurrence of a repeatable directive after its last
+// existing occurrence.
+func (conf *Conf) Add(key string, value string) {
+	newParam := Param{Key: key, Value: value}
+
+	for index := len(*conf) - 1; index >= 0; index-- {
+		if (*conf)[index].Key == key {
+			updated := append(Conf{}, (*conf)[:index+1]...)
+			updated = append(updated, newParam)
+			*conf = append(updated, (*conf)[index+1:]...)
 			return
 		}
 	}
 
-	// insert
 	*conf = append(*conf, newParam)
 }
 
+// SetEntry replaces the occurrence of a repeatable directive whose first
+// argument matches that of value, e.g. the rename-command for one command or
+// the client-output-buffer-limit for one client class, or adds it.
+func (conf *Conf) SetEntry(key string, value string) error {
+	args, err := splitArgs(value)
+	if err != nil {
+		return err
+	}
+
+	if len(args) == 0 {
+		return fmt.Errorf("missing arguments for %s", key)
+	}
+
+	for index, param := range *conf {
+		if param.Key != key {
+			continue
+		}
+
+		existingArgs, err := param.Args()
+		if err == nil && len(existingArgs) > 0 && strings.EqualFold(existingArgs[0], args[0]) {
+			(*conf)[index].Value = value
+			return nil
+		}
+	}
+
+	conf.Add(key, value)
+	return nil
+}
+
+func (conf *Conf) Remove(key string) {
+	conf.SetAll(key)
+}
+
 func (conf Conf) Encode() []byte {
 	output := []byte{}
 
 	for _, param := range conf {
-		line := param.Key + " " + param.Value + "\n"
-		output = append(output, []byte(line)...)
+		line := param.Comment
+		if param.IsDirective() {
+			line = param.encode()
+		}
+		output = append(output, []byte(line+"\n")...)
 	}
 
 	return output
 }
 
+func (param Param) encode() string {
+	if param.line != "" {
+		original, err := parseParam(param.line)
+		if err == nil && original.Key == param.Key && original.Value == param.Value {
+			return param.line
+		}
+	}
+
+	return param.Key + " " + param.Value
+}
+
 func parseParam(line string) (Param, error) {
-	words := strings.SplitN(line, " ", 2)
-	if len(words) != 2 {
+	line = strings.TrimLeft(line, " \t")
+	index := strings.IndexAny(line, " \t")
+	if index < 0 {
 		msg := fmt.Sprintf("Unable to split redis.conf parameter into key/value pair: %s", line)
 		return Param{}, errors.New(msg)
 	}
 
 	return Param{
-		Key:   words[0],
-		Value: words[1],
+		Key:   strings.ToLower(line[:index]),
+		Value: strings.TrimSpace(line[index+1:]),
 	}, nil
 }
 
-func CopyWithInstanceAdditions(fromPath, toPath, instanceID, port, password, pidDir string) error {
+// InstanceNetwork holds the listener settings for a shared instance. Empty
+// fields leave the corresponding directive from the default config alone.
+type InstanceNetwork struct {
+	BindAddresses  []string
+	ProtectedMode  string
+	UnixSocket     string
+	UnixSocketPerm string
+}
+
+// CopyWithInstanceAdditions writes the config for a shared instance, dropping
+// any directives that the given redis-server version does not support and
+// turning on lazyfree where it has it.
+func CopyWithInstanceAdditions(fromPath, toPath, instanceID, port, password, pidDir string, network InstanceNetwork, version Version) error {
 	defaultConfig, err := Load(fromPath)
 	if err != nil {
 		return err
 	}
 
+	if err := defaultConfig.Validate(); err != nil {
+		return fmt.Errorf("%s: %s", fromPath, err)
+	}
+
 	defaultConfig.Set("syslog-enabled", "yes")
 	defaultConfig.Set("syslog-ident", fmt.Sprintf("redis-server-%s", instanceID))
 	defaultConfig.Set("syslog-facility", "local0")
@@ -196,6 +436,25 @@ func CopyWithInstanceAdditions(fromPath, toPath, instanceID, port, password, pid
 
 	defaultConfig.Set("pidfile", filepath.Join(pidDir, instanceID+".pid"))
 
+	if len(network.BindAddresses) > 0 {
+		defaultConfig.Set("bind", strings.Join(network.BindAddresses, " "))
+	}
+
+	if network.ProtectedMode != "" {
+		defaultConfig.Set("protected-mode", network.ProtectedMode)
+	}
+
+	if network.UnixSocket != "" {
+		defaultConfig.Set("unixsocket", network.UnixSocket)
+
+		if network.UnixSocketPerm != "" {
+			defaultConfig.Set("unixsocketperm", network.UnixSocketPerm)
+		}
+	}
+
+	defaultConfig.FilterForVersion(version)
+	defaultConfig.EnableFeatures(version, Features{LazyFree: true})
+
 	err = defaultConfig.Save(toPath)
 	if err != nil {
 		return err
@@ -222,6 +481,30 @@ func (c *Conf) InitForDedicatedNode(password ...string) error {
 	return nil
 }
 
+// RenderForDedicatedNode loads the default redis.conf of a dedicated node
+// and readies it for the node's redis-server: with the given password or a
+// new one, without the directives version does not understand and with the
+// features version has. A zero version leaves the directives as they are.
+func RenderForDedicatedNode(defaultConfPath string, version Version, features Features, password ...string) (Conf, error) {
+	conf, err := Load(defaultConfPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := conf.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %s", defaultConfPath, err)
+	}
+
+	if err := conf.InitForDedicatedNode(password...); err != nil {
+		return nil, err
+	}
+
+	conf.FilterForVersion(version)
+	conf.EnableFeatures(version, features)
+
+	return conf, nil
+}
+
 func calculateMaxMemory() (int, error) {
 	mem := sigar.Mem{}
 	if err := mem.Get(); err != nil {
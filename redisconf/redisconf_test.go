@@ -64,24 +64,379 @@ var _ = Describe("redisconf", func() {
 		})
 	})
 
+	Describe("RenderForDedicatedNode", func() {
+		var (
+			dir             string
+			defaultConfPath string
+			features        redisconf.Features
+		)
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "redisconf")
+			Expect(err).ToNot(HaveOccurred())
+
+			defaultConfPath = filepath.Join(dir, "redis.conf")
+			Expect(ioutil.WriteFile(defaultConfPath, []byte("port 6379\noom-score-adj-values 0 200 800\n"), 0644)).To(Succeed())
+
+			features = redisconf.Features{
+				TLS: redisconf.TLS{Port: 16379, CertFile: "/tls/redis.crt", KeyFile: "/tls/redis.key"},
+			}
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		It("sets a new password and the max memory", func() {
+			conf, err := redisconf.RenderForDedicatedNode(defaultConfPath, redisconf.Version{}, features)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(conf.Password()).ToNot(BeEmpty())
+			Expect(conf.Get("maxmemory")).ToNot(BeEmpty())
+		})
+
+		It("sets the given password", func() {
+			conf, err := redisconf.RenderForDedicatedNode(defaultConfPath, redisconf.Version{}, features, "my-password")
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(conf.Password()).To(Equal("my-password"))
+		})
+
+		It("removes the directives the version does not understand", func() {
+			version, _ := redisconf.ParseVersion("5.0.7")
+			conf, err := redisconf.RenderForDedicatedNode(defaultConfPath, version, features)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(conf.HasKey("oom-score-adj-values")).To(BeFalse())
+			Expect(conf.HasKey("tls-port")).To(BeFalse())
+		})
+
+		It("enables the features the version has", func() {
+			version, _ := redisconf.ParseVersion("6.0.9")
+			conf, err := redisconf.RenderForDedicatedNode(defaultConfPath, version, features)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(conf.Get("oom-score-adj-values")).To(Equal("0 200 800"))
+			Expect(conf.Get("tls-port")).To(Equal("16379"))
+			Expect(conf.Get("tls-cert-file")).To(Equal("/tls/redis.crt"))
+		})
+
+		It("leaves the directives alone for an unknown version", func() {
+			conf, err := redisconf.RenderForDedicatedNode(defaultConfPath, redisconf.Version{}, features)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(conf.HasKey("oom-score-adj-values")).To(BeTrue())
+			Expect(conf.HasKey("tls-port")).To(BeFalse())
+		})
+
+		Context("when the default redis.conf is invalid", func() {
+			BeforeEach(func() {
+				Expect(ioutil.WriteFile(defaultConfPath, []byte("port not-a-port\n"), 0644)).To(Succeed())
+			})
+
+			It("returns an error", func() {
+				_, err := redisconf.RenderForDedicatedNode(defaultConfPath, redisconf.Version{}, features)
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(HavePrefix(defaultConfPath + ": "))
+			})
+		})
+	})
+
 	Describe("Encode", func() {
-		It("encodes the parameters correctly", func() {
+		It("preserves comments and ordering", func() {
 			path, err := filepath.Abs(path.Join("assets", "redis.conf"))
 			Expect(err).ToNot(HaveOccurred())
 			input, err := redisconf.Load(path)
 			Expect(err).ToNot(HaveOccurred())
 
-			expectedOutput := "daemonize no\n" +
+			expectedOutput := "# A comment\n" +
+				"daemonize no\n" +
 				"pidfile /var/run/redis.pid\n" +
 				"port 6379\n" +
+				"# Another comment\n" +
 				"appendonly yes\n" +
 				"client-output-buffer-limit normal 0 0 0\n" +
 				"save 900 1\n" +
 				"save 300 10\n" +
-				"bind 0.0.0.0\n"
+				"bind 0.0.0.0\n" +
+				"# A final comment\n"
 
 			Expect(string(input.Encode())).To(Equal(expectedOutput))
 		})
+
+		It("only changes the managed keys of a template", func() {
+			input := "# Operator notes\n" +
+				"\n" +
+				"    maxmemory 100mb\n" +
+				"rename-command CONFIG \"abc def\"\n" +
+				"Appendonly\tyes\n" +
+				"port 6379\n"
+
+			dir := tempDir("", "redisconf-test")
+			defer os.RemoveAll(dir)
+
+			confPath := filepath.Join(dir, "redis.conf")
+			Expect(ioutil.WriteFile(confPath, []byte(input), 0644)).To(Succeed())
+
+			conf := loadRedisConf(confPath)
+			conf.Set("port", "1234")
+
+			Expect(string(conf.Encode())).To(Equal("# Operator notes\n" +
+				"\n" +
+				"    maxmemory 100mb\n" +
+				"rename-command CONFIG \"abc def\"\n" +
+				"Appendonly\tyes\n" +
+				"port 1234\n"))
+		})
+	})
+
+	Describe("Args", func() {
+		It("splits plain arguments", func() {
+			Expect(redisconf.Param{Key: "save", Value: "900  1"}.Args()).To(Equal([]string{"900", "1"}))
+		})
+
+		It("handles double quotes with escapes", func() {
+			args, err := redisconf.Param{Key: "requirepass", Value: `"a \"quoted\" pass\x41"`}.Args()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(args).To(Equal([]string{`a "quoted" passA`}))
+		})
+
+		It("handles single quotes", func() {
+			args, err := redisconf.Param{Key: "rename-command", Value: `FLUSHALL 'it\'s gone'`}.Args()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(args).To(Equal([]string{"FLUSHALL", "it's gone"}))
+		})
+
+		It("handles empty quoted arguments", func() {
+			Expect(redisconf.Param{Key: "save", Value: `""`}.Args()).To(Equal([]string{""}))
+		})
+
+		It("treats a missing value as empty", func() {
+			Expect(redisconf.Param{Key: "requirepass", Value: ""}.Args()).To(BeEmpty())
+		})
+
+		It("rejects unbalanced quotes", func() {
+			_, err := redisconf.Param{Key: "requirepass", Value: `"secret`}.Args()
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("repeatable directives", func() {
+		var conf redisconf.Conf
+
+		BeforeEach(func() {
+			conf = redisconf.New(
+				redisconf.Param{Key: "save", Value: "900 1"},
+				redisconf.Param{Comment: "# keep me"},
+				redisconf.Param{Key: "save", Value: "300 10"},
+				redisconf.Param{Key: "rename-command", Value: "CONFIG abc"},
+				redisconf.Param{Key: "appendonly", Value: "yes"},
+			)
+		})
+
+		It("returns every value", func() {
+			Expect(conf.GetAll("save")).To(Equal([]string{"900 1", "300 10"}))
+		})
+
+		It("returns the last value from Get, as redis-server does", func() {
+			Expect(conf.Get("save")).To(Equal("300 10"))
+		})
+
+		It("replaces every occurrence with Set", func() {
+			conf.Set("save", "60 1000")
+
+			Expect(conf.GetAll("save")).To(Equal([]string{"60 1000"}))
+			Expect(conf[0]).To(Equal(redisconf.Param{Key: "save", Value: "60 1000"}))
+			Expect(conf[1]).To(Equal(redisconf.Param{Comment: "# keep me"}))
+		})
+
+		It("replaces every occurrence with several values with SetAll", func() {
+			conf.SetAll("save", "60 1000", "3600 1")
+			Expect(conf.GetAll("save")).To(Equal([]string{"60 1000", "3600 1"}))
+		})
+
+		It("adds an occurrence after the last one", func() {
+			conf.Add("save", "60 10000")
+
+			Expect(conf.GetAll("save")).To(Equal([]string{"900 1", "300 10", "60 10000"}))
+			Expect(conf[3]).To(Equal(redisconf.Param{Key: "save", Value: "60 10000"}))
+		})
+
+		It("replaces the entry with the same first argument", func() {
+			Expect(conf.SetEntry("rename-command", "config def")).To(Succeed())
+			Expect(conf.SetEntry("rename-command", "FLUSHALL \"\"")).To(Succeed())
+
+			Expect(conf.GetAll("rename-command")).To(Equal([]string{"config def", "FLUSHALL \"\""}))
+			Expect(conf.CommandAliases()).To(Equal(map[string]string{"config": "def", "FLUSHALL": ""}))
+		})
+
+		It("removes every occurrence", func() {
+			conf.Remove("save")
+
+			Expect(conf.HasKey("save")).To(BeFalse())
+			Expect(conf).To(HaveLen(3))
+		})
+	})
+
+	Describe("Validate", func() {
+		valid := func(key, value string) error {
+			return redisconf.New(redisconf.Param{Key: key, Value: value}).Validate()
+		}
+
+		It("accepts valid values of known directives", func() {
+			Expect(valid("maxmemory", "100mb")).To(Succeed())
+			Expect(valid("maxmemory", "1GB")).To(Succeed())
+			Expect(valid("appendonly", "yes")).To(Succeed())
+			Expect(valid("maxmemory-policy", "allkeys-lru")).To(Succeed())
+			Expect(valid("save", `""`)).To(Succeed())
+			Expect(valid("save", "900 1 300 10")).To(Succeed())
+			Expect(valid("client-output-buffer-limit", "pubsub 32mb 8mb 60")).To(Succeed())
+			Expect(valid("port", "6379")).To(Succeed())
+		})
+
+		It("leaves unknown directives alone", func() {
+			Expect(valid("some-future-directive", "anything")).To(Succeed())
+		})
+
+		It("rejects invalid memory sizes", func() {
+			Expect(valid("maxmemory", "100 megabytes")).To(MatchError(ContainSubstring("invalid maxmemory")))
+		})
+
+		It("rejects invalid booleans", func() {
+			Expect(valid("appendonly", "true")).To(MatchError("invalid appendonly 'true': must be one of yes, no"))
+		})
+
+		It("rejects invalid enums", func() {
+			Expect(valid("maxmemory-policy", "lru")).To(HaveOccurred())
+		})
+
+		It("rejects invalid ports", func() {
+			Expect(valid("port", "70000")).To(HaveOccurred())
+		})
+
+		It("rejects invalid save schedules", func() {
+			Expect(valid("save", "900")).To(HaveOccurred())
+		})
+
+		It("rejects unbalanced quotes", func() {
+			Expect(valid("requirepass", `"secret`)).To(HaveOccurred())
+		})
+
+		It("round trips empty values", func() {
+			dir := tempDir("", "redisconf-test")
+			defer os.RemoveAll(dir)
+
+			confPath := filepath.Join(dir, "redis.conf")
+			Expect(redisconf.New(redisconf.Param{Key: "requirepass", Value: ""}).Save(confPath)).To(Succeed())
+
+			conf, err := redisconf.Load(confPath)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(conf.HasKey("requirepass")).To(BeTrue())
+			Expect(conf.Get("requirepass")).To(BeEmpty())
+		})
+
+		It("is not applied by Load", func() {
+			dir := tempDir("", "redisconf-test")
+			defer os.RemoveAll(dir)
+
+			confPath := filepath.Join(dir, "redis.conf")
+			Expect(ioutil.WriteFile(confPath, []byte("maxmemory-policy lru\n"), 0644)).To(Succeed())
+
+			conf, err := redisconf.Load(confPath)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(conf.Validate()).To(MatchError(ContainSubstring("invalid maxmemory-policy")))
+		})
+	})
+
+	Describe("Setting", func() {
+		It("joins the arguments of every line of a key", func() {
+			conf := redisconf.New(
+				redisconf.Param{Key: "save", Value: "900 1"},
+				redisconf.Param{Key: "port", Value: "6379"},
+				redisconf.Param{Key: "SAVE", Value: `"300" 10`},
+			)
+
+			Expect(conf.Keys()).To(Equal([]string{"save", "port"}))
+			Expect(conf.Setting("save")).To(Equal([]string{"900", "1", "300", "10"}))
+		})
+
+		It("normalizes values the way CONFIG GET returns them", func() {
+			Expect(redisconf.NormalizeSetting([]string{"Slave", "256mb", "64MB", "60"})).To(Equal("replica 268435456 67108864 60"))
+			Expect(redisconf.NormalizeSetting([]string{""})).To(BeEmpty())
+		})
+	})
+
+	Describe("MemoryBytes", func() {
+		It("converts the units redis understands", func() {
+			for value, bytes := range map[string]int64{
+				"100":  100,
+				"1k":   1000,
+				"1KB":  1024,
+				"2m":   2000000,
+				"2mb":  2097152,
+				"1g":   1000000000,
+				"1gb":  1073741824,
+				"512b": 512,
+				"-1":   -1,
+			} {
+				Expect(redisconf.MemoryBytes(value)).To(Equal(bytes), value)
+			}
+		})
+
+		It("rejects anything else", func() {
+			_, err := redisconf.MemoryBytes("lots")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("LoadWithIncludes", func() {
+		var dir string
+
+		BeforeEach(func() {
+			dir = tempDir("", "redisconf-test")
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		write := func(name, contents string) string {
+			confPath := filepath.Join(dir, name)
+			Expect(ioutil.WriteFile(confPath, []byte(contents), 0644)).To(Succeed())
+			return confPath
+		}
+
+		It("replaces include directives with the included directives", func() {
+			write("common.conf", "maxmemory 100mb\nappendonly yes\n")
+			confPath := write("redis.conf", "appendonly no\ninclude common.conf\nport 1234\n")
+
+			plain, err := redisconf.Load(confPath)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(plain.Get("include")).To(Equal("common.conf"))
+
+			conf, err := redisconf.LoadWithIncludes(confPath)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(conf.HasKey("include")).To(BeFalse())
+			Expect(conf.Get("maxmemory")).To(Equal("100mb"))
+			Expect(conf.Get("appendonly")).To(Equal("yes"))
+			Expect(conf.Get("port")).To(Equal("1234"))
+		})
+
+		It("detects include cycles", func() {
+			write("a.conf", "include b.conf\n")
+			confPath := write("b.conf", "include a.conf\n")
+
+			_, err := redisconf.LoadWithIncludes(confPath)
+			Expect(err).To(MatchError(ContainSubstring("include cycle")))
+		})
+
+		It("returns an error for a missing include", func() {
+			confPath := write("redis.conf", "include /does/not/exist.conf\n")
+
+			_, err := redisconf.LoadWithIncludes(confPath)
+			Expect(err).To(HaveOccurred())
+		})
 	})
 
 	Describe("CommandAlias", func() {
@@ -134,7 +489,8 @@ var _ = Describe("redisconf", func() {
 
 				loadedConf, err := redisconf.Load(path)
 				Expect(err).ToNot(HaveOccurred())
-				Expect(loadedConf).To(Equal(conf))
+				Expect(loadedConf).To(HaveLen(len(conf)))
+				Expect(string(loadedConf.Encode())).To(Equal(string(conf.Encode())))
 
 				os.RemoveAll(dir)
 			})
@@ -198,22 +554,48 @@ var _ = Describe("redisconf", func() {
 		})
 	})
 
+	Describe("Password", func() {
+		It("unquotes the password", func() {
+			conf := redisconf.New(redisconf.Param{Key: "requirepass", Value: `"secret"`})
+			Expect(conf.Password()).To(Equal("secret"))
+		})
+	})
+
+	Describe("Host", func() {
+		It("defaults to localhost", func() {
+			Expect(redisconf.New().Host()).To(Equal(redisconf.DefaultHost))
+		})
+
+		It("returns the first bind address", func() {
+			conf := redisconf.New(redisconf.Param{Key: "bind", Value: "10.0.0.5 127.0.0.1"})
+			Expect(conf.Host()).To(Equal("10.0.0.5"))
+			Expect(conf.BindAddresses()).To(Equal([]string{"10.0.0.5", "127.0.0.1"}))
+		})
+	})
+
 	Describe("CopyWithInstanceAdditions", func() {
 		var (
 			copyErr       error
 			resultingConf redisconf.Conf
 			dir           string
+			network       redisconf.InstanceNetwork
+			version       redisconf.Version
 			instanceID    = "an-instance-id"
 			port          = "1234"
 			password      = "an-password"
 		)
 
 		BeforeEach(func() {
+			network = redisconf.InstanceNetwork{}
+			version = redisconf.Version{}
+		})
+
+		JustBeforeEach(func() {
 			fromPath := absPath(path.Join("assets", "redis.conf"))
 			dir = tempDir("", "redisconf-test")
 			toPath := filepath.Join(dir, "redis.conf")
 
-			copyErr = redisconf.CopyWithInstanceAdditions(fromPath, toPath, instanceID, port, password, dir)
+			copyErr = redisconf.CopyWithInstanceAdditions(fromPath, toPath, instanceID, port, password, dir, network, version)
 			resultingConf = loadRedisConf(toPath)
 		})
 
@@ -229,5 +611,66 @@ var _ = Describe("redisconf", func() {
 			Expect(resultingConf.Get("requirepass")).To(Equal(password))
 			Expect(resultingConf.Get("pidfile")).To(Equal(filepath.Join(dir, instanceID+".pid")))
 		})
+
+		It("rejects an invalid default config", func() {
+			fromPath := filepath.Join(dir, "redis.conf-default")
+			Expect(ioutil.WriteFile(fromPath, []byte("maxmemory-policy lru\n"), 0644)).To(Succeed())
+
+			err := redisconf.CopyWithInstanceAdditions(fromPath, filepath.Join(dir, "other.conf"), instanceID, port, password, dir, network, version)
+			Expect(err).To(MatchError(ContainSubstring("invalid maxmemory-policy")))
+		})
+
+		It("leaves the network settings of the default config alone", func() {
+			Expect(resultingConf.Get("bind")).To(Equal("0.0.0.0"))
+			Expect(resultingConf.HasKey("protected-mode")).To(BeFalse())
+			Expect(resultingConf.HasKey("unixsocket")).To(BeFalse())
+		})
+
+		Context("when network settings are given", func() {
+			BeforeEach(func() {
+				network = redisconf.InstanceNetwork{
+					BindAddresses:  []string{"10.0.0.5", "127.0.0.1"},
+					ProtectedMode:  "yes",
+					UnixSocket:     "/var/vcap/sys/run/redis/an-instance-id.sock",
+					UnixSocketPerm: "770",
+				}
+			})
+
+			It("writes them", func() {
+				Expect(resultingConf.Get("bind")).To(Equal("10.0.0.5 127.0.0.1"))
+				Expect(resultingConf.Get("protected-mode")).To(Equal("yes"))
+				Expect(resultingConf.Get("unixsocket")).To(Equal("/var/vcap/sys/run/redis/an-instance-id.sock"))
+				Expect(resultingConf.Get("unixsocketperm")).To(Equal("770"))
+			})
+		})
+
+		Context("when the redis version is too old for some settings", func() {
+			BeforeEach(func() {
+				network = redisconf.InstanceNetwork{ProtectedMode: "yes"}
+				version = redisconf.Version{Major: 3, Minor: 0, Patch: 7}
+			})
+
+			It("leaves them out", func() {
+				Expect(copyErr).NotTo(HaveOccurred())
+				Expect(resultingConf.HasKey("protected-mode")).To(BeFalse())
+				Expect(resultingConf.Get("port")).To(Equal(port))
+			})
+
+			It("does not turn on lazyfree", func() {
+				Expect(resultingConf.HasKey("lazyfree-lazy-eviction")).To(BeFalse())
+			})
+		})
+
+		Context("when the redis version has lazyfree", func() {
+			BeforeEach(func() {
+				version = redisconf.Version{Major: 4, Minor: 0, Patch: 14}
+			})
+
+			It("turns it on", func() {
+				Expect(copyErr).NotTo(HaveOccurred())
+				Expect(resultingConf.Get("lazyfree-lazy-eviction")).To(Equal("yes"))
+				Expect(resultingConf.HasKey("lazyfree-lazy-user-del")).To(BeFalse())
+			})
+		})
 	})
 })
@@ -0,0 +1,249 @@
+package redisconf
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type argsValidator func(args []string) error
+
+var memoryPattern = regexp.MustCompile(`(?i)^-?[0-9]+(b|k|kb|m|mb|g|gb)?$`)
+
+var booleanDirectives = []string{
+	"activerehashing",
+	"aof-load-truncated",
+	"aof-rewrite-incremental-fsync",
+	"aof-use-rdb-preamble",
+	"appendonly",
+	"cluster-enabled",
+	"daemonize",
+	"dynamic-hz",
+	"lazyfree-lazy-eviction",
+	"lazyfree-lazy-expire",
+	"lazyfree-lazy-server-del",
+	"no-appendfsync-on-rewrite",
+	"protected-mode",
+	"rdbchecksum",
+	"rdbcompression",
+	"repl-disable-tcp-nodelay",
+	"repl-diskless-sync",
+	"replica-read-only",
+	"slave-read-only",
+	"stop-writes-on-bgsave-error",
+	"syslog-enabled",
+}
+
+var memoryDirectives = []string{
+	"auto-aof-rewrite-min-size",
+	"client-query-buffer-limit",
+	"maxmemory",
+	"proto-max-bulk-len",
+	"repl-backlog-size",
+}
+
+var integerDirectives = []string{
+	"databases",
+	"hz",
+	"maxclients",
+	"maxmemory-samples",
+	"repl-timeout",
+	"slowlog-log-slower-than",
+	"slowlog-max-len",
+	"tcp-backlog",
+	"tcp-keepalive",
+	"timeout",
+}
+
+var enumDirectives = map[string][]string{
+	"appendfsync":      {"always", "everysec", "no"},
+	"loglevel":         {"debug", "verbose", "notice", "warning"},
+	"maxmemory-policy": {"volatile-lru", "allkeys-lru", "volatile-lfu", "allkeys-lfu", "volatile-random", "allkeys-random", "volatile-ttl", "noeviction"},
+	"supervised":       {"upstart", "systemd", "auto", "no"},
+	"syslog-facility":  {"user", "local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7"},
+}
+
+var validators = buildValidators()
+
+func buildValidators() map[string]argsValidator {
+	validators := map[string]argsValidator{
+		"port":                       validatePort,
+		"save":                       validateSave,
+		"rename-command":             exactArgs(2),
+		"include":                    exactArgs(1),
+		"client-output-buffer-limit": validateClientOutputBufferLimit,
+	}
+
+	for _, key := range booleanDirectives {
+		validators[key] = validateEnum("yes", "no")
+	}
+
+	for _, key := range memoryDirectives {
+		validators[key] = validateMemory
+	}
+
+	for _, key := range integerDirectives {
+		validators[key] = validateInteger
+	}
+
+	for key, values := range enumDirectives {
+		validators[key] = validateEnum(values...)
+	}
+
+	return validators
+}
+
+// Validate checks the arguments of every directive for balanced quotes and
+// the values of known directives for their type. Unknown directives are
+// left to redis-server.
+func (conf Conf) Validate() error {
+	for _, param := range conf {
+		if !param.IsDirective() {
+			continue
+		}
+
+		args, err := param.Args()
+		if err != nil {
+			return fmt.Errorf("invalid %s '%s': %s", param.Key, param.Value, err)
+		}
+
+		validator, found := validators[param.Key]
+		if !found {
+			continue
+		}
+
+		if err := validator(args); err != nil {
+			return fmt.Errorf("invalid %s '%s': %s", param.Key, param.Value, err)
+		}
+	}
+
+	return nil
+}
+
+func exactArgs(count int) argsValidator {
+	return func(args []string) error {
+		if len(args) != count {
+			return fmt.Errorf("expected %d arguments, got %d", count, len(args))
+		}
+		return nil
+	}
+}
+
+func validateEnum(values ...string) argsValidator {
+	return func(args []string) error {
+		if err := exactArgs(1)(args); err != nil {
+			return err
+		}
+
+		for _, value := range values {
+			if strings.EqualFold(args[0], value) {
+				return nil
+			}
+		}
+
+		return fmt.Errorf("must be one of %s", strings.Join(values, ", "))
+	}
+}
+
+func validateMemory(args []string) error {
+	if err := exactArgs(1)(args); err != nil {
+		return err
+	}
+
+	if !memoryPattern.MatchString(args[0]) {
+		return fmt.Errorf("not a memory size")
+	}
+
+	return nil
+}
+
+var memoryUnits = map[string]int64{
+	"":   1,
+	"b":  1,
+	"k":  1000,
+	"kb": 1024,
+	"m":  1000 * 1000,
+	"mb": 1024 * 1024,
+	"g":  1000 * 1000 * 1000,
+	"gb": 1024 * 1024 * 1024,
+}
+
+// MemoryBytes converts a memory size such as 100mb into bytes, with the
+// units redis understands.
+func MemoryBytes(value string) (int64, error) {
+	if !memoryPattern.MatchString(value) {
+		return 0, fmt.Errorf("not a memory size: %s", value)
+	}
+
+	number := strings.TrimRight(strings.ToLower(value), "bkmg")
+	unit := strings.ToLower(value[len(number):])
+
+	bytes, err := strconv.ParseInt(number, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	return bytes * memoryUnits[unit], nil
+}
+
+func validateInteger(args []string) error {
+	if err := exactArgs(1)(args); err != nil {
+		return err
+	}
+
+	if _, err := strconv.ParseInt(args[0], 10, 64); err != nil {
+		return fmt.Errorf("not an integer")
+	}
+
+	return nil
+}
+
+func validatePort(args []string) error {
+	if err := validateInteger(args); err != nil {
+		return err
+	}
+
+	port, _ := strconv.Atoi(args[0])
+	if port < 0 || port > 65535 {
+		return fmt.Errorf("out of range")
+	}
+
+	return nil
+}
+
+func validateSave(args []string) error {
+	if len(args) == 1 && args[0] == "" {
+		return nil
+	}
+
+	if len(args) == 0 || len(args)%2 != 0 {
+		return fmt.Errorf("expected pairs of seconds and changes")
+	}
+
+	for _, arg := range args {
+		if _, err := strconv.ParseUint(arg, 10, 64); err != nil {
+			return fmt.Errorf("expected pairs of seconds and changes")
+		}
+	}
+
+	return nil
+}
+
+func validateClientOutputBufferLimit(args []string) error {
+	if len(args) != 4 {
+		return fmt.Errorf("expected a class and three limits")
+	}
+
+	if err := validateEnum("normal", "slave", "replica", "pubsub", "master")(args[:1]); err != nil {
+		return err
+	}
+
+	for _, limit := range args[1:] {
+		if err := validateMemory([]string{limit}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,214 @@
+package redisconf
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Version is a redis-server version. The zero Version means the version is
+// unknown, in which case no directives are filtered.
+type Version struct {
+	Major int
+	Minor int
+	Patch int
+}
+
+func ParseVersion(version string) (Version, error) {
+	parts := strings.Split(strings.TrimSpace(version), ".")
+	if len(parts) < 2 || len(parts) > 3 {
+		return Version{}, fmt.Errorf("invalid redis version '%s'", version)
+	}
+
+	numbers := make([]int, 3)
+	for i, part := range parts {
+		number, err := strconv.Atoi(part)
+		if err != nil || number < 0 {
+			return Version{}, fmt.Errorf("invalid redis version '%s'", version)
+		}
+		numbers[i] = number
+	}
+
+	return Version{Major: numbers[0], Minor: numbers[1], Patch: numbers[2]}, nil
+}
+
+func (version Version) IsZero() bool {
+	return version == Version{}
+}
+
+func (version Version) String() string {
+	if version.IsZero() {
+		return ""
+	}
+
+	return fmt.Sprintf("%d.%d.%d", version.Major, version.Minor, version.Patch)
+}
+
+func (version Version) AtLeast(other Version) bool {
+	if version.Major != other.Major {
+		return version.Major > other.Major
+	}
+
+	if version.Minor != other.Minor {
+		return version.Minor > other.Minor
+	}
+
+	return version.Patch >= other.Patch
+}
+
+var (
+	redis32 = Version{Major: 3, Minor: 2}
+	redis40 = Version{Major: 4}
+	redis50 = Version{Major: 5}
+	redis60 = Version{Major: 6}
+	redis62 = Version{Major: 6, Minor: 2}
+	redis70 = Version{Major: 7}
+)
+
+// directiveVersions lists the first redis-server version that accepts a
+// directive. Older servers refuse to start when they see one of these.
+var directiveVersions = map[string]Version{
+	"protected-mode":                redis32,
+	"supervised":                    redis32,
+	"list-max-ziplist-size":         redis32,
+	"list-compress-depth":           redis32,
+	"slave-announce-ip":             redis40,
+	"slave-announce-port":           redis40,
+	"activedefrag":                  redis40,
+	"active-defrag-ignore-bytes":    redis40,
+	"active-defrag-threshold-lower": redis40,
+	"active-defrag-threshold-upper": redis40,
+	"active-defrag-cycle-min":       redis40,
+	"active-defrag-cycle-max":       redis40,
+	"lfu-log-factor":                redis40,
+	"lfu-decay-time":                redis40,
+	"replica-read-only":             redis50,
+	"replica-serve-stale-data":      redis50,
+	"replica-priority":              redis50,
+	"replicaof":                     redis50,
+	"stream-node-max-bytes":         redis50,
+	"stream-node-max-entries":       redis50,
+	"dynamic-hz":                    redis50,
+	"aclfile":                       redis60,
+	"acllog-max-len":                redis60,
+	"user":                          redis60,
+	"io-threads":                    redis60,
+	"io-threads-do-reads":           redis60,
+	"tracking-table-max-keys":       redis60,
+	"lazyfree-lazy-user-del":        redis60,
+	"oom-score-adj":                 redis60,
+	"oom-score-adj-values":          redis60,
+	"acl-pubsub-default":            redis62,
+	"enable-protected-configs":      redis70,
+	"enable-debug-command":          redis70,
+	"enable-module-command":         redis70,
+}
+
+// directivePrefixVersions covers families of directives, e.g. every tls-*
+// setting.
+var directivePrefixVersions = map[string]Version{
+	"lazyfree-": redis40,
+	"tls-":      redis60,
+}
+
+// Supports reports whether a redis-server of this version accepts the
+// directive.
+func (version Version) Supports(directive string) bool {
+	if version.IsZero() {
+		return true
+	}
+
+	directive = strings.ToLower(directive)
+
+	if minimum, found := directiveVersions[directive]; found {
+		return version.AtLeast(minimum)
+	}
+
+	for prefix, minimum := range directivePrefixVersions {
+		if strings.HasPrefix(directive, prefix) {
+			return version.AtLeast(minimum)
+		}
+	}
+
+	return true
+}
+
+// FilterForVersion removes the directives that version does not understand
+// and returns their keys, so that a template written for a newer Redis can
+// be used with an older one.
+func (conf *Conf) FilterForVersion(version Version) []string {
+	removed := []string{}
+	filtered := Conf{}
+
+	for _, param := range *conf {
+		if param.IsDirective() && !version.Supports(param.Key) {
+			removed = append(removed, param.Key)
+			continue
+		}
+		filtered = append(filtered, param)
+	}
+
+	*conf = filtered
+	return removed
+}
+
+// Features are newer redis-server features that templating turns on when
+// the server's version has them.
+type Features struct {
+	// LazyFree frees evicted, expired and deleted keys in a background
+	// thread.
+	LazyFree bool
+
+	// TLS serves TLS on TLS.Port alongside the plain port when the port is
+	// set. Clients authenticate with the password, not with certificates.
+	TLS TLS
+}
+
+type TLS struct {
+	Port       int
+	CertFile   string
+	KeyFile    string
+	CACertFile string
+}
+
+var lazyFreeDirectives = []string{
+	"lazyfree-lazy-eviction",
+	"lazyfree-lazy-expire",
+	"lazyfree-lazy-server-del",
+	"lazyfree-lazy-user-del",
+}
+
+// EnableFeatures sets the directives of the features that version has and
+// returns their keys. Directives the template sets already are kept, and an
+// unknown version gets no feature, since an older server would refuse to
+// start.
+func (conf *Conf) EnableFeatures(version Version, features Features) []string {
+	enabled := []string{}
+	if version.IsZero() {
+		return enabled
+	}
+
+	enable := func(key, value string) {
+		if value == "" || conf.HasKey(key) || !version.Supports(key) {
+			return
+		}
+		conf.Set(key, value)
+		enabled = append(enabled, key)
+	}
+
+	if features.LazyFree {
+		for _, key := range lazyFreeDirectives {
+			enable(key, "yes")
+		}
+	}
+
+	if features.TLS.Port != 0 {
+		enable("tls-port", strconv.Itoa(features.TLS.Port))
+		enable("tls-cert-file", features.TLS.CertFile)
+		enable("tls-key-file", features.TLS.KeyFile)
+		enable("tls-ca-cert-file", features.TLS.CACertFile)
+		enable("tls-auth-clients", "no")
+	}
+
+	return enabled
+}
@@ -0,0 +1,131 @@
+package redisconf_test
+
+import (
+	"github.com/pivotal-cf/cf-redis-broker/redisconf"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Version", func() {
+	Describe("ParseVersion", func() {
+		It("parses major.minor.patch", func() {
+			version, err := redisconf.ParseVersion("3.2.8")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(version).To(Equal(redisconf.Version{Major: 3, Minor: 2, Patch: 8}))
+			Expect(version.String()).To(Equal("3.2.8"))
+		})
+
+		It("defaults the patch level to 0", func() {
+			version, err := redisconf.ParseVersion("6.0")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(version).To(Equal(redisconf.Version{Major: 6}))
+		})
+
+		It("rejects malformed versions", func() {
+			for _, version := range []string{"", "6", "6.x", "1.2.3.4", "-1.0"} {
+				_, err := redisconf.ParseVersion(version)
+				Expect(err).To(MatchError("invalid redis version '" + version + "'"))
+			}
+		})
+	})
+
+	Describe("AtLeast", func() {
+		It("compares major, minor and patch in turn", func() {
+			version := redisconf.Version{Major: 4, Minor: 0, Patch: 14}
+			Expect(version.AtLeast(redisconf.Version{Major: 4})).To(BeTrue())
+			Expect(version.AtLeast(redisconf.Version{Major: 3, Minor: 9, Patch: 99})).To(BeTrue())
+			Expect(version.AtLeast(redisconf.Version{Major: 4, Patch: 15})).To(BeFalse())
+			Expect(version.AtLeast(redisconf.Version{Major: 5})).To(BeFalse())
+		})
+	})
+
+	Describe("FilterForVersion", func() {
+		var conf redisconf.Conf
+
+		BeforeEach(func() {
+			conf = redisconf.New(
+				redisconf.Param{Comment: "# newer features"},
+				redisconf.Param{Key: "port", Value: "6379"},
+				redisconf.Param{Key: "protected-mode", Value: "yes"},
+				redisconf.Param{Key: "lazyfree-lazy-eviction", Value: "yes"},
+				redisconf.Param{Key: "tls-port", Value: "6380"},
+				redisconf.Param{Key: "aclfile", Value: "/etc/redis/users.acl"},
+			)
+		})
+
+		It("removes the directives the version does not support", func() {
+			removed := conf.FilterForVersion(redisconf.Version{Major: 4, Minor: 0, Patch: 9})
+
+			Expect(removed).To(Equal([]string{"tls-port", "aclfile"}))
+			Expect(conf.HasKey("port")).To(BeTrue())
+			Expect(conf.HasKey("protected-mode")).To(BeTrue())
+			Expect(conf.HasKey("lazyfree-lazy-eviction")).To(BeTrue())
+			Expect(conf[0].Comment).To(Equal("# newer features"))
+		})
+
+		It("keeps everything for a new enough version", func() {
+			Expect(conf.FilterForVersion(redisconf.Version{Major: 6, Minor: 2})).To(BeEmpty())
+			Expect(conf).To(HaveLen(6))
+		})
+
+		It("keeps everything when the version is unknown", func() {
+			Expect(conf.FilterForVersion(redisconf.Version{})).To(BeEmpty())
+			Expect(conf).To(HaveLen(6))
+		})
+	})
+
+	Describe("EnableFeatures", func() {
+		var (
+			conf     redisconf.Conf
+			features redisconf.Features
+		)
+
+		BeforeEach(func() {
+			conf = redisconf.New(
+				redisconf.Param{Key: "port", Value: "6379"},
+				redisconf.Param{Key: "lazyfree-lazy-expire", Value: "no"},
+			)
+			features = redisconf.Features{
+				LazyFree: true,
+				TLS: redisconf.TLS{
+					Port:     6380,
+					CertFile: "/certs/redis.crt",
+					KeyFile:  "/certs/redis.key",
+				},
+			}
+		})
+
+		It("turns on the features the version has", func() {
+			enabled := conf.EnableFeatures(redisconf.Version{Major: 6, Minor: 2}, features)
+
+			Expect(enabled).To(Equal([]string{
+				"lazyfree-lazy-eviction",
+				"lazyfree-lazy-server-del",
+				"lazyfree-lazy-user-del",
+				"tls-port",
+				"tls-cert-file",
+				"tls-key-file",
+				"tls-auth-clients",
+			}))
+			Expect(conf.Get("tls-port")).To(Equal("6380"))
+			Expect(conf.Get("tls-auth-clients")).To(Equal("no"))
+		})
+
+		It("keeps what the template sets", func() {
+			conf.EnableFeatures(redisconf.Version{Major: 6}, features)
+			Expect(conf.Get("lazyfree-lazy-expire")).To(Equal("no"))
+		})
+
+		It("leaves out the features an older version lacks", func() {
+			enabled := conf.EnableFeatures(redisconf.Version{Major: 5}, features)
+			Expect(enabled).To(Equal([]string{"lazyfree-lazy-eviction", "lazyfree-lazy-server-del"}))
+			Expect(conf.HasKey("tls-port")).To(BeFalse())
+		})
+
+		It("turns on nothing when the version is unknown", func() {
+			Expect(conf.EnableFeatures(redisconf.Version{}, features)).To(BeEmpty())
+			Expect(conf).To(HaveLen(2))
+		})
+	})
+})
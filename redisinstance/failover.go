@@ -0,0 +1,41 @@
+package redisinstance
+
+import (
+	"net/http"
+
+	"github.com/pivotal-cf/brokerapi"
+)
+
+type Failoverer interface {
+	Failover(instanceID string) error
+}
+
+// NewFailoverHandler lets operators trigger a Sentinel failover of a high
+// availability instance, e.g. before maintenance on its master's VM.
+func NewFailoverHandler(failoverer Failoverer) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		if req.Method != "POST" {
+			http.Error(res, "", http.StatusMethodNotAllowed)
+			return
+		}
+
+		instanceID := req.URL.Query().Get("instance_id")
+		if instanceID == "" {
+			http.Error(res, "", http.StatusBadRequest)
+			return
+		}
+
+		err := failoverer.Failover(instanceID)
+		if err == brokerapi.ErrInstanceDoesNotExist {
+			http.Error(res, "", http.StatusNotFound)
+			return
+		}
+
+		if err != nil {
+			http.Error(res, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		res.WriteHeader(http.StatusOK)
+	}
+}
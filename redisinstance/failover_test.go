@@ -0,0 +1,85 @@
+package redisinstance_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/pivotal-cf/brokerapi"
+	"github.com/pivotal-cf/cf-redis-broker/redisinstance"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type fakeFailoverer struct {
+	failedOver []string
+	err        error
+}
+
+func (failoverer *fakeFailoverer) Failover(instanceID string) error {
+	if instanceID == "unknown" {
+		return brokerapi.ErrInstanceDoesNotExist
+	}
+	failoverer.failedOver = append(failoverer.failedOver, instanceID)
+	return failoverer.err
+}
+
+var _ = Describe("Failover", func() {
+	var (
+		recorder   *httptest.ResponseRecorder
+		failoverer *fakeFailoverer
+		handler    http.HandlerFunc
+	)
+
+	BeforeEach(func() {
+		recorder = httptest.NewRecorder()
+		failoverer = new(fakeFailoverer)
+		handler = redisinstance.NewFailoverHandler(failoverer)
+	})
+
+	serve := func(method, url string) {
+		request, err := http.NewRequest(method, url, nil)
+		Expect(err).NotTo(HaveOccurred())
+		handler.ServeHTTP(recorder, request)
+	}
+
+	It("fails over the instance", func() {
+		serve("POST", "http://localhost/failover?instance_id=ha-instance")
+
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		Expect(failoverer.failedOver).To(Equal([]string{"ha-instance"}))
+	})
+
+	It("returns a 404 for unknown instances", func() {
+		serve("POST", "http://localhost/failover?instance_id=unknown")
+		Expect(recorder.Code).To(Equal(http.StatusNotFound))
+	})
+
+	It("returns a 400 without an instance_id", func() {
+		serve("POST", "http://localhost/failover")
+		Expect(recorder.Code).To(Equal(http.StatusBadRequest))
+	})
+
+	It("only accepts POST", func() {
+		serve("GET", "http://localhost/failover?instance_id=ha-instance")
+		Expect(recorder.Code).To(Equal(http.StatusMethodNotAllowed))
+		Expect(failoverer.failedOver).To(BeEmpty())
+	})
+
+	Context("when the failover fails", func() {
+		BeforeEach(func() {
+			failoverer.err = errors.New("NOGOODSLAVE No suitable replica to promote")
+		})
+
+		It("returns a 500 with the error", func() {
+			serve("POST", "http://localhost/failover?instance_id=ha-instance")
+
+			Expect(recorder.Code).To(Equal(http.StatusInternalServerError))
+			body, err := ioutil.ReadAll(recorder.Body)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(body)).To(Equal("NOGOODSLAVE No suitable replica to promote\n"))
+		})
+	})
+})
@@ -0,0 +1,76 @@
+package redisinstance
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/pivotal-cf/brokerapi"
+	"github.com/pivotal-cf/cf-redis-broker/agentapi"
+	"github.com/pivotal-cf/cf-redis-broker/redis"
+)
+
+type InstanceFinder interface {
+	FindByID(instanceID string) (*redis.Instance, error)
+}
+
+type StatsClient interface {
+	Info(host string) (agentapi.InfoResponse, error)
+	Slowlog(host string) (agentapi.SlowlogResponse, error)
+	Clients(host string) (agentapi.ClientsResponse, error)
+}
+
+func NewStatsHandler(instanceFinder InstanceFinder, statsClient StatsClient) http.Handler {
+	router := mux.NewRouter()
+
+	router.Path("/instance/{instance_id}/info").
+		Methods("GET").
+		HandlerFunc(statsHandler(instanceFinder, func(host string) (interface{}, error) {
+			return statsClient.Info(host)
+		}))
+
+	router.Path("/instance/{instance_id}/slowlog").
+		Methods("GET").
+		HandlerFunc(statsHandler(instanceFinder, func(host string) (interface{}, error) {
+			return statsClient.Slowlog(host)
+		}))
+
+	router.Path("/instance/{instance_id}/clients").
+		Methods("GET").
+		HandlerFunc(statsHandler(instanceFinder, func(host string) (interface{}, error) {
+			return statsClient.Clients(host)
+		}))
+
+	return router
+}
+
+func statsHandler(instanceFinder InstanceFinder, fetch func(host string) (interface{}, error)) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		res.Header().Add("Content-Type", "application/json")
+
+		instance, err := instanceFinder.FindByID(mux.Vars(req)["instance_id"])
+		if err == brokerapi.ErrInstanceDoesNotExist {
+			http.Error(res, "", http.StatusNotFound)
+			return
+		}
+
+		if err != nil {
+			http.Error(res, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		stats, err := fetch(instance.Host)
+		if err != nil {
+			http.Error(res, err.Error(), http.StatusBadGateway)
+			return
+		}
+
+		payload, err := json.Marshal(stats)
+		if err != nil {
+			http.Error(res, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		res.Write(payload)
+	}
+}
@@ -0,0 +1,123 @@
+package redisinstance_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/pivotal-cf/brokerapi"
+	"github.com/pivotal-cf/cf-redis-broker/agentapi"
+	"github.com/pivotal-cf/cf-redis-broker/redis"
+	"github.com/pivotal-cf/cf-redis-broker/redis/client"
+	"github.com/pivotal-cf/cf-redis-broker/redisinstance"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type fakeInstanceByIDFinder struct{}
+
+func (finder fakeInstanceByIDFinder) FindByID(instanceID string) (*redis.Instance, error) {
+	if instanceID != "some-instance" {
+		return nil, brokerapi.ErrInstanceDoesNotExist
+	}
+
+	return &redis.Instance{ID: instanceID, Host: "1.2.3.4"}, nil
+}
+
+type fakeStatsClient struct {
+	requestedHost string
+	err           error
+}
+
+func (c *fakeStatsClient) Info(host string) (agentapi.InfoResponse, error) {
+	c.requestedHost = host
+	return agentapi.InfoResponse{"server": {"redis_version": "3.2.8"}}, c.err
+}
+
+func (c *fakeStatsClient) Slowlog(host string) (agentapi.SlowlogResponse, error) {
+	c.requestedHost = host
+	return agentapi.SlowlogResponse{
+		Entries: []client.SlowlogEntry{{ID: 4, Command: []string{"KEYS", "*"}}},
+	}, c.err
+}
+
+func (c *fakeStatsClient) Clients(host string) (agentapi.ClientsResponse, error) {
+	c.requestedHost = host
+	return agentapi.ClientsResponse{
+		Clients: []map[string]string{{"addr": "10.0.0.1:51234"}},
+	}, c.err
+}
+
+var _ = Describe("Stats handler", func() {
+	var (
+		recorder    *httptest.ResponseRecorder
+		statsClient *fakeStatsClient
+		handler     http.Handler
+	)
+
+	BeforeEach(func() {
+		recorder = httptest.NewRecorder()
+		statsClient = new(fakeStatsClient)
+		handler = redisinstance.NewStatsHandler(fakeInstanceByIDFinder{}, statsClient)
+	})
+
+	serve := func(path string) {
+		request, err := http.NewRequest("GET", "http://localhost"+path, nil)
+		Expect(err).NotTo(HaveOccurred())
+		handler.ServeHTTP(recorder, request)
+	}
+
+	It("proxies info requests to the agent on the instance host", func() {
+		serve("/instance/some-instance/info")
+
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		Expect(statsClient.requestedHost).To(Equal("1.2.3.4"))
+
+		info := agentapi.InfoResponse{}
+		Expect(json.Unmarshal(recorder.Body.Bytes(), &info)).To(Succeed())
+		Expect(info["server"]["redis_version"]).To(Equal("3.2.8"))
+	})
+
+	It("proxies slowlog requests to the agent on the instance host", func() {
+		serve("/instance/some-instance/slowlog")
+
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+
+		slowlog := agentapi.SlowlogResponse{}
+		Expect(json.Unmarshal(recorder.Body.Bytes(), &slowlog)).To(Succeed())
+		Expect(slowlog.Entries).To(HaveLen(1))
+		Expect(slowlog.Entries[0].Command).To(Equal([]string{"KEYS", "*"}))
+	})
+
+	It("proxies clients requests to the agent on the instance host", func() {
+		serve("/instance/some-instance/clients")
+
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+
+		clients := agentapi.ClientsResponse{}
+		Expect(json.Unmarshal(recorder.Body.Bytes(), &clients)).To(Succeed())
+		Expect(clients.Clients[0]["addr"]).To(Equal("10.0.0.1:51234"))
+	})
+
+	Context("when the instance does not exist", func() {
+		It("returns a 404", func() {
+			serve("/instance/unknown-instance/info")
+			Expect(recorder.Code).To(Equal(http.StatusNotFound))
+		})
+	})
+
+	Context("when the agent request fails", func() {
+		BeforeEach(func() {
+			statsClient.err = errors.New("Agent error: 500")
+		})
+
+		It("returns a 502 with the error", func() {
+			serve("/instance/some-instance/info")
+
+			Expect(recorder.Code).To(Equal(http.StatusBadGateway))
+			Expect(recorder.Body.String()).To(ContainSubstring("Agent error: 500"))
+		})
+	})
+})
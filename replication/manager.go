@@ -0,0 +1,203 @@
+package replication
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/pivotal-cf/cf-redis-broker/agentapi"
+	"github.com/pivotal-cf/cf-redis-broker/redis/client"
+	"github.com/pivotal-cf/cf-redis-broker/redisconf"
+)
+
+const DefaultSentinelPort = 26379
+
+type ConnectFunc func(port int, password string, aliases map[string]string) (client.Client, error)
+
+// Manager makes the local redis-server the master or a replica of a group
+// and points the local Sentinel at the group's master. Changes are applied
+// to the running processes and written to redis.conf, so that they survive
+// a restart. ConfLock is held while redis.conf is read and written.
+//
+// The Manager connects to the agent's Sentinel on SentinelPort on
+// localhost.
+type Manager struct {
+	ConfPath     string
+	SentinelPort int
+	Connect      ConnectFunc
+	ConfLock     sync.Locker
+}
+
+func New(confPath string, sentinelPort int) *Manager {
+	if sentinelPort == 0 {
+		sentinelPort = DefaultSentinelPort
+	}
+
+	return &Manager{
+		ConfPath:     confPath,
+		SentinelPort: sentinelPort,
+		Connect:      Connect,
+		ConfLock:     new(sync.Mutex),
+	}
+}
+
+func Connect(port int, password string, aliases map[string]string) (client.Client, error) {
+	return client.Connect(
+		client.Port(port),
+		client.Password(password),
+		client.CmdAliases(aliases),
+	)
+}
+
+func (manager *Manager) Configure(request agentapi.ReplicationRequest) error {
+	manager.ConfLock.Lock()
+	defer manager.ConfLock.Unlock()
+
+	conf, err := redisconf.Load(manager.ConfPath)
+	if err != nil {
+		return err
+	}
+
+	err = manager.configureRedis(conf, request)
+	if err != nil {
+		return err
+	}
+
+	sentinel, err := manager.connectToSentinel()
+	if err != nil {
+		return err
+	}
+	defer sentinel.Disconnect()
+
+	err = removeMaster(sentinel, request.MasterName)
+	if err != nil {
+		return err
+	}
+
+	_, err = sentinel.Exec("SENTINEL", "MONITOR", request.MasterName, request.MasterHost, request.MasterPort, request.Quorum)
+	if err != nil {
+		return fmt.Errorf("sentinel monitor %s: %s", request.MasterName, err)
+	}
+
+	if request.Password != "" {
+		_, err = sentinel.Exec("SENTINEL", "SET", request.MasterName, "auth-pass", request.Password)
+		if err != nil {
+			return fmt.Errorf("sentinel set auth-pass %s: %s", request.MasterName, err)
+		}
+	}
+
+	return nil
+}
+
+// Remove stops Sentinel monitoring the group and turns the local
+// redis-server back into a standalone master.
+func (manager *Manager) Remove(masterName string) error {
+	manager.ConfLock.Lock()
+	defer manager.ConfLock.Unlock()
+
+	sentinel, err := manager.connectToSentinel()
+	if err != nil {
+		return err
+	}
+	defer sentinel.Disconnect()
+
+	err = removeMaster(sentinel, masterName)
+	if err != nil {
+		return err
+	}
+
+	conf, err := redisconf.Load(manager.ConfPath)
+	if err != nil {
+		return err
+	}
+
+	redisClient, err := manager.Connect(conf.Port(), conf.Password(), conf.CommandAliases())
+	if err != nil {
+		return err
+	}
+	defer redisClient.Disconnect()
+
+	err = redisClient.ReplicaOf("", 0)
+	if err != nil {
+		return err
+	}
+
+	conf.Remove("slaveof")
+	conf.Remove("replicaof")
+	conf.Remove("masterauth")
+
+	return conf.Save(manager.ConfPath)
+}
+
+func (manager *Manager) Failover(masterName string) error {
+	sentinel, err := manager.connectToSentinel()
+	if err != nil {
+		return err
+	}
+	defer sentinel.Disconnect()
+
+	_, err = sentinel.Exec("SENTINEL", "FAILOVER", masterName)
+	if err != nil {
+		return fmt.Errorf("sentinel failover %s: %s", masterName, err)
+	}
+
+	return nil
+}
+
+// configureRedis sets masterauth before replicating and requirepass last,
+// so that the connection made with the old password stays usable.
+func (manager *Manager) configureRedis(conf redisconf.Conf, request agentapi.ReplicationRequest) error {
+	redisClient, err := manager.Connect(conf.Port(), conf.Password(), conf.CommandAliases())
+	if err != nil {
+		return err
+	}
+	defer redisClient.Disconnect()
+
+	err = redisClient.SetConfig("masterauth", request.Password)
+	if err != nil {
+		return err
+	}
+
+	if request.Replica {
+		err = redisClient.ReplicaOf(request.MasterHost, request.MasterPort)
+	} else {
+		err = redisClient.ReplicaOf("", 0)
+	}
+	if err != nil {
+		return err
+	}
+
+	err = redisClient.SetConfig("requirepass", request.Password)
+	if err != nil {
+		return err
+	}
+
+	conf.Set("requirepass", request.Password)
+	conf.Set("masterauth", request.Password)
+	conf.Remove("replicaof")
+	if request.Replica {
+		conf.Set("slaveof", fmt.Sprintf("%s %d", request.MasterHost, request.MasterPort))
+	} else {
+		conf.Remove("slaveof")
+	}
+
+	return conf.Save(manager.ConfPath)
+}
+
+func (manager *Manager) connectToSentinel() (client.Client, error) {
+	sentinel, err := manager.Connect(manager.SentinelPort, "", nil)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to sentinel: %s", err)
+	}
+	return sentinel, nil
+}
+
+// removeMaster tolerates masters the Sentinel does not know, so that
+// configuring and removing a group can be retried.
+func removeMaster(sentinel client.Client, masterName string) error {
+	_, err := sentinel.Exec("SENTINEL", "REMOVE", masterName)
+	if err != nil && !strings.Contains(err.Error(), "No such master") {
+		return fmt.Errorf("sentinel remove %s: %s", masterName, err)
+	}
+	return nil
+}
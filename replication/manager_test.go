@@ -0,0 +1,160 @@
+package replication_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pivotal-cf/cf-redis-broker/agentapi"
+	"github.com/pivotal-cf/cf-redis-broker/redis/client"
+	"github.com/pivotal-cf/cf-redis-broker/redis/client/fakes"
+	"github.com/pivotal-cf/cf-redis-broker/redisconf"
+	"github.com/pivotal-cf/cf-redis-broker/replication"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Manager", func() {
+	var (
+		dir         string
+		confPath    string
+		redisClient *fakes.Client
+		sentinel    *fakes.Client
+		passwords   []string
+		manager     *replication.Manager
+		request     agentapi.ReplicationRequest
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "replication")
+		Expect(err).NotTo(HaveOccurred())
+
+		confPath = filepath.Join(dir, "redis.conf")
+		conf := redisconf.New(
+			redisconf.Param{Key: "port", Value: "6379"},
+			redisconf.Param{Key: "requirepass", Value: "old-password"},
+		)
+		Expect(conf.Save(confPath)).To(Succeed())
+
+		redisClient = new(fakes.Client)
+		sentinel = &fakes.Client{ExecErrs: map[string]error{}}
+		passwords = []string{}
+
+		manager = replication.New(confPath, 0)
+		manager.Connect = func(port int, password string, aliases map[string]string) (client.Client, error) {
+			passwords = append(passwords, password)
+			if port == replication.DefaultSentinelPort {
+				return sentinel, nil
+			}
+			return redisClient, nil
+		}
+
+		request = agentapi.ReplicationRequest{
+			MasterName: "instance-id",
+			MasterHost: "10.0.0.1",
+			MasterPort: 6379,
+			Password:   "group-password",
+			Quorum:     2,
+			Replica:    true,
+		}
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	loadConf := func() redisconf.Conf {
+		conf, err := redisconf.Load(confPath)
+		Expect(err).NotTo(HaveOccurred())
+		return conf
+	}
+
+	Describe("Configure", func() {
+		It("makes the local redis replicate from the master", func() {
+			Expect(manager.Configure(request)).To(Succeed())
+
+			Expect(passwords[0]).To(Equal("old-password"))
+			Expect(redisClient.SetConfigCalls).To(Equal([]string{
+				"masterauth group-password",
+				"requirepass group-password",
+			}))
+			Expect(redisClient.ReplicaOfCalls).To(Equal([]string{"10.0.0.1 6379"}))
+		})
+
+		It("writes the replication settings to redis.conf", func() {
+			Expect(manager.Configure(request)).To(Succeed())
+
+			conf := loadConf()
+			Expect(conf.Get("slaveof")).To(Equal("10.0.0.1 6379"))
+			Expect(conf.Get("masterauth")).To(Equal("group-password"))
+			Expect(conf.Password()).To(Equal("group-password"))
+		})
+
+		It("points sentinel at the master", func() {
+			Expect(manager.Configure(request)).To(Succeed())
+
+			Expect(sentinel.ExecCalls).To(Equal([]string{
+				"SENTINEL REMOVE instance-id",
+				"SENTINEL MONITOR instance-id 10.0.0.1 6379 2",
+				"SENTINEL SET instance-id auth-pass group-password",
+			}))
+		})
+
+		It("tolerates a master sentinel does not know yet", func() {
+			sentinel.ExecErrs["SENTINEL REMOVE instance-id"] = errors.New("ERR No such master with that name")
+			Expect(manager.Configure(request)).To(Succeed())
+		})
+
+		It("fails when sentinel rejects the master", func() {
+			sentinel.ExecErrs["SENTINEL MONITOR instance-id 10.0.0.1 6379 2"] = errors.New("ERR Invalid IP address")
+			Expect(manager.Configure(request)).To(MatchError("sentinel monitor instance-id: ERR Invalid IP address"))
+		})
+
+		Context("when the node is the master", func() {
+			BeforeEach(func() {
+				request.Replica = false
+			})
+
+			It("stops replicating", func() {
+				Expect(manager.Configure(request)).To(Succeed())
+
+				Expect(redisClient.ReplicaOfCalls).To(Equal([]string{"NO ONE"}))
+				Expect(loadConf().HasKey("slaveof")).To(BeFalse())
+			})
+		})
+	})
+
+	Describe("Remove", func() {
+		BeforeEach(func() {
+			Expect(manager.Configure(request)).To(Succeed())
+			sentinel.ExecCalls = nil
+			redisClient.ReplicaOfCalls = nil
+		})
+
+		It("forgets the master and stops replicating", func() {
+			Expect(manager.Remove("instance-id")).To(Succeed())
+
+			Expect(sentinel.ExecCalls).To(Equal([]string{"SENTINEL REMOVE instance-id"}))
+			Expect(redisClient.ReplicaOfCalls).To(Equal([]string{"NO ONE"}))
+
+			conf := loadConf()
+			Expect(conf.HasKey("slaveof")).To(BeFalse())
+			Expect(conf.HasKey("masterauth")).To(BeFalse())
+		})
+	})
+
+	Describe("Failover", func() {
+		It("asks sentinel to fail over", func() {
+			Expect(manager.Failover("instance-id")).To(Succeed())
+			Expect(sentinel.ExecCalls).To(Equal([]string{"SENTINEL FAILOVER instance-id"}))
+		})
+
+		It("returns sentinel errors", func() {
+			sentinel.ExecErrs["SENTINEL FAILOVER instance-id"] = errors.New("NOGOODSLAVE No suitable replica to promote")
+			Expect(manager.Failover("instance-id")).To(MatchError("sentinel failover instance-id: NOGOODSLAVE No suitable replica to promote"))
+		})
+	})
+})
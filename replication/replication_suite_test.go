@@ -0,0 +1,15 @@
+package replication_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/reporters"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestReplication(t *testing.T) {
+	RegisterFailHandler(Fail)
+	junitReporter := reporters.NewJUnitReporter("junit_replication.xml")
+	RunSpecsWithDefaultAndCustomReporters(t, "Replication Suite", []Reporter{junitReporter})
+}
@@ -0,0 +1,118 @@
+package replication
+
+import (
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+)
+
+const (
+	defaultSentinelRestartDelay = 5 * time.Second
+	sentinelStopTimeout         = 10 * time.Second
+)
+
+// Sentinel runs redis-server in sentinel mode as a child of the agent and
+// restarts it whenever it exits. It listens on Port on every interface, as
+// the other Sentinels of a group and the apps bound to it connect to it too,
+// and accepts connections without a password. Sentinel records the groups
+// it monitors in ConfPath, which is created empty if it does not exist.
+type Sentinel struct {
+	ExecutablePath string
+	ConfPath       string
+	Port           int
+	RestartDelay   time.Duration
+	Logger         lager.Logger
+}
+
+func NewSentinel(executablePath, confPath string, port int, logger lager.Logger) *Sentinel {
+	if port == 0 {
+		port = DefaultSentinelPort
+	}
+
+	return &Sentinel{
+		ExecutablePath: executablePath,
+		ConfPath:       confPath,
+		Port:           port,
+		RestartDelay:   defaultSentinelRestartDelay,
+		Logger:         logger,
+	}
+}
+
+// Run keeps Sentinel running until stop is closed, then stops it. It only
+// returns an error if the conf file cannot be created.
+func (sentinel *Sentinel) Run(stop <-chan struct{}) error {
+	err := sentinel.ensureConf()
+	if err != nil {
+		return err
+	}
+
+	for {
+		cmd := exec.Command(
+			sentinel.ExecutablePath,
+			sentinel.ConfPath,
+			"--sentinel",
+			"--port", strconv.Itoa(sentinel.Port),
+			"--protected-mode", "no",
+			"--daemonize", "no",
+		)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+
+		exited := make(chan error, 1)
+		if err := cmd.Start(); err != nil {
+			exited <- err
+		} else {
+			sentinel.Logger.Info("sentinel-started", lager.Data{
+				"pid":  cmd.Process.Pid,
+				"port": sentinel.Port,
+			})
+			go func() {
+				exited <- cmd.Wait()
+			}()
+		}
+
+		select {
+		case <-stop:
+			sentinel.stop(cmd, exited)
+			return nil
+		case err := <-exited:
+			sentinel.Logger.Error("sentinel-exited", err, lager.Data{
+				"restart_delay": sentinel.RestartDelay.String(),
+			})
+		}
+
+		select {
+		case <-stop:
+			return nil
+		case <-time.After(sentinel.RestartDelay):
+		}
+	}
+}
+
+func (sentinel *Sentinel) stop(cmd *exec.Cmd, exited <-chan error) {
+	if cmd.Process == nil {
+		return
+	}
+
+	cmd.Process.Signal(syscall.SIGTERM)
+	select {
+	case <-exited:
+	case <-time.After(sentinelStopTimeout):
+		cmd.Process.Kill()
+		<-exited
+	}
+}
+
+// ensureConf creates the conf file without touching an existing one, which
+// holds the state Sentinel rewrites it with.
+func (sentinel *Sentinel) ensureConf() error {
+	file, err := os.OpenFile(sentinel.ConfPath, os.O_WRONLY|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,135 @@
+package replication_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/lager/lagertest"
+	"github.com/pivotal-cf/cf-redis-broker/replication"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Sentinel", func() {
+	var (
+		dir      string
+		confPath string
+		argsPath string
+		stop     chan struct{}
+		done     chan struct{}
+		runErr   error
+		sentinel *replication.Sentinel
+	)
+
+	writeExecutable := func(script string) string {
+		path := filepath.Join(dir, "redis-server")
+		Expect(ioutil.WriteFile(path, []byte("#!/bin/sh\n"+script+"\n"), 0755)).To(Succeed())
+		return path
+	}
+
+	runs := func() []string {
+		contents, err := ioutil.ReadFile(argsPath)
+		if err != nil {
+			return nil
+		}
+		return strings.Split(strings.TrimSpace(string(contents)), "\n")
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "sentinel")
+		Expect(err).NotTo(HaveOccurred())
+
+		confPath = filepath.Join(dir, "sentinel.conf")
+		argsPath = filepath.Join(dir, "args")
+		stop = make(chan struct{})
+		done = make(chan struct{})
+
+		sentinel = replication.NewSentinel(
+			writeExecutable("echo \"$@\" >> "+argsPath+"\nexec sleep 60"),
+			confPath,
+			0,
+			lagertest.NewTestLogger("sentinel"),
+		)
+		sentinel.RestartDelay = 10 * time.Millisecond
+	})
+
+	JustBeforeEach(func() {
+		go func() {
+			runErr = sentinel.Run(stop)
+			close(done)
+		}()
+	})
+
+	AfterEach(func() {
+		select {
+		case <-stop:
+		default:
+			close(stop)
+		}
+		Eventually(done, 15*time.Second).Should(BeClosed())
+		os.RemoveAll(dir)
+	})
+
+	It("runs redis-server in sentinel mode on the sentinel port", func() {
+		Eventually(runs).Should(Equal([]string{
+			confPath + " --sentinel --port 26379 --protected-mode no --daemonize no",
+		}))
+	})
+
+	It("creates an empty conf file", func() {
+		Eventually(runs).Should(HaveLen(1))
+
+		contents, err := ioutil.ReadFile(confPath)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(contents).To(BeEmpty())
+	})
+
+	Context("when the conf file exists", func() {
+		BeforeEach(func() {
+			Expect(ioutil.WriteFile(confPath, []byte("sentinel monitor group 10.0.0.1 6379 2\n"), 0600)).To(Succeed())
+		})
+
+		It("keeps what Sentinel recorded in it", func() {
+			Eventually(runs).Should(HaveLen(1))
+
+			contents, err := ioutil.ReadFile(confPath)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(contents)).To(Equal("sentinel monitor group 10.0.0.1 6379 2\n"))
+		})
+	})
+
+	Context("when Sentinel exits", func() {
+		BeforeEach(func() {
+			sentinel.ExecutablePath = writeExecutable("echo \"$@\" >> " + argsPath)
+		})
+
+		It("restarts it", func() {
+			Eventually(runs).Should(HaveLen(3))
+		})
+	})
+
+	It("stops Sentinel when stopped", func() {
+		Eventually(runs).Should(HaveLen(1))
+
+		close(stop)
+		Eventually(done, 15*time.Second).Should(BeClosed())
+		Expect(runErr).NotTo(HaveOccurred())
+		Consistently(runs, 100*time.Millisecond).Should(HaveLen(1))
+	})
+
+	Context("when the conf file cannot be created", func() {
+		BeforeEach(func() {
+			sentinel.ConfPath = filepath.Join(dir, "missing", "sentinel.conf")
+		})
+
+		It("returns the error", func() {
+			Eventually(done).Should(BeClosed())
+			Expect(runErr).To(HaveOccurred())
+		})
+	})
+})
@@ -0,0 +1,203 @@
+package resetter
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	redigo "github.com/garyburd/redigo/redis"
+	"github.com/pivotal-cf/cf-redis-broker/redisconf"
+)
+
+var (
+	errGroupMember = errors.New("node is part of a high availability group or cluster")
+	errACLUsers    = errors.New("node has ACL users besides the default user")
+)
+
+//liveOnly settings are not applied by the fast reset: the password is set
+//first, the others cannot be read back or would move the data of redis
+var liveOnly = map[string]bool{
+	"requirepass":    true,
+	"rename-command": true,
+	"include":        true,
+	"loadmodule":     true,
+	"dir":            true,
+}
+
+//resetInPlace wipes redis without restarting it. The password changes
+//before the clients are killed and the data flushed, so that no client
+//gets back in meanwhile. Redis then takes the default settings and the
+//default redis.conf replaces the live one. On error the old password is put
+//back, so that the full reset can still authenticate
+func (resetter *Resetter) resetInPlace() error {
+	liveConf, err := redisconf.Load(resetter.liveConfPath)
+	if err != nil {
+		return err
+	}
+
+	//Replication and cluster mode cannot be undone at runtime
+	for _, key := range []string{"slaveof", "replicaof", "masterauth"} {
+		if liveConf.HasKey(key) {
+			return errGroupMember
+		}
+	}
+	if strings.EqualFold(confValue(liveConf, "cluster-enabled", "no"), "yes") {
+		return errGroupMember
+	}
+
+	newConf, err := resetter.renderDefaultConf()
+	if err != nil {
+		return err
+	}
+
+	connection, err := resetter.getAuthenticatedRedisConn()
+	if err != nil {
+		return err
+	}
+	defer connection.Close()
+
+	commands := commander{connection: connection, aliases: liveConf.CommandAliases()}
+
+	if err := checkACLUsers(commands); err != nil {
+		return err
+	}
+
+	if _, err := commands.do("SCRIPT", "KILL"); err != nil && !isNoScriptErr(err) {
+		return err
+	}
+
+	if _, err := commands.do("CONFIG", "SET", "requirepass", newConf.Password()); err != nil {
+		return err
+	}
+
+	err = resetData(commands, newConf)
+	if err == nil {
+		err = newConf.Save(resetter.liveConfPath)
+	}
+
+	if err != nil {
+		commands.do("CONFIG", "SET", "requirepass", liveConf.Password())
+		return err
+	}
+
+	return nil
+}
+
+//checkACLUsers refuses nodes where the tenant created ACL users, which
+//only a restart with the default redis.conf removes. Redis before 6.0 has
+//no ACL command and so no users
+func checkACLUsers(commands commander) error {
+	if commands.disabled("ACL") {
+		return nil
+	}
+
+	users, err := redigo.Strings(commands.do("ACL", "LIST"))
+	if err == redigo.ErrNil || (err != nil && strings.Contains(err.Error(), "unknown command")) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if len(users) > 1 {
+		return errACLUsers
+	}
+
+	return nil
+}
+
+//resetData kills every connection of the previous tenant, including
+//replicas and MONITOR clients, which have the slave type that every redis
+//version understands, then wipes the data and applies the default settings.
+//FLUSHALL only rewrites the RDB file when save points are configured, so the
+//empty dataset is saved over the previous tenant's explicitly
+func resetData(commands commander, newConf redisconf.Conf) error {
+	steps := [][]interface{}{
+		{"CLIENT", "KILL", "TYPE", "normal"},
+		{"CLIENT", "KILL", "TYPE", "pubsub"},
+		{"CLIENT", "KILL", "TYPE", "slave"},
+		{"CLIENT", "KILL", "TYPE", "master"},
+		{"FLUSHALL"},
+		{"SAVE"},
+		{"SCRIPT", "FLUSH"},
+	}
+
+	for _, step := range steps {
+		if _, err := commands.do(step[0].(string), step[1:]...); err != nil {
+			return err
+		}
+	}
+
+	if err := applySettings(commands, newConf); err != nil {
+		return err
+	}
+
+	_, err := commands.do("CONFIG", "RESETSTAT")
+	return err
+}
+
+//applySettings sets the settings of conf that the tenant changed. A setting
+//that cannot be changed at runtime fails the fast reset
+func applySettings(commands commander, conf redisconf.Conf) error {
+	for _, key := range conf.Keys() {
+		if liveOnly[key] {
+			continue
+		}
+
+		desired, err := conf.Setting(key)
+		if err != nil {
+			return err
+		}
+
+		live, err := redigo.Strings(commands.do("CONFIG", "GET", key))
+		if err == redigo.ErrNil || (err == nil && len(live) < 2) {
+			//Settings this redis does not know
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if redisconf.NormalizeSetting(strings.Fields(live[1])) == redisconf.NormalizeSetting(desired) {
+			continue
+		}
+
+		if _, err := commands.do("CONFIG", "SET", key, strings.Join(desired, " ")); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//commander sends commands under their rename-command aliases
+type commander struct {
+	connection redigo.Conn
+	aliases    map[string]string
+}
+
+func (commands commander) do(command string, args ...interface{}) (interface{}, error) {
+	if commands.disabled(command) {
+		return nil, fmt.Errorf("%s is disabled by rename-command", command)
+	}
+
+	reply, err := commands.connection.Do(commands.name(command), args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s failed: %s", command, err)
+	}
+
+	return reply, nil
+}
+
+func (commands commander) name(command string) string {
+	for original, alias := range commands.aliases {
+		if strings.EqualFold(original, command) {
+			return alias
+		}
+	}
+	return command
+}
+
+func (commands commander) disabled(command string) bool {
+	return commands.name(command) == ""
+}
@@ -0,0 +1,117 @@
+package resetter
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+
+	"github.com/pivotal-cf/redisutils/monit"
+)
+
+//ProcessControl starts and stops redis. Both return once redis has
+//started or stopped, as far as the supervisor can tell
+type ProcessControl interface {
+	Start() error
+	Stop() error
+}
+
+//MonitProcess controls redis through monit, as on BOSH deployed nodes
+type MonitProcess struct {
+	Monit monit.Monit
+	Name  string
+}
+
+func (process *MonitProcess) Start() error {
+	return process.Monit.StartAndWait(process.Name)
+}
+
+func (process *MonitProcess) Stop() error {
+	return process.Monit.StopAndWait(process.Name)
+}
+
+//SystemdProcess controls redis as a systemd unit
+type SystemdProcess struct {
+	Systemctl string
+	Unit      string
+}
+
+func (process *SystemdProcess) Start() error {
+	return process.systemctl("start")
+}
+
+func (process *SystemdProcess) Stop() error {
+	return process.systemctl("stop")
+}
+
+func (process *SystemdProcess) systemctl(action string) error {
+	systemctl := process.Systemctl
+	if systemctl == "" {
+		systemctl = "systemctl"
+	}
+
+	output, err := exec.Command(systemctl, action, process.Unit).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("systemctl %s %s failed: %s: %s", action, process.Unit, err, output)
+	}
+
+	return nil
+}
+
+//ExecProcess runs redis as a child of the agent, for tests and installs
+//without a process supervisor. It only stops a redis it started itself
+type ExecProcess struct {
+	Path        string
+	Args        []string
+	StopTimeout time.Duration
+
+	cmd  *exec.Cmd
+	done chan error
+}
+
+func (process *ExecProcess) Start() error {
+	if process.cmd != nil {
+		return nil
+	}
+
+	cmd := exec.Command(process.Path, process.Args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	process.cmd = cmd
+	process.done = done
+	return nil
+}
+
+func (process *ExecProcess) Stop() error {
+	if process.cmd == nil {
+		return nil
+	}
+
+	timeout := process.StopTimeout
+	if timeout == 0 {
+		timeout = 30 * time.Second
+	}
+
+	process.cmd.Process.Signal(syscall.SIGTERM)
+	select {
+	case <-process.done:
+	case <-time.After(timeout):
+		process.cmd.Process.Kill()
+		<-process.done
+	}
+
+	process.cmd = nil
+	process.done = nil
+	return nil
+}
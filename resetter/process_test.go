@@ -0,0 +1,95 @@
+package resetter
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	monitFakes "github.com/pivotal-cf/redisutils/monit/fakes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ProcessControl", func() {
+	Describe("MonitProcess", func() {
+		It("starts and stops the monit process", func() {
+			fakeMonit := new(monitFakes.FakeMonit)
+			process := &MonitProcess{Monit: fakeMonit, Name: "redis"}
+
+			Expect(process.Stop()).To(Succeed())
+			Expect(process.Start()).To(Succeed())
+
+			Expect(fakeMonit.StopAndWaitArgsForCall(0)).To(Equal("redis"))
+			Expect(fakeMonit.StartAndWaitArgsForCall(0)).To(Equal("redis"))
+		})
+
+		It("returns monit's errors", func() {
+			fakeMonit := new(monitFakes.FakeMonit)
+			fakeMonit.StopAndWaitReturns(errors.New("timed out"))
+
+			Expect((&MonitProcess{Monit: fakeMonit, Name: "redis"}).Stop()).To(MatchError("timed out"))
+		})
+	})
+
+	Describe("SystemdProcess", func() {
+		var (
+			tmpDir    string
+			callsPath string
+			process   *SystemdProcess
+		)
+
+		BeforeEach(func() {
+			var err error
+			tmpDir, err = ioutil.TempDir("", "systemctl")
+			Expect(err).NotTo(HaveOccurred())
+
+			callsPath = filepath.Join(tmpDir, "calls")
+			systemctl := filepath.Join(tmpDir, "systemctl")
+			script := "#!/bin/sh\necho \"$@\" >> " + callsPath + "\n[ \"$2\" != broken ] || { echo unit not found; exit 5; }\n"
+			Expect(ioutil.WriteFile(systemctl, []byte(script), 0755)).To(Succeed())
+
+			process = &SystemdProcess{Systemctl: systemctl, Unit: "redis-server"}
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(tmpDir)
+		})
+
+		It("stops and starts the unit", func() {
+			Expect(process.Stop()).To(Succeed())
+			Expect(process.Start()).To(Succeed())
+
+			Expect(ioutil.ReadFile(callsPath)).To(Equal([]byte("stop redis-server\nstart redis-server\n")))
+		})
+
+		It("returns systemctl's output on failure", func() {
+			process.Unit = "broken"
+			Expect(process.Start()).To(MatchError(ContainSubstring("unit not found")))
+		})
+	})
+
+	Describe("ExecProcess", func() {
+		It("runs the process until it is stopped", func() {
+			process := &ExecProcess{Path: "sleep", Args: []string{"60"}, StopTimeout: time.Second}
+
+			Expect(process.Start()).To(Succeed())
+			pid := process.cmd.Process.Pid
+
+			Expect(process.Stop()).To(Succeed())
+			Expect(process.cmd).To(BeNil())
+			Expect(processExists(pid)).To(BeFalse())
+		})
+
+		It("does nothing when stopped before it is started", func() {
+			Expect((&ExecProcess{Path: "sleep"}).Stop()).To(Succeed())
+		})
+	})
+})
+
+func processExists(pid int) bool {
+	return syscall.Kill(pid, 0) == nil
+}
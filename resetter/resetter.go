@@ -1,12 +1,16 @@
 package resetter
 
 import (
+	"errors"
 	"fmt"
 	"net"
 	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
+	"code.cloudfoundry.org/lager"
 	redigo "github.com/garyburd/redigo/redis"
 	"github.com/pivotal-cf/cf-redis-broker/redisconf"
 	"github.com/pivotal-cf/redisutils/monit"
@@ -17,13 +21,28 @@ type checker interface {
 	Check(address *net.TCPAddr, timeout time.Duration) error
 }
 
-//Resetter recycles a redis instance
+const clusterConfigFile = "nodes.conf"
+
+var (
+	ErrSnapshotsDisabled = errors.New("snapshots are not enabled")
+	ErrNodeAllocated     = errors.New("node has been allocated since its last reset, force the restore to overwrite the data of its instance")
+)
+
+//Resetter recycles a redis instance. Resets render the default redis.conf
+//for the redis-server Version with Features, as the agent does at start
 type Resetter struct {
 	defaultConfPath string
 	liveConfPath    string
 	portChecker     checker
 	timeout         time.Duration
-	Monit           monit.Monit
+	saveInterval    time.Duration
+	Process         ProcessControl
+	Snapshots       *Snapshots
+	FastReset       bool
+	Version         redisconf.Version
+	Features        redisconf.Features
+	Logger          lager.Logger
+	ConfLock        sync.Locker
 	redis           redis.Redis
 }
 
@@ -34,18 +53,51 @@ func New(defaultConfPath, liveConfPath string, portChecker checker) *Resetter {
 		liveConfPath:    liveConfPath,
 		portChecker:     portChecker,
 		timeout:         time.Second * 30,
-		Monit:           monit.New(),
+		saveInterval:    time.Millisecond * 100,
+		Process:         &MonitProcess{Monit: monit.New(), Name: "redis"},
+		Logger:          lager.NewLogger("resetter"),
+		ConfLock:        new(sync.Mutex),
 		redis:           redis.New(),
 	}
 }
 
-//ResetRedis stops redis, clears the database and starts redis
+//ResetRedis stops redis, clears the database and starts redis. With
+//Snapshots set, redis saves its data first and the data files are moved
+//into a snapshot rather than deleted. With FastReset set and no Snapshots,
+//redis is flushed in place and only restarted when that fails. ConfLock is
+//held throughout, so that nothing else writes redis.conf meanwhile
 func (resetter *Resetter) ResetRedis() error {
+	resetter.ConfLock.Lock()
+	defer resetter.ConfLock.Unlock()
+
+	if resetter.FastReset && resetter.Snapshots == nil {
+		err := resetter.resetInPlace()
+		if err == nil {
+			return nil
+		}
+		resetter.Logger.Error("fast-reset-failed", err, lager.Data{"message": "Falling back to restarting redis"})
+	}
+
+	paths, err := resetter.dataPaths()
+	if err != nil {
+		return err
+	}
+
+	if resetter.Snapshots != nil {
+		if err := resetter.finalSave(); err != nil {
+			return err
+		}
+	}
+
 	if err := resetter.stopRedis(); err != nil {
 		return err
 	}
 
-	if err := resetter.deleteData(); err != nil {
+	if resetter.Snapshots != nil {
+		if err := resetter.snapshotData(paths); err != nil {
+			return err
+		}
+	} else if err := resetter.deleteData(paths); err != nil {
 		return err
 	}
 
@@ -57,6 +109,126 @@ func (resetter *Resetter) ResetRedis() error {
 		return err
 	}
 
+	if err := resetter.waitUntilAvailable(); err != nil {
+		return err
+	}
+
+	if resetter.Snapshots != nil {
+		//Restores then need forcing, which is no reason to fail the reset
+		if err := resetter.Snapshots.MarkUnallocated(); err != nil {
+			resetter.Logger.Error("mark-unallocated-failed", err)
+		}
+	}
+
+	return nil
+}
+
+//MarkAllocated records that the node's credentials have been handed out,
+//after which restoring a snapshot would overwrite the data of an instance
+func (resetter *Resetter) MarkAllocated() error {
+	if resetter.Snapshots == nil {
+		return nil
+	}
+
+	return resetter.Snapshots.MarkAllocated()
+}
+
+//ListSnapshots returns the snapshots taken by earlier resets, newest first
+func (resetter *Resetter) ListSnapshots() ([]Snapshot, error) {
+	if resetter.Snapshots == nil {
+		return []Snapshot{}, nil
+	}
+
+	return resetter.Snapshots.List()
+}
+
+//RestoreSnapshot stops redis, replaces its data with the snapshot's and
+//starts redis again. Redis keeps its current password. Unless forced, it
+//refuses nodes that have been allocated since their last reset. ConfLock is
+//held throughout, so that no reset or conf change runs meanwhile
+func (resetter *Resetter) RestoreSnapshot(id string, force bool) error {
+	if resetter.Snapshots == nil {
+		return ErrSnapshotsDisabled
+	}
+
+	resetter.ConfLock.Lock()
+	defer resetter.ConfLock.Unlock()
+
+	if !force && !resetter.Snapshots.Unallocated() {
+		return ErrNodeAllocated
+	}
+
+	if _, err := resetter.Snapshots.find(id); err != nil {
+		return err
+	}
+
+	paths, err := resetter.dataPaths()
+	if err != nil {
+		return err
+	}
+
+	if err := resetter.stopRedis(); err != nil {
+		return err
+	}
+
+	if err := removeFiles(paths.dataFiles()); err != nil {
+		return err
+	}
+
+	if err := resetter.Snapshots.Restore(id, paths.dir); err != nil {
+		return err
+	}
+
+	if err := resetter.startRedis(); err != nil {
+		return err
+	}
+
+	return resetter.waitUntilAvailable()
+}
+
+//EnableCluster restarts redis in cluster mode with the given password and
+//without any earlier cluster membership, ready to be assigned hash slots
+func (resetter *Resetter) EnableCluster(password string) error {
+	resetter.ConfLock.Lock()
+	defer resetter.ConfLock.Unlock()
+
+	paths, err := resetter.dataPaths()
+	if err != nil {
+		return err
+	}
+
+	if err := resetter.stopRedis(); err != nil {
+		return err
+	}
+
+	conf, err := redisconf.Load(resetter.liveConfPath)
+	if err != nil {
+		return err
+	}
+
+	conf.Set("cluster-enabled", "yes")
+	conf.Set("cluster-config-file", clusterConfigFile)
+	if password != "" {
+		conf.Set("requirepass", password)
+		conf.Set("masterauth", password)
+	}
+
+	if err := conf.Save(resetter.liveConfPath); err != nil {
+		return err
+	}
+
+	if err := removeFiles([]string{filepath.Join(paths.dir, clusterConfigFile)}); err != nil {
+		return err
+	}
+
+	if err := resetter.startRedis(); err != nil {
+		return err
+	}
+
+	return resetter.waitUntilAvailable()
+}
+
+func (resetter *Resetter) waitUntilAvailable() error {
 	conf, err := redisconf.Load(resetter.liveConfPath)
 	if err != nil {
 		return err
@@ -76,7 +248,7 @@ func (resetter *Resetter) stopRedis() error {
 		return err
 	}
 
-	return resetter.Monit.StopAndWait("redis")
+	return resetter.Process.Stop()
 }
 
 func (resetter *Resetter) killScript() error {
@@ -122,25 +294,138 @@ func isNoScriptErr(err error) bool {
 }
 
 func (resetter *Resetter) startRedis() error {
-	return resetter.Monit.StartAndWait("redis")
+	return resetter.Process.Start()
 }
 
-func (resetter *Resetter) deleteData() error {
-	if err := os.Remove("appendonly.aof"); err != nil {
-		return err
+func (resetter *Resetter) deleteData(paths dataPaths) error {
+	return removeFiles(append(paths.dataFiles(), paths.clusterConfig))
+}
+
+//dataPaths are where redis keeps its data
+type dataPaths struct {
+	dir           string
+	rdb           string
+	aof           string
+	clusterConfig string
+}
+
+func (paths dataPaths) dataFiles() []string {
+	return []string{paths.aof, paths.rdb}
+}
+
+//dataPaths reads the data paths from the live redis.conf, using redis'
+//defaults for the file names it does not have. The dir has to be absolute,
+//as redis resolves a relative one against its own working directory, which
+//need not be the agent's
+func (resetter *Resetter) dataPaths() (dataPaths, error) {
+	conf, err := redisconf.LoadWithIncludes(resetter.liveConfPath)
+	if err != nil {
+		return dataPaths{}, err
+	}
+
+	dir := confValue(conf, "dir", "")
+	if !filepath.IsAbs(dir) {
+		return dataPaths{}, fmt.Errorf("%s must set dir to an absolute path, not '%s'", resetter.liveConfPath, dir)
+	}
+
+	return dataPaths{
+		dir:           dir,
+		rdb:           filepath.Join(dir, confValue(conf, "dbfilename", "dump.rdb")),
+		aof:           filepath.Join(dir, confValue(conf, "appendfilename", "appendonly.aof")),
+		clusterConfig: filepath.Join(dir, confValue(conf, "cluster-config-file", clusterConfigFile)),
+	}, nil
+}
+
+func confValue(conf redisconf.Conf, key, defaultValue string) string {
+	args, err := redisconf.Param{Key: key, Value: conf.Get(key)}.Args()
+	if err != nil || len(args) == 0 {
+		return defaultValue
 	}
+	return args[0]
+}
 
-	os.Remove("dump.rdb")
+//removeFiles removes the files that exist, a file such as the AOF is only
+//there when redis was configured to write it
+func removeFiles(files []string) error {
+	for _, file := range files {
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
 	return nil
 }
 
-func (resetter *Resetter) resetConfigWithNewPassword() error {
-	conf, err := redisconf.Load(resetter.defaultConfPath)
+//finalSave has redis write its dataset to disk, so that the snapshot holds
+//every write acknowledged before the reset
+func (resetter *Resetter) finalSave() error {
+	liveConf, err := redisconf.Load(resetter.liveConfPath)
 	if err != nil {
 		return err
 	}
 
-	err = conf.InitForDedicatedNode()
+	connection, err := resetter.getAuthenticatedRedisConn()
+	if err != nil {
+		return err
+	}
+	defer connection.Close()
+
+	commands := commander{connection: connection, aliases: liveConf.CommandAliases()}
+
+	_, err = commands.do("BGSAVE")
+	if err != nil && !strings.Contains(err.Error(), "already in progress") {
+		return fmt.Errorf("failed to save redis data: %s", err.Error())
+	}
+
+	deadline := time.Now().Add(resetter.timeout)
+	for {
+		info, err := redigo.String(commands.do("INFO", "persistence"))
+		if err != nil {
+			return fmt.Errorf("failed to save redis data: %s", err.Error())
+		}
+
+		persistence := parseInfo(info)
+		if persistence["rdb_bgsave_in_progress"] == "0" {
+			if persistence["rdb_last_bgsave_status"] != "ok" {
+				return errors.New("failed to save redis data: background save failed")
+			}
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return errors.New("failed to save redis data: timed out waiting for the background save")
+		}
+		time.Sleep(resetter.saveInterval)
+	}
+}
+
+func (resetter *Resetter) snapshotData(paths dataPaths) error {
+	if _, err := resetter.Snapshots.Take(paths.dataFiles()); err != nil {
+		return err
+	}
+
+	if err := removeFiles([]string{paths.clusterConfig}); err != nil {
+		return err
+	}
+
+	//A snapshot that cannot be pruned now is pruned after the next reset,
+	//which is no reason to fail this one
+	resetter.Snapshots.Prune()
+	return nil
+}
+
+func parseInfo(info string) map[string]string {
+	fields := map[string]string{}
+	for _, line := range strings.Split(info, "\n") {
+		parts := strings.SplitN(strings.TrimSpace(line), ":", 2)
+		if len(parts) == 2 {
+			fields[parts[0]] = parts[1]
+		}
+	}
+	return fields
+}
+
+func (resetter *Resetter) resetConfigWithNewPassword() error {
+	conf, err := resetter.renderDefaultConf()
 	if err != nil {
 		return err
 	}
@@ -151,3 +436,7 @@ func (resetter *Resetter) resetConfigWithNewPassword() error {
 
 	return nil
 }
+
+func (resetter *Resetter) renderDefaultConf() (redisconf.Conf, error) {
+	return redisconf.RenderForDedicatedNode(resetter.defaultConfPath, resetter.Version, resetter.Features)
+}
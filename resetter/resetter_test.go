@@ -7,14 +7,17 @@ import (
 	"net"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
+	"code.cloudfoundry.org/lager/lagertest"
 	"github.com/pivotal-cf/cf-redis-broker/redisconf"
 	monitFakes "github.com/pivotal-cf/redisutils/monit/fakes"
 	"github.com/pivotal-cf/redisutils/redis"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
 )
 
 type fakeChecker struct {
@@ -35,6 +38,7 @@ var _ = Describe("Client", func() {
 		rdbPath         string
 		redisPort       int
 		confPath        string
+		tmpdir          string
 		defaultConfPath string
 		conf            redisconf.Conf
 		fakeMonit       *monitFakes.FakeMonit
@@ -51,7 +55,8 @@ var _ = Describe("Client", func() {
 		fakeMonit = new(monitFakes.FakeMonit)
 		fakePortChecker = new(fakeChecker)
 
-		tmpdir, err := ioutil.TempDir("", "redisconf-test")
+		var err error
+		tmpdir, err = ioutil.TempDir("", "redisconf-test")
 		Expect(err).NotTo(HaveOccurred())
 		defaultConfPath = filepath.Join(tmpdir, "redis.conf-default")
 		confPath = filepath.Join(tmpdir, "redis.conf")
@@ -65,6 +70,14 @@ var _ = Describe("Client", func() {
 				Key:   "requirepass",
 				Value: "default",
 			},
+			redisconf.Param{
+				Key:   "maxmemory-policy",
+				Value: "allkeys-lru",
+			},
+			redisconf.Param{
+				Key:   "dir",
+				Value: tmpdir,
+			},
 		).Save(defaultConfPath)
 		Expect(err).NotTo(HaveOccurred())
 
@@ -85,30 +98,30 @@ var _ = Describe("Client", func() {
 				Key:   "rename-command",
 				Value: "CONFIG aliasedconfigcommand",
 			},
+			redisconf.Param{
+				Key:   "dir",
+				Value: tmpdir,
+			},
 		)
 
 		err = conf.Save(confPath)
 		Expect(err).NotTo(HaveOccurred())
 
-		cwd, err := os.Getwd()
-		Expect(err).NotTo(HaveOccurred())
-
-		aofPath = filepath.Join(cwd, "appendonly.aof")
+		aofPath = filepath.Join(tmpdir, "appendonly.aof")
 		_, err = os.Create(aofPath)
 		Expect(err).NotTo(HaveOccurred())
 
-		rdbPath = filepath.Join(cwd, "dump.rdb")
+		rdbPath = filepath.Join(tmpdir, "dump.rdb")
 		_, err = os.Create(rdbPath)
 		Expect(err).NotTo(HaveOccurred())
 
 		redisClient = New(defaultConfPath, confPath, fakePortChecker)
 		redisClient.redis = redisFake
-		redisClient.Monit = fakeMonit
+		redisClient.Process = &MonitProcess{Monit: fakeMonit, Name: "redis"}
 	})
 
 	AfterEach(func() {
-		os.Remove(aofPath)
-		os.Remove(rdbPath)
+		os.RemoveAll(tmpdir)
 	})
 
 	Describe("#ResetRedis", func() {
@@ -180,6 +193,19 @@ var _ = Describe("Client", func() {
 			})
 		})
 
+		It("holds the conf lock", func() {
+			redisClient.ConfLock.Lock()
+
+			done := make(chan error)
+			go func() {
+				done <- redisClient.ResetRedis()
+			}()
+
+			Consistently(done).ShouldNot(Receive())
+			redisClient.ConfLock.Unlock()
+			Eventually(done).Should(Receive(BeNil()))
+		})
+
 		It("stops and starts redis with monit", func() {
 			Expect(resetErr).NotTo(HaveOccurred())
 			Expect(fakeMonit.StopAndWaitCallCount()).To(Equal(1))
@@ -210,6 +236,19 @@ var _ = Describe("Client", func() {
 			})
 		})
 
+		Context("when redis.conf does not set an absolute dir", func() {
+			BeforeEach(func() {
+				conf.Set("dir", "./data")
+				Expect(conf.Save(confPath)).To(Succeed())
+			})
+
+			It("returns an error without stopping redis or deleting anything", func() {
+				Expect(resetErr).To(MatchError(ContainSubstring("must set dir to an absolute path")))
+				Expect(fakeMonit.StopAndWaitCallCount()).To(Equal(0))
+				Expect(rdbPath).To(BeAnExistingFile())
+			})
+		})
+
 		It("removes the AOF file", func() {
 			Expect(aofPath).NotTo(BeAnExistingFile())
 		})
@@ -246,14 +285,509 @@ var _ = Describe("Client", func() {
 			})
 		})
 
-		Context("when the AOF file cannot be removed", func() {
+		Context("when the node was part of a cluster", func() {
+			var nodesConfPath string
+
+			BeforeEach(func() {
+				nodesConfPath = filepath.Join(tmpdir, "nodes.conf")
+				_, err := os.Create(nodesConfPath)
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			AfterEach(func() {
+				os.Remove(nodesConfPath)
+			})
+
+			It("removes the cluster configuration", func() {
+				Expect(nodesConfPath).NotTo(BeAnExistingFile())
+			})
+		})
+
+		Context("when redis-server serves TLS", func() {
+			BeforeEach(func() {
+				var err error
+				redisClient.Version, err = redisconf.ParseVersion("6.2.6")
+				Expect(err).NotTo(HaveOccurred())
+				redisClient.Features = redisconf.Features{
+					TLS: redisconf.TLS{Port: 16379, CertFile: "/tls/redis.crt", KeyFile: "/tls/redis.key"},
+				}
+
+				defaultConf, err := redisconf.Load(defaultConfPath)
+				Expect(err).NotTo(HaveOccurred())
+				defaultConf.Set("enable-debug-command", "local")
+				Expect(defaultConf.Save(defaultConfPath)).To(Succeed())
+			})
+
+			It("keeps the TLS directives", func() {
+				Expect(resetErr).NotTo(HaveOccurred())
+
+				newConf, err := redisconf.Load(confPath)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(newConf.Get("tls-port")).To(Equal("16379"))
+				Expect(newConf.Get("tls-cert-file")).To(Equal("/tls/redis.crt"))
+				Expect(newConf.Get("tls-key-file")).To(Equal("/tls/redis.key"))
+			})
+
+			It("leaves out the directives redis-server does not understand", func() {
+				newConf, err := redisconf.Load(confPath)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(newConf.HasKey("enable-debug-command")).To(BeFalse())
+			})
+
+			Context("with fast reset", func() {
+				BeforeEach(func() {
+					redisClient.FastReset = true
+				})
+
+				It("keeps the TLS directives", func() {
+					Expect(resetErr).NotTo(HaveOccurred())
+					Expect(fakeMonit.StopAndWaitCallCount()).To(Equal(0))
+
+					newConf, err := redisconf.Load(confPath)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(newConf.Get("tls-port")).To(Equal("16379"))
+					Expect(newConf.HasKey("enable-debug-command")).To(BeFalse())
+				})
+			})
+		})
+
+		Context("with snapshots", func() {
+			var (
+				snapshotDir string
+				commands    []string
+				saveStatus  string
+			)
+
+			BeforeEach(func() {
+				var err error
+				snapshotDir, err = ioutil.TempDir("", "redis-snapshots")
+				Expect(err).NotTo(HaveOccurred())
+
+				redisClient.Snapshots = &Snapshots{Dir: snapshotDir, Retention: time.Hour}
+				redisClient.saveInterval = time.Millisecond
+
+				commands = []string{}
+				saveStatus = "ok"
+				inProgress := 1
+				connFake.DoStub = func(command string, args ...interface{}) (interface{}, error) {
+					commands = append(commands, command)
+					if command != "INFO" {
+						return nil, nil
+					}
+
+					info := fmt.Sprintf("# Persistence\r\nrdb_bgsave_in_progress:%d\r\nrdb_last_bgsave_status:%s\r\n", inProgress, saveStatus)
+					inProgress = 0
+					return []byte(info), nil
+				}
+			})
+
+			AfterEach(func() {
+				os.RemoveAll(snapshotDir)
+			})
+
+			It("has redis save its data before stopping it", func() {
+				Expect(resetErr).NotTo(HaveOccurred())
+				Expect(commands).To(Equal([]string{"AUTH", "BGSAVE", "INFO", "INFO", "AUTH", "SCRIPT"}))
+			})
+
+			Context("when redis.conf renames BGSAVE", func() {
+				BeforeEach(func() {
+					Expect(conf.SetEntry("rename-command", `BGSAVE "renamed-bgsave"`)).To(Succeed())
+					Expect(conf.Save(confPath)).To(Succeed())
+				})
+
+				It("saves with the alias", func() {
+					Expect(resetErr).NotTo(HaveOccurred())
+					Expect(commands).To(Equal([]string{"AUTH", "renamed-bgsave", "INFO", "INFO", "AUTH", "SCRIPT"}))
+				})
+			})
+
+			It("moves the data files into a snapshot", func() {
+				Expect(aofPath).NotTo(BeAnExistingFile())
+				Expect(rdbPath).NotTo(BeAnExistingFile())
+
+				snapshots, err := redisClient.ListSnapshots()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(snapshots).To(HaveLen(1))
+				Expect(snapshots[0].Files).To(ConsistOf("appendonly.aof", "dump.rdb"))
+			})
+
+			It("marks the node unallocated", func() {
+				Expect(redisClient.Snapshots.Unallocated()).To(BeTrue())
+			})
+
+			Context("when an old snapshot has expired", func() {
+				var expired string
+
+				BeforeEach(func() {
+					expired = filepath.Join(snapshotDir, time.Now().Add(-2*time.Hour).UTC().Format(snapshotIDFormat))
+					Expect(os.Mkdir(expired, 0700)).To(Succeed())
+				})
+
+				It("prunes it", func() {
+					Expect(expired).NotTo(BeADirectory())
+				})
+			})
+
+			Context("when the background save fails", func() {
+				BeforeEach(func() {
+					saveStatus = "err"
+				})
+
+				It("does not reset redis", func() {
+					Expect(resetErr).To(MatchError("failed to save redis data: background save failed"))
+					Expect(fakeMonit.StopAndWaitCallCount()).To(Equal(0))
+					Expect(aofPath).To(BeAnExistingFile())
+				})
+			})
+		})
+
+		Context("with fast reset", func() {
+			var (
+				commands [][]interface{}
+				replies  map[string]interface{}
+				failOn   string
+			)
+
+			BeforeEach(func() {
+				redisClient.FastReset = true
+				redisClient.Logger = lagertest.NewTestLogger("resetter")
+
+				commands = [][]interface{}{}
+				replies = map[string]interface{}{}
+				failOn = ""
+				connFake.DoStub = func(command string, args ...interface{}) (interface{}, error) {
+					commands = append(commands, append([]interface{}{command}, args...))
+					if command == failOn {
+						return nil, errors.New("OOM command not allowed")
+					}
+					return replies[strings.TrimSpace(fmt.Sprintln(append([]interface{}{command}, args...)...))], nil
+				}
+			})
+
+			It("flushes redis without restarting it", func() {
+				Expect(resetErr).NotTo(HaveOccurred())
+				Expect(commands).To(HaveLen(15))
+				Expect(commands[0]).To(Equal([]interface{}{"AUTH", redisPassword}))
+				Expect(commands[1]).To(Equal([]interface{}{"ACL", "LIST"}))
+				Expect(commands[2]).To(Equal([]interface{}{"SCRIPT", "KILL"}))
+				Expect(commands[3][:3]).To(Equal([]interface{}{"aliasedconfigcommand", "SET", "requirepass"}))
+				Expect(commands[4:]).To(Equal([][]interface{}{
+					{"CLIENT", "KILL", "TYPE", "normal"},
+					{"CLIENT", "KILL", "TYPE", "pubsub"},
+					{"CLIENT", "KILL", "TYPE", "slave"},
+					{"CLIENT", "KILL", "TYPE", "master"},
+					{"FLUSHALL"},
+					{"SAVE"},
+					{"SCRIPT", "FLUSH"},
+					{"aliasedconfigcommand", "GET", "port"},
+					{"aliasedconfigcommand", "GET", "maxmemory-policy"},
+					{"aliasedconfigcommand", "GET", "maxmemory"},
+					{"aliasedconfigcommand", "RESETSTAT"},
+				}))
+
+				Expect(fakeMonit.StopAndWaitCallCount()).To(Equal(0))
+				Expect(fakeMonit.StartAndWaitCallCount()).To(Equal(0))
+				Expect(fakePortChecker.addressesWaitedOn).To(BeEmpty())
+			})
+
+			It("changes the password", func() {
+				newPassword := commands[3][3]
+				Expect(newPassword).NotTo(Equal(redisPassword))
+				Expect(newPassword).NotTo(Equal("default"))
+			})
+
+			It("replaces redis.conf with the default one rather than rewriting it", func() {
+				Expect(commands).NotTo(ContainElement([]interface{}{"aliasedconfigcommand", "REWRITE"}))
+
+				newConf, err := redisconf.Load(confPath)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(newConf.Password()).To(Equal(commands[3][3]))
+				Expect(newConf.HasKey("rename-command")).To(BeFalse())
+				Expect(newConf.Get("maxmemory-policy")).To(Equal("allkeys-lru"))
+			})
+
+			Context("when the tenant changed a setting", func() {
+				BeforeEach(func() {
+					replies["aliasedconfigcommand GET maxmemory-policy"] = []interface{}{[]byte("maxmemory-policy"), []byte("noeviction")}
+					replies["aliasedconfigcommand GET port"] = []interface{}{[]byte("port"), []byte(fmt.Sprintf("%d", redisPort))}
+				})
+
+				It("puts the default back", func() {
+					Expect(resetErr).NotTo(HaveOccurred())
+					Expect(commands).To(ContainElement([]interface{}{"aliasedconfigcommand", "SET", "maxmemory-policy", "allkeys-lru"}))
+
+					set := []interface{}{}
+					for _, command := range commands {
+						if len(command) > 2 && command[1] == "SET" {
+							set = append(set, command[2])
+						}
+					}
+					Expect(set).To(Equal([]interface{}{"requirepass", "maxmemory-policy"}))
+				})
+			})
+
+			Context("when the tenant created ACL users", func() {
+				BeforeEach(func() {
+					replies["ACL LIST"] = []interface{}{
+						[]byte("user default on nopass ~* &* +@all"),
+						[]byte("user reader on #abc ~* +@read"),
+					}
+				})
+
+				It("restarts redis instead", func() {
+					Expect(resetErr).NotTo(HaveOccurred())
+					Expect(commands).NotTo(ContainElement([]interface{}{"FLUSHALL"}))
+					Expect(fakeMonit.StartAndWaitCallCount()).To(Equal(1))
+				})
+			})
+
+			Context("when a step fails", func() {
+				BeforeEach(func() {
+					failOn = "FLUSHALL"
+				})
+
+				It("puts the password back and restarts redis instead", func() {
+					Expect(resetErr).NotTo(HaveOccurred())
+					Expect(commands).To(ContainElement([]interface{}{"aliasedconfigcommand", "SET", "requirepass", redisPassword}))
+					Expect(fakeMonit.StopAndWaitCallCount()).To(Equal(1))
+					Expect(fakeMonit.StartAndWaitCallCount()).To(Equal(1))
+					Expect(rdbPath).NotTo(BeAnExistingFile())
+					Expect(redisClient.Logger).To(gbytes.Say("fast-reset-failed"))
+				})
+			})
+
+			It("saves the empty dataset over the previous tenant's", func() {
+				Expect(resetErr).NotTo(HaveOccurred())
+
+				flushed := -1
+				for index, command := range commands {
+					if command[0] == "FLUSHALL" {
+						flushed = index
+					}
+				}
+				Expect(flushed).NotTo(Equal(-1))
+				Expect(commands[flushed+1]).To(Equal([]interface{}{"SAVE"}))
+			})
+
+			Context("when the empty dataset cannot be saved", func() {
+				BeforeEach(func() {
+					failOn = "SAVE"
+				})
+
+				It("restarts redis and deletes the data files instead", func() {
+					Expect(resetErr).NotTo(HaveOccurred())
+					Expect(fakeMonit.StopAndWaitCallCount()).To(Equal(1))
+					Expect(rdbPath).NotTo(BeAnExistingFile())
+				})
+			})
+
+			Context("when the node replicates another", func() {
+				BeforeEach(func() {
+					conf.Set("slaveof", "10.0.0.1 6379")
+					Expect(conf.Save(confPath)).To(Succeed())
+				})
+
+				It("restarts redis instead", func() {
+					Expect(resetErr).NotTo(HaveOccurred())
+					Expect(commands).NotTo(ContainElement([]interface{}{"FLUSHALL"}))
+					Expect(fakeMonit.StartAndWaitCallCount()).To(Equal(1))
+				})
+			})
+		})
+
+		Context("when AOF is disabled", func() {
 			BeforeEach(func() {
 				err := os.Remove(aofPath)
 				Expect(err).NotTo(HaveOccurred())
 			})
 
-			It("returns error", func() {
-				Expect(resetErr).To(HaveOccurred())
+			It("resets redis", func() {
+				Expect(resetErr).NotTo(HaveOccurred())
+				Expect(rdbPath).NotTo(BeAnExistingFile())
+			})
+		})
+
+		Context("when redis.conf sets where the data lives", func() {
+			var (
+				dataDir       string
+				customRdbPath string
+				customAofPath string
+			)
+
+			BeforeEach(func() {
+				var err error
+				dataDir, err = ioutil.TempDir("", "redis-data")
+				Expect(err).NotTo(HaveOccurred())
+
+				customRdbPath = filepath.Join(dataDir, "data.rdb")
+				customAofPath = filepath.Join(dataDir, "data.aof")
+				Expect(ioutil.WriteFile(customRdbPath, []byte("data"), 0644)).To(Succeed())
+				Expect(ioutil.WriteFile(customAofPath, []byte("data"), 0644)).To(Succeed())
+
+				conf.Set("dir", dataDir)
+				conf.Set("dbfilename", "data.rdb")
+				conf.Set("appendfilename", `"data.aof"`)
+				Expect(conf.Save(confPath)).To(Succeed())
+			})
+
+			AfterEach(func() {
+				os.RemoveAll(dataDir)
+			})
+
+			It("removes the data files there", func() {
+				Expect(resetErr).NotTo(HaveOccurred())
+				Expect(customRdbPath).NotTo(BeAnExistingFile())
+				Expect(customAofPath).NotTo(BeAnExistingFile())
+			})
+
+			It("leaves the working directory alone", func() {
+				Expect(rdbPath).To(BeAnExistingFile())
+				Expect(aofPath).To(BeAnExistingFile())
+			})
+		})
+	})
+
+	Describe("#RestoreSnapshot", func() {
+		var (
+			snapshotDir string
+			snapshot    Snapshot
+		)
+
+		BeforeEach(func() {
+			var err error
+			snapshotDir, err = ioutil.TempDir("", "redis-snapshots")
+			Expect(err).NotTo(HaveOccurred())
+
+			redisClient.Snapshots = &Snapshots{Dir: snapshotDir, Retention: time.Hour}
+
+			Expect(ioutil.WriteFile(rdbPath, []byte("saved data"), 0644)).To(Succeed())
+			snapshot, err = redisClient.Snapshots.Take([]string{rdbPath})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(ioutil.WriteFile(rdbPath, []byte("new tenant data"), 0644)).To(Succeed())
+			Expect(redisClient.Snapshots.MarkUnallocated()).To(Succeed())
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(snapshotDir)
+		})
+
+		It("puts the snapshot's data back and restarts redis", func() {
+			Expect(redisClient.RestoreSnapshot(snapshot.ID, false)).To(Succeed())
+
+			Expect(ioutil.ReadFile(rdbPath)).To(Equal([]byte("saved data")))
+			Expect(aofPath).NotTo(BeAnExistingFile())
+			Expect(fakeMonit.StopAndWaitCallCount()).To(Equal(1))
+			Expect(fakeMonit.StartAndWaitCallCount()).To(Equal(1))
+			Expect(fakePortChecker.addressesWaitedOn).To(HaveLen(1))
+		})
+
+		It("holds the conf lock", func() {
+			redisClient.ConfLock.Lock()
+
+			done := make(chan error)
+			go func() {
+				done <- redisClient.RestoreSnapshot(snapshot.ID, false)
+			}()
+
+			Consistently(done).ShouldNot(Receive())
+			Expect(fakeMonit.StopAndWaitCallCount()).To(Equal(0))
+			redisClient.ConfLock.Unlock()
+			Eventually(done).Should(Receive(BeNil()))
+		})
+
+		It("removes the snapshot", func() {
+			Expect(redisClient.RestoreSnapshot(snapshot.ID, false)).To(Succeed())
+			Expect(redisClient.ListSnapshots()).To(BeEmpty())
+		})
+
+		It("leaves redis alone for an unknown snapshot", func() {
+			Expect(redisClient.RestoreSnapshot("../../etc", false)).To(Equal(ErrSnapshotNotFound))
+			Expect(fakeMonit.StopAndWaitCallCount()).To(Equal(0))
+		})
+
+		Context("when the node has been allocated since its reset", func() {
+			BeforeEach(func() {
+				Expect(redisClient.MarkAllocated()).To(Succeed())
+			})
+
+			It("refuses to overwrite the instance's data", func() {
+				Expect(redisClient.RestoreSnapshot(snapshot.ID, false)).To(Equal(ErrNodeAllocated))
+				Expect(ioutil.ReadFile(rdbPath)).To(Equal([]byte("new tenant data")))
+				Expect(fakeMonit.StopAndWaitCallCount()).To(Equal(0))
+			})
+
+			It("restores when forced", func() {
+				Expect(redisClient.RestoreSnapshot(snapshot.ID, true)).To(Succeed())
+				Expect(ioutil.ReadFile(rdbPath)).To(Equal([]byte("saved data")))
+			})
+		})
+
+		It("fails without snapshots", func() {
+			redisClient.Snapshots = nil
+			Expect(redisClient.RestoreSnapshot(snapshot.ID, false)).To(Equal(ErrSnapshotsDisabled))
+		})
+	})
+
+	Describe("#EnableCluster", func() {
+		var (
+			enableErr     error
+			nodesConfPath string
+		)
+
+		BeforeEach(func() {
+			nodesConfPath = filepath.Join(tmpdir, "nodes.conf")
+			_, err := os.Create(nodesConfPath)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			os.Remove(nodesConfPath)
+		})
+
+		JustBeforeEach(func() {
+			enableErr = redisClient.EnableCluster("cluster-password")
+		})
+
+		It("restarts redis with monit", func() {
+			Expect(enableErr).NotTo(HaveOccurred())
+			Expect(fakeMonit.StopAndWaitCallCount()).To(Equal(1))
+			Expect(fakeMonit.StartAndWaitCallCount()).To(Equal(1))
+		})
+
+		It("enables cluster mode with the cluster password", func() {
+			newConfig, err := redisconf.Load(confPath)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(newConfig.Get("cluster-enabled")).To(Equal("yes"))
+			Expect(newConfig.Get("cluster-config-file")).To(Equal("nodes.conf"))
+			Expect(newConfig.Get("requirepass")).To(Equal("cluster-password"))
+			Expect(newConfig.Get("masterauth")).To(Equal("cluster-password"))
+			Expect(newConfig.Get("rename-command")).To(Equal("CONFIG aliasedconfigcommand"))
+		})
+
+		It("forgets any earlier cluster membership", func() {
+			Expect(nodesConfPath).NotTo(BeAnExistingFile())
+		})
+
+		It("keeps the data", func() {
+			Expect(aofPath).To(BeAnExistingFile())
+		})
+
+		It("does not return until redis is available again", func() {
+			Expect(fakePortChecker.addressesWaitedOn).To(HaveLen(1))
+		})
+
+		Context("when `monit stop` fails", func() {
+			monitStopError := errors.New("Monit has failed to stop")
+
+			BeforeEach(func() {
+				fakeMonit.StopAndWaitReturns(monitStopError)
+			})
+
+			It("returns the error", func() {
+				Expect(enableErr).To(MatchError(monitStopError))
 			})
 		})
 	})
@@ -0,0 +1,177 @@
+package resetter
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/pivotal-cf/cf-redis-broker/utils"
+)
+
+const (
+	snapshotIDFormat  = "20060102T150405.000000000Z"
+	unallocatedMarker = "unallocated"
+)
+
+var ErrSnapshotNotFound = errors.New("snapshot not found")
+
+//Snapshots keeps the data files of reset instances in Dir for Retention, so
+//that an accidental deprovision can be undone. Dir may be on another
+//partition than the redis data
+type Snapshots struct {
+	Dir       string
+	Retention time.Duration
+}
+
+//Snapshot is a set of data files taken from redis before a reset
+type Snapshot struct {
+	ID      string    `json:"id"`
+	TakenAt time.Time `json:"taken_at"`
+	Files   []string  `json:"files"`
+}
+
+//Take moves the files that exist into a new snapshot
+func (snapshots *Snapshots) Take(files []string) (Snapshot, error) {
+	takenAt := time.Now().UTC()
+	snapshot := Snapshot{ID: takenAt.Format(snapshotIDFormat), TakenAt: takenAt, Files: []string{}}
+
+	snapshotDir := filepath.Join(snapshots.Dir, snapshot.ID)
+	if err := os.MkdirAll(snapshotDir, 0700); err != nil {
+		return snapshot, err
+	}
+
+	for _, file := range files {
+		if _, err := os.Stat(file); os.IsNotExist(err) {
+			continue
+		}
+
+		if err := moveFile(file, filepath.Join(snapshotDir, filepath.Base(file))); err != nil {
+			return snapshot, err
+		}
+		snapshot.Files = append(snapshot.Files, filepath.Base(file))
+	}
+
+	return snapshot, nil
+}
+
+//List returns the snapshots, newest first
+func (snapshots *Snapshots) List() ([]Snapshot, error) {
+	entries, err := ioutil.ReadDir(snapshots.Dir)
+	if os.IsNotExist(err) {
+		return []Snapshot{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	list := []Snapshot{}
+	for _, entry := range entries {
+		snapshot, err := snapshots.find(entry.Name())
+		if err != nil {
+			continue
+		}
+		list = append(list, snapshot)
+	}
+
+	sort.Sort(sort.Reverse(byTakenAt(list)))
+	return list, nil
+}
+
+//Restore moves the files of a snapshot into dataDir and removes the snapshot
+func (snapshots *Snapshots) Restore(id, dataDir string) error {
+	snapshot, err := snapshots.find(id)
+	if err != nil {
+		return err
+	}
+
+	snapshotDir := filepath.Join(snapshots.Dir, snapshot.ID)
+	for _, file := range snapshot.Files {
+		if err := moveFile(filepath.Join(snapshotDir, file), filepath.Join(dataDir, file)); err != nil {
+			return err
+		}
+	}
+
+	return os.RemoveAll(snapshotDir)
+}
+
+//Prune removes the snapshots older than the retention window
+func (snapshots *Snapshots) Prune() error {
+	list, err := snapshots.List()
+	if err != nil {
+		return err
+	}
+
+	cutoff := time.Now().Add(-snapshots.Retention)
+	for _, snapshot := range list {
+		if snapshot.TakenAt.Before(cutoff) {
+			if err := os.RemoveAll(filepath.Join(snapshots.Dir, snapshot.ID)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+//MarkUnallocated records that the node has not been handed to an instance
+//since its last reset, so that a snapshot can be restored onto it
+func (snapshots *Snapshots) MarkUnallocated() error {
+	if err := os.MkdirAll(snapshots.Dir, 0700); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filepath.Join(snapshots.Dir, unallocatedMarker), []byte{}, 0600)
+}
+
+//MarkAllocated records that the node now holds an instance's data
+func (snapshots *Snapshots) MarkAllocated() error {
+	err := os.Remove(filepath.Join(snapshots.Dir, unallocatedMarker))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+//Unallocated reports whether the node has been reset and not allocated since
+func (snapshots *Snapshots) Unallocated() bool {
+	_, err := os.Stat(filepath.Join(snapshots.Dir, unallocatedMarker))
+	return err == nil
+}
+
+func (snapshots *Snapshots) find(id string) (Snapshot, error) {
+	takenAt, err := time.Parse(snapshotIDFormat, id)
+	if err != nil {
+		return Snapshot{}, ErrSnapshotNotFound
+	}
+
+	entries, err := ioutil.ReadDir(filepath.Join(snapshots.Dir, id))
+	if err != nil {
+		return Snapshot{}, ErrSnapshotNotFound
+	}
+
+	snapshot := Snapshot{ID: id, TakenAt: takenAt, Files: []string{}}
+	for _, entry := range entries {
+		snapshot.Files = append(snapshot.Files, entry.Name())
+	}
+
+	return snapshot, nil
+}
+
+//moveFile renames the file, falling back to a copy when source and
+//destination are on different partitions
+func moveFile(source, destination string) error {
+	if err := os.Rename(source, destination); err == nil {
+		return nil
+	}
+
+	return utils.MoveFile(source, destination)
+}
+
+type byTakenAt []Snapshot
+
+func (snapshots byTakenAt) Len() int           { return len(snapshots) }
+func (snapshots byTakenAt) Swap(i, j int)      { snapshots[i], snapshots[j] = snapshots[j], snapshots[i] }
+func (snapshots byTakenAt) Less(i, j int) bool { return snapshots[i].TakenAt.Before(snapshots[j].TakenAt) }
@@ -0,0 +1,61 @@
+package upgrader
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// State records the progress of an upgrade so that an interrupted or failed
+// run can be resumed where it stopped.
+type State struct {
+	RedisBinary  string    `json:"redis_binary"`
+	Completed    []string  `json:"completed"`
+	Failed       string    `json:"failed,omitempty"`
+	Error        string    `json:"error,omitempty"`
+	Finished     bool      `json:"finished"`
+	LastProgress time.Time `json:"last_progress"`
+}
+
+func LoadState(path string) (*State, error) {
+	state := &State{Completed: []string{}}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, err
+	}
+
+	return state, nil
+}
+
+func (state *State) Save(path string) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	tmpPath := path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, path)
+}
+
+func (state *State) isCompleted(instanceID string) bool {
+	for _, completed := range state.Completed {
+		if completed == instanceID {
+			return true
+		}
+	}
+	return false
+}
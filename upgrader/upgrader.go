@@ -0,0 +1,282 @@
+package upgrader
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/pivotal-cf/cf-redis-broker/brokerconfig"
+	"github.com/pivotal-cf/cf-redis-broker/redis"
+	"github.com/pivotal-cf/cf-redis-broker/redis/client"
+	"github.com/pivotal-cf/cf-redis-broker/redisconf"
+)
+
+const (
+	defaultSaveTimeout    = 10 * time.Minute
+	defaultStartTimeout   = 10 * time.Second
+	defaultLoadingTimeout = 10 * time.Minute
+)
+
+type Repository interface {
+	AllInstances() ([]*redis.Instance, []error)
+	Lock(instance *redis.Instance) error
+	Unlock(instance *redis.Instance) error
+	RecordRedisBinary(instance *redis.Instance) error
+	WriteConfigFile(instance *redis.Instance) error
+	InstanceConfigPath(instanceID string) string
+	InstanceDataDir(instanceID string) string
+	InstanceLogFilePath(instanceID string) string
+}
+
+type ProcessController interface {
+	StartAndWaitUntilReady(instance *redis.Instance, configPath, instanceDataDir, logfilePath string, timeout time.Duration) error
+	Stop(instance *redis.Instance, save bool) error
+}
+
+type ConnectFunc func(instance *redis.Instance, aliases map[string]string) (client.Client, error)
+
+// Upgrader restarts shared instances one at a time on a new redis binary,
+// taking a snapshot of each first. It stops at the first instance that fails
+// and records its progress in StatePath, so that the next run resumes there.
+type Upgrader struct {
+	Logger            lager.Logger
+	Repo              Repository
+	ProcessController ProcessController
+	Connect           ConnectFunc
+	StatePath         string
+	SaveTimeout       time.Duration
+	StartTimeout      time.Duration
+	LoadingTimeout    time.Duration
+	Now               func() time.Time
+}
+
+func New(
+	logger lager.Logger,
+	repo Repository,
+	processController ProcessController,
+	statePath string,
+	config brokerconfig.ServiceConfiguration,
+) *Upgrader {
+	upgrader := &Upgrader{
+		Logger:            logger,
+		Repo:              repo,
+		ProcessController: processController,
+		Connect:           Connect,
+		StatePath:         statePath,
+		SaveTimeout:       defaultSaveTimeout,
+		StartTimeout:      time.Duration(config.StartRedisTimeoutSeconds) * time.Second,
+		LoadingTimeout:    defaultLoadingTimeout,
+		Now:               time.Now,
+	}
+
+	if upgrader.StartTimeout <= 0 {
+		upgrader.StartTimeout = defaultStartTimeout
+	}
+
+	return upgrader
+}
+
+func Connect(instance *redis.Instance, aliases map[string]string) (client.Client, error) {
+	return client.Connect(
+		client.Host(instance.Host),
+		client.Port(instance.Port),
+		client.Password(instance.Password),
+		client.CmdAliases(aliases),
+	)
+}
+
+// Upgrade moves every shared instance to redisBinary, a name from the
+// redis_binaries config or empty for the default redis-server.
+func (upgrader *Upgrader) Upgrade(redisBinary string) error {
+	state, err := upgrader.loadState(redisBinary)
+	if err != nil {
+		return err
+	}
+
+	instances, errs := upgrader.Repo.AllInstances()
+	if len(errs) > 0 {
+		return fmt.Errorf("Failed to list shared instances: %s", errs[0])
+	}
+
+	sort.Sort(byID(instances))
+
+	for _, instance := range instances {
+		if state.isCompleted(instance.ID) {
+			continue
+		}
+
+		upgrader.Logger.Info("upgrading-instance", lager.Data{
+			"instance":     instance.ID,
+			"redis_binary": redisBinary,
+		})
+
+		err := upgrader.upgradeInstance(instance, redisBinary)
+		if err != nil {
+			upgrader.Logger.Error("upgrade-instance-failed", err, lager.Data{
+				"instance": instance.ID,
+			})
+
+			state.Failed = instance.ID
+			state.Error = err.Error()
+			if saveErr := upgrader.saveState(state); saveErr != nil {
+				return saveErr
+			}
+
+			return fmt.Errorf("Upgrade halted at instance %s: %s", instance.ID, err)
+		}
+
+		state.Completed = append(state.Completed, instance.ID)
+		state.Failed = ""
+		state.Error = ""
+		if err := upgrader.saveState(state); err != nil {
+			return err
+		}
+	}
+
+	state.Finished = true
+	if err := upgrader.saveState(state); err != nil {
+		return err
+	}
+
+	upgrader.Logger.Info("upgrade-finished", lager.Data{
+		"redis_binary": redisBinary,
+		"instances":    len(state.Completed),
+	})
+
+	return nil
+}
+
+// loadState resumes an unfinished upgrade to the same binary. An unfinished
+// upgrade to a different binary has to be completed, or its state file
+// removed, first.
+func (upgrader *Upgrader) loadState(redisBinary string) (*State, error) {
+	state, err := LoadState(upgrader.StatePath)
+	if err != nil {
+		return nil, err
+	}
+
+	if state == nil || (state.Finished && state.RedisBinary != redisBinary) {
+		return &State{RedisBinary: redisBinary, Completed: []string{}}, nil
+	}
+
+	if state.RedisBinary != redisBinary {
+		return nil, fmt.Errorf(
+			"An upgrade to redis binary '%s' is in progress, resume it or remove %s",
+			state.RedisBinary,
+			upgrader.StatePath,
+		)
+	}
+
+	if state.Failed != "" {
+		upgrader.Logger.Info("resuming-failed-upgrade", lager.Data{
+			"instance": state.Failed,
+			"error":    state.Error,
+		})
+	}
+
+	state.Finished = false
+	return state, nil
+}
+
+func (upgrader *Upgrader) saveState(state *State) error {
+	state.LastProgress = upgrader.Now()
+	return state.Save(upgrader.StatePath)
+}
+
+// upgradeInstance holds the instance lock throughout, so that the process
+// monitor does not restart the instance while it is down.
+func (upgrader *Upgrader) upgradeInstance(instance *redis.Instance, redisBinary string) error {
+	err := upgrader.Repo.Lock(instance)
+	if err != nil {
+		return err
+	}
+	defer upgrader.Repo.Unlock(instance)
+
+	err = upgrader.snapshot(instance)
+	if err != nil {
+		return err
+	}
+
+	err = upgrader.ProcessController.Stop(instance, true)
+	if err != nil {
+		return err
+	}
+
+	instance.RedisBinary = redisBinary
+
+	err = upgrader.Repo.RecordRedisBinary(instance)
+	if err != nil {
+		return err
+	}
+
+	err = upgrader.Repo.WriteConfigFile(instance)
+	if err != nil {
+		return err
+	}
+
+	err = upgrader.ProcessController.StartAndWaitUntilReady(
+		instance,
+		upgrader.Repo.InstanceConfigPath(instance.ID),
+		upgrader.Repo.InstanceDataDir(instance.ID),
+		upgrader.Repo.InstanceLogFilePath(instance.ID),
+		upgrader.StartTimeout,
+	)
+	if err != nil {
+		return err
+	}
+
+	return upgrader.waitUntilServing(instance)
+}
+
+// connect uses the command aliases of the instance's redis.conf, which
+// renames commands such as BGSAVE and CONFIG.
+func (upgrader *Upgrader) connect(instance *redis.Instance) (client.Client, error) {
+	conf, err := redisconf.Load(upgrader.Repo.InstanceConfigPath(instance.ID))
+	if err != nil {
+		return nil, err
+	}
+
+	return upgrader.Connect(instance, conf.CommandAliases())
+}
+
+func (upgrader *Upgrader) snapshot(instance *redis.Instance) error {
+	redisClient, err := upgrader.connect(instance)
+	if err != nil {
+		return err
+	}
+	defer redisClient.Disconnect()
+
+	lastSave, err := redisClient.LastRDBSaveTime()
+	if err != nil {
+		return err
+	}
+
+	err = redisClient.RunBGSave()
+	if err != nil {
+		return err
+	}
+
+	return redisClient.WaitForNewSaveSince(lastSave, upgrader.SaveTimeout)
+}
+
+func (upgrader *Upgrader) waitUntilServing(instance *redis.Instance) error {
+	redisClient, err := upgrader.connect(instance)
+	if err != nil {
+		return err
+	}
+	defer redisClient.Disconnect()
+
+	err = redisClient.WaitUntilRedisNotLoading(int(upgrader.LoadingTimeout / time.Millisecond))
+	if err != nil {
+		return err
+	}
+
+	return redisClient.Ping()
+}
+
+type byID []*redis.Instance
+
+func (instances byID) Len() int           { return len(instances) }
+func (instances byID) Swap(i, j int)      { instances[i], instances[j] = instances[j], instances[i] }
+func (instances byID) Less(i, j int) bool { return instances[i].ID < instances[j].ID }
@@ -0,0 +1,15 @@
+package upgrader_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/reporters"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestUpgrader(t *testing.T) {
+	RegisterFailHandler(Fail)
+	junitReporter := reporters.NewJUnitReporter("junit_upgrader.xml")
+	RunSpecsWithDefaultAndCustomReporters(t, "Upgrader Suite", []Reporter{junitReporter})
+}
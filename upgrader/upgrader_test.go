@@ -0,0 +1,285 @@
+package upgrader_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"code.cloudfoundry.org/lager/lagertest"
+	"github.com/pivotal-cf/cf-redis-broker/redis"
+	"github.com/pivotal-cf/cf-redis-broker/redis/client"
+	"github.com/pivotal-cf/cf-redis-broker/redis/client/fakes"
+	"github.com/pivotal-cf/cf-redis-broker/upgrader"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type fakeRepository struct {
+	dir       string
+	instances []*redis.Instance
+	listErr   error
+	events    *[]string
+}
+
+func (repo *fakeRepository) AllInstances() ([]*redis.Instance, []error) {
+	if repo.listErr != nil {
+		return nil, []error{repo.listErr}
+	}
+	return repo.instances, nil
+}
+
+func (repo *fakeRepository) Lock(instance *redis.Instance) error {
+	*repo.events = append(*repo.events, "lock "+instance.ID)
+	return nil
+}
+
+func (repo *fakeRepository) Unlock(instance *redis.Instance) error {
+	*repo.events = append(*repo.events, "unlock "+instance.ID)
+	return nil
+}
+
+func (repo *fakeRepository) RecordRedisBinary(instance *redis.Instance) error {
+	*repo.events = append(*repo.events, "record "+instance.ID+" "+instance.RedisBinary)
+	return nil
+}
+
+func (repo *fakeRepository) WriteConfigFile(instance *redis.Instance) error {
+	*repo.events = append(*repo.events, "write-config "+instance.ID)
+	return nil
+}
+
+func (repo *fakeRepository) InstanceConfigPath(instanceID string) string {
+	return filepath.Join(repo.dir, instanceID, "redis.conf")
+}
+
+func (repo *fakeRepository) InstanceDataDir(instanceID string) string {
+	return "/data/" + instanceID + "/db"
+}
+
+func (repo *fakeRepository) InstanceLogFilePath(instanceID string) string {
+	return "/log/" + instanceID + "/redis-server.log"
+}
+
+type fakeProcessController struct {
+	startErrs map[string]error
+	events    *[]string
+}
+
+func (controller *fakeProcessController) StartAndWaitUntilReady(instance *redis.Instance, configPath, instanceDataDir, logfilePath string, timeout time.Duration) error {
+	*controller.events = append(*controller.events, "start "+instance.ID+" "+configPath)
+	return controller.startErrs[instance.ID]
+}
+
+func (controller *fakeProcessController) Stop(instance *redis.Instance, save bool) error {
+	if save {
+		*controller.events = append(*controller.events, "stop "+instance.ID+" save")
+	} else {
+		*controller.events = append(*controller.events, "stop "+instance.ID)
+	}
+	return nil
+}
+
+var _ = Describe("Upgrader", func() {
+	var (
+		events     []string
+		repo       *fakeRepository
+		controller *fakeProcessController
+		clients    map[string]*fakes.Client
+		aliases    map[string]map[string]string
+		dir        string
+		statePath  string
+		subject    *upgrader.Upgrader
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "upgrader")
+		Expect(err).NotTo(HaveOccurred())
+		statePath = filepath.Join(dir, "upgrade.json")
+
+		events = []string{}
+		repo = &fakeRepository{
+			dir:       dir,
+			instances: []*redis.Instance{{ID: "b"}, {ID: "a"}, {ID: "c"}},
+			events:    &events,
+		}
+		for _, instance := range repo.instances {
+			Expect(os.Mkdir(filepath.Join(dir, instance.ID), 0755)).To(Succeed())
+			Expect(ioutil.WriteFile(repo.InstanceConfigPath(instance.ID), []byte("port 6379\n"), 0644)).To(Succeed())
+		}
+		controller = &fakeProcessController{startErrs: map[string]error{}, events: &events}
+		clients = map[string]*fakes.Client{}
+		aliases = map[string]map[string]string{}
+
+		subject = &upgrader.Upgrader{
+			Logger:            lagertest.NewTestLogger("upgrader"),
+			Repo:              repo,
+			ProcessController: controller,
+			Connect: func(instance *redis.Instance, commandAliases map[string]string) (client.Client, error) {
+				aliases[instance.ID] = commandAliases
+				if clients[instance.ID] == nil {
+					clients[instance.ID] = new(fakes.Client)
+				}
+				return clients[instance.ID], nil
+			},
+			StatePath:      statePath,
+			SaveTimeout:    time.Second,
+			StartTimeout:   time.Second,
+			LoadingTimeout: time.Second,
+			Now:            time.Now,
+		}
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	loadState := func() *upgrader.State {
+		state, err := upgrader.LoadState(statePath)
+		Expect(err).NotTo(HaveOccurred())
+		return state
+	}
+
+	It("upgrades the instances one at a time in ID order", func() {
+		Expect(subject.Upgrade("4.0")).To(Succeed())
+
+		Expect(events).To(Equal([]string{
+			"lock a", "stop a save", "record a 4.0", "write-config a", "start a " + repo.InstanceConfigPath("a"), "unlock a",
+			"lock b", "stop b save", "record b 4.0", "write-config b", "start b " + repo.InstanceConfigPath("b"), "unlock b",
+			"lock c", "stop c save", "record c 4.0", "write-config c", "start c " + repo.InstanceConfigPath("c"), "unlock c",
+		}))
+	})
+
+	It("snapshots each instance before stopping it", func() {
+		Expect(subject.Upgrade("4.0")).To(Succeed())
+
+		for _, id := range []string{"a", "b", "c"} {
+			Expect(clients[id].RunBGSaveCallCount).To(Equal(1))
+			Expect(clients[id].WaitForNewSaveSinceCallCount).To(Equal(1))
+		}
+	})
+
+	It("records the finished upgrade", func() {
+		Expect(subject.Upgrade("4.0")).To(Succeed())
+
+		state := loadState()
+		Expect(state.RedisBinary).To(Equal("4.0"))
+		Expect(state.Completed).To(Equal([]string{"a", "b", "c"}))
+		Expect(state.Finished).To(BeTrue())
+	})
+
+	Context("when the instance's redis.conf renames commands", func() {
+		BeforeEach(func() {
+			conf := "port 6379\nrename-command BGSAVE \"a-bgsave\"\nrename-command CONFIG \"a-config\"\n"
+			Expect(ioutil.WriteFile(repo.InstanceConfigPath("a"), []byte(conf), 0644)).To(Succeed())
+		})
+
+		It("connects with the aliases", func() {
+			Expect(subject.Upgrade("4.0")).To(Succeed())
+			Expect(aliases["a"]).To(Equal(map[string]string{"BGSAVE": "a-bgsave", "CONFIG": "a-config"}))
+			Expect(aliases["b"]).To(BeEmpty())
+		})
+	})
+
+	Context("when the instance's redis.conf cannot be read", func() {
+		BeforeEach(func() {
+			Expect(os.Remove(repo.InstanceConfigPath("a"))).To(Succeed())
+		})
+
+		It("halts without stopping the instance", func() {
+			Expect(subject.Upgrade("4.0")).To(MatchError(ContainSubstring("Upgrade halted at instance a")))
+			Expect(events).To(Equal([]string{"lock a", "unlock a"}))
+		})
+	})
+
+	Context("when the background save fails", func() {
+		BeforeEach(func() {
+			clients["a"] = &fakes.Client{ExpectedWaitForNewSaveSinceErr: errors.New("Timed out waiting for background save to complete")}
+		})
+
+		It("halts without stopping the instance", func() {
+			err := subject.Upgrade("4.0")
+			Expect(err).To(MatchError("Upgrade halted at instance a: Timed out waiting for background save to complete"))
+			Expect(events).To(Equal([]string{"lock a", "unlock a"}))
+		})
+	})
+
+	Context("when an instance does not respond after the restart", func() {
+		BeforeEach(func() {
+			clients["b"] = &fakes.Client{PingReturns: errors.New("connection refused")}
+		})
+
+		It("halts at that instance", func() {
+			Expect(subject.Upgrade("4.0")).To(MatchError("Upgrade halted at instance b: connection refused"))
+			Expect(events).NotTo(ContainElement("lock c"))
+		})
+	})
+
+	Context("when an instance fails to start", func() {
+		BeforeEach(func() {
+			controller.startErrs["b"] = errors.New("redis failed to start")
+		})
+
+		It("halts at that instance and records the failure", func() {
+			Expect(subject.Upgrade("4.0")).To(MatchError("Upgrade halted at instance b: redis failed to start"))
+			Expect(events).NotTo(ContainElement("lock c"))
+
+			state := loadState()
+			Expect(state.Completed).To(Equal([]string{"a"}))
+			Expect(state.Failed).To(Equal("b"))
+			Expect(state.Error).To(Equal("redis failed to start"))
+			Expect(state.Finished).To(BeFalse())
+		})
+
+		It("resumes at the failed instance", func() {
+			Expect(subject.Upgrade("4.0")).NotTo(Succeed())
+
+			delete(controller.startErrs, "b")
+			events = events[:0]
+
+			Expect(subject.Upgrade("4.0")).To(Succeed())
+			Expect(events[0]).To(Equal("lock b"))
+			Expect(events).NotTo(ContainElement("lock a"))
+
+			state := loadState()
+			Expect(state.Completed).To(Equal([]string{"a", "b", "c"}))
+			Expect(state.Failed).To(BeEmpty())
+			Expect(state.Finished).To(BeTrue())
+		})
+
+		It("refuses to start an upgrade to another binary", func() {
+			Expect(subject.Upgrade("4.0")).NotTo(Succeed())
+			events = events[:0]
+
+			err := subject.Upgrade("5.0")
+			Expect(err).To(MatchError("An upgrade to redis binary '4.0' is in progress, resume it or remove " + statePath))
+			Expect(events).To(BeEmpty())
+		})
+	})
+
+	Context("when a previous upgrade to another binary has finished", func() {
+		BeforeEach(func() {
+			Expect(subject.Upgrade("4.0")).To(Succeed())
+			events = events[:0]
+		})
+
+		It("upgrades every instance again", func() {
+			Expect(subject.Upgrade("5.0")).To(Succeed())
+			Expect(events).To(ContainElement("record a 5.0"))
+			Expect(loadState().Completed).To(Equal([]string{"a", "b", "c"}))
+		})
+	})
+
+	Context("when the instances cannot be listed", func() {
+		BeforeEach(func() {
+			repo.listErr = errors.New("permission denied")
+		})
+
+		It("returns an error", func() {
+			Expect(subject.Upgrade("4.0")).To(MatchError("Failed to list shared instances: permission denied"))
+		})
+	})
+})